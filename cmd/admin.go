@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// adminTokenEnv names the environment variable that holds the relay's admin
+// token, so it stays out of shell history and process listings.
+const adminTokenEnv = "GIT_SHARE_ADMIN_TOKEN"
+
+var adminToken string
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Manage a relay you operate",
+	Long: `Administrative commands for a relay started with an admin token
+(serve --admin-token, or the ` + adminTokenEnv + ` environment variable).`,
+}
+
+var adminPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete every blob stored on the relay",
+	Long: `Delete every blob stored on the relay, e.g. during incident response.
+Pending codes stop working immediately.`,
+	Args: cobra.NoArgs,
+	RunE: runAdminPurge,
+}
+
+func init() {
+	adminCmd.PersistentFlags().StringVar(&adminToken, "token", "", "admin token (default $"+adminTokenEnv+")")
+	// Not the relay's auth token, which configures --token on send and receive
+	adminCmd.PersistentFlags().SetAnnotation("token", noConfigAnnotation, []string{"true"})
+	adminCmd.AddCommand(adminPurgeCmd)
+	rootCmd.AddCommand(adminCmd)
+}
+
+func runAdminPurge(cmd *cobra.Command, args []string) error {
+	token := adminToken
+	if token == "" {
+		token = os.Getenv(adminTokenEnv)
+	}
+	if token == "" {
+		return fmt.Errorf("an admin token is required; pass --token or set %s", adminTokenEnv)
+	}
+
+	removed, err := newClient().Purge(token)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Purged %d blobs from %s.\n", removed, serverURL)
+	return nil
+}
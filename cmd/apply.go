@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/gitshare"
+	"github.com/flawiddsouza/git-share/internal/git"
+)
+
+var (
+	applyCommit   bool
+	applySignoff  bool
+	applyThreeWay bool
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply [file]",
+	Short: "Apply a patch saved locally, without the relay",
+	Long: `Apply a patch from a file, or from stdin if no file (or "-") is given,
+the same way receive applies a downloaded one. Nothing is sent to or fetched
+from the relay, so this also works for patches carried over by other means.
+
+Examples:
+  git-share receive <code> --dry-run > p.patch
+  git-share apply p.patch              # apply to the working tree
+  git-share apply --commit p.patch     # apply a format-patch as commits
+  git-share apply --3way < p.patch     # merge with conflict markers if needed`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runApply,
+}
+
+func init() {
+	applyCmd.Flags().BoolVar(&applyCommit, "commit", false, "apply as commits with git am (needs a format-patch)")
+	applyCmd.Flags().BoolVar(&applySignoff, "signoff", false, "with --commit, add a Signed-off-by trailer for you")
+	applyCmd.Flags().BoolVar(&applyThreeWay, "3way", false, "fall back to a three-way merge, leaving conflict markers instead of failing")
+	rootCmd.AddCommand(applyCmd)
+}
+
+// applyDeps is what apply needs from git.
+type applyDeps interface {
+	FindRepoRoot() (string, error)
+	ApplyPatch(patch []byte, opts git.ApplyOptions) error
+	PatchStats(patch []byte) (string, error)
+}
+
+type realApplyDeps struct{}
+
+func (d realApplyDeps) FindRepoRoot() (string, error) { return git.FindRepoRoot() }
+func (d realApplyDeps) ApplyPatch(patch []byte, opts git.ApplyOptions) error {
+	return git.ApplyPatchWithOptions(patch, opts)
+}
+func (d realApplyDeps) PatchStats(patch []byte) (string, error) { return git.PatchStats(patch) }
+
+func runApply(cmd *cobra.Command, args []string) error {
+	opts := git.ApplyOptions{Commit: applyCommit, Signoff: applySignoff, ThreeWay: applyThreeWay}
+	return runApplyWithDeps(os.Stdin, os.Stderr, realApplyDeps{}, args, opts)
+}
+
+func runApplyWithDeps(stdin io.Reader, stderr io.Writer, deps applyDeps, args []string, opts git.ApplyOptions) error {
+	if opts.Signoff && !opts.Commit {
+		fmt.Fprintf(stderr, "Warning: --signoff only applies with --commit; ignoring it.\n")
+		opts.Signoff = false
+	}
+
+	// 1. Read the patch
+	var patch []byte
+	var err error
+	if len(args) == 0 || args[0] == "-" {
+		patch, err = io.ReadAll(stdin)
+	} else {
+		patch, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("reading patch: %w", err)
+	}
+	if len(patch) == 0 {
+		return fmt.Errorf("the patch is empty")
+	}
+
+	// 2. Make sure we're in a git repo
+	if _, err := deps.FindRepoRoot(); err != nil {
+		return err
+	}
+
+	// 3. Apply it
+	fmt.Fprintf(stderr, "Applying patch...\n")
+	if err := deps.ApplyPatch(patch, opts); err != nil {
+		gitshare.ExplainApplyError(stderr, err)
+		return err
+	}
+	fmt.Fprintf(stderr, "\nPatch applied successfully.\n")
+	if stats, _ := deps.PatchStats(patch); stats != "" {
+		fmt.Fprintf(stderr, "\n%s\n", stats)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flawiddsouza/git-share/internal/git"
+)
+
+type mockApplyDeps struct {
+	noRepo          bool
+	applyErr        error
+	applied         []byte
+	appliedAsCommit bool
+	threeWay        bool
+}
+
+func (m *mockApplyDeps) FindRepoRoot() (string, error) {
+	if m.noRepo {
+		return "", errors.New("not a git repository (or any parent)")
+	}
+	return "/repo", nil
+}
+func (m *mockApplyDeps) ApplyPatch(patch []byte, opts git.ApplyOptions) error {
+	if m.applyErr != nil {
+		return m.applyErr
+	}
+	m.applied = patch
+	m.appliedAsCommit = opts.Commit
+	m.threeWay = opts.ThreeWay
+	return nil
+}
+func (m *mockApplyDeps) PatchStats(patch []byte) (string, error) { return "", nil }
+
+func TestApply(t *testing.T) {
+	const patch = "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+b\n"
+	file := filepath.Join(t.TempDir(), "p.patch")
+	if err := os.WriteFile(file, []byte(patch), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		stdin   string
+		args    []string
+		opts    git.ApplyOptions
+		noRepo  bool
+		wantErr string
+	}{
+		{name: "from a file", args: []string{file}},
+		{name: "from stdin", stdin: patch},
+		{name: "dash is stdin", stdin: patch, args: []string{"-"}},
+		{name: "as commits, three-way", args: []string{file}, opts: git.ApplyOptions{Commit: true, ThreeWay: true}},
+		{name: "empty", stdin: "", wantErr: "the patch is empty"},
+		{name: "missing file", args: []string{filepath.Join(t.TempDir(), "nope.patch")}, wantErr: "reading patch"},
+		{name: "outside a repo", args: []string{file}, noRepo: true, wantErr: "not a git repository"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockApplyDeps{noRepo: tt.noRepo}
+			err := runApplyWithDeps(strings.NewReader(tt.stdin), &bytes.Buffer{}, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				if deps.applied != nil {
+					t.Error("nothing should be applied")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(deps.applied) != patch {
+				t.Errorf("applied %q, want %q", deps.applied, patch)
+			}
+			if deps.appliedAsCommit != tt.opts.Commit || deps.threeWay != tt.opts.ThreeWay {
+				t.Errorf("applied with commit=%v 3way=%v, want %+v", deps.appliedAsCommit, deps.threeWay, tt.opts)
+			}
+		})
+	}
+}
+
+func TestApplyConflicts(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	deps := &mockApplyDeps{applyErr: &git.ConflictError{Files: []string{"a.txt"}}}
+	err := runApplyWithDeps(strings.NewReader("diff content"), stderr, deps, nil, git.ApplyOptions{ThreeWay: true})
+	var conflict *git.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("error = %v, want a *git.ConflictError", err)
+	}
+	if !strings.Contains(stderr.String(), "conflicts to resolve by hand:\n  a.txt\n") {
+		t.Errorf("stderr should list the conflicted files\nGOT:\n%s", stderr.String())
+	}
+}
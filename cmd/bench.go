@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+	"github.com/flawiddsouza/git-share/internal/crypto"
+	"github.com/flawiddsouza/git-share/internal/ui"
+)
+
+var (
+	benchSize       string
+	benchIterations int
+)
+
+// benchTTL is the TTL of benchmark blobs. Each one is received right after
+// it is sent, so this only matters if the benchmark is interrupted.
+const benchTTL = 60
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure relay round-trip latency and throughput",
+	Long: `Send and receive random blobs through the relay and report send and
+receive latency (p50/p95) and throughput. Every blob is received right after
+it is sent, so nothing is left behind on the relay.
+
+Examples:
+  git-share bench
+  git-share bench --server https://my-relay.example.com --size 1MB --iterations 20`,
+	Args: cobra.NoArgs,
+	RunE: runBench,
+}
+
+func init() {
+	benchCmd.Flags().StringVar(&benchSize, "size", "64KB", "size of each blob (e.g. 64KB, 1MB)")
+	benchCmd.Flags().IntVar(&benchIterations, "iterations", 10, "number of send/receive round trips")
+	rootCmd.AddCommand(benchCmd)
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	size, err := ui.ParseByteSize(benchSize)
+	if err != nil {
+		return fmt.Errorf("invalid size %q: %w", benchSize, err)
+	}
+	if size < 1 {
+		return fmt.Errorf("--size must be at least 1 byte")
+	}
+	if benchIterations < 1 {
+		return fmt.Errorf("--iterations must be at least 1")
+	}
+
+	fmt.Fprintf(os.Stderr, "Benchmarking %s with %d round trips of %s...\n", serverURL, benchIterations, ui.FormatByteSize(size))
+	quiet = true // progress lines would interleave with the timings
+	result, err := benchRelay(newClient(), size, benchIterations)
+	if err != nil {
+		return err
+	}
+	printBench(os.Stdout, result)
+	return nil
+}
+
+// benchResult holds the timings of a benchmark run.
+type benchResult struct {
+	Send    []time.Duration
+	Receive []time.Duration
+	Wire    int // bytes of base64 data sent and received per round trip
+}
+
+// benchRelay sends and immediately receives iterations random blobs of size
+// bytes, timing each request.
+func benchRelay(c *client.Client, size int64, iterations int) (benchResult, error) {
+	blob := make([]byte, size)
+	if _, err := rand.Read(blob); err != nil {
+		return benchResult{}, fmt.Errorf("generating blob: %w", err)
+	}
+	data := base64.StdEncoding.EncodeToString(blob)
+
+	result := benchResult{Wire: len(data)}
+	for i := 0; i < iterations; i++ {
+		codeID, err := crypto.GenerateCodeID()
+		if err != nil {
+			return result, fmt.Errorf("generating code ID: %w", err)
+		}
+
+		start := time.Now()
+		if _, err := c.Send(codeID, data, benchTTL); err != nil {
+			return result, fmt.Errorf("send %d/%d failed: %w", i+1, iterations, err)
+		}
+		result.Send = append(result.Send, time.Since(start))
+
+		start = time.Now()
+		got, err := c.Receive(codeID)
+		if err != nil {
+			return result, fmt.Errorf("receive %d/%d failed: %w", i+1, iterations, err)
+		}
+		result.Receive = append(result.Receive, time.Since(start))
+
+		if got != data {
+			return result, fmt.Errorf("receive %d/%d returned different data than was sent", i+1, iterations)
+		}
+	}
+	return result, nil
+}
+
+func printBench(w io.Writer, r benchResult) {
+	var total time.Duration
+	for i := range r.Send {
+		total += r.Send[i] + r.Receive[i]
+	}
+	transferred := float64(2 * r.Wire * len(r.Send))
+	throughput := transferred / total.Seconds()
+
+	fmt.Fprintf(w, "Round trips: %d\n", len(r.Send))
+	fmt.Fprintf(w, "Blob size:   %s on the wire\n", ui.FormatByteSize(int64(r.Wire)))
+	fmt.Fprintf(w, "Send:        p50 %s  p95 %s\n", percentile(r.Send, 0.50), percentile(r.Send, 0.95))
+	fmt.Fprintf(w, "Receive:     p50 %s  p95 %s\n", percentile(r.Receive, 0.50), percentile(r.Receive, 0.95))
+	fmt.Fprintf(w, "Throughput:  %s/s\n", ui.FormatByteSize(int64(throughput)))
+}
+
+// percentile returns the nearest-rank p-th percentile of durations, rounded
+// for display.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	rank = max(rank, 0)
+	return sorted[rank].Round(time.Microsecond)
+}
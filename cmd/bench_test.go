@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+	"github.com/flawiddsouza/git-share/internal/server"
+)
+
+func TestBenchRelay(t *testing.T) {
+	relay := server.New(server.DefaultConfig())
+	srv := httptest.NewServer(relay.Handler())
+	defer srv.Close()
+
+	result, err := benchRelay(client.New(srv.URL), 1024, 5)
+	if err != nil {
+		t.Fatalf("benchRelay failed: %v", err)
+	}
+	if len(result.Send) != 5 || len(result.Receive) != 5 {
+		t.Fatalf("got %d sends and %d receives, want 5 each", len(result.Send), len(result.Receive))
+	}
+	for i := range result.Send {
+		if result.Send[i] <= 0 || result.Receive[i] <= 0 {
+			t.Errorf("round trip %d has a non-positive timing: send %s, receive %s", i, result.Send[i], result.Receive[i])
+		}
+	}
+
+	// Every blob was consumed
+	resp, err := http.Get(srv.URL + "/api/health")
+	if err != nil {
+		t.Fatalf("health check failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var health struct {
+		Blobs int `json:"blobs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatalf("decoding health: %v", err)
+	}
+	if health.Blobs != 0 {
+		t.Errorf("relay still holds %d blobs after the benchmark", health.Blobs)
+	}
+
+	out := &bytes.Buffer{}
+	printBench(out, result)
+	for _, want := range []string{"Round trips: 5", "Blob size:   1.3KB", "Send:        p50 ", "Throughput:  "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q\nGOT:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	durations := []time.Duration{5, 1, 4, 2, 3, 10, 9, 8, 7, 6}
+	for i := range durations {
+		durations[i] *= time.Millisecond
+	}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{p: 0.50, want: 5 * time.Millisecond},
+		{p: 0.95, want: 10 * time.Millisecond},
+		{p: 0, want: 1 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(durations, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %s, want %s", tt.p, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/internal/crypto"
+	"github.com/flawiddsouza/git-share/internal/wordlist"
+)
+
+var (
+	codeInfoWordlist string
+	codeInfoTTL      string
+	codeInfoRate     int
+)
+
+var codeInfoCmd = &cobra.Command{
+	Use:   "code-info <code>",
+	Short: "Show how hard a code is to guess",
+	Long: `Parse a code and estimate its strength: the bits of entropy in the code
+ID and in the passphrase, and what brute-forcing them would take. Nothing is
+sent to the relay, so the code stays unused.
+
+The code ID is only useful online: a guess has to reach the relay before the
+receiver takes the patch or its TTL runs out, at whatever rate the relay
+allows. The passphrase protects the blob from anyone who already has it,
+such as the relay operator, who can guess offline as fast as they can.
+
+Examples:
+  git-share code-info k7Xm9pQ2wR-alpha-bravo-charlie-delta
+  git-share code-info k7Xm9pQ2wR --ttl 30m --rate 100
+  git-share code-info <code> --wordlist words.txt   # words from a custom list`,
+	Args: cobra.ExactArgs(1),
+	RunE: runCodeInfo,
+}
+
+func init() {
+	codeInfoCmd.Flags().StringVar(&codeInfoWordlist, "wordlist", "", "wordlist file the passphrase words came from (default: the built-in lists)")
+	codeInfoCmd.Flags().StringVar(&codeInfoTTL, "ttl", "1h", "how long the code is on the relay")
+	codeInfoCmd.Flags().IntVar(&codeInfoRate, "rate", 1000, "guesses per second an attacker can make against the relay")
+	rootCmd.AddCommand(codeInfoCmd)
+}
+
+func runCodeInfo(cmd *cobra.Command, args []string) error {
+	ttl, err := time.ParseDuration(codeInfoTTL)
+	if err != nil || ttl <= 0 {
+		return fmt.Errorf("invalid ttl %q: use a positive duration such as 30m or 2h", codeInfoTTL)
+	}
+	if codeInfoRate < 1 {
+		return fmt.Errorf("--rate must be at least 1")
+	}
+	listSize := 0
+	if codeInfoWordlist != "" {
+		words, err := wordlist.Load(codeInfoWordlist)
+		if err != nil {
+			return err
+		}
+		listSize = len(words)
+	}
+	return printCodeInfo(os.Stdout, args[0], listSize, ttl, codeInfoRate)
+}
+
+// printCodeInfo describes the strength of code. listSize is the size of the
+// list the words came from, or 0 for the built-in lists.
+func printCodeInfo(w io.Writer, code string, listSize int, ttl time.Duration, rate int) error {
+	code = strings.TrimSpace(code)
+	codeID, passphrase := code, ""
+	if strings.Contains(code, crypto.CodeSep) {
+		var err error
+		if codeID, passphrase, err = crypto.ParseCode(code); err != nil {
+			return err
+		}
+	}
+
+	idBits := float64(len(codeID)) * math.Log2(62)
+	fmt.Fprintf(w, "Code ID:      %d characters, %.1f bits\n", len(codeID), idBits)
+
+	var words []string
+	var wordBits float64
+	if passphrase == "" {
+		fmt.Fprintf(w, "Passphrase:   not in the code; chosen by the sender, so its strength is unknown\n")
+	} else {
+		words = strings.Split(passphrase, crypto.PassphraseSep)
+		builtIn := listSize == 0
+		if builtIn {
+			listSize = builtInListSize(words)
+		}
+		wordBits = wordlist.Entropy(listSize, len(words))
+		fmt.Fprintf(w, "Passphrase:   %d words from a list of %d, %.1f bits\n", len(words), listSize, wordBits)
+		if builtIn && slices.ContainsFunc(words, func(word string) bool { return !wordlist.Contains(word) }) {
+			fmt.Fprintf(w, "              some words aren't in a built-in list; use --wordlist to count the list they came from\n")
+		}
+		fmt.Fprintf(w, "Total:        %.1f bits\n", idBits+wordBits)
+	}
+
+	// One-time use and the TTL bound an online attack to one window
+	guesses := float64(rate) * ttl.Seconds()
+	chance := guesses / math.Pow(62, float64(len(codeID)))
+	fmt.Fprintf(w, "\nOnline:       %.0f guesses at %d/s over a %s TTL find the code ID with a chance of %.2g\n", guesses, rate, ttl, min(chance, 1))
+	if passphrase != "" {
+		combinations := new(big.Int).Exp(big.NewInt(int64(listSize)), big.NewInt(int64(len(words))), nil)
+		fmt.Fprintf(w, "Offline:      %s passphrases to try for anyone holding the blob; %s\n", combinations, entropyStrength(wordBits))
+		fmt.Fprintf(w, "              each try is instant unless the patch was sent with --kdf argon2id\n")
+	}
+	return nil
+}
+
+// builtInListSize returns the size of the smallest built-in list holding all
+// of words, e.g. 256 for German words, or the default list's size if none
+// does.
+func builtInListSize(words []string) int {
+	size := 0
+	for _, lang := range wordlist.Languages() {
+		list, err := wordlist.ForLang(lang)
+		if err != nil || (size != 0 && len(list) >= size) {
+			continue
+		}
+		if !slices.ContainsFunc(words, func(word string) bool { return !slices.Contains(list, word) }) {
+			size = len(list)
+		}
+	}
+	if size == 0 {
+		return wordlist.Len()
+	}
+	return size
+}
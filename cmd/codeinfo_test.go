@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/wordlist"
+)
+
+func TestPrintCodeInfo(t *testing.T) {
+	builtIn := "k7Xm9pQ2wR-" + strings.Join(wordlist.Words[:4], "-")
+	german, err := wordlist.ForLang("de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		code     string
+		listSize int
+		ttl      time.Duration
+		rate     int
+		want     []string
+		dontWant []string
+	}{
+		{
+			name: "generated code",
+			code: builtIn, ttl: time.Hour, rate: 1000,
+			want: []string{
+				"Code ID:      10 characters, 59.5 bits",
+				"Passphrase:   4 words from a list of 1024, 40.0 bits",
+				"Total:        99.5 bits",
+				"3600000 guesses at 1000/s over a 1h0m0s TTL find the code ID with a chance of 4.3e-12",
+				"Offline:      1099511627776 passphrases",
+			},
+			dontWant: []string{"aren't in a built-in list"},
+		},
+		{
+			name: "words from a smaller built-in list",
+			code: "k7Xm9pQ2wR-" + strings.Join(german[:4], "-"), ttl: time.Hour, rate: 1000,
+			want:     []string{"from a list of 256, 32.0 bits", "Offline:      4294967296 passphrases"},
+			dontWant: []string{"aren't in a built-in list"},
+		},
+		{
+			name: "words from another list",
+			code: "k7Xm9pQ2wR-zzz-yyy-xxx-www", ttl: time.Hour, rate: 1000,
+			want: []string{"aren't in a built-in list"},
+		},
+		{
+			name: "custom list size",
+			code: "k7Xm9pQ2wR-zzz-yyy-xxx-www", listSize: 1024, ttl: time.Hour, rate: 1000,
+			want:     []string{"from a list of 1024, 40.0 bits", "Total:        99.5 bits"},
+			dontWant: []string{"aren't in a built-in list"},
+		},
+		{
+			name: "code ID only",
+			code: "k7Xm9pQ2wR", ttl: 30 * time.Minute, rate: 100,
+			want:     []string{"not in the code", "180000 guesses at 100/s over a 30m0s TTL"},
+			dontWant: []string{"Offline", "Total"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			if err := printCodeInfo(out, tt.code, tt.listSize, tt.ttl, tt.rate); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output missing %q\nGOT:\n%s", want, out.String())
+				}
+			}
+			for _, dontWant := range tt.dontWant {
+				if strings.Contains(out.String(), dontWant) {
+					t.Errorf("output has %q\nGOT:\n%s", dontWant, out.String())
+				}
+			}
+		})
+	}
+
+	if err := printCodeInfo(&bytes.Buffer{}, "k7Xm9pQ2wR-one-two", 0, time.Hour, 1000); err == nil {
+		t.Error("expected an error for a code with too few words")
+	}
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/internal/config"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show the effective settings and where each one comes from",
+	Long: `Show the settings that can be configured outside the command line,
+their effective values, and where each value comes from.
+
+A setting is taken from the first of:
+  1. its flag, e.g. --server
+  2. the environment, e.g. GIT_SHARE_SERVER or GIT_SHARE_ALLOW_REF_PATTERN
+  3. git config, e.g. git config git-share.server <url>
+  4. the config file, ~/.config/git-share/config.yaml
+     ($XDG_CONFIG_HOME/git-share/config.yaml if that is set)
+  5. the built-in default
+
+The value of token, a relay's auth token, is not shown.
+
+The config file holds "key: value" lines:
+  server: https://relay.example.com
+  ttl: 30m`,
+	Args: cobra.NoArgs,
+	RunE: runConfig,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+}
+
+func runConfig(cmd *cobra.Command, args []string) error {
+	sources, err := configSources()
+	if err != nil {
+		return err
+	}
+	if path, err := config.Path(); err == nil {
+		status := ""
+		if _, err := os.Stat(path); err != nil {
+			status = " (not found)"
+		}
+		fmt.Fprintf(os.Stdout, "Config file: %s%s\n\n", path, status)
+	}
+	return writeConfig(os.Stdout, cmd, sources)
+}
+
+// writeConfig lists each configurable flag's effective value and its source.
+func writeConfig(w io.Writer, cmd *cobra.Command, sources []configSource) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "SETTING\tVALUE\tFROM\n")
+	for _, name := range configFlags {
+		value, from := configuredValue(sources, name)
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			value, from = flag.Value.String(), "--"+name+" flag"
+		}
+		if from == "" {
+			value, from = flagDefault(name), "default"
+		}
+		if name == "token" && value != "" {
+			value = "(hidden)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, value, from)
+	}
+	return tw.Flush()
+}
+
+// flagDefault returns the built-in default of a configurable flag.
+func flagDefault(name string) string {
+	for _, c := range []*cobra.Command{rootCmd, sendCmd} {
+		if flag := c.Flags().Lookup(name); flag != nil {
+			return flag.DefValue
+		}
+		if flag := c.PersistentFlags().Lookup(name); flag != nil {
+			return flag.DefValue
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/internal/git"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that git-share can send and receive from here",
+	Long: `Check the things git-share needs and print what is wrong with a hint on
+fixing it: that git is installed, that the current directory is in a git
+repository, and that the relay answers. It exits non-zero if git is missing
+or the relay can't be reached; not being in a repository is only a warning,
+since send and receive can be run from one later.
+
+Examples:
+  git-share doctor
+  git-share doctor --server https://my-relay.example.com`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// doctorDeps is what doctor checks.
+type doctorDeps interface {
+	GitVersion() (string, error)
+	FindRepoRoot() (string, error)
+	RelayHealth() (blobs int, err error)
+}
+
+type realDoctorDeps struct{}
+
+func (d realDoctorDeps) GitVersion() (string, error)   { return git.Version() }
+func (d realDoctorDeps) FindRepoRoot() (string, error) { return git.FindRepoRoot() }
+func (d realDoctorDeps) RelayHealth() (int, error)     { return newClient().Health() }
+
+// doctorCheck is the outcome of one check.
+type doctorCheck struct {
+	Name     string
+	Err      error
+	Detail   string // what was found, when the check passed
+	Hint     string // how to fix it, when it failed
+	Critical bool   // a failure means git-share can't work
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	return writeDoctor(os.Stdout, runDoctorChecks(realDoctorDeps{}, serverURL))
+}
+
+// runDoctorChecks runs every check in the order they are printed.
+func runDoctorChecks(deps doctorDeps, server string) []doctorCheck {
+	var checks []doctorCheck
+
+	version, err := deps.GitVersion()
+	checks = append(checks, doctorCheck{
+		Name: "git", Err: err, Detail: version, Critical: true,
+		Hint: "install git from https://git-scm.com and make sure it is on your PATH",
+	})
+
+	root, err := deps.FindRepoRoot()
+	checks = append(checks, doctorCheck{
+		Name: "git repository", Err: err, Detail: root,
+		Hint: "run git-share inside the repository to share from or apply to, or create one with git init",
+	})
+
+	blobs, err := deps.RelayHealth()
+	checks = append(checks, doctorCheck{
+		Name: "relay " + server, Err: err, Detail: fmt.Sprintf("reachable, holding %d patch(es)", blobs), Critical: true,
+		Hint: "check the address and your network, or pick another relay with --server (or $GIT_SHARE_SERVER); behind a proxy, set --proxy",
+	})
+	return checks
+}
+
+// writeDoctor prints the checks as a checklist, and returns an error if a
+// critical one failed.
+func writeDoctor(w io.Writer, checks []doctorCheck) error {
+	failed := 0
+	for _, c := range checks {
+		switch {
+		case c.Err == nil:
+			fmt.Fprintf(w, "ok    %s: %s\n", c.Name, c.Detail)
+			continue
+		case c.Critical:
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", c.Name, c.Err)
+		default:
+			fmt.Fprintf(w, "warn  %s: %v\n", c.Name, c.Err)
+		}
+		fmt.Fprintf(w, "      %s\n", c.Hint)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
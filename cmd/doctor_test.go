@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type mockDoctorDeps struct {
+	gitErr, repoErr, relayErr error
+}
+
+func (m mockDoctorDeps) GitVersion() (string, error)   { return "2.43.0", m.gitErr }
+func (m mockDoctorDeps) FindRepoRoot() (string, error) { return "/repo", m.repoErr }
+func (m mockDoctorDeps) RelayHealth() (int, error)     { return 2, m.relayErr }
+
+func TestDoctor(t *testing.T) {
+	tests := []struct {
+		name    string
+		deps    mockDoctorDeps
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "all good",
+			want: []string{"ok    git: 2.43.0", "ok    git repository: /repo", "ok    relay https://relay.example.com: reachable, holding 2 patch(es)"},
+		},
+		{
+			name: "outside a repository",
+			deps: mockDoctorDeps{repoErr: errors.New("not a git repository")},
+			want: []string{"warn  git repository: not a git repository", "      run git-share inside"},
+		},
+		{
+			name:    "no git",
+			deps:    mockDoctorDeps{gitErr: errors.New(`exec: "git": executable file not found in $PATH`)},
+			want:    []string{"FAIL  git: exec:", "      install git"},
+			wantErr: true,
+		},
+		{
+			name:    "relay down",
+			deps:    mockDoctorDeps{relayErr: errors.New("connection refused")},
+			want:    []string{"FAIL  relay https://relay.example.com: connection refused", "--server"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := writeDoctor(&out, runDoctorChecks(tt.deps, "https://relay.example.com"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output is missing %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrintEntropy(t *testing.T) {
+	// 1024 distinct words in diceware format, plus noise Load should skip
+	var list strings.Builder
+	list.WriteString("# custom list\n\n")
+	for i := 0; i < 1024; i++ {
+		fmt.Fprintf(&list, "%05d\tword%d\n", i, i)
+	}
+	list.WriteString("00000\tword0\n")
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte(list.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		words int
+		want  []string
+	}{
+		{
+			name:  "default list",
+			words: 4,
+			want:  []string{"built-in (1024 words)", "Combinations: 1099511627776 (1024^4)", "Entropy:      40.0 bits", "Strength:     strong"},
+		},
+		{
+			name:  "custom larger list",
+			path:  path,
+			words: 6,
+			want:  []string{"(1024 words)", "Combinations: 1152921504606846976 (1024^6)", "Entropy:      60.0 bits", "Strength:     strong"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			if err := printEntropy(out, tt.path, tt.words); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output missing %q\nGOT:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+
+	if err := printEntropy(&bytes.Buffer{}, path, 0); err == nil {
+		t.Error("expected an error for --words 0")
+	}
+	if err := printEntropy(&bytes.Buffer{}, filepath.Join(t.TempDir(), "missing"), 4); err == nil {
+		t.Error("expected an error for a missing wordlist")
+	}
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/internal/config"
+	"github.com/flawiddsouza/git-share/internal/crypto"
+)
+
+var keygenForce bool
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen [path]",
+	Short: "Create a key pair for signing patches",
+	Long: `Create an Ed25519 key pair for signing the patches you send, so receivers
+can check they came from you. The private key is written to path (default
+~/.config/git-share/id) and the public key to path.pub.
+
+Sign with "git-share send --sign <path>". Give receivers the public key
+printed here; "git-share receive --verify <public key or .pub file>" then
+refuses patches that aren't signed by it.
+
+Examples:
+  git-share keygen                     # ~/.config/git-share/id and id.pub
+  git-share keygen ~/.ssh/git-share    # somewhere else`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runKeygen,
+}
+
+func init() {
+	keygenCmd.Flags().BoolVar(&keygenForce, "force", false, "overwrite an existing key")
+	rootCmd.AddCommand(keygenCmd)
+}
+
+func runKeygen(cmd *cobra.Command, args []string) error {
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		var err error
+		if path, err = defaultKeyPath(); err != nil {
+			return err
+		}
+	}
+	return writeKeyPair(os.Stdout, os.Stderr, path, keygenForce)
+}
+
+// defaultKeyPath is where keygen writes the private key unless told
+// otherwise.
+func defaultKeyPath() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "id"), nil
+}
+
+// writeKeyPair creates a signing key at path and its public key at
+// path.pub, and prints the public key to stdout.
+func writeKeyPair(stdout, stderr io.Writer, path string, force bool) error {
+	pubPath := path + ".pub"
+	if !force {
+		for _, p := range []string{path, pubPath} {
+			if _, err := os.Stat(p); err == nil {
+				return fmt.Errorf("%s already exists; pass --force to replace it", p)
+			}
+		}
+	}
+
+	pub, priv, err := crypto.GenerateSigningKey()
+	if err != nil {
+		return err
+	}
+	privPEM, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("creating key directory: %w", err)
+	}
+	if err := os.WriteFile(path, privPEM, 0o600); err != nil {
+		return fmt.Errorf("writing private key: %w", err)
+	}
+	line := crypto.FormatPublicKey(pub)
+	if err := os.WriteFile(pubPath, []byte(line+"\n"), 0o644); err != nil {
+		return fmt.Errorf("writing public key: %w", err)
+	}
+
+	fmt.Fprintf(stderr, "Private key: %s (keep it secret)\n", path)
+	fmt.Fprintf(stderr, "Public key:  %s\n", pubPath)
+	fmt.Fprintf(stderr, "Fingerprint: %s\n\n", crypto.KeyFingerprint(pub))
+	fmt.Fprintf(stderr, "Give receivers this public key to pass to receive --verify:\n")
+	fmt.Fprintln(stdout, line)
+	return nil
+}
+
+// loadSigningKey reads a private key written by keygen.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	priv, err := crypto.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return priv, nil
+}
+
+// loadTrustedKeys parses the public keys given to receive --verify, each
+// either a key as keygen prints it or a file holding one.
+func loadTrustedKeys(values []string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, v := range values {
+		pub, err := crypto.ParsePublicKey(v)
+		if err != nil {
+			data, readErr := os.ReadFile(v)
+			if readErr != nil {
+				if errors.Is(readErr, os.ErrNotExist) {
+					return nil, fmt.Errorf("--verify %q: %w", v, err)
+				}
+				return nil, fmt.Errorf("reading public key: %w", readErr)
+			}
+			if pub, err = crypto.ParsePublicKey(string(data)); err != nil {
+				return nil, fmt.Errorf("%s: %w", v, err)
+			}
+		}
+		keys = append(keys, pub)
+	}
+	return keys, nil
+}
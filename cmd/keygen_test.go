@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteKeyPair(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "id")
+	var stdout bytes.Buffer
+	if err := writeKeyPair(&stdout, &bytes.Buffer{}, path, false); err != nil {
+		t.Fatalf("writeKeyPair failed: %v", err)
+	}
+
+	priv, err := loadSigningKey(path)
+	if err != nil {
+		t.Fatalf("loadSigningKey failed: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("private key mode = %v, want 0600", info.Mode().Perm())
+	}
+	want := priv.Public().(ed25519.PublicKey)
+
+	// The printed key and the .pub file both work with --verify
+	line := strings.TrimSpace(stdout.String())
+	keys, err := loadTrustedKeys([]string{line, path + ".pub"})
+	if err != nil {
+		t.Fatalf("loadTrustedKeys failed: %v", err)
+	}
+	for _, k := range keys {
+		if !k.Equal(want) {
+			t.Errorf("trusted key %x, want %x", k, want)
+		}
+	}
+
+	if err := writeKeyPair(&bytes.Buffer{}, &bytes.Buffer{}, path, false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected an existing key to be kept, got %v", err)
+	}
+	if err := writeKeyPair(&bytes.Buffer{}, &bytes.Buffer{}, path, true); err != nil {
+		t.Errorf("--force failed: %v", err)
+	}
+	if _, err := loadTrustedKeys([]string{filepath.Join(t.TempDir(), "missing.pub")}); err == nil {
+		t.Error("expected an error for a missing key file")
+	}
+	if _, err := loadSigningKey(path + ".pub"); err == nil {
+		t.Error("expected an error loading a public key as the signing key")
+	}
+}
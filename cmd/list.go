@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/internal/ledger"
+)
+
+var listPrune bool
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the codes you have sent",
+	Long: `List the codes sent from this machine, newest last, with when each one
+expires. Codes past their TTL are marked "likely expired"; a code may also be
+gone sooner, once it has been received.
+
+The list is kept in ~/.local/state/git-share/sent.json ($XDG_STATE_HOME/
+git-share/sent.json if that is set). It holds the codes and their times,
+never the patches. Blobs written by "git-share save" are not listed.
+
+Examples:
+  git-share list            # show sent codes
+  git-share list --prune    # forget the expired ones first`,
+	Args: cobra.NoArgs,
+	RunE: runList,
+}
+
+func init() {
+	listCmd.Flags().BoolVar(&listPrune, "prune", false, "remove expired codes from the list")
+	rootCmd.AddCommand(listCmd)
+}
+
+func runList(cmd *cobra.Command, args []string) error {
+	path, err := ledger.Path()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if listPrune {
+		n, err := ledger.Prune(path, now)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Removed %d expired code(s).\n", n)
+	}
+	entries, err := ledger.Load(path)
+	if err != nil {
+		return err
+	}
+	return writeList(os.Stdout, entries, now)
+}
+
+// writeList prints the sent codes as a table, marking those past their TTL.
+func writeList(w io.Writer, entries []ledger.Entry, now time.Time) error {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No sent codes.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "CODE\tSPACE\tSENT\tEXPIRES\tSTATUS\n")
+	for _, e := range entries {
+		space := e.Space
+		if space == "" {
+			space = "-"
+		}
+		status := "live"
+		if e.Expired(now) {
+			status = "likely expired"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Code, space,
+			e.Created.Local().Format(time.DateTime), e.Expires.Local().Format(time.DateTime), status)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/ledger"
+)
+
+func TestWriteList(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := []ledger.Entry{
+		{Code: "old-alpha-bravo", CodeID: "old", Created: now.Add(-2 * time.Hour), Expires: now.Add(-time.Hour)},
+		{Code: "new-charlie-delta", CodeID: "new", Space: "team", Created: now, Expires: now.Add(time.Hour)},
+	}
+	var out bytes.Buffer
+	if err := writeList(&out, entries, now); err != nil {
+		t.Fatalf("writeList failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want a header and two codes:\n%s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[1], "old-alpha-bravo") || !strings.HasSuffix(lines[1], "likely expired") {
+		t.Errorf("expired code line = %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "team") || !strings.HasSuffix(lines[2], "live") {
+		t.Errorf("live code line = %q", lines[2])
+	}
+
+	out.Reset()
+	writeList(&out, nil, now)
+	if !strings.Contains(out.String(), "No sent codes") {
+		t.Errorf("empty list printed %q", out.String())
+	}
+}
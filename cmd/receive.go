@@ -1,93 +1,211 @@
-package cmd
-
-import (
-	"encoding/base64"
-	"fmt"
-	"os"
-	"strings"
-
-	"github.com/spf13/cobra"
-
-	"github.com/flawiddsouza/git-share/internal/client"
-	"github.com/flawiddsouza/git-share/internal/crypto"
-	"github.com/flawiddsouza/git-share/internal/git"
-)
-
-var (
-	receiveCommit bool
-)
-
-var receiveCmd = &cobra.Command{
-	Use:   "receive <code>",
-	Short: "Download, decrypt, and apply a git patch",
-	Long: `Download an encrypted patch from the relay server, decrypt it
-using the embedded passphrase, and apply it to the current repository.
-
-The code is the full string output by the sender, e.g.:
-  git-share receive k7Xm9pQ2wR-alpha-bravo-charlie-delta`,
-	Args: cobra.MinimumNArgs(1),
-	RunE: runReceive,
-}
-
-func init() {
-	receiveCmd.Flags().BoolVar(&receiveCommit, "commit", false, "apply as a commit (cherry-pick style)")
-	rootCmd.AddCommand(receiveCmd)
-}
-
-func runReceive(cmd *cobra.Command, args []string) error {
-	// Support both "code" as single arg and "codeId word1-word2-word3-word4" as two args
-	code := strings.Join(args, "-")
-
-	// 1. Parse the combined code
-	codeID, passphrase, err := crypto.ParseCode(code)
-	if err != nil {
-		return err
-	}
-
-	// 2. Make sure we're in a git repo
-	_, err = git.FindRepoRoot()
-	if err != nil {
-		return err
-	}
-
-	// 3. Download from relay server
-	fmt.Fprintf(os.Stderr, "Downloading patch...\n")
-	c := client.New(serverURL)
-	encodedData, err := c.Receive(codeID)
-	if err != nil {
-		return err
-	}
-
-	// 4. Decode base64
-	encrypted, err := base64.StdEncoding.DecodeString(encodedData)
-	if err != nil {
-		return fmt.Errorf("decoding data: %w", err)
-	}
-
-	// 5. Derive key and decrypt
-	fmt.Fprintf(os.Stderr, "Decrypting...\n")
-	key, err := crypto.DeriveKey(passphrase)
-	if err != nil {
-		return fmt.Errorf("deriving key: %w", err)
-	}
-
-	patch, err := crypto.Decrypt(encrypted, key)
-	if err != nil {
-		return err
-	}
-
-	// 6. Apply the patch
-	fmt.Fprintf(os.Stderr, "Applying patch...\n")
-	if err := git.ApplyPatch(patch, receiveCommit); err != nil {
-		return err
-	}
-
-	// 7. Show stats
-	stats, _ := git.PatchStats(patch)
-	fmt.Fprintf(os.Stderr, "\nPatch applied successfully.\n")
-	if stats != "" {
-		fmt.Fprintf(os.Stderr, "\n%s\n", stats)
-	}
-
-	return nil
-}
+package cmd
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/gitshare"
+	"github.com/flawiddsouza/git-share/internal/ui"
+)
+
+var (
+	receiveCommit        bool
+	receiveReview        bool
+	receiveNoColor       bool
+	receiveNotes         bool
+	receiveFiles         bool
+	receivePass          string
+	receiveAskPass       bool
+	receivePeek          bool
+	receiveVerify        []string
+	receiveStdout        bool
+	receiveJSON          bool
+	receiveSignoff       bool
+	receiveNoApply       bool
+	receiveOutput        string
+	receiveThen          string
+	receiveYes           bool
+	receiveOutside       bool
+	receiveWait          time.Duration
+	receiveFormat        string
+	receiveSummaryFormat string
+	receiveKeepAuthor    bool
+	receiveSelectHunks   bool
+	receiveDryRun        bool
+	receiveThreeWay      bool
+	receiveStrictBase    bool
+	receiveAutocorrect   bool
+)
+
+var receiveCmd = &cobra.Command{
+	Use:     "receive <code>",
+	Aliases: []string{"r", "get"},
+	Short:   "Download, decrypt, and apply a git patch",
+	Long: `Download an encrypted patch from the relay server, decrypt it
+using the embedded passphrase, and apply it to the current repository.
+
+The code is the full string output by the sender, e.g.:
+  git-share receive k7Xm9pQ2wR-alpha-bravo-charlie-delta
+
+The words may also be passed as separate arguments, and a pasted
+"git-share receive" prefix is ignored.
+
+A commit is applied to the working tree unless you pass --commit, which
+applies it with git am. --keep-author is a middle ground: the commit is
+applied with git apply, then only its files are committed with the original
+author, date, and message. Other staged changes are left out of that commit.
+
+With --review the patch is shown (through $PAGER on a terminal) and you are
+asked before it is applied. The patch is consumed on the relay either way; if
+you decline, it is saved to a temporary file so it is not lost.
+Patches over 5MB are summarized and confirmed the same way unless you pass
+--yes.
+
+With --interactive you are asked about each hunk (y to apply it, n to skip
+it, q to stop and apply what you chose so far), and only the chosen hunks are
+applied. Binary files are applied whole or not at all.
+
+If the sender chose their own passphrase, pass just the code ID along with
+--passphrase.
+
+To keep the code ID and the passphrase on separate channels, pass just the
+code ID with --ask-passphrase. The patch is downloaded, then the words are
+asked for on the terminal without echoing them (even if stdin is piped). A
+mistyped passphrase can be retried a few times, since the relay has already
+deleted the patch.
+
+With --autocorrect, a passphrase that fails to decrypt the patch is retried
+with one word replaced by a built-in wordlist word one typo away from it
+(a letter added, dropped, or changed), and the correction is reported. Only
+a single mistyped word can be fixed, and only a limited number of candidates
+are tried.
+
+With --peek the patch is downloaded without being deleted from the relay,
+so a receive that fails afterwards (say, in decryption or applying) can be
+retried with the same code. This bypasses one-time use, and only works on a
+relay started with --allow-peek. Receive without --peek to delete the patch.
+
+With --verify the patch must be signed (send --sign) by one of the given
+public keys, each as printed by git-share keygen or a .pub file holding one;
+anything else is refused before it is applied. Without --verify, a signed
+patch's signature is still checked and its key's fingerprint shown.
+
+With --no-apply the patch is only downloaded and decrypted, and git is not
+touched, so this works outside a repository. --output saves the patch to a
+file ("-" for stdout) the same way, e.g. to archive it, read it in an
+editor, or apply it to another checkout later with git-share apply.
+
+With --3way a patch that doesn't apply cleanly is merged using the base
+blobs it records, if they exist in your repository. Conflicting hunks are
+left as conflict markers in the working tree for you to resolve; with
+--commit the git am session is left in progress for 'git am --continue'.
+
+Uncommitted changes are sent along with the commit they were made on. If
+your HEAD is a different commit, a warning is printed before applying, since
+the patch may apply only in part or land on the wrong branch; with
+--strict-base the patch is refused instead.
+
+With --dry-run the patch is downloaded and decrypted, its diffstat is
+printed to stderr and the full diff to stdout, and nothing is applied. The
+relay deletes a patch once it is received, so the code can't be used again
+afterwards; save the patch with --no-apply --output if you may want it.
+
+With --format github-suggestion the patch is not applied; a small
+single-file patch is printed as suggestion blocks to paste into a GitHub
+pull request review. Other patches are printed as is.
+
+With --then, a command is run through the shell after the patch is applied,
+e.g. to run the tests. Its output is streamed and its exit code becomes
+git-share's exit code. It is not run if the patch fails to apply.
+
+Connection errors and relay server errors are retried --retries times,
+waiting --retry-delay and then twice as long each time. "Not found" is never
+retried, since the patch may have just been received by someone else.
+
+--summary-format controls what is printed once the patch is applied: the
+usual message and diffstat ("text"), a JSON summary on stdout ("json"), or
+nothing at all ("none"), for tools that show their own result. Progress
+messages still go to stderr; --json silences those too.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runReceive,
+}
+
+func init() {
+	receiveCmd.Flags().BoolVar(&receiveCommit, "commit", false, "apply as a commit (cherry-pick style)")
+	receiveCmd.Flags().BoolVar(&receiveSignoff, "signoff", false, "with --commit, add your Signed-off-by trailer to applied commits")
+	receiveCmd.Flags().BoolVar(&receiveNotes, "with-notes", false, "with --commit, attach the sender's git notes to the applied commit")
+	receiveCmd.Flags().BoolVar(&receiveKeepAuthor, "keep-author", false, "after applying a commit to the working tree, commit it with its original author and date")
+	receiveCmd.Flags().BoolVar(&receiveSelectHunks, "interactive", false, "choose which hunks to apply, like git add -p")
+	receiveCmd.Flags().BoolVar(&receiveReview, "review", false, "show a colorized diff and ask before applying")
+	receiveCmd.Flags().BoolVar(&receiveNoColor, "no-color", false, "disable colored output")
+	receiveCmd.Flags().StringVar(&receivePass, "passphrase", "", "passphrase chosen by the sender; the code is then just the code ID")
+	receiveCmd.Flags().BoolVar(&receiveAskPass, "ask-passphrase", false, "pass just the code ID and type the passphrase words at a hidden prompt")
+	receiveCmd.MarkFlagsMutuallyExclusive("passphrase", "ask-passphrase")
+	receiveCmd.Flags().BoolVar(&receiveJSON, "json", false, "print only a JSON result to stdout")
+	receiveCmd.Flags().StringVar(&receiveSummaryFormat, "summary-format", gitshare.SummaryText, "what to print after applying: text, json, or none")
+	receiveCmd.Flags().BoolVar(&receiveStdout, "stdout-messages", false, "write status messages and a JSON summary to stdout instead of stderr")
+	receiveCmd.Flags().BoolVar(&receiveThreeWay, "3way", false, "fall back to a three-way merge, leaving conflict markers instead of failing")
+	receiveCmd.Flags().BoolVar(&receiveDryRun, "dry-run", false, "print the diffstat and full diff without applying (the code is still used up)")
+	receiveCmd.Flags().StringArrayVar(&receiveVerify, "verify", nil, "only accept a patch signed by this public key or .pub file (repeatable, for several trusted senders)")
+	receiveCmd.Flags().BoolVar(&receiveAutocorrect, "autocorrect", false, "if decryption fails, retry with one mistyped passphrase word corrected")
+	receiveCmd.Flags().BoolVar(&receiveStrictBase, "strict-base", false, "refuse uncommitted changes made on a different commit than your HEAD, instead of warning")
+	receiveCmd.Flags().BoolVar(&receivePeek, "peek", false, "download without deleting the patch from the relay, to retry a failed receive (bypasses one-time use; needs serve --allow-peek)")
+	receiveCmd.Flags().BoolVar(&receiveNoApply, "no-apply", false, "only download and decrypt; don't touch git")
+	receiveCmd.Flags().StringVarP(&receiveOutput, "output", "o", "", "write the patch to this file (\"-\" for stdout) instead of applying it; implies --no-apply")
+	receiveCmd.Flags().StringVar(&receiveThen, "then", "", "run this shell command after a successful apply and exit with its status")
+	receiveCmd.Flags().StringVar(&receiveFormat, "format", "", "print the patch instead of applying it; \"github-suggestion\" for PR suggestion blocks")
+	addTransferFlags(receiveCmd)
+	receiveCmd.Flags().DurationVar(&receiveWait, "wait", 0, "if the patch isn't uploaded yet, keep checking for this long (e.g. 30s)")
+	receiveCmd.Flags().BoolVar(&receiveOutside, "allow-outside", false, "let the patch write files outside the repository (git apply --unsafe-paths)")
+	receiveCmd.Flags().BoolVarP(&receiveYes, "yes", "y", false, "do not ask for confirmation before applying a large patch")
+	receiveCmd.Flags().BoolVar(&receiveFiles, "files-only", false, "list the changed file paths instead of the diffstat")
+	rootCmd.AddCommand(receiveCmd)
+}
+
+func runReceive(cmd *cobra.Command, args []string) error {
+	opts := gitshare.ReceiveOptions{
+		Code:           strings.Join(args, " "),
+		Server:         serverURL,
+		Space:          space,
+		Commit:         receiveCommit,
+		Signoff:        receiveSignoff,
+		Notes:          receiveNotes,
+		KeepAuthor:     receiveKeepAuthor,
+		Review:         receiveReview,
+		SelectHunks:    receiveSelectHunks,
+		DryRun:         receiveDryRun,
+		Color:          ui.UseColor(os.Stdout, receiveNoColor),
+		Files:          receiveFiles,
+		Passphrase:     receivePass,
+		AskPassphrase:  receiveAskPass,
+		Peek:           receivePeek,
+		StrictBase:     receiveStrictBase,
+		Autocorrect:    receiveAutocorrect,
+		StdoutMessages: receiveStdout,
+		JSON:           receiveJSON,
+		SummaryFormat:  receiveSummaryFormat,
+		NoApply:        receiveNoApply,
+		Output:         receiveOutput,
+		Then:           receiveThen,
+		Yes:            receiveYes,
+		AllowOutside:   receiveOutside,
+		ThreeWay:       receiveThreeWay,
+		Wait:           receiveWait,
+		Format:         receiveFormat,
+		Interactive:    ui.IsTerminal(os.Stdin) && ui.IsTerminal(os.Stderr),
+		Stdin:          os.Stdin,
+
+		Connection: connection(),
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+	}
+	trusted, err := loadTrustedKeys(receiveVerify)
+	if err != nil {
+		return err
+	}
+	opts.Trusted = trusted
+	return gitshare.Receive(opts)
+}
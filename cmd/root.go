@@ -1,41 +1,269 @@
-package cmd
-
-import (
-	"fmt"
-	"os"
-
-	"github.com/spf13/cobra"
-)
-
-const (
-	defaultServer = "https://git-share.artelin.dev"
-)
-
-var serverURL string
-
-var rootCmd = &cobra.Command{
-	Use:   "git-share",
-	Short: "Securely share git patches with E2E encryption",
-	Long: `git-share is a CLI tool for sharing git patches securely.
-
-It encrypts your changes with a one-time passphrase, uploads the encrypted
-blob to a relay server, and gives you a code to share. The receiver uses
-the code to download, decrypt, and apply the patch. The patch is destroyed
-after a single use.
-
-Think of it as "croc" but specifically for git patches.`,
-	SilenceErrors: true,
-	SilenceUsage:  true,
-}
-
-func init() {
-	rootCmd.PersistentFlags().StringVar(&serverURL, "server", defaultServer, "relay server URL")
-}
-
-// Execute runs the root command.
-func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
-}
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/gitshare"
+	"github.com/flawiddsouza/git-share/internal/client"
+	"github.com/flawiddsouza/git-share/internal/config"
+	"github.com/flawiddsouza/git-share/internal/git"
+	"github.com/flawiddsouza/git-share/internal/ui"
+)
+
+const (
+	defaultServer = gitshare.DefaultServer
+)
+
+var (
+	serverURL  string
+	space      string
+	retries    int
+	retryDelay time.Duration
+	quiet      bool
+
+	proxy              string
+	proxyURL           *url.URL // parsed from proxy
+	insecureSkipVerify bool
+
+	token string
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "git-share",
+	Short: "Securely share git patches with E2E encryption",
+	Long: `git-share is a CLI tool for sharing git patches securely.
+
+It encrypts your changes with a one-time passphrase, uploads the encrypted
+blob to a relay server, and gives you a code to share. The receiver uses
+the code to download, decrypt, and apply the patch. The patch is destroyed
+after a single use.
+
+Think of it as "croc" but specifically for git patches.`,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		sources, err := configSources()
+		if err != nil {
+			return err
+		}
+		if err := applyConfigDefaults(cmd, sources); err != nil {
+			return err
+		}
+		return checkConnectionFlags(os.Stderr)
+	},
+}
+
+// configFlags are the flags that can be set outside the command line, e.g.
+// "git config git-share.server https://relay.example.com".
+var configFlags = []string{"server", "space", "proxy", "ttl", "allow-ref-pattern", "token"}
+
+// noConfigAnnotation marks a flag that shares a name in configFlags but
+// means something else, so configured values are not applied to it.
+const noConfigAnnotation = "git-share-no-config"
+
+// configSource is a place flag values can be configured.
+type configSource struct {
+	// describe says where a flag's value comes from, e.g. "$GIT_SHARE_SERVER"
+	describe func(flag string) string
+	// lookup returns a flag's value, or "" if it is not set here
+	lookup func(flag string) (string, error)
+}
+
+// envSource reads GIT_SHARE_<FLAG> environment variables, e.g.
+// GIT_SHARE_ALLOW_REF_PATTERN for --allow-ref-pattern.
+func envSource(getenv func(string) string) configSource {
+	name := func(flag string) string {
+		return "GIT_SHARE_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+	}
+	return configSource{
+		describe: func(flag string) string { return "$" + name(flag) },
+		lookup:   func(flag string) (string, error) { return getenv(name(flag)), nil },
+	}
+}
+
+// gitConfigSource reads "git-share.<flag>" from git config, so a repo can
+// pin its team's relay.
+func gitConfigSource(lookup func(key string) (string, error)) configSource {
+	return configSource{
+		describe: func(flag string) string { return "git config git-share." + flag },
+		lookup:   func(flag string) (string, error) { return lookup("git-share." + flag) },
+	}
+}
+
+// fileSource reads settings loaded from the config file at path.
+func fileSource(path string, values map[string]string) (configSource, error) {
+	for key := range values {
+		if !slices.Contains(configFlags, key) {
+			return configSource{}, fmt.Errorf("%s: unknown setting %q; the settings are %s", path, key, strings.Join(configFlags, ", "))
+		}
+	}
+	return configSource{
+		describe: func(string) string { return path },
+		lookup:   func(flag string) (string, error) { return values[flag], nil },
+	}, nil
+}
+
+// configSources returns where flag values are configured, most specific
+// first: the environment, git config, then the config file.
+func configSources() ([]configSource, error) {
+	sources := []configSource{envSource(os.Getenv), gitConfigSource(git.ConfigValue)}
+	path, err := config.Path()
+	if err != nil {
+		return sources, nil
+	}
+	values, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fileSource(path, values)
+	if err != nil {
+		return nil, err
+	}
+	return append(sources, file), nil
+}
+
+// configuredValue returns a flag's value from the first source that sets
+// it, and that source's description. It returns "" if none does.
+func configuredValue(sources []configSource, flag string) (value, from string) {
+	for _, source := range sources {
+		value, err := source.lookup(flag)
+		if err != nil {
+			// Not fatal: commands like serve may run outside a repo
+			continue
+		}
+		if value != "" {
+			return value, source.describe(flag)
+		}
+	}
+	return "", ""
+}
+
+// applyConfigDefaults fills flags the user did not set from the configured
+// values. Flags always win over the sources, which win over the built-in
+// defaults.
+func applyConfigDefaults(cmd *cobra.Command, sources []configSource) error {
+	for _, name := range configFlags {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed || flag.Annotations[noConfigAnnotation] != nil {
+			continue
+		}
+		value, from := configuredValue(sources, name)
+		if value == "" {
+			continue
+		}
+		if err := flag.Value.Set(value); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", from, value, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&serverURL, "server", defaultServer, "relay server URL")
+	rootCmd.PersistentFlags().StringVar(&space, "space", "", "namespace on a shared relay; sender and receiver must use the same one")
+	rootCmd.PersistentFlags().StringVar(&proxy, "proxy", "", "reach the relay through this proxy, e.g. http://proxy:3128 (default: $HTTPS_PROXY, $HTTP_PROXY and $NO_PROXY)")
+	rootCmd.PersistentFlags().BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "don't check the relay's TLS certificate (self-signed internal relays only)")
+}
+
+// checkConnectionFlags parses --proxy and warns about
+// --insecure-skip-verify.
+func checkConnectionFlags(stderr io.Writer) error {
+	if proxy != "" {
+		u, err := parseProxy(proxy)
+		if err != nil {
+			return err
+		}
+		proxyURL = u
+	}
+	if insecureSkipVerify {
+		fmt.Fprintf(stderr, "WARNING: --insecure-skip-verify turns off TLS certificate checks. Anyone on the\n")
+		fmt.Fprintf(stderr, "network path can pose as the relay and drop or replace patches. Patches stay\n")
+		fmt.Fprintf(stderr, "encrypted, but only use this for a relay whose certificate you can't check.\n")
+	}
+	return nil
+}
+
+// parseProxy checks a --proxy URL. A bare host:port means an HTTP proxy.
+func parseProxy(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: the scheme must be http, https or socks5", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: no host", s)
+	}
+	return u, nil
+}
+
+// addTransferFlags adds --retries, --retry-delay, --quiet and --token to a
+// command that uploads or downloads patches.
+func addTransferFlags(cmd *cobra.Command) {
+	defaults := client.DefaultOptions()
+	cmd.Flags().StringVar(&token, "token", "", "auth token for a relay started with serve --auth-token")
+	cmd.Flags().IntVar(&retries, "retries", defaults.Retries, "retry this many times after a connection error or relay server error")
+	cmd.Flags().DurationVar(&retryDelay, "retry-delay", defaults.RetryDelay, "wait this long before the first retry, doubling for each one after")
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "don't show upload and download progress")
+}
+
+// connection returns how to reach the relay, from the --proxy,
+// --insecure-skip-verify, retry and progress flags. Progress is shown for
+// slow transfers when stderr is a terminal.
+func connection() gitshare.Connection {
+	conn := gitshare.Connection{
+		Proxy:              proxyURL,
+		InsecureSkipVerify: insecureSkipVerify,
+		Token:              token,
+		Retries:            retries,
+		RetryDelay:         retryDelay,
+	}
+	if !quiet && ui.IsTerminal(os.Stderr) {
+		conn.Progress = os.Stderr
+	}
+	return conn
+}
+
+// newClient returns a relay client for the --server and --space flags and
+// the connection flags, for commands that talk to the relay directly.
+func newClient() *client.Client {
+	opts := client.DefaultOptions()
+	opts.Space = space
+	opts.Retries = max(retries, 0)
+	opts.RetryDelay = retryDelay
+	opts.Proxy = proxyURL
+	opts.InsecureSkipVerify = insecureSkipVerify
+	opts.Token = token
+	if !quiet && ui.IsTerminal(os.Stderr) {
+		opts.Progress = ui.NewProgressMeter(os.Stderr).Update
+	}
+	return client.NewWithOptions(serverURL, opts)
+}
+
+// Execute runs the root command.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		// Pass on the exit code of a command receive --then ran, silently
+		var exit gitshare.ExitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.Code)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
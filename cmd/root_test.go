@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/internal/git"
+)
+
+func TestCommandAliases(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+		run  func(*cobra.Command, []string) error
+		flag string
+	}{
+		{args: []string{"s"}, want: "send", run: RunSend, flag: "staged"},
+		{args: []string{"put", "HEAD"}, want: "send", run: RunSend, flag: "ttl"},
+		{args: []string{"r", "code"}, want: "receive", run: runReceive, flag: "commit"},
+		{args: []string{"get", "code", "--commit"}, want: "receive", run: runReceive, flag: "commit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args[0], func(t *testing.T) {
+			cmd, rest, err := rootCmd.Find(tt.args)
+			if err != nil {
+				t.Fatalf("Find(%v) error: %v", tt.args, err)
+			}
+			if cmd.Name() != tt.want {
+				t.Fatalf("alias %q resolved to %q, want %q", tt.args[0], cmd.Name(), tt.want)
+			}
+			if reflect.ValueOf(cmd.RunE).Pointer() != reflect.ValueOf(tt.run).Pointer() {
+				t.Fatalf("alias %q does not run the %s command", tt.args[0], tt.want)
+			}
+			if err := cmd.ParseFlags(rest); err != nil {
+				t.Fatalf("parsing %v via alias: %v", rest, err)
+			}
+			if cmd.Flags().Lookup(tt.flag) == nil {
+				t.Errorf("flag --%s not available via alias %q", tt.flag, tt.args[0])
+			}
+		})
+	}
+}
+
+func TestGitConfigDefaults(t *testing.T) {
+	// A repo that pins its relay and TTL in .git/config
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "git-share.server", "https://team-relay.example.com"},
+		{"config", "git-share.ttl", "30m"},
+	} {
+		c := exec.Command("git", args...)
+		c.Dir = dir
+		if err := c.Run(); err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	newCmd := func() (*cobra.Command, *string, *string) {
+		var server, ttl string
+		cmd := &cobra.Command{Use: "test"}
+		cmd.Flags().StringVar(&server, "server", defaultServer, "")
+		cmd.Flags().StringVar(&ttl, "ttl", "1h", "")
+		return cmd, &server, &ttl
+	}
+
+	// Picked up from git config
+	cmd, server, ttl := newCmd()
+	if err := applyConfigDefaults(cmd, []configSource{gitConfigSource(git.ConfigValue)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *server != "https://team-relay.example.com" || *ttl != "30m" {
+		t.Errorf("got server=%q ttl=%q, want the git config values", *server, *ttl)
+	}
+
+	// An explicit flag wins
+	cmd, server, ttl = newCmd()
+	cmd.ParseFlags([]string{"--server", "https://flag.example.com"})
+	if err := applyConfigDefaults(cmd, []configSource{gitConfigSource(git.ConfigValue)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *server != "https://flag.example.com" {
+		t.Errorf("server = %q, want the flag value", *server)
+	}
+	if *ttl != "30m" {
+		t.Errorf("ttl = %q, want the git config value", *ttl)
+	}
+
+	// Built-in defaults when nothing is configured
+	cmd, server, _ = newCmd()
+	none := func(string) (string, error) { return "", nil }
+	if err := applyConfigDefaults(cmd, []configSource{gitConfigSource(none)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *server != defaultServer {
+		t.Errorf("server = %q, want the built-in default", *server)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	env := map[string]string{"GIT_SHARE_SERVER": "https://env.example.com", "GIT_SHARE_TOKEN": "s3cret"}
+	gitConfig := map[string]string{"git-share.server": "https://git.example.com", "git-share.ttl": "30m"}
+	file, err := fileSource("config.yaml", map[string]string{"server": "https://file.example.com", "ttl": "2h", "space": "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := []configSource{
+		envSource(func(key string) string { return env[key] }),
+		gitConfigSource(func(key string) (string, error) { return gitConfig[key], nil }),
+		file,
+	}
+
+	newCmd := func() *cobra.Command {
+		cmd := &cobra.Command{Use: "test"}
+		cmd.Flags().String("server", defaultServer, "")
+		cmd.Flags().String("space", "", "")
+		cmd.Flags().String("ttl", "1h", "")
+		cmd.Flags().String("allow-ref-pattern", "", "")
+		return cmd
+	}
+
+	cmd := newCmd()
+	cmd.ParseFlags([]string{"--space", "flag-space"})
+	if err := applyConfigDefaults(cmd, sources); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for flag, want := range map[string]string{"server": "https://env.example.com", "ttl": "30m", "space": "flag-space", "allow-ref-pattern": ""} {
+		if got := cmd.Flags().Lookup(flag).Value.String(); got != want {
+			t.Errorf("--%s = %q, want %q", flag, got, want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeConfig(&out, cmd, sources); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"server             https://env.example.com  $GIT_SHARE_SERVER",
+		"space              flag-space               --space flag",
+		"ttl                30m                      git config git-share.ttl",
+		"allow-ref-pattern                           default",
+		"token              (hidden)                 $GIT_SHARE_TOKEN",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("config output missing %q\nGOT:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "s3cret") {
+		t.Errorf("config output shows the auth token:\n%s", out.String())
+	}
+
+	if _, err := fileSource("config.yaml", map[string]string{"sever": "x"}); err == nil || !strings.Contains(err.Error(), `unknown setting "sever"`) {
+		t.Errorf("expected an unknown setting error, got %v", err)
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: "http://proxy.corp:3128", want: "http://proxy.corp:3128"},
+		{in: "proxy.corp:3128", want: "http://proxy.corp:3128"},
+		{in: "socks5://127.0.0.1:1080", want: "socks5://127.0.0.1:1080"},
+		{in: "ftp://proxy.corp", wantErr: "scheme must be"},
+		{in: "http://", wantErr: "no host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			u, err := parseProxy(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || u.String() != tt.want {
+				t.Errorf("parseProxy(%q) = %v, %v; want %s", tt.in, u, err, tt.want)
+			}
+		})
+	}
+}
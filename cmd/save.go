@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/gitshare"
+	"github.com/flawiddsouza/git-share/internal/payload"
+	"github.com/flawiddsouza/git-share/internal/ui"
+)
+
+var (
+	saveStaged    bool
+	saveAll       bool
+	saveUntracked bool
+	saveCompress  bool
+	saveKDF       string
+
+	loadCommit   bool
+	loadThreeWay bool
+	loadPass     string
+)
+
+var saveCmd = &cobra.Command{
+	Use:   "save <file> [commit or range]",
+	Short: "Encrypt git changes to a file instead of uploading them",
+	Long: `Collect and encrypt git changes like send, but write the encrypted blob
+to a file instead of uploading it, and print the code. Carry the file over
+any way you like, and apply it with "git-share load <file> <code>". The relay
+is never contacted, so this works on air-gapped machines.
+
+The file is as safe to carry as a blob on the relay: it can't be read
+without the code. Unlike the relay, nothing deletes it after use.
+
+Examples:
+  git-share save fix.gsb               # uncommitted working tree changes
+  git-share save fix.gsb --staged      # staged changes only
+  git-share save fix.gsb HEAD~3..      # the last three commits`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runSave,
+}
+
+var loadCmd = &cobra.Command{
+	Use:   "load <file> <code>",
+	Short: "Decrypt and apply a blob written by save",
+	Long: `Read a blob written by "git-share save", decrypt it with the code, and
+apply it like receive does. The relay is never contacted.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runLoad,
+}
+
+func init() {
+	saveCmd.Flags().BoolVar(&saveStaged, "staged", false, "save staged changes only")
+	saveCmd.Flags().BoolVar(&saveAll, "all", false, "save staged and unstaged changes together")
+	saveCmd.Flags().BoolVar(&saveUntracked, "include-untracked", false, "also save new files that haven't been added to git yet")
+	saveCmd.Flags().BoolVar(&saveCompress, "compress", false, "gzip the patch before encrypting it")
+	saveCmd.Flags().StringVar(&saveKDF, "kdf", gitshare.KDFHKDF, "key derivation: \"hkdf\" or \"argon2id\" (slow to brute-force)")
+	rootCmd.AddCommand(saveCmd)
+
+	loadCmd.Flags().BoolVar(&loadCommit, "commit", false, "apply as commits with git am (for saved commits)")
+	loadCmd.Flags().BoolVar(&loadThreeWay, "3way", false, "fall back to a three-way merge, leaving conflict markers instead of failing")
+	loadCmd.Flags().StringVar(&loadPass, "passphrase", "", "passphrase, if the blob was saved with a custom one (the code is then the bare code ID)")
+	rootCmd.AddCommand(loadCmd)
+}
+
+func runSave(cmd *cobra.Command, args []string) error {
+	opts := gitshare.SendOptions{
+		Ref:           firstArg(args[1:]),
+		Staged:        saveStaged,
+		All:           saveAll,
+		Untracked:     saveUntracked,
+		TTL:           SendTTL,
+		KDF:           saveKDF,
+		SaveTo:        args[0],
+		Compress:      saveCompress,
+		CompressLevel: payload.DefaultCompressLevel,
+		Interactive:   ui.IsTerminal(os.Stdin) && ui.IsTerminal(os.Stderr),
+		Stdin:         os.Stdin,
+		Stdout:        os.Stdout,
+		Stderr:        os.Stderr,
+	}
+	_, err := gitshare.Send(opts)
+	return err
+}
+
+func runLoad(cmd *cobra.Command, args []string) error {
+	opts := gitshare.ReceiveOptions{
+		Code:        args[1],
+		Commit:      loadCommit,
+		ThreeWay:    loadThreeWay,
+		Passphrase:  loadPass,
+		Color:       ui.UseColor(os.Stdout, false),
+		Interactive: ui.IsTerminal(os.Stdin) && ui.IsTerminal(os.Stderr),
+		Stdin:       os.Stdin,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+	}
+	return gitshare.Load(args[0], opts)
+}
@@ -1,159 +1,252 @@
-package cmd
-
-import (
-	"encoding/base64"
-	"fmt"
-	"os"
-	"time"
-
-	"github.com/spf13/cobra"
-
-	"github.com/flawiddsouza/git-share/internal/client"
-	"github.com/flawiddsouza/git-share/internal/crypto"
-	"github.com/flawiddsouza/git-share/internal/git"
-)
-
-var (
-	SendStaged bool
-	SendTTL    string
-)
-
-var sendCmd = &cobra.Command{
-	Use:   "send [commit or range]",
-	Short: "Encrypt and upload git changes to the relay server",
-	Long: `Collect git changes, encrypt them with a one-time passphrase,
-and upload to the relay server. Outputs a code for the receiver.
-
-Examples:
-  git-share send                       # uncommitted working tree changes
-  git-share send --staged              # staged changes only
-  git-share send abc123                # a specific commit (by SHA)
-  git-share send HEAD~3..              # last 3 commits
-  git-share send main..feature         # commits in feature not in main`,
-	RunE: RunSend,
-}
-
-func init() {
-	sendCmd.Flags().BoolVar(&SendStaged, "staged", false, "send staged changes only")
-	sendCmd.Flags().StringVar(&SendTTL, "ttl", "1h", "time-to-live for the patch (e.g. 15m, 1h)")
-	rootCmd.AddCommand(sendCmd)
-}
-
-type sendDeps interface {
-	FindRepoRoot() (string, error)
-	GetCommitPatch(ref string) ([]byte, error)
-	GetStagedDiff() ([]byte, error)
-	GetDiff() ([]byte, error)
-	GenerateCode() (code, codeID, passphrase string, err error)
-	DeriveKey(passphrase string) ([]byte, error)
-	Encrypt(data, key []byte) ([]byte, error)
-	Send(codeID, data string, ttl int) (*client.SendResponse, error)
-	PatchStats(patch []byte) (string, error)
-}
-
-type realSendDeps struct{}
-
-func (d realSendDeps) FindRepoRoot() (string, error) { return git.FindRepoRoot() }
-func (d realSendDeps) GetCommitPatch(ref string) ([]byte, error) {
-	return git.GetCommitPatch(ref)
-}
-func (d realSendDeps) GetStagedDiff() ([]byte, error) { return git.GetStagedDiff() }
-func (d realSendDeps) GetDiff() ([]byte, error)       { return git.GetDiff() }
-func (d realSendDeps) GenerateCode() (string, string, string, error) {
-	return crypto.GenerateCode()
-}
-func (d realSendDeps) DeriveKey(passphrase string) ([]byte, error) {
-	return crypto.DeriveKey(passphrase)
-}
-func (d realSendDeps) Encrypt(data, key []byte) ([]byte, error) {
-	return crypto.Encrypt(data, key)
-}
-func (d realSendDeps) Send(codeID, data string, ttl int) (*client.SendResponse, error) {
-	c := client.New(serverURL)
-	return c.Send(codeID, data, ttl)
-}
-func (d realSendDeps) PatchStats(patch []byte) (string, error) { return git.PatchStats(patch) }
-
-func RunSend(cmd *cobra.Command, args []string) error {
-	return runSendWithDeps(os.Stdout, os.Stderr, realSendDeps{}, args, SendStaged, SendTTL)
-}
-
-func runSendWithDeps(stdout, stderr interface {
-	Write([]byte) (int, error)
-}, deps sendDeps, args []string, staged bool, ttlStr string) error {
-	// 1. Make sure we're in a git repo
-	_, err := deps.FindRepoRoot()
-	if err != nil {
-		return err
-	}
-
-	// 2. Collect the patch
-	fmt.Fprintf(stderr, "Collecting changes...\n")
-	var patch []byte
-	isCommit := false
-
-	switch {
-	case len(args) > 0:
-		// Positional arg = commit ref or range
-		patch, err = deps.GetCommitPatch(args[0])
-		isCommit = true
-	case staged:
-		patch, err = deps.GetStagedDiff()
-	default:
-		patch, err = deps.GetDiff()
-	}
-	if err != nil {
-		return err
-	}
-	fmt.Fprintf(stderr, "   Found %d bytes of changes\n", len(patch))
-
-	// Show a summary of changes
-	stats, _ := deps.PatchStats(patch)
-	if stats != "" {
-		fmt.Fprintf(stderr, "\nSummary of changes:\n%s\n", stats)
-	}
-
-	// 3. Generate the code (codeID + passphrase)
-	code, codeID, passphrase, err := deps.GenerateCode()
-	if err != nil {
-		return fmt.Errorf("generating code: %w", err)
-	}
-
-	// 4. Derive encryption key and encrypt
-	key, err := deps.DeriveKey(passphrase)
-	if err != nil {
-		return fmt.Errorf("deriving key: %w", err)
-	}
-
-	encrypted, err := deps.Encrypt(patch, key)
-	if err != nil {
-		return fmt.Errorf("encrypting: %w", err)
-	}
-
-	// 5. Parse TTL
-	ttl, err := time.ParseDuration(ttlStr)
-	if err != nil {
-		return fmt.Errorf("invalid TTL %q: %w", ttlStr, err)
-	}
-
-	// 6. Upload to relay server
-	fmt.Fprintf(stderr, "Encrypting and uploading...\n")
-	encoded := base64.StdEncoding.EncodeToString(encrypted)
-
-	resp, err := deps.Send(codeID, encoded, int(ttl.Seconds()))
-	if err != nil {
-		return fmt.Errorf("upload failed: %w", err)
-	}
-
-	// 7. Print the receive command
-	fmt.Fprintf(stderr, "\nEncrypted and uploaded.\n")
-	fmt.Fprintf(stderr, "Share this with the receiver:\n\n")
-	fmt.Fprintf(stdout, "   git-share receive %s\n", code)
-	if isCommit {
-		fmt.Fprintf(stderr, "OR to receive as a commit instead of a patch:\n")
-		fmt.Fprintf(stdout, "   git-share receive %s --commit\n", code)
-	}
-	fmt.Fprintf(stderr, "\nExpires: %s | One-time use only\n", resp.Expiry)
-
-	return nil
-}
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/gitshare"
+	"github.com/flawiddsouza/git-share/internal/payload"
+	"github.com/flawiddsouza/git-share/internal/ui"
+	"github.com/flawiddsouza/git-share/internal/wordlist"
+)
+
+var (
+	SendStaged      bool
+	SendAll         bool
+	SendUntracked   bool
+	SendPaths       []string
+	SendTextconv    bool
+	SendTTL         string
+	SendSplitSize   string
+	SendCommitFirst bool
+	SendAsCommit    bool
+	SendMessage     string
+	SendPassphrase  string
+	SendPassStdin   bool
+	SendCompress    bool
+	SendCompressLvl int
+	SendYes         bool
+	SendUpstream    bool
+	SendBase        string
+	SendAllowEmpty  bool
+	SendBase64URL   bool
+	SendShow        bool
+	SendPatchFile   string
+	SendLang        string
+	SendWordlist    string
+	SendJSON        bool
+	SendKeepAlive   bool
+	SendForce       bool
+	SendCheckApply  bool
+	SendAllowRefs   string
+	SendKDF         string
+	SendDownloads   int
+	SendQR          bool
+	SendStash       string
+	SendLast        int
+	SendSign        string
+)
+
+var sendCmd = &cobra.Command{
+	Use:     "send [commit or range]",
+	Aliases: []string{"s", "put"},
+	Short:   "Encrypt and upload git changes to the relay server",
+	Long: `Collect git changes, encrypt them with a one-time passphrase,
+and upload to the relay server. Outputs a code for the receiver.
+
+Examples:
+  git-share send                       # uncommitted working tree changes
+  git-share send --staged              # staged changes only
+  git-share send --all                 # staged and unstaged changes together
+  git-share send --include-untracked   # also new files not yet added to git
+  git-share send --stash               # the latest stash entry (--stash 2 for stash@{2})
+  git-share send --path src/foo.go     # only changes to some files (repeatable)
+  git-share send abc123                # a specific commit (by SHA)
+  git-share send --patch-file fix.patch  # an existing .patch or .diff file
+  git-share send --show HEAD           # a commit as "git show" output, message first
+  git-share send HEAD~3..              # last 3 commits
+  git-share send --last 3              # the same, without the range syntax
+  git-share send main..feature         # commits in feature not in main
+  git-share send --upstream            # commits not yet in the upstream branch
+  git-share send --base main           # commits since main
+  git-share send --allow-empty         # exit 0 when there is nothing to send
+  git-share send --check-apply         # estimate how likely the patch is to conflict
+  git-share send --keep-alive          # keep the patch from expiring until it is received
+  git-share send --max-downloads 3     # let three people receive the patch
+  git-share send --qr                  # also show the receive command as a QR code
+  git-share send --split-size 5MB      # upload in parts of at most 5MB each
+  git-share send --commit-first -m "wip"  # commit everything, then send that commit
+  git-share send --as-commit -m "wip"  # send the changes as a commit without making one here
+  git-share send --lang de             # passphrase words from the German wordlist
+  git-share send --wordlist words.txt  # passphrase words from your own list
+  git-share send --passphrase-stdin    # encrypt with your own passphrase, print only the code ID
+  git-share send --kdf argon2id        # key derivation that is slow to brute-force
+  git-share send --sign ~/.config/git-share/id  # sign with a key from git-share keygen
+  git-share send --compress-level 9    # gzip before encrypting, smallest output
+  git-share send --json                # print only a JSON result, for scripts
+
+--json prints a single JSON object on stdout with the code, code_id, expiry,
+bytes (the patch size) and is_commit, or an error, and nothing on stderr.
+
+A patch over the relay's size limit is uploaded in parts under the one code
+when the relay can join them (git-share serve --max-upload-size); the
+receiver does nothing different. For other relays, --split-size uploads the
+parts as separate blobs that receive fetches in turn.
+
+--textconv runs the textconv filters set up in .gitattributes, so files such
+as documents or images with a converter show up as readable text instead of
+a binary patch. The converted diff doesn't hold the files' real content, so
+it can't be applied: receive prints it instead, as with --dry-run. Without
+--textconv, patches never use the filters.
+
+--wordlist picks the passphrase words from a file with one word per line
+(diceware lines like "11111 abacus" work too) instead of a built-in list. It
+needs at least 256 distinct words without dashes or spaces. The receiver
+doesn't need the file: codes decrypt the same whatever list they came from,
+though receive --autocorrect can only fix words from the built-in lists.
+
+--allow-ref-pattern restricts the commit or range you can name to refs
+matching a regular expression, e.g. '[a-z0-9-]+' to refuse remote refs
+like origin/main and expressions like HEAD~3. It has to match the whole
+ref, as if it began with ^ and ended with $. It is usually pinned in git
+config (git-share.allow-ref-pattern) for shared or automated checkouts.
+Each side of a range must match; an empty side stands for HEAD. It also
+covers --base, --stash=<entry>, the upstream branch --upstream sends
+since, and the HEAD~N..HEAD range of --last N.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: RunSend,
+}
+
+func init() {
+	sendCmd.Flags().BoolVar(&SendStaged, "staged", false, "send staged changes only")
+	sendCmd.Flags().BoolVar(&SendUntracked, "include-untracked", false, "also send new files that haven't been added to git yet (ignored files are skipped)")
+	sendCmd.Flags().StringArrayVar(&SendPaths, "path", nil, "only send changes to this path (repeatable; applies to working tree and --staged changes)")
+	sendCmd.Flags().BoolVar(&SendTextconv, "textconv", false, "show files through their .gitattributes textconv filters, for reading only; the patch can't be applied")
+	sendCmd.Flags().BoolVar(&SendAll, "all", false, "send staged and unstaged changes together (the diff from HEAD)")
+	sendCmd.Flags().StringVar(&SendStash, "stash", "", "send a stash entry's changes (default stash@{0}; e.g. --stash stash@{2} or --stash 2)")
+	sendCmd.Flags().Lookup("stash").NoOptDefVal = gitshare.LatestStash
+	sendCmd.MarkFlagsMutuallyExclusive("staged", "all")
+	sendCmd.Flags().StringVar(&SendTTL, "ttl", "1h", "time-to-live for the patch (e.g. 15m, 1h)")
+	addTransferFlags(sendCmd)
+	sendCmd.Flags().BoolVar(&SendQR, "qr", false, "also show the receive command as a QR code on stderr, to scan with a phone")
+	sendCmd.Flags().IntVar(&SendDownloads, "max-downloads", 1, "let the patch be received this many times before it is deleted, e.g. by each of a team")
+	sendCmd.Flags().IntVar(&SendLast, "last", 0, "send the last N commits (HEAD~N..HEAD)")
+	sendCmd.Flags().BoolVar(&SendUpstream, "upstream", false, "send the commits since the upstream branch (@{upstream})")
+	sendCmd.Flags().StringVar(&SendBase, "base", "", "send the commits since this ref (the fallback for --upstream)")
+	sendCmd.Flags().BoolVar(&SendAllowEmpty, "allow-empty", false, "exit successfully when there are no changes to share")
+	sendCmd.Flags().BoolVar(&SendShow, "show", false, "send a single commit as \"git show\" output; the receiver sees the message and applies the diff")
+	sendCmd.Flags().StringVar(&SendPatchFile, "patch-file", "", "send an existing .patch or .diff file instead of collecting changes from git")
+	sendCmd.Flags().BoolVar(&SendCommitFirst, "commit-first", false, "commit all changes (including new files) and send the new commit")
+	sendCmd.Flags().BoolVar(&SendAsCommit, "as-commit", false, "send working tree changes as a commit authored by you, for receive --commit, without committing them here")
+	sendCmd.Flags().StringVarP(&SendMessage, "message", "m", "", "commit message for --commit-first or --as-commit")
+	sendCmd.Flags().BoolVar(&SendKeepAlive, "keep-alive", false, "keep extending the patch's TTL until it is received or you press Ctrl-C")
+	sendCmd.Flags().StringVar(&SendSplitSize, "split-size", "", "split the upload into parts of at most this size (e.g. 5MB)")
+	sendCmd.Flags().StringVar(&SendPassphrase, "passphrase", "", "encrypt with this passphrase instead of a generated one")
+	sendCmd.Flags().BoolVar(&SendPassStdin, "passphrase-stdin", false, "read the passphrase from stdin")
+	sendCmd.MarkFlagsMutuallyExclusive("passphrase", "passphrase-stdin")
+	sendCmd.Flags().StringVar(&SendLang, "lang", wordlist.DefaultLang, fmt.Sprintf("language of the generated passphrase words (%s)", strings.Join(wordlist.Languages(), ", ")))
+	sendCmd.MarkFlagsMutuallyExclusive("passphrase", "lang")
+	sendCmd.Flags().StringVar(&SendWordlist, "wordlist", "", "pick passphrase words from this file (one word per line, at least 256) instead of a built-in list")
+	sendCmd.MarkFlagsMutuallyExclusive("wordlist", "lang")
+	sendCmd.MarkFlagsMutuallyExclusive("wordlist", "passphrase")
+	sendCmd.MarkFlagsMutuallyExclusive("wordlist", "passphrase-stdin")
+	sendCmd.Flags().BoolVar(&SendBase64URL, "base64url", false, "upload with the URL-safe base64 alphabet, for proxies that mangle '+' and '/'")
+	sendCmd.Flags().BoolVar(&SendCompress, "compress", false, "gzip the patch before encrypting it")
+	sendCmd.Flags().BoolVarP(&SendYes, "yes", "y", false, "do not ask for confirmation before sending a large patch")
+	sendCmd.Flags().BoolVar(&SendJSON, "json", false, "print only a JSON result to stdout")
+	sendCmd.MarkFlagsMutuallyExclusive("json", "keep-alive")
+	sendCmd.Flags().StringVar(&SendKDF, "kdf", gitshare.KDFHKDF, "key derivation: \"hkdf\" (fast, works everywhere) or \"argon2id\" (slow to brute-force, CLI only)")
+	sendCmd.Flags().StringVar(&SendSign, "sign", "", "sign the patch with this private key from git-share keygen, so receivers can --verify it")
+	sendCmd.Flags().StringVar(&SendAllowRefs, "allow-ref-pattern", "", "only send commits named by refs that this regular expression matches in full")
+	sendCmd.Flags().BoolVar(&SendCheckApply, "check-apply", false, "check the patch matches your tree and estimate how likely it is to conflict for the receiver")
+	sendCmd.Flags().BoolVar(&SendForce, "force", false, "send to the public relay even if the patch looks like it contains secrets")
+	sendCmd.Flags().IntVar(&SendCompressLvl, "compress-level", payload.DefaultCompressLevel, "gzip level from 1 (fastest) to 9 (smallest); implies --compress")
+	rootCmd.AddCommand(sendCmd)
+}
+
+func RunSend(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed("last") && SendLast == 0 {
+		return gitshare.ErrLastNotPositive
+	}
+	opts := gitshare.SendOptions{
+		Ref:         firstArg(args),
+		Staged:      SendStaged,
+		All:         SendAll,
+		Untracked:   SendUntracked,
+		Paths:       SendPaths,
+		Textconv:    SendTextconv,
+		TTL:         SendTTL,
+		SplitSize:   SendSplitSize,
+		CommitFirst: SendCommitFirst,
+		AsCommit:    SendAsCommit,
+		Message:     SendMessage,
+		Passphrase:  SendPassphrase,
+		Space:       space,
+		Upstream:    SendUpstream,
+		Base:        SendBase,
+		AllowEmpty:  SendAllowEmpty,
+		Show:        SendShow,
+		PatchFile:   SendPatchFile,
+		Lang:        SendLang,
+		KeepAlive:   SendKeepAlive,
+		Server:      serverURL,
+		Force:       SendForce,
+		CheckApply:  SendCheckApply,
+		AllowRefs:   SendAllowRefs,
+		KDF:         SendKDF,
+		Downloads:   SendDownloads,
+		QR:          SendQR,
+		Stash:       SendStash,
+		Last:        SendLast,
+
+		Compress:      SendCompress || cmd.Flags().Changed("compress-level"),
+		CompressLevel: SendCompressLvl,
+		URLSafe:       SendBase64URL,
+
+		Yes:         SendYes,
+		JSON:        SendJSON,
+		Interactive: ui.IsTerminal(os.Stdin) && ui.IsTerminal(os.Stderr),
+		Stdin:       os.Stdin,
+
+		Connection: connection(),
+		Record:     true,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+	}
+	if SendWordlist != "" {
+		words, err := wordlist.Load(SendWordlist)
+		if err != nil {
+			return err
+		}
+		if err := wordlist.SetWordlist(words); err != nil {
+			return fmt.Errorf("%s: %w", SendWordlist, err)
+		}
+	}
+	if SendSign != "" {
+		key, err := loadSigningKey(SendSign)
+		if err != nil {
+			return err
+		}
+		opts.SignKey = key
+	}
+	if SendPassStdin {
+		passphrase, err := ui.ReadPassphrase(os.Stdin)
+		if err != nil {
+			return err
+		}
+		opts.Passphrase = passphrase
+	}
+	_, err := gitshare.Send(opts)
+	return err
+}
+
+// firstArg returns args[0], or "" if there are no args.
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
@@ -1,102 +1,259 @@
-package cmd
-
-import (
-	"fmt"
-	"strconv"
-	"strings"
-	"time"
-
-	"github.com/spf13/cobra"
-
-	"github.com/flawiddsouza/git-share/internal/server"
-)
-
-var (
-	servePort    int
-	serveMaxTTL  string
-	serveMaxSize string
-)
-
-var serveCmd = &cobra.Command{
-	Use:   "serve",
-	Short: "Start the relay server",
-	Long: `Start the git-share relay server. The server stores encrypted blobs
-in memory and serves them once before deleting. Blobs expire after the
-configured TTL.
-
-This can be self-hosted or used as a public relay.`,
-	RunE: runServe,
-}
-
-func init() {
-	serveCmd.Flags().IntVar(&servePort, "port", 3141, "port to listen on")
-	serveCmd.Flags().StringVar(&serveMaxTTL, "max-ttl", "1h", "maximum TTL for stored patches")
-	serveCmd.Flags().StringVar(&serveMaxSize, "max-size", "10MB", "maximum blob size (e.g. 5MB, 512KB, 1GB)")
-	rootCmd.AddCommand(serveCmd)
-}
-
-func runServe(cmd *cobra.Command, args []string) error {
-	maxTTL, err := time.ParseDuration(serveMaxTTL)
-	if err != nil {
-		return fmt.Errorf("invalid max-ttl %q: %w", serveMaxTTL, err)
-	}
-
-	maxSize, err := parseByteSize(serveMaxSize)
-	if err != nil {
-		return fmt.Errorf("invalid max-size %q: %w", serveMaxSize, err)
-	}
-
-	config := server.DefaultConfig()
-	config.Port = servePort
-	config.MaxTTL = maxTTL
-	config.MaxSize = maxSize
-
-	srv := server.New(config)
-	return srv.Start()
-}
-
-// parseByteSize parses a human-readable byte size string like "10MB", "512KB", "1GB".
-func parseByteSize(s string) (int64, error) {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return 0, fmt.Errorf("empty size string")
-	}
-
-	// Find where the numeric part ends
-	i := 0
-	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
-		i++
-	}
-	if i == 0 {
-		return 0, fmt.Errorf("no numeric value found")
-	}
-
-	numStr := s[:i]
-	unit := strings.TrimSpace(strings.ToUpper(s[i:]))
-
-	num, err := strconv.ParseFloat(numStr, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid number %q: %w", numStr, err)
-	}
-
-	var multiplier float64
-	switch unit {
-	case "B", "":
-		multiplier = 1
-	case "KB", "K":
-		multiplier = 1024
-	case "MB", "M":
-		multiplier = 1024 * 1024
-	case "GB", "G":
-		multiplier = 1024 * 1024 * 1024
-	default:
-		return 0, fmt.Errorf("unknown unit %q (use B, KB, MB, or GB)", unit)
-	}
-
-	result := int64(num * multiplier)
-	if result <= 0 {
-		return 0, fmt.Errorf("size must be greater than zero")
-	}
-
-	return result, nil
-}
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flawiddsouza/git-share/internal/server"
+	"github.com/flawiddsouza/git-share/internal/ui"
+)
+
+var (
+	servePort           int
+	serveMaxTTL         string
+	serveMinTTL         string
+	serveRejectShortTTL bool
+	serveStrictTTL      bool
+	serveMaxSize        string
+	serveMaxUpload      string
+	serveMaxPending     string
+	serveWebUI          bool
+
+	serveTLSCert      string
+	serveTLSKey       string
+	serveRequireHTTPS bool
+	serveTrustProxy   bool
+
+	serveAuditMisses bool
+	serveAuditIPs    bool
+	serveMissAlert   int
+	serveLogSample   float64
+
+	serveAdminToken  string
+	serveAuthToken   string
+	serveAuthReceive bool
+	serveMaxConns    int
+	serveRateLimit   int
+	serveShorten     bool
+
+	serveSnapshotFile string
+	serveRestore      string
+	serveStoreDir     string
+	serveTTLMode      string
+	serveAllowPeek    bool
+	serveCleanup      string
+)
+
+// authTokenEnv names the environment variable that holds the relay's auth
+// token, like adminTokenEnv.
+const authTokenEnv = "GIT_SHARE_AUTH_TOKEN"
+
+// Bounds for --cleanup-interval. Below a second the loop is mostly
+// overhead; above an hour, expired blobs could outlive most TTLs.
+const (
+	minCleanupInterval = time.Second
+	maxCleanupInterval = time.Hour
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the relay server",
+	Long: `Start the git-share relay server. The server stores encrypted blobs
+in memory and serves them once before deleting. Blobs expire after the
+configured TTL.
+
+This can be self-hosted or used as a public relay.
+
+With --web-ui the relay also serves a page at / where a code can be pasted
+to download and decrypt a patch in the browser, for people without the CLI.
+--shorten also gives each sender a short link to that page with the code ID
+filled in; the passphrase is never part of the link.
+
+Behind a TLS-terminating proxy, use --trust-proxy so X-Forwarded-Proto is
+honored; --require-https then rejects clients that connected over plain HTTP.
+
+An admin token (--admin-token or $GIT_SHARE_ADMIN_TOKEN) enables admin
+endpoints such as "git-share admin purge".
+
+An auth token (--auth-token or $GIT_SHARE_AUTH_TOKEN) keeps a private relay
+from taking anonymous uploads: sends and extends must carry it, which
+"git-share send --token" (or $GIT_SHARE_TOKEN) does, and others get a 401.
+With --auth-receive, receives, peeks and status checks must carry it too;
+the web receive page can't, so the two don't mix.
+
+--allow-peek lets "git-share receive --peek" download a patch without
+deleting it, to debug a receive that failed after the download. This
+bypasses one-time use: anyone with a code ID can then read the encrypted
+blob any number of times until it expires. Leave it off on shared relays.
+
+Blobs live only in memory unless --store-dir is set, which also writes each
+blob to a file there and loads them back at startup; expired files are
+removed. Alternatively, sending SIGUSR1 saves them to --snapshot-file,
+and --restore loads such a snapshot at startup, so a relay can be restarted
+without dropping pending patches. Restored blobs keep their original expiry;
+ones that expired in the meantime are dropped. Snapshots hold only the
+encrypted blobs, never passphrases.
+
+Expired blobs can't be received, but they stay in memory until the next
+cleanup, every 30s by default. A busy relay with short TTLs can free them
+sooner with --cleanup-interval 10s; a quiet one can wake less often.
+
+A patch over --max-size can still be sent in parts of up to --max-size
+each, which the relay joins under one code once all have arrived, up to
+--max-upload-size in total (100MB by default; 0 refuses parts). Parts
+waiting for the rest are held in memory and dropped when the TTL runs out;
+--max-pending-uploads caps them across all uploads (512MB by default, or
+--max-upload-size if that is larger), and parts over it get a 507 until
+others finish.`,
+	RunE: runServe,
+}
+
+func init() {
+	serveCmd.Flags().IntVar(&servePort, "port", 3141, "port to listen on")
+	serveCmd.Flags().StringVar(&serveMaxTTL, "max-ttl", "1h", "maximum TTL for stored patches")
+	serveCmd.Flags().StringVar(&serveTTLMode, "ttl-mode", server.TTLAbsolute, "\"absolute\" expires blobs a TTL after upload; \"sliding\" a TTL after they were last read")
+	serveCmd.Flags().StringVar(&serveMinTTL, "min-ttl", "0s", "minimum TTL; shorter requests are raised to it")
+	serveCmd.Flags().BoolVar(&serveRejectShortTTL, "reject-short-ttl", false, "reject requests below --min-ttl instead of raising them")
+	serveCmd.Flags().BoolVar(&serveStrictTTL, "strict-ttl", false, "reject requests above --max-ttl instead of capping them")
+	serveCmd.Flags().StringVar(&serveMaxSize, "max-size", "10MB", "maximum blob size (e.g. 5MB, 512KB, 1GB)")
+	serveCmd.Flags().StringVar(&serveMaxUpload, "max-upload-size", "100MB", "maximum size of a blob sent in parts of up to --max-size; 0 to refuse parts")
+	serveCmd.Flags().StringVar(&serveMaxPending, "max-pending-uploads", "512MB", "maximum total size of the parts held for unfinished uploads; 0 for no limit")
+	serveCmd.Flags().BoolVar(&serveWebUI, "web-ui", false, "serve a browser receive page at /")
+	serveCmd.Flags().BoolVar(&serveShorten, "shorten", false, "give senders a short link to the web receive page (needs --web-ui)")
+	serveCmd.Flags().StringVar(&serveTLSCert, "tls-cert", "", "TLS certificate file (serves HTTPS with --tls-key)")
+	serveCmd.Flags().StringVar(&serveTLSKey, "tls-key", "", "TLS private key file")
+	serveCmd.Flags().BoolVar(&serveRequireHTTPS, "require-https", false, "reject requests that did not arrive over HTTPS")
+	serveCmd.Flags().BoolVar(&serveTrustProxy, "trust-proxy", false, "trust X-Forwarded-* headers from a reverse proxy")
+	serveCmd.Flags().BoolVar(&serveAuditMisses, "audit-misses", false, "log receives of unknown or expired code IDs")
+	serveCmd.Flags().BoolVar(&serveAuditIPs, "audit-ips", false, "include client IPs in audit logs")
+	serveCmd.Flags().Float64Var(&serveLogSample, "log-sample-rate", 1, "fraction of successful requests to log (0 to 1); errors are always logged")
+	serveCmd.Flags().BoolVar(&serveAllowPeek, "allow-peek", false, "let clients download blobs without deleting them (bypasses one-time use; for debugging)")
+	serveCmd.Flags().StringVar(&serveAdminToken, "admin-token", "", "enable admin endpoints with this bearer token (default $"+adminTokenEnv+")")
+	serveCmd.Flags().StringVar(&serveAuthToken, "auth-token", "", "only accept sends and extends that carry this bearer token (default $"+authTokenEnv+")")
+	serveCmd.Flags().BoolVar(&serveAuthReceive, "auth-receive", false, "require the auth token for receives and status checks as well")
+	serveCmd.Flags().IntVar(&serveRateLimit, "rate-limit", 0, "maximum sends, receives, status checks and extends per minute per client IP; more get a 429 (0 = no limit)")
+	serveCmd.Flags().IntVar(&serveMaxConns, "max-conns", 0, "maximum requests handled at once; more get a 503 (0 = no limit)")
+	serveCmd.Flags().StringVar(&serveSnapshotFile, "snapshot-file", "git-share-snapshot.json", "file the store is saved to on SIGUSR1")
+	serveCmd.Flags().StringVar(&serveRestore, "restore", "", "load blobs from a snapshot file at startup")
+	serveCmd.Flags().StringVar(&serveStoreDir, "store-dir", "", "also keep blobs as files in this directory so restarts don't lose them")
+	serveCmd.Flags().StringVar(&serveCleanup, "cleanup-interval", server.DefaultCleanupInterval.String(), "how often expired blobs are removed from memory and --store-dir (1s to 1h)")
+	serveCmd.Flags().IntVar(&serveMissAlert, "miss-alert", 0, "warn when one client misses this many times in a minute (0 = off)")
+	rootCmd.AddCommand(serveCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	maxTTL, err := time.ParseDuration(serveMaxTTL)
+	if err != nil {
+		return fmt.Errorf("invalid max-ttl %q: %w", serveMaxTTL, err)
+	}
+
+	minTTL, err := time.ParseDuration(serveMinTTL)
+	if err != nil {
+		return fmt.Errorf("invalid min-ttl %q: %w", serveMinTTL, err)
+	}
+	if minTTL > maxTTL {
+		return fmt.Errorf("--min-ttl (%s) cannot be longer than --max-ttl (%s)", minTTL, maxTTL)
+	}
+
+	cleanupInterval, err := time.ParseDuration(serveCleanup)
+	if err != nil {
+		return fmt.Errorf("invalid cleanup-interval %q: %w", serveCleanup, err)
+	}
+	if cleanupInterval < minCleanupInterval || cleanupInterval > maxCleanupInterval {
+		return fmt.Errorf("--cleanup-interval must be between %s and %s", minCleanupInterval, maxCleanupInterval)
+	}
+
+	maxSize, err := ui.ParseByteSize(serveMaxSize)
+	if err != nil {
+		return fmt.Errorf("invalid max-size %q: %w", serveMaxSize, err)
+	}
+	maxUpload, err := ui.ParseByteSize(serveMaxUpload)
+	if err != nil {
+		return fmt.Errorf("invalid max-upload-size %q: %w", serveMaxUpload, err)
+	}
+	if maxUpload > 0 && maxUpload < maxSize {
+		return fmt.Errorf("--max-upload-size must be 0 or at least --max-size")
+	}
+	maxPending, err := ui.ParseByteSize(serveMaxPending)
+	if err != nil {
+		return fmt.Errorf("invalid max-pending-uploads %q: %w", serveMaxPending, err)
+	}
+	if !cmd.Flags().Changed("max-pending-uploads") {
+		// The default makes room for at least one upload of the largest size
+		maxPending = max(maxPending, maxUpload)
+	}
+	if maxPending > 0 && maxPending < maxUpload {
+		return fmt.Errorf("--max-pending-uploads must be 0 or at least --max-upload-size")
+	}
+
+	config := server.DefaultConfig()
+	config.Port = servePort
+	config.MaxTTL = maxTTL
+	config.MinTTL = minTTL
+	config.RejectShortTTL = serveRejectShortTTL
+	config.StrictTTL = serveStrictTTL
+	config.MaxSize = maxSize
+	config.MaxUploadSize = maxUpload
+	config.MaxPendingUploads = maxPending
+	config.WebUI = serveWebUI
+	config.Shorten = serveShorten
+	config.TLSCert = serveTLSCert
+	config.TLSKey = serveTLSKey
+	config.RequireHTTPS = serveRequireHTTPS
+	config.TrustProxy = serveTrustProxy
+	config.AuditMisses = serveAuditMisses
+	config.AuditIPs = serveAuditIPs
+	config.MissAlert = serveMissAlert
+	config.LogSampleRate = serveLogSample
+	config.AdminToken = serveAdminToken
+	config.AuthToken = serveAuthToken
+	config.AuthReceive = serveAuthReceive
+	config.AllowPeek = serveAllowPeek
+	config.MaxConns = serveMaxConns
+	config.RateLimit = serveRateLimit
+	config.SnapshotFile = serveSnapshotFile
+	config.RestoreFile = serveRestore
+	config.StoreDir = serveStoreDir
+	config.TTLMode = serveTTLMode
+	config.CleanupInterval = cleanupInterval
+	if config.TTLMode != server.TTLAbsolute && config.TTLMode != server.TTLSliding {
+		return fmt.Errorf("invalid --ttl-mode %q; use %q or %q", config.TTLMode, server.TTLAbsolute, server.TTLSliding)
+	}
+	if config.AdminToken == "" {
+		config.AdminToken = os.Getenv(adminTokenEnv)
+	}
+	if config.AuthToken == "" {
+		config.AuthToken = os.Getenv(authTokenEnv)
+	}
+	if config.AuthReceive && config.AuthToken == "" {
+		return fmt.Errorf("--auth-receive needs an auth token; pass --auth-token or set %s", authTokenEnv)
+	}
+	if config.AuthReceive && config.WebUI {
+		return fmt.Errorf("--auth-receive can't be used with --web-ui, since the web receive page has no way to send the token")
+	}
+	if config.MaxConns < 0 {
+		return fmt.Errorf("--max-conns cannot be negative")
+	}
+	if config.RateLimit < 0 {
+		return fmt.Errorf("--rate-limit cannot be negative")
+	}
+	if config.LogSampleRate < 0 || config.LogSampleRate > 1 {
+		return fmt.Errorf("--log-sample-rate must be between 0 and 1")
+	}
+
+	if config.Shorten && !config.WebUI {
+		return fmt.Errorf("--shorten needs --web-ui, since short links open the web receive page")
+	}
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+	if config.RequireHTTPS && config.TLSCert == "" && !config.TrustProxy {
+		return fmt.Errorf("--require-https needs --tls-cert/--tls-key, or --trust-proxy behind a TLS-terminating proxy")
+	}
+
+	srv := server.New(config)
+	return srv.Start()
+}
@@ -0,0 +1,30 @@
+package gitshare
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/flawiddsouza/git-share/internal/git"
+)
+
+// ExplainApplyError tells the user what to do about a failed apply: which
+// files to resolve after a three-way merge, or why the patch didn't apply.
+func ExplainApplyError(stderr io.Writer, err error) {
+	var conflict *git.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		fmt.Fprintf(stderr, "\nThe patch was merged, but these files have conflicts to resolve by hand:\n")
+		for _, path := range conflict.Files {
+			fmt.Fprintf(stderr, "  %s\n", path)
+		}
+	case errors.Is(err, git.ErrPatchConflict):
+		fmt.Fprintf(stderr, "\nThe patch doesn't match your files; they may have changed since it was made.\n")
+		fmt.Fprintf(stderr, "It can be merged with conflict markers using --3way, or the sender can rebase it.\n")
+	case errors.Is(err, git.ErrCorruptPatch):
+		fmt.Fprintf(stderr, "\nThe patch appears to be corrupted, perhaps by an editor or a copy and paste.\n")
+		fmt.Fprintf(stderr, "Ask the sender to send it again.\n")
+	case errors.Is(err, git.ErrEmptyPatch):
+		fmt.Fprintf(stderr, "\nThe patch has no changes to apply.\n")
+	}
+}
@@ -0,0 +1,30 @@
+package gitshare
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/flawiddsouza/git-share/internal/git"
+)
+
+func TestExplainApplyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: fmt.Errorf("%w: git said so", git.ErrPatchConflict), want: "using --3way"},
+		{err: fmt.Errorf("%w: git said so", git.ErrCorruptPatch), want: "appears to be corrupted"},
+		{err: git.ErrEmptyPatch, want: "no changes to apply"},
+		{err: errors.New("something else")},
+	}
+	for _, tt := range tests {
+		stderr := &bytes.Buffer{}
+		ExplainApplyError(stderr, tt.err)
+		if tt.want == "" && stderr.Len() > 0 || !strings.Contains(stderr.String(), tt.want) {
+			t.Errorf("ExplainApplyError(%v) printed %q, want %q", tt.err, stderr.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package gitshare sends and receives git patches through a git-share relay.
+// It is what the git-share commands run, for tools that want to share
+// patches without shelling out to the CLI.
+//
+// Send collects changes from the git repository in the working directory,
+// encrypts them, uploads them, and returns the code to give the receiver.
+// Receive downloads the patch for a code, decrypts it, and applies it:
+//
+//	code, err := gitshare.Send(gitshare.SendOptions{Staged: true, TTL: "30m"})
+//	...
+//	err = gitshare.Receive(gitshare.ReceiveOptions{Code: code})
+//
+// Messages the CLI would print go to the Stdout and Stderr writers in the
+// options, and are discarded when those are nil.
+package gitshare
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+	"github.com/flawiddsouza/git-share/internal/ui"
+)
+
+// DefaultServer is the public relay, used when no server is given.
+const DefaultServer = "https://git-share.artelin.dev"
+
+// Connection says how to reach the relay. The zero value makes one attempt
+// per request, uses $HTTPS_PROXY, $HTTP_PROXY and $NO_PROXY, and checks TLS
+// certificates.
+type Connection struct {
+	Proxy              *url.URL // proxy for every request; nil uses the environment
+	InsecureSkipVerify bool     // don't check the relay's TLS certificate
+	Token              string   // auth token for relays that require one
+
+	// Retries is how many times a request is retried after a connection
+	// error or a relay server error, waiting RetryDelay before the first
+	// retry and twice as long before each one after that.
+	Retries    int
+	RetryDelay time.Duration
+
+	Progress io.Writer // draw the progress of slow transfers here; nil for none
+}
+
+// newClient returns a client for the relay at server, inside space if set.
+func (c Connection) newClient(server, space string) *client.Client {
+	if server == "" {
+		server = DefaultServer
+	}
+	opts := client.DefaultOptions()
+	opts.Space = space
+	opts.Retries = max(c.Retries, 0)
+	opts.RetryDelay = c.RetryDelay
+	opts.Proxy = c.Proxy
+	opts.InsecureSkipVerify = c.InsecureSkipVerify
+	opts.Token = c.Token
+	if c.Progress != nil {
+		opts.Progress = ui.NewProgressMeter(c.Progress).Update
+	}
+	return client.NewWithOptions(server, opts)
+}
+
+// ExitError is returned by Receive when the ReceiveOptions.Then command
+// exits with a non-zero status, which git-share exits with in turn.
+type ExitError struct {
+	Code int
+}
+
+func (e ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Send collects changes as opts describes, encrypts them, and uploads them
+// to the relay, or writes them to opts.SaveTo. It returns the code the
+// receiver needs; with opts.Passphrase set, that is only the code ID.
+func Send(opts SendOptions) (string, error) {
+	var args []string
+	if opts.Ref != "" {
+		args = []string{opts.Ref}
+	}
+	if opts.Server == "" && opts.SaveTo == "" {
+		// A saved blob goes to no relay, so it is held to no relay's rules
+		opts.Server = DefaultServer
+	}
+	if opts.TTL == "" {
+		opts.TTL = "1h"
+	}
+	deps := realSendDeps{relay: opts.Connection.newClient(opts.Server, opts.Space), record: opts.Record}
+	return runSendWithDeps(writerOrDiscard(opts.Stdout), writerOrDiscard(opts.Stderr), deps, args, opts)
+}
+
+// Receive downloads the patch for opts.Code, decrypts it, and applies it to
+// the git repository in the working directory, as opts describes.
+func Receive(opts ReceiveOptions) error {
+	deps := realReceiveDeps{relay: opts.Connection.newClient(opts.Server, opts.Space)}
+	return runReceiveWithDeps(writerOrDiscard(opts.Stdout), writerOrDiscard(opts.Stderr), deps, strings.Fields(opts.Code), opts)
+}
+
+// Load decrypts and applies a blob that Send wrote to a file with
+// SendOptions.SaveTo, the same way Receive does. The relay is not contacted.
+func Load(file string, opts ReceiveOptions) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading blob: %w", err)
+	}
+	deps := fileReceiveDeps{data: string(data)}
+	return runReceiveWithDeps(writerOrDiscard(opts.Stdout), writerOrDiscard(opts.Stderr), deps, strings.Fields(opts.Code), opts)
+}
+
+func writerOrDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
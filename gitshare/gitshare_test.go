@@ -0,0 +1,83 @@
+package gitshare
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+	"github.com/flawiddsouza/git-share/internal/gittest"
+	"github.com/flawiddsouza/git-share/internal/server"
+)
+
+func TestSendReceive(t *testing.T) {
+	relay := httptest.NewServer(server.New(server.DefaultConfig()).Handler())
+	defer relay.Close()
+	repo := gittest.New(t)
+	repo.WriteFile("test.txt", "changed\n")
+
+	code, err := Send(SendOptions{Server: relay.URL})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if strings.Count(code, "-") < 2 {
+		t.Fatalf("Send returned %q, want a code with passphrase words", code)
+	}
+
+	repo.Git("checkout", "-q", "--", "test.txt")
+	if err := Receive(ReceiveOptions{Code: code, Server: relay.URL}); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if got, _ := os.ReadFile(repo.Path("test.txt")); string(got) != "changed\n" {
+		t.Errorf("test.txt = %q after Receive, want the sent change", got)
+	}
+
+	var gone *client.GoneError
+	if err := Receive(ReceiveOptions{Code: code, Server: relay.URL}); !errors.As(err, &gone) {
+		t.Errorf("second Receive = %v, want a *client.GoneError", err)
+	}
+}
+
+func TestSendReceiveStream(t *testing.T) {
+	relay := httptest.NewServer(server.New(server.DefaultConfig()).Handler())
+	defer relay.Close()
+	repo := gittest.New(t)
+	// Over crypto.StreamThreshold, so the patch is encrypted as a stream
+	large := strings.Repeat("a line of the large file\n", 60000)
+	repo.WriteFile("large.txt", large)
+	repo.Git("add", "large.txt")
+
+	code, err := Send(SendOptions{Server: relay.URL, Staged: true})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	repo.Git("rm", "-q", "--cached", "large.txt")
+	os.Remove(repo.Path("large.txt"))
+	if err := Receive(ReceiveOptions{Code: code, Server: relay.URL}); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if got, _ := os.ReadFile(repo.Path("large.txt")); string(got) != large {
+		t.Errorf("large.txt has %d bytes after Receive, want %d", len(got), len(large))
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	repo := gittest.New(t)
+	repo.WriteFile("test.txt", "changed\n")
+	blob := filepath.Join(t.TempDir(), "fix.gsb")
+
+	code, err := Send(SendOptions{SaveTo: blob})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	repo.Git("checkout", "-q", "--", "test.txt")
+	if err := Load(blob, ReceiveOptions{Code: code}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got, _ := os.ReadFile(repo.Path("test.txt")); string(got) != "changed\n" {
+		t.Errorf("test.txt = %q after Load, want the saved change", got)
+	}
+}
@@ -0,0 +1,938 @@
+package gitshare
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+	"github.com/flawiddsouza/git-share/internal/crypto"
+	"github.com/flawiddsouza/git-share/internal/diffcolor"
+	"github.com/flawiddsouza/git-share/internal/git"
+	"github.com/flawiddsouza/git-share/internal/payload"
+	"github.com/flawiddsouza/git-share/internal/ui"
+	"github.com/flawiddsouza/git-share/internal/wordlist"
+)
+
+// Values of ReceiveOptions.SummaryFormat.
+const (
+	SummaryText = "text"
+	SummaryJSON = "json"
+	SummaryNone = "none"
+)
+
+// autocorrectAttempts caps how many corrected passphrases --autocorrect
+// tries, since each one derives a key, which is slow with Argon2id.
+const autocorrectAttempts = 64
+
+// passphraseAttempts is how many times --ask-passphrase asks for the
+// passphrase before giving up.
+const passphraseAttempts = 3
+
+// largeReceiveSize is the patch size above which receive asks before
+// applying, since a large patch may rewrite much of the tree.
+const largeReceiveSize = 5 * 1024 * 1024
+
+// receivePollInterval is how often receive --wait checks for the patch.
+const receivePollInterval = 2 * time.Second
+
+type receiveDeps interface {
+	FindRepoRoot() (string, error)
+	Receive(codeID string) (string, error)
+	Peek(codeID string) (string, error)
+	Status(codeID string) (*client.StatusResponse, error)
+	DeriveKey(passphrase string) ([]byte, error)
+	DeriveKeyArgon2(passphrase string, params crypto.Argon2Params) ([]byte, error)
+	Decrypt(data, key []byte) ([]byte, error)
+	ApplyPatch(patch []byte, opts git.ApplyOptions) error
+	CommitPatch(patch []byte, info git.CommitInfo) (string, error)
+	AddNotes(ref, notes string) error
+	PatchStats(patch []byte) (string, error)
+	PatchSummary(patch []byte) (git.Summary, error)
+	Page(text []byte, w io.Writer) error
+	SavePatch(patch []byte) (string, error)
+	WriteOutput(path string, patch []byte) error
+	Sleep(d time.Duration)
+	RunCommand(command string, stdout, stderr io.Writer) (int, error)
+	AskPassphrase(prompt string) (string, error)
+	HeadCommit() (string, error)
+}
+
+// realReceiveDeps runs a receive against a relay, git, and the crypto
+// package.
+type realReceiveDeps struct {
+	relay *client.Client
+}
+
+func (d realReceiveDeps) FindRepoRoot() (string, error) { return git.FindRepoRoot() }
+func (d realReceiveDeps) Receive(codeID string) (string, error) {
+	return d.relay.Receive(codeID)
+}
+func (d realReceiveDeps) Peek(codeID string) (string, error) {
+	return d.relay.Peek(codeID)
+}
+func (d realReceiveDeps) Status(codeID string) (*client.StatusResponse, error) {
+	return d.relay.Status(codeID)
+}
+func (d realReceiveDeps) DeriveKey(passphrase string) ([]byte, error) {
+	return crypto.DeriveKey(passphrase)
+}
+func (d realReceiveDeps) DeriveKeyArgon2(passphrase string, params crypto.Argon2Params) ([]byte, error) {
+	return crypto.DeriveKeyArgon2(passphrase, params)
+}
+func (d realReceiveDeps) Decrypt(data, key []byte) ([]byte, error) {
+	return crypto.DecryptBlob(data, key)
+}
+func (d realReceiveDeps) ApplyPatch(patch []byte, opts git.ApplyOptions) error {
+	return git.ApplyPatchWithOptions(patch, opts)
+}
+func (d realReceiveDeps) CommitPatch(patch []byte, info git.CommitInfo) (string, error) {
+	return git.CommitPatch(patch, info)
+}
+func (d realReceiveDeps) AddNotes(ref, notes string) error        { return git.AddNotes(ref, notes) }
+func (d realReceiveDeps) PatchStats(patch []byte) (string, error) { return git.PatchStats(patch) }
+func (d realReceiveDeps) PatchSummary(patch []byte) (git.Summary, error) {
+	return git.PatchSummary(patch)
+}
+
+// Page shows text through $PAGER when stdout is a terminal, and writes it to w otherwise.
+func (d realReceiveDeps) Page(text []byte, w io.Writer) error {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -R"
+	}
+	if !ui.IsTerminal(os.Stdout) || pager == "cat" {
+		_, err := w.Write(text)
+		return err
+	}
+
+	fields := strings.Fields(pager)
+	c := exec.Command(fields[0], fields[1:]...)
+	c.Stdin = bytes.NewReader(text)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		// Fall back to plain output if the pager is missing or broken
+		_, err = w.Write(text)
+		return err
+	}
+	return nil
+}
+
+func (d realReceiveDeps) SavePatch(patch []byte) (string, error) {
+	f, err := os.CreateTemp("", "git-share-*.patch")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(patch); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// WriteOutput writes the patch to a file, or to stdout for "-".
+func (d realReceiveDeps) WriteOutput(path string, patch []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(patch)
+		return err
+	}
+	return os.WriteFile(path, patch, 0644)
+}
+
+func (d realReceiveDeps) Sleep(dur time.Duration)     { time.Sleep(dur) }
+func (d realReceiveDeps) HeadCommit() (string, error) { return git.HeadCommit() }
+func (d realReceiveDeps) AskPassphrase(prompt string) (string, error) {
+	return ui.ReadTTYPassphrase(prompt)
+}
+
+// RunCommand runs command through the shell and returns its exit code. The
+// error is only set when the command could not be started.
+func (d realReceiveDeps) RunCommand(command string, stdout, stderr io.Writer) (int, error) {
+	c := exec.Command("sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", command)
+	}
+	c.Stdin = os.Stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+	err := c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// ReceiveOptions controls a receive. The zero value applies the patch to
+// the working tree.
+type ReceiveOptions struct {
+	Code           string // the code from the sender, or just the code ID with a passphrase
+	Server         string // relay URL; "" means DefaultServer
+	Space          string // relay namespace the sender used
+	Commit         bool
+	Signoff        bool // add a Signed-off-by trailer to applied commits
+	Notes          bool // attach the sender's git notes to the applied commit
+	KeepAuthor     bool // commit a working-tree apply with the original authorship
+	Review         bool
+	SelectHunks    bool                // ask which hunks to apply
+	DryRun         bool                // print the diffstat and diff instead of applying
+	Color          bool                // colorize the review diff
+	Files          bool                // print changed paths instead of the diffstat
+	Passphrase     string              // sender-chosen passphrase; the code is then the bare code ID
+	AskPassphrase  bool                // prompt for the passphrase; the code is then the bare code ID
+	Peek           bool                // download without consuming the patch
+	Autocorrect    bool                // retry a wrong passphrase with one mistyped word corrected
+	StrictBase     bool                // refuse a patch made on a commit other than HEAD
+	Trusted        []ed25519.PublicKey // require a signature by one of these keys
+	StdoutMessages bool                // route messages and a JSON summary to stdout
+	JSON           bool                // print only a JSON result
+	SummaryFormat  string              // what to print after applying; "" means SummaryText
+	NoApply        bool                // only download and decrypt
+	Output         string              // where to write the patch; implies NoApply
+	Then           string              // shell command to run after a successful apply
+	Yes            bool                // skip the large patch confirmation
+	AllowOutside   bool                // let the patch write outside the repository
+	ThreeWay       bool                // merge with conflict markers when the patch doesn't apply
+	Wait           time.Duration       // how long to wait for a patch that isn't uploaded yet
+	Format         string              // print the patch in this format instead of applying it
+	Interactive    bool                // a user can answer prompts on Stdin
+	Stdin          io.Reader           // answers to prompts
+
+	Connection Connection // how to reach the relay
+
+	Stdout io.Writer // the patch, summaries and --then output; nil discards them
+	Stderr io.Writer // progress and messages; nil discards them
+}
+
+func runReceiveWithDeps(stdout, stderr io.Writer, deps receiveDeps, args []string, opts ReceiveOptions) error {
+	switch {
+	case opts.JSON:
+		stderr = io.Discard
+	case opts.StdoutMessages:
+		stderr = stdout
+	}
+
+	summary, err := receivePatch(stdout, stderr, deps, args, opts)
+	switch {
+	case opts.JSON:
+		if err != nil {
+			summary.Error = err.Error()
+		}
+		if werr := writeReceiveSummary(stdout, summary); err == nil {
+			err = werr
+		}
+	case opts.StdoutMessages && err == nil:
+		err = writeReceiveSummary(stdout, summary)
+	case opts.SummaryFormat == SummaryJSON && err == nil && summary.Applied:
+		err = writeReceiveSummary(stdout, summary)
+	}
+	if err != nil || !summary.Applied || opts.Then == "" {
+		return err
+	}
+
+	return runThen(stdout, stderr, deps, opts.Then)
+}
+
+// runThen runs the --then command, turning a non-zero exit into an
+// ExitError so git-share exits with the same status.
+func runThen(stdout, stderr io.Writer, deps receiveDeps, command string) error {
+	fmt.Fprintf(stderr, "\nRunning %s\n", command)
+	code, err := deps.RunCommand(command, stdout, stderr)
+	if err != nil {
+		return fmt.Errorf("running --then command: %w", err)
+	}
+	if code != 0 {
+		return ExitError{Code: code}
+	}
+	return nil
+}
+
+// receivePatch downloads, decrypts, and applies a patch, describing what it
+// did in the returned summary.
+func receivePatch(stdout, stderr io.Writer, deps receiveDeps, args []string, opts ReceiveOptions) (receiveSummary, error) {
+	summary := receiveSummary{Mode: "patch"}
+	if opts.Commit {
+		summary.Mode = "commit"
+	}
+
+	code := codeFromArgs(args)
+
+	// 1. Parse the combined code
+	var codeID, passphrase string
+	var err error
+	switch {
+	case opts.AskPassphrase:
+		if opts.Passphrase != "" {
+			return summary, fmt.Errorf("--ask-passphrase cannot be combined with --passphrase")
+		}
+		if strings.Contains(code, crypto.CodeSep) {
+			return summary, fmt.Errorf("with --ask-passphrase, pass only the code ID")
+		}
+		codeID = code
+	case opts.Passphrase != "":
+		if strings.Contains(code, crypto.CodeSep) {
+			return summary, fmt.Errorf("with --passphrase, pass only the code ID")
+		}
+		codeID, passphrase = code, opts.Passphrase
+	default:
+		codeID, passphrase, err = crypto.ParseCode(code)
+		if err != nil {
+			return summary, err
+		}
+	}
+	if opts.Autocorrect && opts.Passphrase != "" {
+		return summary, fmt.Errorf("--autocorrect corrects generated passphrase words, so it cannot be combined with --passphrase")
+	}
+	if opts.Notes && !opts.Commit {
+		return summary, fmt.Errorf("--with-notes requires --commit")
+	}
+	if opts.Signoff && !opts.Commit {
+		fmt.Fprintf(stderr, "Warning: --signoff only applies with --commit; ignoring it.\n")
+	}
+	if opts.KeepAuthor && opts.Commit {
+		return summary, fmt.Errorf("--keep-author cannot be combined with --commit, which already keeps the author")
+	}
+	if opts.Output != "" {
+		// Saving the patch is instead of applying it
+		if opts.Commit || opts.Review || opts.SelectHunks || opts.KeepAuthor || opts.ThreeWay || opts.DryRun || opts.Format != "" || opts.Then != "" {
+			return summary, fmt.Errorf("--output saves the patch without applying it, so it cannot be combined with --commit, --review, --interactive, --keep-author, --3way, --dry-run, --format, or --then")
+		}
+		opts.NoApply = true
+	}
+	if opts.KeepAuthor && (opts.NoApply || opts.Format != "") {
+		return summary, fmt.Errorf("--keep-author cannot be combined with --no-apply or --format")
+	}
+	if opts.SelectHunks && (opts.Commit || opts.Review || opts.JSON || opts.NoApply || opts.Format != "") {
+		return summary, fmt.Errorf("--interactive cannot be combined with --commit, --review, --json, --no-apply, or --format")
+	}
+	if opts.DryRun && (opts.Commit || opts.Review || opts.SelectHunks || opts.KeepAuthor || opts.NoApply || opts.Format != "" || opts.Then != "" || opts.JSON || opts.StdoutMessages) {
+		return summary, fmt.Errorf("--dry-run cannot be combined with --commit, --review, --interactive, --keep-author, --no-apply, --format, --then, --json, or --stdout-messages")
+	}
+	if opts.AllowOutside && opts.Commit {
+		return summary, fmt.Errorf("--allow-outside cannot be combined with --commit")
+	}
+	if opts.JSON && opts.Review {
+		return summary, fmt.Errorf("--json cannot be combined with --review")
+	}
+	if opts.Then != "" && (opts.JSON || opts.NoApply) {
+		return summary, fmt.Errorf("--then cannot be combined with --json or --no-apply")
+	}
+	switch opts.SummaryFormat {
+	case "":
+		opts.SummaryFormat = SummaryText
+	case SummaryText, SummaryJSON, SummaryNone:
+	default:
+		return summary, fmt.Errorf("unknown --summary-format %q; use %s, %s, or %s", opts.SummaryFormat, SummaryText, SummaryJSON, SummaryNone)
+	}
+	if opts.SummaryFormat != SummaryText && (opts.JSON || opts.StdoutMessages) {
+		return summary, fmt.Errorf("--summary-format cannot be combined with --json or --stdout-messages, which print their own summary")
+	}
+	if opts.Format != "" && opts.Format != formatGitHubSuggestion {
+		return summary, fmt.Errorf("unknown --format %q; the supported format is %q", opts.Format, formatGitHubSuggestion)
+	}
+	if opts.Format != "" && opts.NoApply {
+		return summary, fmt.Errorf("--format cannot be combined with --no-apply")
+	}
+	if opts.Format != "" {
+		// Printing a suggestion never touches git
+		opts.NoApply = true
+	}
+
+	// 2. Make sure we're in a git repo
+	if !opts.NoApply && !opts.DryRun {
+		_, err = deps.FindRepoRoot()
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	// 3. Download from relay server
+	if opts.Peek {
+		fmt.Fprintf(stderr, "Peeking: the patch stays on the relay until it is received without --peek or expires.\n")
+		deps = peekingDeps{deps}
+	}
+	fmt.Fprintf(stderr, "Downloading patch...\n")
+	encodedData, err := waitForPatch(stderr, deps, codeID, opts.Wait)
+	if err != nil {
+		return summary, err
+	}
+
+	// 4. Derive key and decrypt
+	var keys *blobKeys
+	var plaintext []byte
+	if opts.AskPassphrase {
+		keys, plaintext, err = askPassphrase(stderr, deps, codeID, encodedData, opts.Autocorrect)
+	} else {
+		fmt.Fprintf(stderr, "Decrypting...\n")
+		keys = newBlobKeys(deps, passphrase)
+		plaintext, err = decryptBlob(keys, encodedData)
+		if errors.Is(err, crypto.ErrDecryptionFailed) && opts.Autocorrect {
+			if fixedKeys, fixed, ok := autocorrect(stderr, deps, passphrase, encodedData); ok {
+				keys, plaintext, err = fixedKeys, fixed, nil
+			}
+		}
+	}
+	if err != nil {
+		return summary, err
+	}
+
+	header, patch, err := payload.Decode(plaintext)
+	if err != nil {
+		return summary, err
+	}
+
+	// 5. Reassemble split uploads
+	if header.Kind == payload.KindManifest {
+		header, patch, err = fetchParts(stderr, deps, header.Parts, keys)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	if err := checkSignature(stderr, header, patch, opts.Trusted); err != nil {
+		return summary, err
+	}
+
+	// A commit sent with --show carries its message ahead of the diff
+	if header.Format == payload.FormatShow {
+		message, diff := git.SplitShow(patch)
+		if len(message) > 0 {
+			fmt.Fprintf(stderr, "\n%s\n", bytes.TrimRight(message, "\n"))
+		}
+		if !opts.NoApply {
+			if len(diff) == 0 {
+				return summary, fmt.Errorf("the shared commit has no changes to apply")
+			}
+			patch = diff
+		}
+		if opts.Commit {
+			fmt.Fprintf(stderr, "Warning: this commit was sent with --show, so it is applied to the working tree rather than as a commit.\n")
+			opts.Commit, opts.Signoff, opts.Notes = false, false, false
+			summary.Mode = "patch"
+		}
+	}
+
+	// A --textconv diff is for reading; applying it would write the
+	// converted text over the real files
+	if header.Format == payload.FormatTextconv && !opts.DryRun && !opts.NoApply && opts.Format != formatGitHubSuggestion {
+		fmt.Fprintf(stderr, "Warning: this patch was sent with --textconv for reading, so it is printed instead of applied.\n")
+		opts.DryRun = true
+	}
+	summary.Bytes = len(patch)
+	summary.Fingerprint = crypto.Fingerprint(patch)
+	summary.Files = git.PatchFiles(patch)
+
+	// Print the patch as review suggestions
+	if opts.Format == formatGitHubSuggestion {
+		text, err := githubSuggestions(patch)
+		if err != nil {
+			fmt.Fprintf(stderr, "This patch can't be shown as a suggestion (%v); printing it as is.\n", err)
+			_, err = stdout.Write(patch)
+			return summary, err
+		}
+		_, err = io.WriteString(stdout, text)
+		return summary, err
+	}
+
+	// Show what the patch would do, and stop there
+	if opts.DryRun {
+		if stats, _ := deps.PatchStats(patch); stats != "" {
+			fmt.Fprintf(stderr, "\n%s\n", stats)
+		}
+		if _, err := stdout.Write(patch); err != nil {
+			return summary, err
+		}
+		fmt.Fprintf(stderr, "Dry run: nothing was applied. The code has been used and can't be received again.\n")
+		return summary, nil
+	}
+
+	// Hand over the patch without touching git
+	if opts.NoApply {
+		if opts.Output == "" {
+			fmt.Fprintf(stderr, "Decrypted %s. Not applied; use --output to save it.\n", ui.FormatByteSize(int64(len(patch))))
+			return summary, nil
+		}
+		if err := deps.WriteOutput(opts.Output, patch); err != nil {
+			return summary, fmt.Errorf("writing patch: %w", err)
+		}
+		if opts.Output != "-" {
+			fmt.Fprintf(stderr, "Decrypted %s and saved it to %s.\n", ui.FormatByteSize(int64(len(patch))), opts.Output)
+		}
+		return summary, nil
+	}
+
+	if err := checkBase(stderr, deps, header.Base, opts.StrictBase); err != nil {
+		return summary, err
+	}
+
+	// Read the authorship to keep before anything touches the tree
+	var commitInfo git.CommitInfo
+	if opts.KeepAuthor {
+		if commitInfo, err = git.ParseMbox(patch); err != nil {
+			return summary, fmt.Errorf("--keep-author: %w", err)
+		}
+	}
+
+	// 6. Let the user review the patch before it touches the tree
+	if opts.SelectHunks {
+		patch, err = selectHunks(opts.Stdin, stderr, patch, opts.Color)
+		if err != nil {
+			return summary, err
+		}
+		if patch == nil {
+			fmt.Fprintf(stderr, "No hunks chosen; nothing was applied.\n")
+			return summary, nil
+		}
+		summary.Files = git.PatchFiles(patch)
+	} else if opts.Review {
+		apply, err := reviewPatch(stdout, stderr, deps, patch, opts)
+		if err != nil {
+			return summary, err
+		}
+		if !apply {
+			return summary, nil
+		}
+	} else if len(patch) > largeReceiveSize && !opts.Yes {
+		apply, err := confirmLargePatch(stderr, deps, patch, opts)
+		if err != nil {
+			return summary, err
+		}
+		if !apply {
+			return summary, nil
+		}
+	}
+
+	// 7. Apply the patch
+	fmt.Fprintf(stderr, "Applying patch...\n")
+	applyOpts := git.ApplyOptions{
+		Commit:       opts.Commit,
+		Signoff:      opts.Signoff && opts.Commit,
+		AllowOutside: opts.AllowOutside,
+		ThreeWay:     opts.ThreeWay,
+	}
+	if err := deps.ApplyPatch(patch, applyOpts); err != nil {
+		ExplainApplyError(stderr, err)
+		var conflict *git.ConflictError
+		if errors.Is(err, git.ErrPatchConflict) && !errors.As(err, &conflict) {
+			// The relay copy is consumed, so keep the patch for a retry
+			if path, serr := deps.SavePatch(patch); serr == nil {
+				fmt.Fprintf(stderr, "The patch was saved to %s; retry with: git-share apply --3way %s\n", path, path)
+			}
+		}
+		return summary, err
+	}
+	summary.Applied = true
+
+	if opts.KeepAuthor {
+		sha, err := deps.CommitPatch(patch, commitInfo)
+		if err != nil {
+			return summary, fmt.Errorf("patch applied, but committing it failed: %w", err)
+		}
+		fmt.Fprintf(stderr, "Committed %s as %s.\n", shortSHA(sha), commitInfo.Author)
+	}
+
+	// Re-attach git notes to the new commit
+	switch {
+	case header.Notes != "" && opts.Notes:
+		if err := deps.AddNotes("HEAD", header.Notes); err != nil {
+			return summary, err
+		}
+		fmt.Fprintf(stderr, "Attached git notes to the applied commit.\n")
+	case header.Notes != "":
+		fmt.Fprintf(stderr, "The patch includes git notes; receive with --commit --with-notes to attach them.\n")
+	case opts.Notes:
+		fmt.Fprintf(stderr, "The patch has no git notes to attach.\n")
+	}
+
+	// 8. Show stats
+	if opts.SummaryFormat == SummaryNone {
+		return summary, nil
+	}
+	if opts.SummaryFormat == SummaryText {
+		fmt.Fprintf(stderr, "\nPatch applied successfully.\n")
+	}
+	if opts.JSON || opts.StdoutMessages || opts.SummaryFormat == SummaryJSON {
+		if stats, err := deps.PatchSummary(patch); err == nil {
+			summary.Insertions, summary.Deletions = stats.Added, stats.Deleted
+		}
+		return summary, nil
+	}
+	if opts.Files {
+		for _, path := range summary.Files {
+			fmt.Fprintln(stdout, path)
+		}
+		return summary, nil
+	}
+	stats, _ := deps.PatchStats(patch)
+	if stats != "" {
+		fmt.Fprintf(stderr, "\n%s\n", stats)
+	}
+
+	return summary, nil
+}
+
+// waitForPatch receives a patch, polling for up to wait while the relay has
+// never seen the code ID, in case the sender is still uploading. A patch that
+// expired or was already received is reported at once.
+func waitForPatch(stderr io.Writer, deps receiveDeps, codeID string, wait time.Duration) (string, error) {
+	for waited := time.Duration(0); ; {
+		data, err := deps.Receive(codeID)
+		if !errors.Is(err, client.ErrNotFound) || waited >= wait {
+			return data, err
+		}
+		if waited == 0 {
+			fmt.Fprintf(stderr, "The patch isn't on the relay yet; waiting up to %s...\n", wait)
+		}
+		pause := min(receivePollInterval, wait-waited)
+		deps.Sleep(pause)
+		waited += pause
+	}
+}
+
+// codeFromArgs turns the receive arguments back into one code. A single
+// argument is used verbatim; otherwise the arguments are joined, so
+// "codeId word1 word2 word3 word4" works too. A pasted "git-share receive"
+// prefix is dropped.
+func codeFromArgs(args []string) string {
+	for len(args) > 1 && isReceivePrefix(args[0]) {
+		args = args[1:]
+	}
+	if len(args) == 1 {
+		return args[0]
+	}
+
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		// Avoid doubled separators from pastes like "k7- alpha -bravo"
+		if arg = strings.Trim(arg, crypto.CodeSep); arg != "" {
+			parts = append(parts, arg)
+		}
+	}
+	return strings.Join(parts, crypto.CodeSep)
+}
+
+// isReceivePrefix reports whether arg is part of a pasted receive command
+// rather than the code.
+func isReceivePrefix(arg string) bool {
+	switch arg {
+	case "git-share", "receive", "r", "get":
+		return true
+	}
+	return false
+}
+
+// receiveSummary is the machine-readable result of a receive.
+type receiveSummary struct {
+	Applied      bool     `json:"applied"`
+	Mode         string   `json:"mode"` // "patch" or "commit"
+	Files        []string `json:"files"`
+	FilesChanged int      `json:"files_changed"` // len(Files), for tools that only want the count
+	Insertions   int      `json:"insertions"`
+	Deletions    int      `json:"deletions"`
+	Bytes        int      `json:"bytes"`
+	Fingerprint  string   `json:"fingerprint,omitempty"` // SHA-256 of the patch
+	Error        string   `json:"error,omitempty"`
+}
+
+func writeReceiveSummary(w io.Writer, s receiveSummary) error {
+	if s.Files == nil {
+		s.Files = []string{}
+	}
+	s.FilesChanged = len(s.Files)
+	return json.NewEncoder(w).Encode(s)
+}
+
+// reviewPatch shows the patch and asks whether to apply it. A declined patch
+// is saved to a temporary file, since the relay copy is already consumed.
+func reviewPatch(stdout, stderr io.Writer, deps receiveDeps, patch []byte, opts ReceiveOptions) (bool, error) {
+	preview := patch
+	if opts.Color {
+		preview = diffcolor.Colorize(patch)
+	}
+	if err := deps.Page(preview, stdout); err != nil {
+		return false, fmt.Errorf("showing patch: %w", err)
+	}
+
+	apply, err := ui.Confirm(opts.Stdin, stderr, "\nApply this patch?")
+	if err != nil {
+		return false, err
+	}
+	if apply {
+		return true, nil
+	}
+
+	path, err := deps.SavePatch(patch)
+	if err != nil {
+		return false, fmt.Errorf("patch not applied, and saving it failed: %w", err)
+	}
+	fmt.Fprintf(stderr, "Patch not applied. It was saved to %s\n", path)
+	return false, nil
+}
+
+// confirmLargePatch shows the stats of a large patch and asks whether to
+// apply it. Without a terminal it only warns. A declined patch is saved to a
+// temporary file, since the relay copy is already consumed.
+func confirmLargePatch(stderr io.Writer, deps receiveDeps, patch []byte, opts ReceiveOptions) (bool, error) {
+	size := ui.FormatByteSize(int64(len(patch)))
+	if !opts.Interactive {
+		fmt.Fprintf(stderr, "Warning: applying a large patch (%s).\n", size)
+		return true, nil
+	}
+
+	fmt.Fprintf(stderr, "\nThis patch is %s.\n", size)
+	if stats, _ := deps.PatchStats(patch); stats != "" {
+		fmt.Fprintf(stderr, "%s\n", stats)
+	}
+	apply, err := ui.Confirm(opts.Stdin, stderr, "Apply it?")
+	if err != nil || apply {
+		return apply, err
+	}
+
+	path, err := deps.SavePatch(patch)
+	if err != nil {
+		return false, fmt.Errorf("patch not applied, and saving it failed: %w", err)
+	}
+	fmt.Fprintf(stderr, "Patch not applied; pass --yes to skip this check. It was saved to %s\n", path)
+	return false, nil
+}
+
+// decryptBlob decodes a base64 or base64url blob from the relay and decrypts it.
+func decryptBlob(keys *blobKeys, encodedData string) ([]byte, error) {
+	encrypted, err := payload.DecodeData(encodedData)
+	if err != nil {
+		return nil, fmt.Errorf("decoding data: %w", err)
+	}
+
+	return keys.decrypt(encrypted)
+}
+
+// askPassphrase asks for the passphrase of a downloaded blob until it
+// decrypts the blob, up to passphraseAttempts times, since the relay has
+// already deleted it and a typo shouldn't lose the patch.
+func askPassphrase(stderr io.Writer, deps receiveDeps, codeID, encodedData string, correct bool) (*blobKeys, []byte, error) {
+	for attempt := 1; ; attempt++ {
+		words, err := deps.AskPassphrase("Passphrase: ")
+		if err != nil {
+			return nil, nil, err
+		}
+		var passphrase string
+		if _, passphrase, err = crypto.ParseCodeParts(codeID, words); err == nil {
+			fmt.Fprintf(stderr, "Decrypting...\n")
+			keys := newBlobKeys(deps, passphrase)
+			var plaintext []byte
+			plaintext, err = decryptBlob(keys, encodedData)
+			if err == nil || !errors.Is(err, crypto.ErrDecryptionFailed) {
+				return keys, plaintext, err
+			}
+			if correct {
+				if fixedKeys, fixed, ok := autocorrect(stderr, deps, passphrase, encodedData); ok {
+					return fixedKeys, fixed, nil
+				}
+			}
+		}
+		if attempt == passphraseAttempts {
+			return nil, nil, err
+		}
+		fmt.Fprintf(stderr, "%v; try again.\n", err)
+	}
+}
+
+// autocorrect retries a passphrase that didn't decrypt a blob with one of
+// its words replaced by a built-in word a single typo away. Words that are
+// in no list are the likeliest typos, so they are tried first. At most
+// autocorrectAttempts passphrases are tried; ok is false if none worked.
+func autocorrect(stderr io.Writer, deps receiveDeps, passphrase, encodedData string) (keys *blobKeys, plaintext []byte, ok bool) {
+	words := strings.Split(passphrase, crypto.PassphraseSep)
+	var order []int
+	for i, w := range words {
+		if !wordlist.Contains(w) {
+			order = append(order, i)
+		}
+	}
+	for i, w := range words {
+		if wordlist.Contains(w) {
+			order = append(order, i)
+		}
+	}
+
+	fmt.Fprintf(stderr, "Decryption failed; trying to correct a mistyped word...\n")
+	attempts := 0
+	for _, i := range order {
+		for _, candidate := range wordlist.Near(words[i]) {
+			if attempts == autocorrectAttempts {
+				fmt.Fprintf(stderr, "No correction found in %d tries.\n", attempts)
+				return nil, nil, false
+			}
+			attempts++
+			fixed := slices.Clone(words)
+			fixed[i] = candidate
+			keys := newBlobKeys(deps, strings.Join(fixed, crypto.PassphraseSep))
+			plaintext, err := decryptBlob(keys, encodedData)
+			if err == nil {
+				fmt.Fprintf(stderr, "Corrected word %d from %q to %q.\n", i+1, words[i], candidate)
+				return keys, plaintext, true
+			}
+			if !errors.Is(err, crypto.ErrDecryptionFailed) {
+				return nil, nil, false
+			}
+		}
+	}
+	fmt.Fprintf(stderr, "No correction found.\n")
+	return nil, nil, false
+}
+
+// blobKeys derives the key for each blob with the KDF its header names,
+// deriving each key only once, since the parts of a split upload share one.
+type blobKeys struct {
+	deps       receiveDeps
+	passphrase string
+	derived    map[string][]byte // by KDF header; "" for HKDF
+}
+
+func newBlobKeys(deps receiveDeps, passphrase string) *blobKeys {
+	return &blobKeys{deps: deps, passphrase: passphrase, derived: make(map[string][]byte)}
+}
+
+// decrypt decrypts a blob. A blob with an Argon2id KDF header that fails to
+// decrypt is retried as a legacy HKDF blob, in case its random nonce only
+// looked like a header.
+func (k *blobKeys) decrypt(encrypted []byte) ([]byte, error) {
+	params, rest, headed, headerErr := crypto.ParseKDFHeader(encrypted)
+	if headed && headerErr == nil {
+		header := string(encrypted[:len(encrypted)-len(rest)])
+		key, err := k.key(header, func() ([]byte, error) { return k.deps.DeriveKeyArgon2(k.passphrase, params) })
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := k.deps.Decrypt(rest, key)
+		if err == nil {
+			return plaintext, nil
+		}
+		headerErr = err
+	}
+
+	key, err := k.key("", func() ([]byte, error) { return k.deps.DeriveKey(k.passphrase) })
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := k.deps.Decrypt(encrypted, key)
+	if err != nil && headerErr != nil {
+		return nil, headerErr
+	}
+	return plaintext, err
+}
+
+func (k *blobKeys) key(id string, derive func() ([]byte, error)) ([]byte, error) {
+	if key, ok := k.derived[id]; ok {
+		return key, nil
+	}
+	key, err := derive()
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	k.derived[id] = key
+	return key, nil
+}
+
+// checkBase compares the commit a working tree diff was made on with HEAD,
+// since a diff applied elsewhere may apply only in part, or to the wrong
+// branch. A difference is a warning, or an error with strict.
+func checkBase(stderr io.Writer, deps receiveDeps, base string, strict bool) error {
+	if base == "" {
+		return nil
+	}
+	head, err := deps.HeadCommit()
+	if err == nil && head == base {
+		return nil
+	}
+	here := "this repository has no commits"
+	if err == nil {
+		here = "HEAD is " + shortSHA(head)
+	}
+	if strict {
+		return fmt.Errorf("the patch was made on commit %s, but %s; check out that commit, or receive without --strict-base", shortSHA(base), here)
+	}
+	fmt.Fprintf(stderr, "Warning: the patch was made on commit %s, but %s. It may apply only in part, or to the wrong branch.\n", shortSHA(base), here)
+	return nil
+}
+
+// checkSignature checks a signed patch's signature, and with trusted keys,
+// that it is signed by one of them.
+func checkSignature(stderr io.Writer, header payload.Header, patch []byte, trusted []ed25519.PublicKey) error {
+	if header.Signature == "" {
+		if len(trusted) > 0 {
+			return fmt.Errorf("the patch is not signed, but --verify requires a signature from a trusted sender")
+		}
+		return nil
+	}
+	pub, err := crypto.ParsePublicKey(header.SignerKey)
+	if err != nil {
+		return fmt.Errorf("the patch's signing key is invalid: %w", err)
+	}
+	if err := crypto.Verify(pub, payload.SignedData(header, patch), header.Signature); err != nil {
+		return fmt.Errorf("the patch's signature doesn't match its contents; it may have been tampered with")
+	}
+	fingerprint := crypto.KeyFingerprint(pub)
+	if len(trusted) == 0 {
+		fmt.Fprintf(stderr, "Signed by key %s (pass --verify to require a trusted key)\n", fingerprint)
+		return nil
+	}
+	if !slices.ContainsFunc(trusted, func(k ed25519.PublicKey) bool { return k.Equal(pub) }) {
+		return fmt.Errorf("the patch is signed by key %s, which is not a --verify key", fingerprint)
+	}
+	fmt.Fprintf(stderr, "Verified signature from trusted key %s\n", fingerprint)
+	return nil
+}
+
+// peekingDeps downloads blobs without consuming them, for receive --peek.
+type peekingDeps struct{ receiveDeps }
+
+func (d peekingDeps) Receive(codeID string) (string, error) { return d.Peek(codeID) }
+
+// fetchParts downloads every part listed in a manifest and decrypts the
+// reassembled blob. Every part is checked before any is received, so a
+// missing one doesn't cost the others their one download.
+func fetchParts(stderr io.Writer, deps receiveDeps, parts []string, keys *blobKeys) (payload.Header, []byte, error) {
+	unavailable := func(i int, err error) error {
+		return fmt.Errorf("part %d/%d is unavailable (it may have already been received or expired, ask the sender to resend): %w", i+1, len(parts), err)
+	}
+	for i, partID := range parts {
+		if _, err := deps.Status(partID); err != nil {
+			return payload.Header{}, nil, unavailable(i, err)
+		}
+	}
+
+	fmt.Fprintf(stderr, "Downloading %d parts...\n", len(parts))
+	var encoded strings.Builder
+	for i, partID := range parts {
+		data, err := deps.Receive(partID)
+		if err != nil {
+			return payload.Header{}, nil, unavailable(i, err)
+		}
+		encoded.WriteString(data)
+	}
+
+	plaintext, err := decryptBlob(keys, encoded.String())
+	if err != nil {
+		return payload.Header{}, nil, err
+	}
+
+	header, patch, err := payload.Decode(plaintext)
+	if err != nil {
+		return payload.Header{}, nil, err
+	}
+	if header.Kind != payload.KindPatch {
+		return payload.Header{}, nil, fmt.Errorf("unexpected payload kind %q in split upload", header.Kind)
+	}
+	return header, patch, nil
+}
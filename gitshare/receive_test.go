@@ -0,0 +1,1353 @@
+package gitshare
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+	"github.com/flawiddsouza/git-share/internal/crypto"
+	"github.com/flawiddsouza/git-share/internal/git"
+	"github.com/flawiddsouza/git-share/internal/payload"
+)
+
+type mockReceiveDeps struct {
+	relay           map[string]string
+	applied         []byte
+	appliedAsCommit bool
+	signoff         bool
+	allowOutside    bool
+	threeWay        bool
+	stats           string
+	paged           []byte
+	savedPatch      []byte
+	notes           map[string]string
+	derivedFrom     string   // passphrase passed to DeriveKey
+	argon2Salts     [][]byte // salts passed to DeriveKeyArgon2
+	summary         git.Summary
+	applyErr        error
+	noRepo          bool
+	outputs         map[string][]byte // WriteOutput calls by path
+	ran             []string          // RunCommand calls
+	exitCode        int               // returned by RunCommand
+	notYet          int               // Receive reports not found this many times first
+	receiveErr      error             // returned by Receive when set
+	slept           time.Duration
+	committed       *git.CommitInfo // CommitPatch call
+	answers         []string        // returned by AskPassphrase, in order
+	passphrase      string          // when set, Decrypt fails for keys from other passphrases
+	peeked          []string        // code IDs passed to Peek
+	head            string          // returned by HeadCommit; empty for a repo without commits
+}
+
+func (m *mockReceiveDeps) FindRepoRoot() (string, error) {
+	if m.noRepo {
+		return "", errors.New("not a git repository (or any parent)")
+	}
+	return "/repo", nil
+}
+func (m *mockReceiveDeps) Receive(codeID string) (string, error) {
+	if m.receiveErr != nil {
+		return "", m.receiveErr
+	}
+	if m.notYet > 0 {
+		m.notYet--
+		return "", client.ErrNotFound
+	}
+	data, ok := m.relay[codeID]
+	if !ok {
+		return "", client.ErrNotFound
+	}
+	delete(m.relay, codeID)
+	return data, nil
+}
+func (m *mockReceiveDeps) DeriveKey(passphrase string) ([]byte, error) {
+	m.derivedFrom = passphrase
+	return []byte("key"), nil
+}
+func (m *mockReceiveDeps) DeriveKeyArgon2(passphrase string, params crypto.Argon2Params) ([]byte, error) {
+	m.derivedFrom = passphrase
+	m.argon2Salts = append(m.argon2Salts, params.Salt)
+	return []byte("argon2-key"), nil
+}
+func (m *mockReceiveDeps) Decrypt(data, key []byte) ([]byte, error) {
+	if m.passphrase != "" && m.derivedFrom != m.passphrase {
+		return nil, crypto.ErrDecryptionFailed
+	}
+	return data, nil
+}
+func (m *mockReceiveDeps) ApplyPatch(patch []byte, opts git.ApplyOptions) error {
+	if m.applyErr != nil {
+		return m.applyErr
+	}
+	m.applied = patch
+	m.appliedAsCommit = opts.Commit
+	m.signoff = opts.Signoff
+	m.allowOutside = opts.AllowOutside
+	m.threeWay = opts.ThreeWay
+	return nil
+}
+func (m *mockReceiveDeps) CommitPatch(patch []byte, info git.CommitInfo) (string, error) {
+	m.committed = &info
+	return "0123456789abcdef0123456789abcdef01234567", nil
+}
+func (m *mockReceiveDeps) AddNotes(ref, notes string) error {
+	if m.notes == nil {
+		m.notes = map[string]string{}
+	}
+	m.notes[ref] = notes
+	return nil
+}
+func (m *mockReceiveDeps) PatchStats(patch []byte) (string, error) { return m.stats, nil }
+func (m *mockReceiveDeps) PatchSummary(patch []byte) (git.Summary, error) {
+	return m.summary, nil
+}
+func (m *mockReceiveDeps) Page(text []byte, w io.Writer) error {
+	m.paged = text
+	_, err := w.Write(text)
+	return err
+}
+func (m *mockReceiveDeps) WriteOutput(path string, patch []byte) error {
+	if m.outputs == nil {
+		m.outputs = map[string][]byte{}
+	}
+	m.outputs[path] = patch
+	return nil
+}
+func (m *mockReceiveDeps) Sleep(d time.Duration) { m.slept += d }
+func (m *mockReceiveDeps) RunCommand(command string, stdout, stderr io.Writer) (int, error) {
+	m.ran = append(m.ran, command)
+	fmt.Fprintf(stdout, "output of %s\n", command)
+	return m.exitCode, nil
+}
+func (m *mockReceiveDeps) Peek(codeID string) (string, error) {
+	m.peeked = append(m.peeked, codeID)
+	data, ok := m.relay[codeID]
+	if !ok {
+		return "", client.ErrNotFound
+	}
+	return data, nil
+}
+func (m *mockReceiveDeps) Status(codeID string) (*client.StatusResponse, error) {
+	data, ok := m.relay[codeID]
+	if !ok {
+		return nil, client.ErrNotFound
+	}
+	return &client.StatusResponse{OK: true, Size: len(data)}, nil
+}
+func (m *mockReceiveDeps) HeadCommit() (string, error) {
+	if m.head == "" {
+		return "", errors.New("resolving HEAD: no commits")
+	}
+	return m.head, nil
+}
+func (m *mockReceiveDeps) AskPassphrase(prompt string) (string, error) {
+	if len(m.answers) == 0 {
+		return "", io.EOF
+	}
+	answer := m.answers[0]
+	m.answers = m.answers[1:]
+	return answer, nil
+}
+func (m *mockReceiveDeps) SavePatch(patch []byte) (string, error) {
+	m.savedPatch = patch
+	return "/tmp/git-share-123.patch", nil
+}
+
+// sendToRelay runs a send against an in-memory relay and returns the code.
+func sendToRelay(t *testing.T, relay map[string]string, patch string, opts SendOptions) string {
+	t.Helper()
+	deps := &mockSendDeps{
+		repoRoot:   "/repo",
+		patch:      []byte(patch),
+		code:       "main-alpha-bravo-charlie-delta",
+		codeID:     "main",
+		passphrase: "alpha-bravo-charlie-delta",
+		expiry:     "2026-02-27T17:00:00Z",
+		relay:      relay,
+	}
+	if opts.TTL == "" {
+		opts.TTL = "1h"
+	}
+	if _, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, nil, opts); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	return deps.code
+}
+
+func TestRunReceiveWithDeps(t *testing.T) {
+	relay := map[string]string{}
+	code := sendToRelay(t, relay, "diff content", SendOptions{})
+
+	stderr := &bytes.Buffer{}
+	deps := &mockReceiveDeps{relay: relay, stats: "file.txt | 2 +"}
+	err := runReceiveWithDeps(&bytes.Buffer{}, stderr, deps, []string{code}, ReceiveOptions{Commit: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(deps.applied) != "diff content" {
+		t.Errorf("applied %q, want %q", deps.applied, "diff content")
+	}
+	if !deps.appliedAsCommit {
+		t.Error("expected --commit to be passed through to ApplyPatch")
+	}
+	if !strings.Contains(stderr.String(), "Patch applied successfully.") {
+		t.Errorf("stderr missing success message\nGOT:\n%s", stderr.String())
+	}
+}
+
+func TestReceiveSplitPatch(t *testing.T) {
+	relay := map[string]string{}
+	patch := strings.Repeat("0123456789", 4)
+	// The encoded blob is 56 bytes of base64, so 20-byte parts give three parts.
+	code := sendToRelay(t, relay, patch, SendOptions{SplitSize: "20B"})
+
+	if len(relay) != 4 {
+		t.Fatalf("expected 3 parts plus a manifest on the relay, got %d blobs", len(relay))
+	}
+
+	deps := &mockReceiveDeps{relay: relay}
+	err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(deps.applied) != patch {
+		t.Errorf("reassembled patch mismatch:\ngot:  %q\nwant: %q", deps.applied, patch)
+	}
+	if len(relay) != 0 {
+		t.Errorf("expected every part to be consumed, %d blobs left", len(relay))
+	}
+}
+
+func TestReceiveSplitPatchMissingPart(t *testing.T) {
+	for i, missing := range []string{"part1", "part2", "part3"} {
+		t.Run(missing, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, strings.Repeat("0123456789", 4), SendOptions{SplitSize: "20B"})
+			delete(relay, missing)
+
+			deps := &mockReceiveDeps{relay: relay}
+			err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{})
+			if err == nil {
+				t.Fatal("expected an error for a missing part")
+			}
+			if want := fmt.Sprintf("part %d/3 is unavailable", i+1); !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q, want %q", err, want)
+			}
+			if deps.applied != nil {
+				t.Error("nothing should be applied when a part is missing")
+			}
+			// None of the parts that are there was consumed
+			for _, part := range []string{"part1", "part2", "part3"} {
+				if _, ok := relay[part]; !ok && part != missing {
+					t.Errorf("%s was consumed", part)
+				}
+			}
+		})
+	}
+}
+
+func TestReceiveReview(t *testing.T) {
+	tests := []struct {
+		name      string
+		answer    string
+		color     bool
+		wantApply bool
+	}{
+		{name: "accepted", answer: "y\n", wantApply: true},
+		{name: "accepted with color", answer: "yes\n", color: true, wantApply: true},
+		{name: "declined", answer: "n\n"},
+		{name: "no answer", answer: ""},
+	}
+
+	patch := "@@ -1 +1 @@\n-old\n+new\n"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, patch, SendOptions{})
+
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			deps := &mockReceiveDeps{relay: relay}
+			opts := ReceiveOptions{Review: true, Color: tt.color, Stdin: strings.NewReader(tt.answer)}
+			if err := runReceiveWithDeps(stdout, stderr, deps, []string{code}, opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.color != strings.Contains(stdout.String(), "\x1b[") {
+				t.Errorf("color=%v but preview was %q", tt.color, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), "Apply this patch?") {
+				t.Errorf("expected a confirmation prompt\nGOT:\n%s", stderr.String())
+			}
+			if tt.wantApply {
+				if string(deps.applied) != patch {
+					t.Errorf("expected patch to be applied, got %q", deps.applied)
+				}
+				return
+			}
+			if deps.applied != nil {
+				t.Error("declined patch should not be applied")
+			}
+			if string(deps.savedPatch) != patch {
+				t.Errorf("declined patch should be saved, got %q", deps.savedPatch)
+			}
+			if !strings.Contains(stderr.String(), "/tmp/git-share-123.patch") {
+				t.Errorf("expected saved path in output\nGOT:\n%s", stderr.String())
+			}
+		})
+	}
+}
+
+func TestReceiveWithNotes(t *testing.T) {
+	relay := map[string]string{}
+	sendDeps := &mockSendDeps{
+		patch:      []byte("From abc Mon Sep 17 00:00:00 2001\n"),
+		code:       "main-alpha-bravo-charlie-delta",
+		codeID:     "main",
+		passphrase: "alpha-bravo-charlie-delta",
+		relay:      relay,
+		notes:      "Reviewed-by: Jane\n",
+	}
+	if _, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, sendDeps, []string{"HEAD"}, SendOptions{TTL: "1h"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	deps := &mockReceiveDeps{relay: relay}
+	opts := ReceiveOptions{Commit: true, Notes: true}
+	if err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{sendDeps.code}, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(deps.applied) != string(sendDeps.patch) {
+		t.Errorf("applied %q, want the bare patch %q", deps.applied, sendDeps.patch)
+	}
+	if deps.notes["HEAD"] != "Reviewed-by: Jane\n" {
+		t.Errorf("notes on HEAD = %q", deps.notes["HEAD"])
+	}
+}
+
+func TestReceiveBase(t *testing.T) {
+	const base = "1111111111111111111111111111111111111111"
+	tests := []struct {
+		name        string
+		sentOn      string // sender's HEAD; empty sends no base
+		ref         string // commit the sender shares; empty for working tree changes
+		head        string // receiver's HEAD
+		strict      bool
+		wantApplied bool
+		wantWarning bool
+	}{
+		{"same commit", base, "", base, true, true, false},
+		{"different commit", base, "", "2222222222222222222222222222222222222222", false, true, true},
+		{"different commit strict", base, "", "2222222222222222222222222222222222222222", true, false, false},
+		{"no commits yet", base, "", "", false, true, true},
+		{"no base sent", "", "", "2222222222222222222222222222222222222222", true, true, false},
+		{"commit sent", base, "HEAD", "2222222222222222222222222222222222222222", true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			sendDeps := &mockSendDeps{
+				patch:      []byte("diff content"),
+				code:       "main-alpha-bravo-charlie-delta",
+				codeID:     "main",
+				passphrase: "alpha-bravo-charlie-delta",
+				relay:      relay,
+				head:       tt.sentOn,
+			}
+			var args []string
+			if tt.ref != "" {
+				args = []string{tt.ref}
+			}
+			if _, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, sendDeps, args, SendOptions{TTL: "1h"}); err != nil {
+				t.Fatalf("send failed: %v", err)
+			}
+
+			deps := &mockReceiveDeps{relay: relay, head: tt.head}
+			var stderr bytes.Buffer
+			err := runReceiveWithDeps(&bytes.Buffer{}, &stderr, deps, []string{sendDeps.code}, ReceiveOptions{StrictBase: tt.strict})
+			if tt.wantApplied {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(deps.applied) != "diff content" {
+					t.Errorf("applied %q, want the patch without its header", deps.applied)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), "made on commit 1111111") || deps.applied != nil {
+				t.Errorf("err = %v, applied %q; want a refusal naming the base", err, deps.applied)
+			}
+			if got := strings.Contains(stderr.String(), "Warning: the patch was made on commit 1111111"); got != tt.wantWarning {
+				t.Errorf("warning shown = %v, want %v\nGOT:\n%s", got, tt.wantWarning, stderr.String())
+			}
+		})
+	}
+}
+
+func TestReceiveKeepAuthor(t *testing.T) {
+	mbox := "From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001\n" +
+		"From: Ada Lovelace <ada@example.com>\n" +
+		"Date: Mon, 10 Dec 1990 12:00:00 +0100\n" +
+		"Subject: [PATCH] Fix the thing\n\n" +
+		"---\n a.txt | 2 +-\n\n" +
+		"diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+b\n"
+
+	relay := map[string]string{}
+	code := sendToRelay(t, relay, mbox, SendOptions{})
+	stderr := &bytes.Buffer{}
+	deps := &mockReceiveDeps{relay: relay}
+	if err := runReceiveWithDeps(&bytes.Buffer{}, stderr, deps, []string{code}, ReceiveOptions{KeepAuthor: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deps.appliedAsCommit {
+		t.Error("--keep-author should apply to the working tree, not with git am")
+	}
+	want := git.CommitInfo{Author: "Ada Lovelace <ada@example.com>", Date: "Mon, 10 Dec 1990 12:00:00 +0100", Message: "Fix the thing"}
+	if deps.committed == nil || *deps.committed != want {
+		t.Errorf("committed %+v, want %+v", deps.committed, want)
+	}
+	if !strings.Contains(stderr.String(), "Committed 0123456 as Ada Lovelace <ada@example.com>.") {
+		t.Errorf("stderr missing the commit\nGOT:\n%s", stderr.String())
+	}
+
+	// A plain diff has no author to keep, and is left unapplied
+	relay = map[string]string{}
+	code = sendToRelay(t, relay, "diff --git a/a.txt b/a.txt\n", SendOptions{})
+	deps = &mockReceiveDeps{relay: relay}
+	err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{KeepAuthor: true})
+	if err == nil || !strings.Contains(err.Error(), "plain diff") {
+		t.Errorf("error = %v, want a plain diff error", err)
+	}
+	if deps.applied != nil || deps.committed != nil {
+		t.Error("a plain diff should not be applied with --keep-author")
+	}
+
+	err = runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockReceiveDeps{}, []string{"id-a-b-c-d"}, ReceiveOptions{KeepAuthor: true, Commit: true})
+	if err == nil || !strings.Contains(err.Error(), "--commit") {
+		t.Errorf("error = %v, want a --commit conflict", err)
+	}
+}
+
+func TestReceiveDryRun(t *testing.T) {
+	patch := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+b\n"
+	relay := map[string]string{}
+	code := sendToRelay(t, relay, patch, SendOptions{})
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	deps := &mockReceiveDeps{relay: relay, stats: " a.txt | 2 +-", noRepo: true}
+	if err := runReceiveWithDeps(stdout, stderr, deps, []string{code}, ReceiveOptions{DryRun: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deps.applied != nil {
+		t.Error("--dry-run should not apply the patch")
+	}
+	if stdout.String() != patch {
+		t.Errorf("stdout = %q, want the patch", stdout.String())
+	}
+	for _, want := range []string{" a.txt | 2 +-", "nothing was applied", "can't be received again"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr missing %q\nGOT:\n%s", want, stderr.String())
+		}
+	}
+
+	err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockReceiveDeps{}, []string{"id-a-b-c-d"}, ReceiveOptions{DryRun: true, Commit: true})
+	if err == nil || !strings.Contains(err.Error(), "--dry-run cannot be combined") {
+		t.Errorf("error = %v, want a --dry-run conflict", err)
+	}
+}
+
+func TestReceiveInteractive(t *testing.T) {
+	fileA := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+A\n"
+	fileB := "diff --git a/b.txt b/b.txt\n--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-b\n+B\n"
+
+	tests := []struct {
+		name        string
+		answers     string
+		wantApplied string
+		wantFiles   []string
+	}{
+		{name: "one of two hunks", answers: "n\ny\n", wantApplied: fileB, wantFiles: []string{"b.txt"}},
+		{name: "none", answers: "n\nn\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, fileA+fileB, SendOptions{})
+
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			deps := &mockReceiveDeps{relay: relay}
+			opts := ReceiveOptions{SelectHunks: true, Stdin: strings.NewReader(tt.answers), SummaryFormat: SummaryJSON}
+			if err := runReceiveWithDeps(stdout, stderr, deps, []string{code}, opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(deps.applied) != tt.wantApplied {
+				t.Errorf("applied %q, want %q", deps.applied, tt.wantApplied)
+			}
+			if tt.wantApplied == "" {
+				if !strings.Contains(stderr.String(), "No hunks chosen") {
+					t.Errorf("stderr missing the nothing-chosen message\nGOT:\n%s", stderr.String())
+				}
+				return
+			}
+			var summary receiveSummary
+			if err := json.Unmarshal(stdout.Bytes(), &summary); err != nil {
+				t.Fatalf("stdout is not a JSON summary: %v\nGOT:\n%s", err, stdout.String())
+			}
+			if !reflect.DeepEqual(summary.Files, tt.wantFiles) {
+				t.Errorf("summary files = %v, want %v", summary.Files, tt.wantFiles)
+			}
+		})
+	}
+
+	err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockReceiveDeps{}, []string{"id-a-b-c-d"}, ReceiveOptions{SelectHunks: true, Commit: true})
+	if err == nil || !strings.Contains(err.Error(), "--interactive cannot be combined") {
+		t.Errorf("error = %v, want a --commit conflict", err)
+	}
+}
+
+func TestReceiveWithNotesRequiresCommit(t *testing.T) {
+	err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockReceiveDeps{}, []string{"id-a-b-c-d"}, ReceiveOptions{Notes: true})
+	if err == nil || !strings.Contains(err.Error(), "requires --commit") {
+		t.Errorf("expected --commit requirement error, got %v", err)
+	}
+}
+
+func TestReceiveFilesOnly(t *testing.T) {
+	relay := map[string]string{}
+	patch := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+b\n" +
+		"diff --git a/old.txt b/new.txt\nsimilarity index 100%\nrename from old.txt\nrename to new.txt\n"
+	code := sendToRelay(t, relay, patch, SendOptions{})
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	deps := &mockReceiveDeps{relay: relay, stats: "a.txt | 2 +-"}
+	if err := runReceiveWithDeps(stdout, stderr, deps, []string{code}, ReceiveOptions{Files: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout.String() != "a.txt\nnew.txt\n" {
+		t.Errorf("stdout = %q, want file list", stdout.String())
+	}
+	if strings.Contains(stderr.String(), "a.txt | 2 +-") {
+		t.Errorf("--files-only should not print the diffstat\nGOT:\n%s", stderr.String())
+	}
+}
+
+func TestReceiveArgon2(t *testing.T) {
+	for _, split := range []string{"", "10"} {
+		t.Run("split="+split, func(t *testing.T) {
+			relay := map[string]string{}
+			sendDeps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff --git a/a.txt b/a.txt\n+a\n"), code: "main-a-b-c-d", codeID: "main", relay: relay}
+			_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, sendDeps, nil, SendOptions{TTL: "1h", KDF: KDFArgon2id, SplitSize: split})
+			if err != nil {
+				t.Fatalf("send failed: %v", err)
+			}
+			if sendDeps.argon2Salt == nil {
+				t.Fatal("send should derive the key with Argon2id")
+			}
+
+			deps := &mockReceiveDeps{relay: relay}
+			if err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{"main-a-b-c-d"}, ReceiveOptions{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(deps.applied) != string(sendDeps.patch) {
+				t.Errorf("applied %q, want %q", deps.applied, sendDeps.patch)
+			}
+			// The manifest and the parts share one key
+			if len(deps.argon2Salts) != 1 || !bytes.Equal(deps.argon2Salts[0], sendDeps.argon2Salt) {
+				t.Errorf("receiver derived with salts %x, want once with %x", deps.argon2Salts, sendDeps.argon2Salt)
+			}
+		})
+	}
+
+	_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockSendDeps{}, nil, SendOptions{TTL: "1h", KDF: "scrypt"})
+	if err == nil || !strings.Contains(err.Error(), `unknown --kdf "scrypt"`) {
+		t.Errorf("error = %v, want an unknown --kdf error", err)
+	}
+}
+
+func TestReceiveCustomPassphrase(t *testing.T) {
+	relay := map[string]string{}
+	sendDeps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content"), relay: relay}
+	_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, sendDeps, nil, SendOptions{TTL: "1h", Passphrase: "our shared secret"})
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	deps := &mockReceiveDeps{relay: relay}
+	err = runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{"part1"}, ReceiveOptions{Passphrase: "our shared secret"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deps.derivedFrom != sendDeps.derivedFrom {
+		t.Errorf("receiver derived key from %q, sender from %q", deps.derivedFrom, sendDeps.derivedFrom)
+	}
+	if string(deps.applied) != "diff content" {
+		t.Errorf("applied %q, want %q", deps.applied, "diff content")
+	}
+
+	err = runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockReceiveDeps{}, []string{"abc-alpha-bravo-charlie-delta"}, ReceiveOptions{Passphrase: "x"})
+	if err == nil || !strings.Contains(err.Error(), "only the code ID") {
+		t.Errorf("expected an error for a full code with --passphrase, got %v", err)
+	}
+}
+
+func TestReceiveAskPassphrase(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		opts       ReceiveOptions
+		answers    []string
+		wantErr    string
+		wantStderr []string
+	}{
+		{name: "words with spaces", args: []string{"main"}, answers: []string{"alpha bravo charlie delta"}},
+		{name: "words with dashes", args: []string{"main"}, answers: []string{"alpha-bravo-charlie-delta"}},
+		{name: "retry after a typo", args: []string{"main"}, answers: []string{"alpha bravo charlie", "alpha bravo charlie echo", "alpha bravo charlie delta"},
+			wantStderr: []string{"expected 4 words, got 3; try again.", "decryption failed (wrong passphrase?); try again."}},
+		{name: "gives up", args: []string{"main"}, answers: []string{"a b c d", "a b c d", "a b c d", "alpha bravo charlie delta"}, wantErr: "wrong passphrase"},
+		{name: "full code", args: []string{"main-alpha-bravo-charlie-delta"}, wantErr: "only the code ID"},
+		{name: "with --passphrase", args: []string{"main"}, opts: ReceiveOptions{Passphrase: "x"}, wantErr: "cannot be combined with --passphrase"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			sendToRelay(t, relay, "diff content", SendOptions{})
+			deps := &mockReceiveDeps{relay: relay, answers: tt.answers, passphrase: "alpha-bravo-charlie-delta"}
+			tt.opts.AskPassphrase = true
+			var stderr bytes.Buffer
+			err := runReceiveWithDeps(&bytes.Buffer{}, &stderr, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(deps.applied) != "diff content" {
+				t.Errorf("applied %q, want %q", deps.applied, "diff content")
+			}
+			for _, want := range tt.wantStderr {
+				if !strings.Contains(stderr.String(), want) {
+					t.Errorf("stderr missing %q:\n%s", want, stderr.String())
+				}
+			}
+		})
+	}
+}
+
+func TestReceiveAutocorrect(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       string
+		opts       ReceiveOptions
+		answers    []string
+		wantErr    string
+		wantStderr string
+	}{
+		{name: "one typo", code: "main-bike-birdd-boat-cake", opts: ReceiveOptions{Autocorrect: true},
+			wantStderr: `Corrected word 2 from "birdd" to "bird".`},
+		{name: "typo to another word", code: "main-bike-bird-boat-cave", opts: ReceiveOptions{Autocorrect: true},
+			wantStderr: `Corrected word 4 from "cave" to "cake".`},
+		{name: "off by default", code: "main-bike-birdd-boat-cake", wantErr: "wrong passphrase"},
+		{name: "two typos", code: "main-bike-birdd-boat-cakee", opts: ReceiveOptions{Autocorrect: true}, wantErr: "wrong passphrase"},
+		{name: "asked for", code: "main", opts: ReceiveOptions{Autocorrect: true, AskPassphrase: true}, answers: []string{"bike bird boatt cake"},
+			wantStderr: `Corrected word 3 from "boatt" to "boat".`},
+		{name: "with --passphrase", code: "main", opts: ReceiveOptions{Autocorrect: true, Passphrase: "bike-bird-boat-cake"}, wantErr: "cannot be combined with --passphrase"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			sendToRelay(t, relay, "diff content", SendOptions{})
+			deps := &mockReceiveDeps{relay: relay, answers: tt.answers, passphrase: "bike-bird-boat-cake"}
+			var stderr bytes.Buffer
+			err := runReceiveWithDeps(&bytes.Buffer{}, &stderr, deps, []string{tt.code}, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v\n%s", err, stderr.String())
+			}
+			if string(deps.applied) != "diff content" {
+				t.Errorf("applied %q, want %q", deps.applied, "diff content")
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr missing %q:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestReceiveVerify(t *testing.T) {
+	pub, priv, _ := crypto.GenerateSigningKey()
+	other, _, _ := crypto.GenerateSigningKey()
+	tests := []struct {
+		name       string
+		sign       ed25519.PrivateKey
+		compress   bool
+		trusted    []ed25519.PublicKey
+		tamper     func(header *payload.Header, patch []byte) []byte // rewrites the payload, keeping the signature
+		wantErr    string
+		wantStderr string
+	}{
+		{name: "unsigned"},
+		{name: "signed, not verified", sign: priv, wantStderr: "Signed by key " + crypto.KeyFingerprint(pub)},
+		{name: "trusted", sign: priv, trusted: []ed25519.PublicKey{other, pub}, wantStderr: "Verified signature from trusted key"},
+		{name: "trusted and compressed", sign: priv, compress: true, trusted: []ed25519.PublicKey{pub}, wantStderr: "Verified signature"},
+		{name: "untrusted", sign: priv, trusted: []ed25519.PublicKey{other}, wantErr: "not a --verify key"},
+		{name: "unsigned but required", trusted: []ed25519.PublicKey{pub}, wantErr: "not signed"},
+		{name: "tampered", sign: priv, wantErr: "doesn't match its contents",
+			tamper: func(*payload.Header, []byte) []byte { return []byte("rm -rf content") }},
+		{name: "base changed", sign: priv, wantErr: "doesn't match its contents",
+			tamper: func(header *payload.Header, patch []byte) []byte { header.Base = "0123abc"; return patch }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			opts := SendOptions{SignKey: tt.sign, Compress: tt.compress, CompressLevel: payload.DefaultCompressLevel}
+			code := sendToRelay(t, relay, "diff content", opts)
+			if tt.tamper != nil {
+				for id, data := range relay {
+					raw, _ := payload.DecodeData(data)
+					header, patch, _ := payload.Decode(raw)
+					patch = tt.tamper(&header, patch)
+					raw, _ = payload.Encode(header, patch)
+					relay[id] = payload.EncodeData(raw, false)
+				}
+			}
+
+			deps := &mockReceiveDeps{relay: relay}
+			var stderr bytes.Buffer
+			err := runReceiveWithDeps(&bytes.Buffer{}, &stderr, deps, []string{code}, ReceiveOptions{Trusted: tt.trusted})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				if deps.applied != nil {
+					t.Error("a patch that fails verification should not be applied")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(deps.applied) != "diff content" {
+				t.Errorf("applied %q", deps.applied)
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr missing %q:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestReceivePeek(t *testing.T) {
+	relay := map[string]string{}
+	code := sendToRelay(t, relay, "diff content", SendOptions{SplitSize: "8B"})
+	parts := len(relay)
+
+	deps := &mockReceiveDeps{relay: relay, applyErr: git.ErrPatchConflict}
+	err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{Peek: true})
+	if !errors.Is(err, git.ErrPatchConflict) {
+		t.Fatalf("expected the apply to fail, got %v", err)
+	}
+	if len(deps.peeked) != parts || len(relay) != parts {
+		t.Fatalf("peeked %d blobs and left %d on the relay, want %d of each", len(deps.peeked), len(relay), parts)
+	}
+
+	// The same code works again once the problem is fixed
+	deps = &mockReceiveDeps{relay: relay}
+	if err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{}); err != nil {
+		t.Fatalf("receive after peek failed: %v", err)
+	}
+	if string(deps.applied) != "diff content" || len(relay) != 0 {
+		t.Errorf("applied %q leaving %d blobs, want the patch and none", deps.applied, len(relay))
+	}
+}
+
+func TestReceiveCompressedPatch(t *testing.T) {
+	patch := strings.Repeat("+compressible line\n", 500)
+	for _, level := range []int{1, 6, 9} {
+		relay := map[string]string{}
+		code := sendToRelay(t, relay, patch, SendOptions{Compress: true, CompressLevel: level})
+		if len(relay["main"]) >= len(patch) {
+			t.Errorf("level %d: relay stored %d bytes for a %d byte patch", level, len(relay["main"]), len(patch))
+		}
+
+		deps := &mockReceiveDeps{relay: relay}
+		if err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{}); err != nil {
+			t.Fatalf("level %d: unexpected error: %v", level, err)
+		}
+		if string(deps.applied) != patch {
+			t.Errorf("level %d: applied patch does not match", level)
+		}
+	}
+}
+
+func TestReceiveStdoutMessages(t *testing.T) {
+	relay := map[string]string{}
+	patch := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+b\n"
+	code := sendToRelay(t, relay, patch, SendOptions{})
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	deps := &mockReceiveDeps{relay: relay}
+	if err := runReceiveWithDeps(stdout, stderr, deps, []string{code}, ReceiveOptions{StdoutMessages: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected nothing on stderr, got:\n%s", stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "Downloading patch...") {
+		t.Errorf("status messages should go to stdout\nGOT:\n%s", stdout.String())
+	}
+
+	// The summary is the last line
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	var summary map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &summary); err != nil {
+		t.Fatalf("last line is not JSON: %v\nGOT:\n%s", err, stdout.String())
+	}
+	if summary["applied"] != true {
+		t.Errorf("applied = %v, want true", summary["applied"])
+	}
+	if files, _ := summary["files"].([]interface{}); len(files) != 1 || files[0] != "a.txt" {
+		t.Errorf("files = %v, want [a.txt]", summary["files"])
+	}
+	if summary["fingerprint"] != crypto.Fingerprint([]byte(patch)) {
+		t.Errorf("fingerprint = %v, want SHA-256 of the patch", summary["fingerprint"])
+	}
+}
+
+func TestReceiveJSON(t *testing.T) {
+	patch := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1,2 @@\n-a\n+b\n+c\n"
+
+	tests := []struct {
+		name     string
+		applyErr error
+		commit   bool
+		want     receiveSummary
+	}{
+		{
+			name:   "applied as commit",
+			commit: true,
+			want: receiveSummary{
+				Applied: true, Mode: "commit", Files: []string{"a.txt"}, FilesChanged: 1, Insertions: 2, Deletions: 1,
+				Bytes: len(patch), Fingerprint: crypto.Fingerprint([]byte(patch)),
+			},
+		},
+		{
+			name:     "apply failure",
+			applyErr: errors.New("patch does not apply"),
+			want: receiveSummary{
+				Mode: "patch", Files: []string{"a.txt"}, FilesChanged: 1, Bytes: len(patch),
+				Fingerprint: crypto.Fingerprint([]byte(patch)), Error: "patch does not apply",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, patch, SendOptions{})
+
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			deps := &mockReceiveDeps{
+				relay:    relay,
+				stats:    "a.txt | 3 ++-",
+				summary:  git.Summary{Added: 2, Deleted: 1},
+				applyErr: tt.applyErr,
+			}
+			err := runReceiveWithDeps(stdout, stderr, deps, []string{code}, ReceiveOptions{JSON: true, Commit: tt.commit})
+			if (err != nil) != (tt.applyErr != nil) {
+				t.Fatalf("error = %v, want %v", err, tt.applyErr)
+			}
+			if stderr.Len() != 0 {
+				t.Errorf("--json should suppress other output, stderr:\n%s", stderr.String())
+			}
+
+			var got receiveSummary
+			dec := json.NewDecoder(stdout)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&got); err != nil {
+				t.Fatalf("stdout is not a single JSON summary: %v\nGOT:\n%s", err, stdout.String())
+			}
+			if dec.More() {
+				t.Errorf("unexpected output after the JSON summary:\n%s", stdout.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("summary = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceiveSummaryFormat(t *testing.T) {
+	patch := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1,2 @@\n-a\n+b\n+c\n"
+
+	tests := []struct {
+		format     string
+		wantStdout string
+		wantStderr []string
+		noStderr   []string
+	}{
+		{format: "", wantStderr: []string{"Applying patch...", "Patch applied successfully.", "a.txt | 3 ++-"}},
+		{format: SummaryText, wantStderr: []string{"Patch applied successfully.", "a.txt | 3 ++-"}},
+		{
+			format: SummaryJSON,
+			wantStdout: `{"applied":true,"mode":"patch","files":["a.txt"],"files_changed":1,"insertions":2,"deletions":1,` +
+				`"bytes":` + fmt.Sprint(len(patch)) + `,"fingerprint":"` + crypto.Fingerprint([]byte(patch)) + `"}` + "\n",
+			wantStderr: []string{"Applying patch..."},
+			noStderr:   []string{"Patch applied successfully.", "a.txt | 3 ++-"},
+		},
+		{
+			format:     SummaryNone,
+			wantStderr: []string{"Applying patch..."},
+			noStderr:   []string{"Patch applied successfully.", "a.txt | 3 ++-"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, patch, SendOptions{})
+
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			deps := &mockReceiveDeps{relay: relay, stats: "a.txt | 3 ++-", summary: git.Summary{Added: 2, Deleted: 1}}
+			if err := runReceiveWithDeps(stdout, stderr, deps, []string{code}, ReceiveOptions{SummaryFormat: tt.format}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.applied == nil {
+				t.Fatal("patch should be applied")
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			for _, want := range tt.wantStderr {
+				if !strings.Contains(stderr.String(), want) {
+					t.Errorf("stderr missing %q\nGOT:\n%s", want, stderr.String())
+				}
+			}
+			for _, unwanted := range tt.noStderr {
+				if strings.Contains(stderr.String(), unwanted) {
+					t.Errorf("stderr should not contain %q\nGOT:\n%s", unwanted, stderr.String())
+				}
+			}
+		})
+	}
+}
+
+func TestReceiveSummaryFormatInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ReceiveOptions
+		want string
+	}{
+		{name: "unknown format", opts: ReceiveOptions{SummaryFormat: "yaml"}, want: "unknown --summary-format"},
+		{name: "with --json", opts: ReceiveOptions{SummaryFormat: SummaryNone, JSON: true}, want: "cannot be combined"},
+		{name: "with --stdout-messages", opts: ReceiveOptions{SummaryFormat: SummaryJSON, StdoutMessages: true}, want: "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockReceiveDeps{}, []string{"id-a-b-c-d"}, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceiveSignoff(t *testing.T) {
+	tests := []struct {
+		name        string
+		commit      bool
+		wantSignoff bool
+		wantWarning bool
+	}{
+		{name: "commit mode", commit: true, wantSignoff: true},
+		{name: "patch mode", commit: false, wantSignoff: false, wantWarning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, "diff content", SendOptions{})
+
+			stderr := &bytes.Buffer{}
+			deps := &mockReceiveDeps{relay: relay}
+			err := runReceiveWithDeps(&bytes.Buffer{}, stderr, deps, []string{code}, ReceiveOptions{Commit: tt.commit, Signoff: true})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.signoff != tt.wantSignoff {
+				t.Errorf("signoff passed = %v, want %v", deps.signoff, tt.wantSignoff)
+			}
+			if got := strings.Contains(stderr.String(), "--signoff only applies with --commit"); got != tt.wantWarning {
+				t.Errorf("warning = %v, want %v\nGOT:\n%s", got, tt.wantWarning, stderr.String())
+			}
+		})
+	}
+}
+
+func TestReceiveNoApplyOutsideRepo(t *testing.T) {
+	tests := []struct {
+		name       string
+		noApply    bool
+		output     string
+		wantStderr string
+	}{
+		{name: "to file", noApply: true, output: "/tmp/out.patch", wantStderr: "saved it to /tmp/out.patch"},
+		{name: "without output", noApply: true, wantStderr: "use --output to save it"},
+		{name: "output implies no-apply", output: "/tmp/out.patch", wantStderr: "saved it to /tmp/out.patch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, "diff content", SendOptions{})
+
+			stderr := &bytes.Buffer{}
+			deps := &mockReceiveDeps{relay: relay, noRepo: true}
+			err := runReceiveWithDeps(&bytes.Buffer{}, stderr, deps, []string{code}, ReceiveOptions{NoApply: tt.noApply, Output: tt.output})
+			if err != nil {
+				t.Fatalf("unexpected error outside a repo: %v", err)
+			}
+			if deps.applied != nil {
+				t.Error("--no-apply should not apply the patch")
+			}
+			if tt.output != "" && string(deps.outputs[tt.output]) != "diff content" {
+				t.Errorf("wrote %q to %s", deps.outputs[tt.output], tt.output)
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr missing %q\nGOT:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+
+	// Saving can't be combined with ways of applying
+	err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockReceiveDeps{noRepo: true}, []string{"abc-alpha-bravo-charlie-delta"}, ReceiveOptions{Output: "out.patch", Commit: true})
+	if err == nil || !strings.Contains(err.Error(), "--output saves the patch without applying it") {
+		t.Errorf("expected --output with --commit to be rejected, got %v", err)
+	}
+
+	// Applying still needs a repository
+	relay := map[string]string{}
+	code := sendToRelay(t, relay, "diff content", SendOptions{})
+	err = runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockReceiveDeps{relay: relay, noRepo: true}, []string{code}, ReceiveOptions{})
+	if err == nil || !strings.Contains(err.Error(), "not a git repository") {
+		t.Errorf("expected a repository error, got %v", err)
+	}
+}
+
+func TestReceiveThen(t *testing.T) {
+	tests := []struct {
+		name     string
+		applyErr error
+		exitCode int
+		wantRan  bool
+		wantExit int // 0 means no ExitError
+	}{
+		{name: "command succeeds", wantRan: true},
+		{name: "command fails", exitCode: 3, wantRan: true, wantExit: 3},
+		{name: "apply fails", applyErr: errors.New("patch does not apply")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, "diff content", SendOptions{})
+
+			stdout := &bytes.Buffer{}
+			deps := &mockReceiveDeps{relay: relay, applyErr: tt.applyErr, exitCode: tt.exitCode}
+			err := runReceiveWithDeps(stdout, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{Then: "go test ./..."})
+
+			if ran := len(deps.ran) > 0; ran != tt.wantRan {
+				t.Fatalf("command ran = %v, want %v", ran, tt.wantRan)
+			}
+			if tt.wantRan && !strings.Contains(stdout.String(), "output of go test ./...") {
+				t.Errorf("command output not streamed\nGOT:\n%s", stdout.String())
+			}
+
+			var exit ExitError
+			switch {
+			case tt.applyErr != nil:
+				if !errors.Is(err, tt.applyErr) {
+					t.Errorf("expected the apply error, got %v", err)
+				}
+			case tt.wantExit != 0:
+				if !errors.As(err, &exit) || exit.Code != tt.wantExit {
+					t.Errorf("expected exit status %d, got %v", tt.wantExit, err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCodeFromArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "single arg", args: []string{"k7Xm9pQ2wR-alpha-bravo-charlie-delta"}, want: "k7Xm9pQ2wR-alpha-bravo-charlie-delta"},
+		{name: "code ID and words", args: []string{"k7Xm9pQ2wR", "alpha", "bravo", "charlie", "delta"}, want: "k7Xm9pQ2wR-alpha-bravo-charlie-delta"},
+		{name: "code ID and passphrase", args: []string{"k7Xm9pQ2wR", "alpha-bravo-charlie-delta"}, want: "k7Xm9pQ2wR-alpha-bravo-charlie-delta"},
+		{name: "split after separator", args: []string{"k7Xm9pQ2wR-alpha-", "bravo", "charlie-delta"}, want: "k7Xm9pQ2wR-alpha-bravo-charlie-delta"},
+		{name: "pasted command", args: []string{"git-share", "receive", "k7Xm9pQ2wR-alpha-bravo-charlie-delta"}, want: "k7Xm9pQ2wR-alpha-bravo-charlie-delta"},
+		{name: "pasted alias", args: []string{"r", "k7Xm9pQ2wR", "alpha", "bravo", "charlie", "delta"}, want: "k7Xm9pQ2wR-alpha-bravo-charlie-delta"},
+		{name: "bare code ID", args: []string{"k7Xm9pQ2wR"}, want: "k7Xm9pQ2wR"},
+		{name: "prefix-like code", args: []string{"receive"}, want: "receive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := codeFromArgs(tt.args); got != tt.want {
+				t.Errorf("codeFromArgs(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceiveBase64URL(t *testing.T) {
+	// Bytes that encode to "+" and "/" in standard base64
+	patch := "diff \xfb\xff\xbf\xfe" + strings.Repeat("x", 30)
+
+	tests := []struct {
+		name string
+		opts SendOptions
+	}{
+		{name: "standard", opts: SendOptions{}},
+		{name: "base64url", opts: SendOptions{URLSafe: true}},
+		{name: "base64url split", opts: SendOptions{URLSafe: true, SplitSize: "20B"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, patch, tt.opts)
+			for id, data := range relay {
+				if tt.opts.URLSafe && strings.ContainsAny(data, "+/") {
+					t.Errorf("blob %s uses the standard alphabet: %q", id, data)
+				}
+			}
+
+			deps := &mockReceiveDeps{relay: relay}
+			if err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(deps.applied) != patch {
+				t.Errorf("applied %q, want %q", deps.applied, patch)
+			}
+		})
+	}
+}
+
+func TestReceiveLargePatch(t *testing.T) {
+	large := strings.Repeat("x", largeReceiveSize+1)
+
+	tests := []struct {
+		name        string
+		patch       string
+		opts        ReceiveOptions
+		wantApplied bool
+		wantPrompt  bool
+		wantSaved   bool
+	}{
+		{name: "small patch", patch: "diff content", opts: ReceiveOptions{Interactive: true}, wantApplied: true},
+		{name: "accepted", patch: large, opts: ReceiveOptions{Interactive: true, Stdin: strings.NewReader("y\n")}, wantApplied: true, wantPrompt: true},
+		{name: "declined", patch: large, opts: ReceiveOptions{Interactive: true, Stdin: strings.NewReader("n\n")}, wantPrompt: true, wantSaved: true},
+		{name: "yes skips the prompt", patch: large, opts: ReceiveOptions{Interactive: true, Yes: true}, wantApplied: true},
+		{name: "not a terminal", patch: large, opts: ReceiveOptions{}, wantApplied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, tt.patch, SendOptions{Yes: true})
+
+			stderr := &bytes.Buffer{}
+			deps := &mockReceiveDeps{relay: relay, stats: "file.txt | 5000000 +"}
+			if err := runReceiveWithDeps(&bytes.Buffer{}, stderr, deps, []string{code}, tt.opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if applied := deps.applied != nil; applied != tt.wantApplied {
+				t.Errorf("applied = %v, want %v", applied, tt.wantApplied)
+			}
+			if got := strings.Contains(stderr.String(), "Apply it?"); got != tt.wantPrompt {
+				t.Errorf("prompted = %v, want %v\nGOT:\n%s", got, tt.wantPrompt, stderr.String())
+			}
+			if saved := deps.savedPatch != nil; saved != tt.wantSaved {
+				t.Errorf("saved = %v, want %v", saved, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestReceiveAllowOutside(t *testing.T) {
+	relay := map[string]string{}
+	code := sendToRelay(t, relay, "diff content", SendOptions{})
+
+	deps := &mockReceiveDeps{relay: relay}
+	if err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{AllowOutside: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !deps.allowOutside {
+		t.Error("expected --allow-outside to be passed through to ApplyPatch")
+	}
+
+	code = sendToRelay(t, relay, "diff content", SendOptions{})
+	err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockReceiveDeps{relay: relay}, []string{code}, ReceiveOptions{AllowOutside: true, Commit: true})
+	if err == nil || !strings.Contains(err.Error(), "cannot be combined with --commit") {
+		t.Errorf("expected a --commit conflict error, got %v", err)
+	}
+}
+
+func TestReceiveThreeWay(t *testing.T) {
+	relay := map[string]string{}
+	code := sendToRelay(t, relay, "diff content", SendOptions{})
+	deps := &mockReceiveDeps{relay: relay}
+	if err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{ThreeWay: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !deps.threeWay {
+		t.Error("expected --3way to be passed through to ApplyPatch")
+	}
+
+	code = sendToRelay(t, relay, "diff content", SendOptions{})
+	stderr := &bytes.Buffer{}
+	deps = &mockReceiveDeps{relay: relay, applyErr: &git.ConflictError{Files: []string{"a.txt", "b.txt"}}}
+	err := runReceiveWithDeps(&bytes.Buffer{}, stderr, deps, []string{code}, ReceiveOptions{ThreeWay: true})
+	var conflict *git.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("error = %v, want a *git.ConflictError", err)
+	}
+	if !strings.Contains(stderr.String(), "conflicts to resolve by hand:\n  a.txt\n  b.txt\n") {
+		t.Errorf("stderr should list the conflicted files\nGOT:\n%s", stderr.String())
+	}
+}
+
+func TestReceiveSavesConflictingPatch(t *testing.T) {
+	relay := map[string]string{}
+	code := sendToRelay(t, relay, "diff content", SendOptions{})
+	stderr := &bytes.Buffer{}
+	deps := &mockReceiveDeps{relay: relay, applyErr: fmt.Errorf("%w: patch does not apply", git.ErrPatchConflict)}
+	err := runReceiveWithDeps(&bytes.Buffer{}, stderr, deps, []string{code}, ReceiveOptions{})
+	if !errors.Is(err, git.ErrPatchConflict) {
+		t.Fatalf("error = %v, want one matching git.ErrPatchConflict", err)
+	}
+	if string(deps.savedPatch) != "diff content" {
+		t.Errorf("saved %q, want the patch", deps.savedPatch)
+	}
+	if !strings.Contains(stderr.String(), "git-share apply --3way /tmp/git-share-123.patch") {
+		t.Errorf("stderr should suggest a three-way retry\nGOT:\n%s", stderr.String())
+	}
+}
+
+func TestReceiveShow(t *testing.T) {
+	show := "commit 0123456789abcdef\nAuthor: A U Thor <a@example.com>\n\n    Fix the frobnicator\n\ndiff --git a/x b/x\n+fixed\n"
+	relay := map[string]string{}
+	deps := &mockSendDeps{patch: []byte(show), code: "main-a-b-c-d", codeID: "main", relay: relay}
+	if _, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, nil, SendOptions{Show: true, TTL: "1h"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	stderr := &bytes.Buffer{}
+	recv := &mockReceiveDeps{relay: relay}
+	if err := runReceiveWithDeps(&bytes.Buffer{}, stderr, recv, []string{"main-a-b-c-d"}, ReceiveOptions{Commit: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Fix the frobnicator") {
+		t.Errorf("commit message not shown\nGOT:\n%s", stderr.String())
+	}
+	if string(recv.applied) != "diff --git a/x b/x\n+fixed\n" {
+		t.Errorf("applied %q, want only the diff", recv.applied)
+	}
+	if recv.appliedAsCommit {
+		t.Error("a --show patch should be applied to the working tree")
+	}
+}
+
+func TestReceiveTextconv(t *testing.T) {
+	const diff = "diff --git a/doc.up b/doc.up\n-OLD\n+NEW\n"
+	relay := map[string]string{}
+	deps := &mockSendDeps{patch: []byte(diff), code: "main-a-b-c-d", codeID: "main", relay: relay}
+	if _, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, nil, SendOptions{Textconv: true, TTL: "1h"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	recv := &mockReceiveDeps{relay: relay}
+	if err := runReceiveWithDeps(stdout, stderr, recv, []string{"main-a-b-c-d"}, ReceiveOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recv.applied != nil {
+		t.Errorf("a --textconv patch was applied: %q", recv.applied)
+	}
+	if stdout.String() != diff || !strings.Contains(stderr.String(), "printed instead of applied") {
+		t.Errorf("stdout = %q, stderr = %q; want the patch printed with a warning", stdout.String(), stderr.String())
+	}
+}
+
+func TestReceiveWait(t *testing.T) {
+	tests := []struct {
+		name      string
+		notYet    int
+		wait      time.Duration
+		gone      bool
+		wantErr   error
+		wantSlept time.Duration
+	}{
+		{name: "appears after two polls", notYet: 2, wait: time.Minute, wantSlept: 2 * receivePollInterval},
+		{name: "never appears", notYet: 100, wait: 5 * time.Second, wantErr: client.ErrNotFound, wantSlept: 5 * time.Second},
+		{name: "without --wait", notYet: 1, wantErr: client.ErrNotFound},
+		{name: "already received", wait: time.Minute, gone: true, wantErr: &client.GoneError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, "diff content", SendOptions{})
+			deps := &mockReceiveDeps{relay: relay, notYet: tt.notYet}
+			if tt.gone {
+				deps.receiveErr = &client.GoneError{Reason: "consumed"}
+			}
+
+			err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, []string{code}, ReceiveOptions{Wait: tt.wait})
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(deps.applied) != "diff content" {
+					t.Errorf("applied %q", deps.applied)
+				}
+			case *client.GoneError:
+				if !errors.As(err, &want) {
+					t.Errorf("expected a GoneError, got %v", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("expected %v, got %v", want, err)
+				}
+			}
+			if deps.slept != tt.wantSlept {
+				t.Errorf("waited %s, want %s", deps.slept, tt.wantSlept)
+			}
+		})
+	}
+}
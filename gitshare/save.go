@@ -0,0 +1,35 @@
+package gitshare
+
+import (
+	"fmt"
+	"io"
+)
+
+// saveBlob writes an encoded blob to opts.SaveTo and prints the load command.
+func saveBlob(stdout, stderr io.Writer, deps sendDeps, encoded, code string, isCommit bool, opts SendOptions) error {
+	if err := deps.WriteFile(opts.SaveTo, []byte(encoded)); err != nil {
+		return fmt.Errorf("saving blob: %w", err)
+	}
+
+	fmt.Fprintf(stderr, "\nEncrypted and saved to %s.\n", opts.SaveTo)
+	fmt.Fprintf(stderr, "Carry the file over, and share this with the receiver:\n\n")
+	loadArgs := opts.SaveTo + " " + code
+	if opts.Passphrase != "" {
+		loadArgs += " --passphrase <passphrase>"
+	}
+	fmt.Fprintf(stdout, "   git-share load %s\n", loadArgs)
+	if isCommit {
+		fmt.Fprintf(stderr, "OR to load it as a commit instead of a patch:\n")
+		fmt.Fprintf(stdout, "   git-share load %s --commit\n", loadArgs)
+	}
+	return nil
+}
+
+// fileReceiveDeps serves a blob from a file in place of the relay, so load
+// goes through the same decrypt and apply steps as receive.
+type fileReceiveDeps struct {
+	realReceiveDeps
+	data string
+}
+
+func (d fileReceiveDeps) Receive(codeID string) (string, error) { return d.data, nil }
@@ -0,0 +1,44 @@
+package gitshare
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	const patch = "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+b\n"
+	stdout := &bytes.Buffer{}
+	relay := map[string]string{}
+	deps := &mockSendDeps{repoRoot: "/repo", patch: []byte(patch), code: "main-a-b-c-d", codeID: "main", relay: relay}
+	if _, err := runSendWithDeps(stdout, &bytes.Buffer{}, deps, nil, SendOptions{TTL: "1h", SaveTo: "fix.gsb"}); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if len(relay) != 0 {
+		t.Errorf("save should not upload anything, uploaded %v", relay)
+	}
+	if !strings.Contains(stdout.String(), "git-share load fix.gsb main-a-b-c-d") {
+		t.Errorf("stdout should give the load command\nGOT:\n%s", stdout.String())
+	}
+
+	// load hands the file to the receive pipeline in place of the relay
+	receiveDeps := &mockReceiveDeps{relay: map[string]string{"main": deps.saved["fix.gsb"]}}
+	if err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, receiveDeps, []string{"main-a-b-c-d"}, ReceiveOptions{}); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if string(receiveDeps.applied) != patch {
+		t.Errorf("applied %q, want %q", receiveDeps.applied, patch)
+	}
+
+	_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockSendDeps{}, nil, SendOptions{TTL: "1h", SaveTo: "fix.gsb", SplitSize: "1MB"})
+	if err == nil || !strings.Contains(err.Error(), "can't be split") {
+		t.Errorf("error = %v, want a --split-size conflict", err)
+	}
+}
+
+func TestFileReceiveDeps(t *testing.T) {
+	deps := fileReceiveDeps{data: "blob"}
+	if got, err := deps.Receive("anything"); got != "blob" || err != nil {
+		t.Errorf("Receive = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,107 @@
+package gitshare
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/flawiddsouza/git-share/internal/diffcolor"
+	"github.com/flawiddsouza/git-share/internal/git"
+)
+
+// errQuitSelection stops hunk selection, keeping what was chosen so far.
+var errQuitSelection = errors.New("quit")
+
+// selectHunks asks about each hunk of a patch, like "git add -p", and
+// returns a patch with only the chosen ones, or nil if none were chosen.
+// Binary files and files without hunks, such as pure renames, are chosen
+// whole.
+func selectHunks(in io.Reader, out io.Writer, patch []byte, color bool) ([]byte, error) {
+	files, err := git.ParseHunks(patch)
+	if err != nil {
+		return nil, fmt.Errorf("splitting the patch into hunks: %w", err)
+	}
+	reader := bufio.NewReader(in)
+	show := func(text string) {
+		if color {
+			text = string(diffcolor.Colorize([]byte(text)))
+		}
+		fmt.Fprint(out, text)
+	}
+
+	var selected []git.FileDiff
+	quit := false
+	for _, file := range files {
+		if quit {
+			break
+		}
+		path := file.NewPath
+		if path == "" {
+			path = file.OldPath
+		}
+
+		if file.Binary || len(file.Hunks) == 0 {
+			fmt.Fprintf(out, "\n%s (whole file)\n", path)
+			show(strings.Join(file.Header, "\n") + "\n")
+			apply, err := askHunk(reader, out, "Apply this file")
+			switch {
+			case errors.Is(err, errQuitSelection):
+				quit = true
+			case err != nil:
+				return nil, err
+			case apply:
+				selected = append(selected, file)
+			}
+			continue
+		}
+
+		keep := make([]bool, len(file.Hunks))
+		for i, h := range file.Hunks {
+			fmt.Fprintf(out, "\n%s (hunk %d/%d)\n", path, i+1, len(file.Hunks))
+			show(string(git.FormatDiff([]git.FileDiff{{Hunks: []git.Hunk{h}}})))
+			keep[i], err = askHunk(reader, out, "Apply this hunk")
+			if errors.Is(err, errQuitSelection) {
+				quit = true
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if f, ok := git.SelectHunks(file, keep); ok {
+			selected = append(selected, f)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, nil
+	}
+	return git.FormatDiff(selected), nil
+}
+
+// askHunk asks a y/n/q question until it gets one of those answers. The end
+// of input counts as q.
+func askHunk(reader *bufio.Reader, out io.Writer, question string) (bool, error) {
+	for {
+		fmt.Fprintf(out, "%s [y,n,q]? ", question)
+		answer, err := reader.ReadString('\n')
+		if err != nil && answer == "" {
+			if err == io.EOF {
+				fmt.Fprintln(out)
+				return false, errQuitSelection
+			}
+			return false, fmt.Errorf("reading answer: %w", err)
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		case "q", "quit":
+			return false, errQuitSelection
+		}
+		fmt.Fprintf(out, "Please answer y (apply), n (skip), or q (apply what was chosen and stop).\n")
+	}
+}
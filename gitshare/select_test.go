@@ -0,0 +1,91 @@
+package gitshare
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// selectPatch has two hunks in a.txt, one in b.txt, and a binary file.
+const selectPatch = "diff --git a/a.txt b/a.txt\n" +
+	"--- a/a.txt\n" +
+	"+++ b/a.txt\n" +
+	"@@ -1,3 +1,4 @@\n" +
+	" one\n" +
+	"-two\n" +
+	"+2a\n" +
+	"+2b\n" +
+	" three\n" +
+	"@@ -10,3 +11,3 @@ section\n" +
+	" ten\n" +
+	"-eleven\n" +
+	"+11\n" +
+	" twelve\n" +
+	"diff --git a/b.txt b/b.txt\n" +
+	"--- a/b.txt\n" +
+	"+++ b/b.txt\n" +
+	"@@ -1 +1 @@\n" +
+	"-b\n" +
+	"+B\n" +
+	"diff --git a/logo.png b/logo.png\n" +
+	"index 1111111..2222222 100644\n" +
+	"GIT binary patch\n" +
+	"literal 4\n" +
+	"LcmZQzWMT#Y01f~L\n" +
+	"\n" +
+	"literal 0\n" +
+	"HcmV?d00001\n" +
+	"\n"
+
+func TestSelectHunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers string
+		want    string // "" when nothing is chosen
+	}{
+		{
+			name:    "second hunk and binary file",
+			answers: "n\ny\nn\ny\n",
+			want: "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n" +
+				"@@ -10,3 +10,3 @@ section\n ten\n-eleven\n+11\n twelve\n" +
+				"diff --git a/logo.png b/logo.png\nindex 1111111..2222222 100644\nGIT binary patch\nliteral 4\nLcmZQzWMT#Y01f~L\n\nliteral 0\nHcmV?d00001\n\n",
+		},
+		{
+			name:    "quit keeps earlier choices",
+			answers: "y\nq\n",
+			want:    "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1,3 +1,4 @@\n one\n-two\n+2a\n+2b\n three\n",
+		},
+		{
+			name:    "invalid answers are asked again",
+			answers: "maybe\nn\nn\ny\nn\n",
+			want:    "diff --git a/b.txt b/b.txt\n--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-b\n+B\n",
+		},
+		{name: "nothing chosen", answers: "n\nn\nn\nn\n"},
+		{name: "end of input", answers: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			got, err := selectHunks(strings.NewReader(tt.answers), out, []byte(selectPatch), false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("selected patch =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectHunksPrompts(t *testing.T) {
+	out := &bytes.Buffer{}
+	if _, err := selectHunks(strings.NewReader("maybe\nq\n"), out, []byte(selectPatch), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"a.txt (hunk 1/2)\n@@ -1,3 +1,4 @@\n one\n-two\n", "Apply this hunk [y,n,q]? ", "Please answer y"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q\nGOT:\n%s", want, out.String())
+		}
+	}
+}
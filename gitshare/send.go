@@ -0,0 +1,1115 @@
+package gitshare
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+	"github.com/flawiddsouza/git-share/internal/crypto"
+	"github.com/flawiddsouza/git-share/internal/git"
+	"github.com/flawiddsouza/git-share/internal/ledger"
+	"github.com/flawiddsouza/git-share/internal/payload"
+	"github.com/flawiddsouza/git-share/internal/qr"
+	"github.com/flawiddsouza/git-share/internal/secrets"
+	"github.com/flawiddsouza/git-share/internal/ui"
+	"github.com/flawiddsouza/git-share/internal/wordlist"
+)
+
+// LatestStash is the stash entry sent when SendOptions.Stash names none.
+const LatestStash = "stash@{0}"
+
+// Key derivations for SendOptions.KDF.
+const (
+	KDFHKDF     = "hkdf"
+	KDFArgon2id = "argon2id"
+)
+
+// keepAliveInterval is how often send --keep-alive checks on and extends the
+// patch, at most; short TTLs are extended at half their length.
+const keepAliveInterval = 30 * time.Second
+
+// largePatchSize is the patch size above which send asks for confirmation,
+// to catch accidentally sharing a huge range or the whole history.
+const largePatchSize = 1024 * 1024
+
+// partOverhead is room left in each part of an upload the relay joins for
+// the rest of the request, so the part stays under the relay's max size.
+const partOverhead = 1024
+
+type sendDeps interface {
+	FindRepoRoot() (string, error)
+	GetCommitPatch(ref string) ([]byte, error)
+	GetShowPatch(ref string) ([]byte, error)
+	GetStashPatch(ref string) ([]byte, error)
+	GetNotes(ref string) (string, error)
+	CommitCount(ref string) (int, error)
+	UpstreamRef() (string, error)
+	GetStagedDiff(paths ...string) ([]byte, error)
+	GetDiff(paths ...string) ([]byte, error)
+	GetDiffFromHead() ([]byte, error)
+	GetUntrackedDiff() ([]byte, error)
+	GetTextconvDiff(staged, all bool, paths ...string) ([]byte, error)
+	CommitAll(message string) (string, error)
+	Author() (string, error)
+	HeadCommit() (string, error)
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte) error
+	GenerateCode(lang string) (code, codeID, passphrase string, err error)
+	GenerateCodeID() (string, error)
+	DeriveKey(passphrase string) ([]byte, error)
+	DeriveKeyArgon2(passphrase string, params crypto.Argon2Params) ([]byte, error)
+	Encrypt(data, key []byte) ([]byte, error)
+	Send(codeID, data string, ttl, maxDownloads int) (*client.SendResponse, error)
+	SendParts(codeID, data string, ttl, maxDownloads, partSize int) (*client.SendResponse, error)
+	Status(codeID string) (*client.StatusResponse, error)
+	Extend(codeID string, ttl int) (*client.ExtendResponse, error)
+	Capabilities() (*client.Capabilities, error)
+	Limits() (*client.Limits, error)
+	Sleep(d time.Duration)
+	PatchStats(patch []byte) (string, error)
+	CheckReverse(patch []byte, cached bool) error
+	RecordSent(e ledger.Entry) error
+}
+
+// realSendDeps runs a send against git, the crypto package, and a relay.
+type realSendDeps struct {
+	relay  *client.Client
+	record bool // add sent codes to the ledger
+}
+
+func (d realSendDeps) FindRepoRoot() (string, error) { return git.FindRepoRoot() }
+func (d realSendDeps) GetCommitPatch(ref string) ([]byte, error) {
+	return git.GetCommitPatch(ref)
+}
+func (d realSendDeps) GetShowPatch(ref string) ([]byte, error) {
+	return git.GetShowPatch(ref)
+}
+func (d realSendDeps) GetStashPatch(ref string) ([]byte, error) {
+	return git.GetStashPatch(ref)
+}
+func (d realSendDeps) GetNotes(ref string) (string, error) { return git.GetNotes(ref) }
+func (d realSendDeps) UpstreamRef() (string, error)        { return git.UpstreamRef() }
+func (d realSendDeps) CommitCount(ref string) (int, error) { return git.CommitCount(ref) }
+func (d realSendDeps) GetStagedDiff(paths ...string) ([]byte, error) {
+	return git.GetStagedDiff(paths...)
+}
+func (d realSendDeps) GetDiff(paths ...string) ([]byte, error) { return git.GetDiff(paths...) }
+func (d realSendDeps) GetDiffFromHead() ([]byte, error)        { return git.GetDiffFromHead() }
+func (d realSendDeps) GetUntrackedDiff() ([]byte, error)       { return git.GetUntrackedDiff() }
+func (d realSendDeps) GetTextconvDiff(staged, all bool, paths ...string) ([]byte, error) {
+	return git.GetTextconvDiff(staged, all, paths...)
+}
+func (d realSendDeps) CommitAll(message string) (string, error) {
+	return git.CommitAll(message)
+}
+func (d realSendDeps) Author() (string, error)              { return git.Author() }
+func (d realSendDeps) HeadCommit() (string, error)          { return git.HeadCommit() }
+func (d realSendDeps) ReadFile(path string) ([]byte, error) { return os.ReadFile(path) }
+func (d realSendDeps) WriteFile(path string, data []byte) error {
+	return os.WriteFile(path, data, 0o600)
+}
+func (d realSendDeps) GenerateCode(lang string) (string, string, string, error) {
+	return crypto.GenerateCodeLang(lang)
+}
+func (d realSendDeps) GenerateCodeID() (string, error) { return crypto.GenerateCodeID() }
+func (d realSendDeps) DeriveKey(passphrase string) ([]byte, error) {
+	return crypto.DeriveKey(passphrase)
+}
+func (d realSendDeps) DeriveKeyArgon2(passphrase string, params crypto.Argon2Params) ([]byte, error) {
+	return crypto.DeriveKeyArgon2(passphrase, params)
+}
+func (d realSendDeps) Encrypt(data, key []byte) ([]byte, error) {
+	return crypto.EncryptBlob(data, key)
+}
+func (d realSendDeps) Send(codeID, data string, ttl, maxDownloads int) (*client.SendResponse, error) {
+	return d.relay.SendMulti(codeID, data, ttl, maxDownloads)
+}
+func (d realSendDeps) SendParts(codeID, data string, ttl, maxDownloads, partSize int) (*client.SendResponse, error) {
+	return d.relay.SendParts(codeID, data, ttl, maxDownloads, partSize)
+}
+func (d realSendDeps) Status(codeID string) (*client.StatusResponse, error) {
+	return d.relay.Status(codeID)
+}
+func (d realSendDeps) Extend(codeID string, ttl int) (*client.ExtendResponse, error) {
+	return d.relay.Extend(codeID, ttl)
+}
+func (d realSendDeps) Capabilities() (*client.Capabilities, error) {
+	return d.relay.Capabilities()
+}
+func (d realSendDeps) Limits() (*client.Limits, error) {
+	return d.relay.Limits()
+}
+func (d realSendDeps) Sleep(dur time.Duration)                 { time.Sleep(dur) }
+func (d realSendDeps) PatchStats(patch []byte) (string, error) { return git.PatchStats(patch) }
+func (d realSendDeps) CheckReverse(patch []byte, cached bool) error {
+	return git.CheckReverse(patch, cached)
+}
+func (d realSendDeps) RecordSent(e ledger.Entry) error {
+	if !d.record {
+		return nil
+	}
+	path, err := ledger.Path()
+	if err != nil {
+		return err
+	}
+	return ledger.Add(path, e)
+}
+
+// SendOptions controls a send. The zero value sends the working tree
+// changes to the public relay with a one-hour TTL.
+type SendOptions struct {
+	Ref         string // commit or range to send, e.g. "HEAD~3.."; empty for working tree changes
+	Staged      bool
+	All         bool     // staged and unstaged changes together
+	Untracked   bool     // add untracked files to a working tree diff
+	Paths       []string // limit a working tree or staged diff to these pathspecs
+	Textconv    bool     // show files through their textconv filters, for reading only
+	TTL         string   // how long the relay keeps the patch, e.g. "30m"; "" means an hour
+	SplitSize   string
+	CommitFirst bool
+	AsCommit    bool // wrap a working tree diff in a commit
+	Message     string
+	Passphrase  string             // user-supplied passphrase; generated when empty
+	Space       string             // relay namespace the receiver must also use
+	Upstream    bool               // send the commits since the upstream branch
+	Base        string             // send the commits since this ref
+	AllowEmpty  bool               // treat "no changes" as success
+	Show        bool               // send one commit as "git show" output
+	PatchFile   string             // send this patch file instead of collecting one
+	Lang        string             // language of the generated passphrase words
+	KeepAlive   bool               // extend the TTL until the patch is received
+	Server      string             // relay URL; "" means DefaultServer
+	Force       bool               // send likely secrets to the public relay anyway
+	CheckApply  bool               // report how likely the patch is to apply cleanly
+	AllowRefs   string             // regular expression every named ref must match
+	KDF         string             // KDFHKDF or KDFArgon2id; "" means KDFHKDF
+	SaveTo      string             // write the encrypted blob to this file instead of uploading it
+	Downloads   int                // times the patch can be received; 0 means once
+	QR          bool               // draw the receive command as a QR code
+	Stash       string             // send this stash entry
+	Last        int                // send the last this many commits
+	SignKey     ed25519.PrivateKey // sign the patch with this key
+
+	Compress      bool
+	CompressLevel int
+	URLSafe       bool // base64url-encode the upload
+
+	JSON        bool      // print only a JSON result on Stdout
+	Yes         bool      // skip the large patch confirmation
+	Interactive bool      // a user can answer prompts on Stdin
+	Stdin       io.Reader // answers to prompts
+
+	Connection Connection // how to reach the relay
+	Record     bool       // add the code to the ledger that "git-share list" shows
+
+	Stdout io.Writer // the receive command; nil discards it, since Send returns the code
+	Stderr io.Writer // progress and warnings; nil discards them
+}
+
+// sendSummary is the result send --json prints.
+type sendSummary struct {
+	Code     string `json:"code"`
+	CodeID   string `json:"code_id"`
+	Expiry   string `json:"expiry,omitempty"` // empty for a blob saved to a file
+	Bytes    int    `json:"bytes"`            // size of the patch before compressing and encrypting
+	IsCommit bool   `json:"is_commit"`        // receive --commit can apply it
+	ShortURL string `json:"short_url,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func runSendWithDeps(stdout, stderr io.Writer, deps sendDeps, args []string, opts SendOptions) (string, error) {
+	if !opts.JSON {
+		return sendPatch(stdout, stderr, deps, args, opts, &sendSummary{})
+	}
+	if opts.KeepAlive {
+		return "", fmt.Errorf("--json cannot be combined with --keep-alive, which keeps running after the code is printed")
+	}
+	// Nobody sees the prompts, so only the JSON is printed
+	opts.Interactive = false
+	var summary sendSummary
+	code, err := sendPatch(io.Discard, io.Discard, deps, args, opts, &summary)
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	if werr := json.NewEncoder(stdout).Encode(summary); err == nil {
+		err = werr
+	}
+	return code, err
+}
+
+// sendPatch collects, encrypts, and uploads a patch, filling in summary as
+// it goes.
+func sendPatch(stdout, stderr io.Writer, deps sendDeps, args []string, opts SendOptions, summary *sendSummary) (string, error) {
+	if opts.Lang != "" {
+		if _, err := wordlist.ForLang(opts.Lang); err != nil {
+			return "", err
+		}
+	}
+	if opts.KeepAlive && opts.SplitSize != "" {
+		return "", fmt.Errorf("--keep-alive cannot be combined with --split-size")
+	}
+	if opts.Compress && (opts.CompressLevel < payload.MinCompressLevel || opts.CompressLevel > payload.MaxCompressLevel) {
+		return "", fmt.Errorf("--compress-level must be between %d and %d", payload.MinCompressLevel, payload.MaxCompressLevel)
+	}
+
+	// The refs named on the command line are checked before git is
+	// touched; the upstream once it is known below
+	named := slices.Clone(args)
+	if opts.Base != "" {
+		named = append(named, opts.Base)
+	}
+	if opts.Last > 0 {
+		named = append(named, fmt.Sprintf("HEAD~%d..HEAD", opts.Last))
+	}
+	if opts.Stash != "" && opts.Stash != LatestStash {
+		// Named as --stash=<entry>; a bare --stash leaves it in args
+		named = append(named, opts.Stash)
+	}
+	if err := checkRefPolicy(opts.AllowRefs, named...); err != nil {
+		return "", err
+	}
+	if opts.SaveTo != "" && (opts.SplitSize != "" || opts.KeepAlive || opts.Space != "" || opts.Downloads > 1) {
+		return "", fmt.Errorf("a saved blob can't be split, kept alive, put in a space, or downloaded more than once")
+	}
+	if opts.Downloads < 0 {
+		return "", fmt.Errorf("--max-downloads must be at least 1")
+	}
+	downloads := max(opts.Downloads, 1)
+	if opts.KDF != "" && opts.KDF != KDFHKDF && opts.KDF != KDFArgon2id {
+		return "", fmt.Errorf("unknown --kdf %q; use %q or %q", opts.KDF, KDFHKDF, KDFArgon2id)
+	}
+	if opts.Last < 0 {
+		return "", ErrLastNotPositive
+	}
+	if opts.Untracked && (len(args) > 0 || opts.Staged || opts.Stash != "" || opts.Last != 0 || opts.PatchFile != "" || opts.Show || opts.CommitFirst || opts.Upstream || opts.Base != "") {
+		return "", fmt.Errorf("--include-untracked only applies to working tree changes, alone or with --all")
+	}
+	if len(opts.Paths) > 0 && (len(args) > 0 || opts.All || opts.Untracked || opts.Stash != "" || opts.Last != 0 || opts.PatchFile != "" || opts.Show || opts.CommitFirst || opts.Upstream || opts.Base != "") {
+		return "", fmt.Errorf("--path only applies to working tree changes or --staged")
+	}
+
+	if opts.Textconv && (len(args) > 0 || opts.Untracked || opts.Stash != "" || opts.Last != 0 || opts.PatchFile != "" || opts.Show || opts.CommitFirst || opts.AsCommit || opts.Upstream || opts.Base != "") {
+		return "", fmt.Errorf("--textconv only applies to working tree changes, --staged, or --all")
+	}
+	if opts.Textconv && opts.CheckApply {
+		return "", fmt.Errorf("--textconv diffs can't be applied, so --check-apply has nothing to check")
+	}
+
+	if opts.AsCommit {
+		if len(args) > 0 || opts.Stash != "" || opts.Last != 0 || opts.PatchFile != "" || opts.Show || opts.CommitFirst || opts.Upstream || opts.Base != "" {
+			return "", fmt.Errorf("--as-commit only applies to working tree changes, --staged, or --all")
+		}
+		if strings.TrimSpace(opts.Message) == "" {
+			return "", fmt.Errorf("--as-commit requires a commit message (--message)")
+		}
+	}
+
+	// 1. Make sure we're in a git repo, unless the patch is already a file
+	var err error
+	if opts.PatchFile == "" {
+		if _, err = deps.FindRepoRoot(); err != nil {
+			return "", err
+		}
+	}
+
+	// 2. Collect the patch
+	fmt.Fprintf(stderr, "Collecting changes...\n")
+	var patch []byte
+	isCommit := false
+	commitRef := "" // set when a single commit is shared
+	format := ""
+
+	switch {
+	case opts.PatchFile != "":
+		if len(args) > 0 || opts.Staged || opts.All || opts.Stash != "" || opts.Last != 0 || opts.Show || opts.CommitFirst || opts.Upstream || opts.Base != "" {
+			return "", fmt.Errorf("--patch-file cannot be combined with a commit reference or other ways of collecting changes")
+		}
+		patch, isCommit, err = readPatchFile(deps, opts.PatchFile)
+		if err != nil {
+			return "", err
+		}
+	case opts.Stash != "":
+		if len(args) > 1 || (len(args) == 1 && opts.Stash != LatestStash) || opts.Last != 0 || opts.Staged || opts.All || opts.Show || opts.CommitFirst || opts.Upstream || opts.Base != "" {
+			return "", fmt.Errorf("--stash cannot be combined with a commit reference or other ways of collecting changes")
+		}
+		ref := stashRef(opts.Stash, args)
+		fmt.Fprintf(stderr, "   Sharing %s\n", ref)
+		patch, err = deps.GetStashPatch(ref)
+	case opts.Show:
+		if len(args) > 1 || opts.Staged || opts.All || opts.CommitFirst || opts.Upstream || opts.Base != "" || opts.Last != 0 {
+			return "", fmt.Errorf("--show takes a single commit and cannot be combined with --staged, --all, --commit-first, --upstream, --base, or --last")
+		}
+		ref := "HEAD"
+		if len(args) == 1 {
+			ref = args[0]
+		}
+		if strings.Contains(ref, "..") {
+			return "", fmt.Errorf("--show takes a single commit, not a range")
+		}
+		patch, err = deps.GetShowPatch(ref)
+		format = payload.FormatShow
+	case opts.Upstream || opts.Base != "":
+		if len(args) > 0 || opts.Staged || opts.All || opts.CommitFirst || opts.Last != 0 {
+			return "", fmt.Errorf("--upstream and --base cannot be combined with a commit reference, --staged, --all, --commit-first, or --last")
+		}
+		var base string
+		base, err = resolveBase(deps, opts)
+		if err != nil {
+			return "", err
+		}
+		if err := checkRefPolicy(opts.AllowRefs, base+"..HEAD"); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(stderr, "   Sharing commits since %s\n", base)
+		patch, err = deps.GetCommitPatch(base + "..HEAD")
+		isCommit = true
+	case opts.Last != 0:
+		if len(args) > 0 || opts.Staged || opts.All || opts.CommitFirst {
+			return "", fmt.Errorf("--last cannot be combined with a commit reference, --staged, --all, or --commit-first")
+		}
+		var count int
+		count, err = deps.CommitCount("HEAD")
+		if err != nil {
+			return "", err
+		}
+		// HEAD~N needs a parent below the last N commits
+		if opts.Last >= count {
+			return "", fmt.Errorf("--last %d needs more than %d commits, but HEAD has %d; "+
+				"the first commit has no parent to diff against", opts.Last, opts.Last, count)
+		}
+		fmt.Fprintf(stderr, "   Sharing the last %d commit(s)\n", opts.Last)
+		patch, err = deps.GetCommitPatch(fmt.Sprintf("HEAD~%d..HEAD", opts.Last))
+		isCommit = true
+	case opts.CommitFirst:
+		if len(args) > 0 || opts.Staged || opts.All {
+			return "", fmt.Errorf("--commit-first cannot be combined with a commit reference, --staged, or --all")
+		}
+		if strings.TrimSpace(opts.Message) == "" {
+			return "", fmt.Errorf("--commit-first requires a commit message (--message)")
+		}
+		var sha string
+		sha, err = deps.CommitAll(opts.Message)
+		if err != nil {
+			return "", allowEmpty(stderr, err, opts)
+		}
+		fmt.Fprintf(stderr, "   Created commit %s\n", shortSHA(sha))
+		patch, err = deps.GetCommitPatch(sha)
+		isCommit = true
+		commitRef = sha
+	case opts.Textconv:
+		if opts.All && opts.Staged {
+			return "", fmt.Errorf("--all cannot be combined with a commit reference or --staged")
+		}
+		patch, err = deps.GetTextconvDiff(opts.Staged, opts.All, opts.Paths...)
+		format = payload.FormatTextconv
+	case opts.All:
+		if len(args) > 0 || opts.Staged {
+			return "", fmt.Errorf("--all cannot be combined with a commit reference or --staged")
+		}
+		patch, err = deps.GetDiffFromHead()
+	case len(args) > 0:
+		// Positional arg = commit ref or range
+		patch, err = deps.GetCommitPatch(args[0])
+		isCommit = true
+		if !strings.Contains(args[0], "..") {
+			commitRef = args[0]
+		}
+	case opts.Staged:
+		patch, err = deps.GetStagedDiff(opts.Paths...)
+	default:
+		patch, err = deps.GetDiff(opts.Paths...)
+	}
+	if opts.Untracked {
+		patch, err = addUntracked(deps, patch, err)
+	}
+	if err != nil {
+		return "", allowEmpty(stderr, err, opts)
+	}
+	fmt.Fprintf(stderr, "   Found %d bytes of changes\n", len(patch))
+	if opts.Textconv {
+		fmt.Fprintf(stderr, "Warning: with --textconv, files with a textconv filter are shown converted. The patch is for reading; the receiver can view or save it, but not apply it.\n")
+	}
+
+	// Dress the diff up as a commit so receive --commit can git am it
+	if opts.AsCommit {
+		author, err := deps.Author()
+		if err != nil {
+			return "", err
+		}
+		if patch, err = git.DiffToCommitPatch(patch, opts.Message, author); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(stderr, "   Sending as a commit by %s\n", author)
+		isCommit = true
+	}
+	summary.Bytes, summary.IsCommit = len(patch), isCommit
+
+	// A diff of the local tree was made against HEAD
+	local := (!isCommit || opts.AsCommit) && opts.PatchFile == "" && !opts.Show
+	header := payload.Header{Kind: payload.KindPatch, Format: format}
+	if local && opts.Stash == "" {
+		// Without commits there is no base to compare; send without one
+		header.Base, _ = deps.HeadCommit()
+	}
+
+	// Carry git notes along with a single commit
+	if commitRef != "" {
+		header.Notes, err = deps.GetNotes(commitRef)
+		if err != nil {
+			return "", err
+		}
+		if header.Notes != "" {
+			fmt.Fprintf(stderr, "   Including git notes\n")
+		}
+	}
+
+	if opts.SignKey != nil {
+		pub := opts.SignKey.Public().(ed25519.PublicKey)
+		header.Signature = crypto.Sign(opts.SignKey, payload.SignedData(header, patch))
+		header.SignerKey = crypto.FormatPublicKey(pub)
+		fmt.Fprintf(stderr, "   Signed with key %s\n", crypto.KeyFingerprint(pub))
+	}
+
+	// Show a summary of changes
+	stats, _ := deps.PatchStats(patch)
+	if stats != "" {
+		fmt.Fprintf(stderr, "\nSummary of changes:\n%s\n", stats)
+	}
+
+	if opts.CheckApply {
+		checkApply(stderr, deps, patch, local, opts.Staged)
+	}
+
+	// Keep likely secrets off the public relay unless forced
+	if err := checkSecrets(stderr, patch, opts); err != nil {
+		return "", err
+	}
+
+	warnLFSPointers(stderr, patch)
+
+	// Make sure a very large patch is intended
+	if len(patch) > largePatchSize && !opts.Yes {
+		if !opts.Interactive {
+			fmt.Fprintf(stderr, "Warning: sending a large patch (%s).\n", ui.FormatByteSize(int64(len(patch))))
+		} else {
+			fmt.Fprintf(stderr, "\nThis patch is %s. Largest files:\n", ui.FormatByteSize(int64(len(patch))))
+			for _, f := range largestFiles(patch, 5) {
+				fmt.Fprintf(stderr, "   %8s  %s\n", ui.FormatByteSize(int64(f.size)), f.path)
+			}
+			ok, err := ui.Confirm(opts.Stdin, stderr, "Send it anyway?")
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				return "", fmt.Errorf("send cancelled; pass --yes to skip this check")
+			}
+		}
+	}
+
+	// 3. Generate the code (codeID + passphrase)
+	var code, codeID, passphrase string
+	if opts.Passphrase != "" {
+		// The passphrase travels over another channel, so only the code ID is shared
+		codeID, err = deps.GenerateCodeID()
+		if err != nil {
+			return "", fmt.Errorf("generating code ID: %w", err)
+		}
+		code, passphrase = codeID, opts.Passphrase
+		if crypto.IsWeakPassphrase(passphrase) {
+			fmt.Fprintf(stderr, "Warning: the passphrase is shorter than %d characters and may be guessable.\n", crypto.MinPassphraseLength)
+		}
+	} else {
+		code, codeID, passphrase, err = deps.GenerateCode(opts.Lang)
+		if err != nil {
+			return "", fmt.Errorf("generating code: %w", err)
+		}
+	}
+
+	summary.Code, summary.CodeID = code, codeID
+
+	// 4. Derive encryption key and encrypt
+	seal, err := newSealer(deps, passphrase, opts.KDF)
+	if err != nil {
+		return "", err
+	}
+
+	body := patch
+	if opts.Compress {
+		body, err = payload.Compress(patch, opts.CompressLevel)
+		if err != nil {
+			return "", err
+		}
+		header.Encoding = payload.EncodingGzip
+		fmt.Fprintf(stderr, "   Compressed to %s (level %d)\n", ui.FormatByteSize(int64(len(body))), opts.CompressLevel)
+	}
+
+	plaintext := body
+	if header.HasMetadata() {
+		plaintext, err = payload.Encode(header, body)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	encrypted, err := seal(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("encrypting: %w", err)
+	}
+
+	// 5. Parse TTL and split size
+	ttl, err := time.ParseDuration(opts.TTL)
+	if err != nil {
+		return "", fmt.Errorf("invalid TTL %q: %w", opts.TTL, err)
+	}
+	if opts.KeepAlive && ttl < time.Second {
+		return "", fmt.Errorf("--keep-alive needs a TTL of at least 1s, got %s", ttl)
+	}
+
+	var splitSize int64
+	if opts.SplitSize != "" {
+		splitSize, err = ui.ParseByteSize(opts.SplitSize)
+		if err != nil {
+			return "", fmt.Errorf("invalid split size %q: %w", opts.SplitSize, err)
+		}
+	}
+
+	// Write the blob to a file instead, for carrying to an offline machine
+	if opts.SaveTo != "" {
+		return code, saveBlob(stdout, stderr, deps, payload.EncodeData(encrypted, opts.URLSafe), code, isCommit, opts)
+	}
+
+	// 6. Upload to relay server
+	fmt.Fprintf(stderr, "Encrypting and uploading...\n")
+	encoded := payload.EncodeData(encrypted, opts.URLSafe)
+
+	// Refuse a blob the relay would reject only after the whole upload
+	var partSize int
+	if splitSize == 0 {
+		if partSize, err = checkRelaySize(deps, len(encoded), opts.Compress); err != nil {
+			return "", err
+		}
+	} else if err := checkSplitSize(deps, min(int64(len(encoded)), splitSize)); err != nil {
+		return "", err
+	}
+
+	// Check what the relay supports when it matters; older relays don't say
+	if opts.KeepAlive {
+		opts.KeepAlive = adaptToRelay(stderr, deps, opts.KeepAlive)
+	}
+
+	// A relay that ignores the count would delete the patch after one download
+	if downloads > 1 {
+		if caps, err := deps.Capabilities(); err != nil || !caps.Has(client.FeatureMaxDownloads) {
+			return "", fmt.Errorf("the relay doesn't support --max-downloads; it would delete the patch after the first download")
+		}
+	}
+
+	var stored int
+	if splitSize > 0 && int64(len(encoded)) > splitSize {
+		encoded, stored, err = uploadParts(stderr, deps, encoded, splitSize, seal, int(ttl.Seconds()), downloads, opts.URLSafe)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var resp *client.SendResponse
+	if partSize > 0 {
+		fmt.Fprintf(stderr, "Uploading in %d parts, which the relay joins under one code...\n", (len(encoded)+partSize-1)/partSize)
+		resp, err = deps.SendParts(codeID, encoded, int(ttl.Seconds()), downloads, partSize)
+	} else {
+		resp, err = deps.Send(codeID, encoded, int(ttl.Seconds()), downloads)
+	}
+	if err != nil {
+		return "", fmt.Errorf("upload failed: %w", err)
+	}
+	stored += resp.Size
+	summary.Expiry, summary.ShortURL = resp.Expiry, resp.ShortURL
+	if resp.TTL > 0 && resp.TTL != int(ttl.Seconds()) {
+		fmt.Fprintf(stderr, "The relay adjusted the TTL to %s.\n", time.Duration(resp.TTL)*time.Second)
+	}
+
+	// 7. Print the receive command
+	fmt.Fprintf(stderr, "\nEncrypted and uploaded.\n")
+	if stored > 0 {
+		fmt.Fprintf(stderr, "Patch size: %s | Stored on relay: %s (encrypted, base64)\n", ui.FormatByteSize(int64(len(patch))), ui.FormatByteSize(int64(stored)))
+	}
+	fmt.Fprintf(stderr, "Share this with the receiver:\n\n")
+	receiveArgs := code
+	if opts.Passphrase != "" {
+		receiveArgs += " --passphrase <passphrase>"
+	}
+	if opts.Space != "" {
+		receiveArgs += " --space " + opts.Space
+	}
+	fmt.Fprintf(stdout, "   git-share receive %s\n", receiveArgs)
+	if opts.QR {
+		showQR(stderr, "git-share receive "+receiveArgs)
+	}
+	if isCommit {
+		fmt.Fprintf(stderr, "OR to receive as a commit instead of a patch:\n")
+		fmt.Fprintf(stdout, "   git-share receive %s --commit\n", receiveArgs)
+	}
+	if opts.Passphrase != "" {
+		fmt.Fprintf(stderr, "Send the passphrase separately; it is not part of the code.\n")
+	}
+	if resp.ShortURL != "" {
+		fmt.Fprintf(stderr, "OR open this link in a browser. It does not include the passphrase, so send that separately:\n")
+		fmt.Fprintf(stdout, "   %s\n", resp.ShortURL)
+		if opts.Passphrase == "" {
+			fmt.Fprintf(stderr, "   Passphrase: %s\n", passphrase)
+		}
+	}
+	if downloads > 1 {
+		fmt.Fprintf(stderr, "\nExpires: %s | Can be received %d times\n", resp.Expiry, downloads)
+	} else {
+		fmt.Fprintf(stderr, "\nExpires: %s | One-time use only\n", resp.Expiry)
+	}
+
+	// Remember the code for "git-share list"; losing track of it isn't fatal
+	if err := deps.RecordSent(sentEntry(code, codeID, opts, resp, ttl)); err != nil {
+		fmt.Fprintf(stderr, "Warning: can't record the code for git-share list: %v\n", err)
+	}
+
+	// 8. Optionally keep the patch alive until it is received
+	if opts.KeepAlive {
+		if resp.TTL > 0 {
+			ttl = time.Duration(resp.TTL) * time.Second
+		}
+		return code, keepAlive(stderr, deps, codeID, ttl)
+	}
+	return code, nil
+}
+
+// sentEntry describes an upload for the ledger. The relay's expiry wins
+// over the requested TTL, which it may have adjusted.
+func sentEntry(code, codeID string, opts SendOptions, resp *client.SendResponse, ttl time.Duration) ledger.Entry {
+	now := time.Now().UTC().Truncate(time.Second)
+	expires, err := time.Parse(time.RFC3339, resp.Expiry)
+	if err != nil {
+		if resp.TTL > 0 {
+			ttl = time.Duration(resp.TTL) * time.Second
+		}
+		expires = now.Add(ttl)
+	}
+	return ledger.Entry{
+		Code:    code,
+		CodeID:  codeID,
+		Server:  opts.Server,
+		Space:   opts.Space,
+		Created: now,
+		Expires: expires,
+	}
+}
+
+// ErrLastNotPositive rejects a --last that doesn't name any commits.
+var ErrLastNotPositive = errors.New("--last must be a positive number of commits")
+
+// stashRef returns the stash entry to send. A bare --stash takes an optional
+// value only as --stash=<entry>, so "--stash stash@{2}" leaves the entry as
+// an argument. A number n stands for stash@{n}.
+func stashRef(flag string, args []string) string {
+	ref := flag
+	if flag == LatestStash && len(args) == 1 {
+		ref = args[0]
+	}
+	if _, err := strconv.Atoi(ref); err == nil {
+		ref = "stash@{" + ref + "}"
+	}
+	return ref
+}
+
+// showQR draws text as a QR code for scanning off the screen. A text too
+// long to encode is only a warning; the plain command is already printed.
+func showQR(stderr io.Writer, text string) {
+	code, err := qr.Encode(text)
+	if err != nil {
+		fmt.Fprintf(stderr, "Warning: can't show a QR code: %v\n", err)
+		return
+	}
+	fmt.Fprintf(stderr, "\n")
+	code.Render(stderr, false)
+}
+
+// addUntracked appends the untracked files to a working tree diff. A tree
+// with only untracked files is not "no changes".
+func addUntracked(deps sendDeps, patch []byte, err error) ([]byte, error) {
+	if err != nil && !errors.Is(err, git.ErrNoChanges) {
+		return nil, err
+	}
+	untracked, uerr := deps.GetUntrackedDiff()
+	if uerr != nil {
+		return nil, uerr
+	}
+	if len(untracked) == 0 {
+		return patch, err
+	}
+	return append(patch, untracked...), nil
+}
+
+// adaptToRelay checks an upload against the relay's capabilities, turning
+// keep-alive off on relays that can't extend patches. Relays that don't
+// report their capabilities are assumed to support everything.
+func adaptToRelay(stderr io.Writer, deps sendDeps, keepAlive bool) bool {
+	caps, err := deps.Capabilities()
+	if err != nil {
+		return keepAlive
+	}
+	if keepAlive && !caps.Has(client.FeatureExtend) {
+		fmt.Fprintf(stderr, "Warning: the relay can't extend patches, so --keep-alive is ignored.\n")
+		keepAlive = false
+	}
+	return keepAlive
+}
+
+// checkRelaySize refuses an upload over the relay's size limit, which the
+// relay would otherwise only reject once it had all of it. Relays that
+// don't report a limit are left to enforce their own. An upload over the
+// limit for one request that fits the relay's limit for uploads in parts
+// is allowed, and partSize is what to send it in; otherwise it is 0.
+func checkRelaySize(deps sendDeps, size int, compressed bool) (partSize int, err error) {
+	maxSize, maxUpload := relayLimits(deps)
+	if maxSize <= 0 || int64(size) <= maxSize {
+		return 0, nil
+	}
+	if int64(size) <= maxUpload && maxSize > partOverhead {
+		return int(maxSize - partOverhead), nil
+	}
+
+	fixes := []string{"send it in parts with --split-size " + ui.FormatByteSize(maxSize)}
+	if !compressed {
+		fixes = append(fixes, "shrink it with --compress")
+	}
+	fixes = append(fixes, "use a relay with a higher limit (git-share serve --max-size)")
+	return 0, fmt.Errorf("the encrypted patch is %s, over the relay's limit of %s; %s, or %s",
+		ui.FormatByteSize(int64(size)), ui.FormatByteSize(max(maxSize, maxUpload)), strings.Join(fixes[:len(fixes)-1], ", "), fixes[len(fixes)-1])
+}
+
+// checkSplitSize refuses --split-size parts of size bytes that are over the
+// relay's size limit for one upload, which the relay would only reject
+// once it had the first of them.
+func checkSplitSize(deps sendDeps, size int64) error {
+	maxSize, _ := relayLimits(deps)
+	if maxSize <= 0 || size <= maxSize {
+		return nil
+	}
+	return fmt.Errorf("parts of %s are over the relay's limit of %s per upload; use --split-size %s or less",
+		ui.FormatByteSize(size), ui.FormatByteSize(maxSize), ui.FormatByteSize(maxSize))
+}
+
+// relayLimits returns the relay's size limits for one upload and for an
+// upload in parts, or 0 for a relay that doesn't report them.
+func relayLimits(deps sendDeps) (maxSize, maxUpload int64) {
+	if limits, err := deps.Limits(); err == nil {
+		return limits.MaxSize, limits.MaxUploadSize
+	}
+	if caps, err := deps.Capabilities(); err == nil {
+		// Relays from before /api/limits report it with their capabilities
+		return caps.MaxSize, caps.MaxUploadSize
+	}
+	return 0, 0
+}
+
+// keepAlive extends a patch's TTL until the status endpoint reports it gone.
+// It runs until then, or until the sender interrupts it.
+func keepAlive(stderr io.Writer, deps sendDeps, codeID string, ttl time.Duration) error {
+	fmt.Fprintf(stderr, "Keeping the patch on the relay until it is received (Ctrl-C to stop)...\n")
+	// A second at least, so a tiny TTL doesn't make it hammer the relay
+	pause := max(min(keepAliveInterval, ttl/2), time.Second)
+	for {
+		deps.Sleep(pause)
+		if _, err := deps.Status(codeID); err != nil {
+			return keepAliveDone(stderr, err)
+		}
+		if _, err := deps.Extend(codeID, int(ttl.Seconds())); err != nil {
+			return keepAliveDone(stderr, err)
+		}
+	}
+}
+
+// keepAliveDone reports why a keep-alive loop stopped. A received patch is
+// success; one that expired anyway, or a relay error, is not.
+func keepAliveDone(stderr io.Writer, err error) error {
+	var gone *client.GoneError
+	switch {
+	case errors.As(err, &gone) && gone.Reason == "consumed":
+		fmt.Fprintf(stderr, "The patch was received.\n")
+		return nil
+	case errors.Is(err, client.ErrNotFound):
+		fmt.Fprintf(stderr, "The patch is no longer on the relay.\n")
+		return nil
+	case errors.As(err, &gone):
+		return fmt.Errorf("the patch expired before it was received")
+	}
+	return fmt.Errorf("keeping the patch alive: %w", err)
+}
+
+type patchFile struct {
+	path string
+	size int
+}
+
+// largestFiles returns up to n files with the most patch bytes, largest first.
+func largestFiles(patch []byte, n int) []patchFile {
+	var files []patchFile
+	sections := bytes.Split(patch, []byte("\ndiff --git "))
+	for i, section := range sections {
+		if i > 0 {
+			section = append([]byte("diff --git "), section...)
+		}
+		paths := git.PatchFiles(section)
+		if len(paths) == 0 {
+			continue
+		}
+		files = append(files, patchFile{path: paths[0], size: len(section)})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].size > files[j].size })
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files
+}
+
+// allowEmpty reports a "no changes" error as a notice instead when
+// --allow-empty is set, so pipelines can treat it as success.
+func allowEmpty(stderr io.Writer, err error, opts SendOptions) error {
+	if !opts.AllowEmpty || !errors.Is(err, git.ErrNoChanges) {
+		return err
+	}
+	fmt.Fprintf(stderr, "Nothing to share: %v\n", err)
+	return nil
+}
+
+// checkApply reports how likely a patch is to apply cleanly for the
+// receiver. A patch of local changes is also checked against the tree it
+// came from, which catches a tree in an unexpected state.
+func checkApply(stderr io.Writer, deps sendDeps, patch []byte, local, staged bool) {
+	if local {
+		if err := deps.CheckReverse(patch, staged); err != nil {
+			fmt.Fprintf(stderr, "Warning: the patch does not match your working tree, so it may not have the base you expect:\n   %v\n", err)
+		}
+	}
+
+	risk, err := git.ContextRisk(patch)
+	switch {
+	case err != nil:
+		fmt.Fprintf(stderr, "Apply risk: unknown (%v)\n", err)
+	case risk.Hunks == 0:
+		fmt.Fprintf(stderr, "Apply risk: %s (no hunks change existing lines)\n", risk.Level)
+	case risk.Sparse == 0:
+		fmt.Fprintf(stderr, "Apply risk: %s (%d hunks, all with full context)\n", risk.Level, risk.Hunks)
+	default:
+		fmt.Fprintf(stderr, "Apply risk: %s (%d of %d hunks have little context and may apply in the wrong place or conflict)\n", risk.Level, risk.Sparse, risk.Hunks)
+	}
+}
+
+// checkSecrets scans the patch for likely secrets. Sending them to the public
+// relay, a server the sender doesn't control, needs --force; for any other
+// relay the findings are only a warning.
+func checkSecrets(stderr io.Writer, patch []byte, opts SendOptions) error {
+	findings, err := secrets.Scan(patch)
+	if err != nil || len(findings) == 0 {
+		// A patch the scanner can't parse is still sent; git will judge it
+		return nil
+	}
+
+	public := strings.TrimRight(opts.Server, "/") == DefaultServer
+	if public && !opts.Force {
+		fmt.Fprintf(stderr, "\nThis patch looks like it contains secrets:\n")
+	} else {
+		fmt.Fprintf(stderr, "\nWarning: this patch looks like it contains secrets:\n")
+	}
+	for _, f := range findings {
+		fmt.Fprintf(stderr, "   %s:%d  %s\n", f.File, f.Line, f.Kind)
+	}
+	if !public {
+		return nil
+	}
+	fmt.Fprintf(stderr, "It is going to %s, a public relay run by a third party.\n", DefaultServer)
+	fmt.Fprintf(stderr, "The patch is encrypted, but anyone who gets the code can read it.\n")
+	if opts.Force {
+		return nil
+	}
+	return fmt.Errorf("refusing to send likely secrets to the public relay; pass --force to send anyway, or --server to use your own relay")
+}
+
+// warnLFSPointers warns when the patch writes Git LFS pointer files, since
+// the receiver gets the pointers but not the objects behind them.
+func warnLFSPointers(stderr io.Writer, patch []byte) {
+	pointers, err := git.LFSPointers(patch)
+	if err != nil || len(pointers) == 0 {
+		return
+	}
+	fmt.Fprintf(stderr, "\nWarning: this patch includes Git LFS pointers, not the files they point to:\n")
+	for _, path := range pointers {
+		fmt.Fprintf(stderr, "   %s\n", path)
+	}
+	fmt.Fprintf(stderr, "The receiver needs access to the same LFS storage (and \"git lfs pull\") to get their contents.\n")
+}
+
+// readPatchFile reads a patch file for --patch-file, reporting whether it is
+// a commit in mbox format (from "git format-patch") rather than a plain diff.
+func readPatchFile(deps sendDeps, path string) (patch []byte, isCommit bool, err error) {
+	patch, err = deps.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading patch file: %w", err)
+	}
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return nil, false, fmt.Errorf("%s is empty", path)
+	}
+	if !looksLikePatch(patch) {
+		return nil, false, fmt.Errorf("%s does not look like a patch (expected it to start with \"diff\", \"From \" or \"---\")", path)
+	}
+	return patch, bytes.HasPrefix(patch, []byte("From ")), nil
+}
+
+// looksLikePatch reports whether data starts like a diff or a format-patch
+// mail, ignoring leading blank lines.
+func looksLikePatch(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	for _, prefix := range []string{"diff", "From ", "---"} {
+		if bytes.HasPrefix(data, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveBase picks the ref that --upstream or --base sends commits since.
+// --base is the fallback when the branch has no upstream.
+func resolveBase(deps sendDeps, opts SendOptions) (string, error) {
+	if !opts.Upstream {
+		return opts.Base, nil
+	}
+	upstream, err := deps.UpstreamRef()
+	if err == nil {
+		return upstream, nil
+	}
+	if !errors.Is(err, git.ErrNoUpstream) {
+		return "", err
+	}
+	if opts.Base != "" {
+		return opts.Base, nil
+	}
+	return "", fmt.Errorf("%w; set one with 'git branch --set-upstream-to' or pass --base <ref>", err)
+}
+
+// shortSHA abbreviates a commit SHA for display.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// sealer encrypts a blob for the receiver.
+type sealer func(plaintext []byte) ([]byte, error)
+
+// newSealer derives the key with the chosen KDF and returns a sealer that
+// encrypts with it. Blobs keyed with Argon2id start with the KDF header the
+// receiver needs to derive the key again; HKDF blobs have none, as before.
+func newSealer(deps sendDeps, passphrase, kdf string) (sealer, error) {
+	var key, header []byte
+	var err error
+	if kdf == KDFArgon2id {
+		params, perr := crypto.DefaultArgon2Params()
+		if perr != nil {
+			return nil, perr
+		}
+		key, err = deps.DeriveKeyArgon2(passphrase, params)
+		header = crypto.KDFHeader(params)
+	} else {
+		key, err = deps.DeriveKey(passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	return func(plaintext []byte) ([]byte, error) {
+		encrypted, err := deps.Encrypt(plaintext, key)
+		if err != nil || header == nil {
+			return encrypted, err
+		}
+		return append(slices.Clip(header), encrypted...), nil
+	}, nil
+}
+
+// uploadParts uploads an encoded blob as parts of at most partSize bytes,
+// each under its own code ID, and returns the encoded manifest that lists
+// them along with the number of bytes the relay stored. Each part can be
+// downloaded as many times as the patch. The manifest is encrypted with the
+// same key as the patch, so the part code IDs are only visible to the
+// receiver.
+func uploadParts(stderr io.Writer, deps sendDeps, encoded string, partSize int64, seal sealer, ttl, downloads int, urlSafe bool) (string, int, error) {
+	total := (int64(len(encoded)) + partSize - 1) / partSize
+	fmt.Fprintf(stderr, "   Splitting into %d parts\n", total)
+
+	parts := make([]string, 0, total)
+	stored := 0
+	for i := int64(0); i < total; i++ {
+		start := i * partSize
+		end := min(start+partSize, int64(len(encoded)))
+
+		partID, err := deps.GenerateCodeID()
+		if err != nil {
+			return "", 0, fmt.Errorf("generating part code ID: %w", err)
+		}
+		resp, err := deps.Send(partID, encoded[start:end], ttl, downloads)
+		if err != nil {
+			return "", 0, fmt.Errorf("upload of part %d/%d failed: %w", i+1, total, err)
+		}
+		parts = append(parts, partID)
+		stored += resp.Size
+	}
+
+	manifest, err := payload.Encode(payload.Header{Kind: payload.KindManifest, Parts: parts}, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	encrypted, err := seal(manifest)
+	if err != nil {
+		return "", 0, fmt.Errorf("encrypting manifest: %w", err)
+	}
+	return payload.EncodeData(encrypted, urlSafe), stored, nil
+}
+
+// checkRefPolicy rejects commits or ranges with a ref that doesn't match
+// pattern, which has to match the whole ref. It only looks at the text of
+// the refs, so it can run before git is touched. An empty pattern allows
+// everything.
+func checkRefPolicy(pattern string, revs ...string) error {
+	if pattern == "" || len(revs) == 0 {
+		return nil
+	}
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return fmt.Errorf("invalid --allow-ref-pattern %q: %w", pattern, err)
+	}
+
+	for _, rev := range revs {
+		sides := []string{rev}
+		if from, to, ok := strings.Cut(rev, "..."); ok {
+			sides = []string{from, to}
+		} else if from, to, ok := strings.Cut(rev, ".."); ok {
+			sides = []string{from, to}
+		}
+		for _, ref := range sides {
+			if ref == "" {
+				ref = "HEAD"
+			}
+			if !re.MatchString(ref) {
+				return fmt.Errorf("ref %q is not allowed by --allow-ref-pattern %q", ref, pattern)
+			}
+		}
+	}
+	return nil
+}
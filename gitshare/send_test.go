@@ -0,0 +1,1555 @@
+package gitshare
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+	"github.com/flawiddsouza/git-share/internal/crypto"
+	"github.com/flawiddsouza/git-share/internal/git"
+	"github.com/flawiddsouza/git-share/internal/ledger"
+	"github.com/flawiddsouza/git-share/internal/payload"
+	"github.com/flawiddsouza/git-share/internal/qr"
+)
+
+type mockSendDeps struct {
+	repoRoot    string
+	patch       []byte
+	err         error
+	code        string
+	codeID      string
+	passphrase  string
+	expiry      string
+	capturedRef string
+	stats       string
+	relay       map[string]string // when set, Send stores uploads here
+	nextPartID  int
+	commitSHA   string
+	commitErr   error
+	commitMsg   string
+	notes       string
+	upstream    string // empty means no upstream is configured
+	derivedFrom string // passphrase passed to DeriveKey
+	lang        string // language passed to GenerateCode
+	available   int    // Status calls that succeed before statusErr is returned
+	statusErr   error
+	extends     []int // TTLs passed to Extend
+	slept       []time.Duration
+	shortURL    string               // returned by Send
+	reverseErr  error                // returned by CheckReverse
+	checked     string               // "tree" or "index" when CheckReverse ran
+	gitCalled   bool                 // FindRepoRoot ran
+	caps        *client.Capabilities // nil for a relay that doesn't report them
+	untracked   []byte               // returned by GetUntrackedDiff
+	paths       []string             // pathspecs passed to GetDiff or GetStagedDiff
+	argon2Salt  []byte               // salt passed to DeriveKeyArgon2
+	saved       map[string]string    // WriteFile calls by path
+	downloads   []int                // maxDownloads passed to each Send
+	commits     int                  // returned by CommitCount
+	limits      *client.Limits       // nil for a relay that doesn't report them
+	partSize    int                  // what SendParts was last asked to split into
+	recorded    []ledger.Entry       // RecordSent calls
+	recordErr   error                // returned by RecordSent
+	author      string               // returned by Author
+	head        string               // returned by HeadCommit; empty for a repo without commits
+}
+
+func (m *mockSendDeps) FindRepoRoot() (string, error) {
+	m.gitCalled = true
+	return m.repoRoot, nil
+}
+func (m *mockSendDeps) GetCommitPatch(ref string) ([]byte, error) {
+	m.capturedRef = ref
+	return m.patch, m.err
+}
+func (m *mockSendDeps) GetStashPatch(ref string) ([]byte, error) {
+	m.capturedRef = "stash " + ref
+	return m.patch, m.err
+}
+func (m *mockSendDeps) GetShowPatch(ref string) ([]byte, error) {
+	m.capturedRef = "show " + ref
+	return m.patch, m.err
+}
+func (m *mockSendDeps) GetNotes(ref string) (string, error) { return m.notes, nil }
+func (m *mockSendDeps) CommitCount(ref string) (int, error) { return m.commits, nil }
+func (m *mockSendDeps) UpstreamRef() (string, error) {
+	if m.upstream == "" {
+		return "", git.ErrNoUpstream
+	}
+	return m.upstream, nil
+}
+func (m *mockSendDeps) GetStagedDiff(paths ...string) ([]byte, error) {
+	m.paths = paths
+	return m.patch, m.err
+}
+func (m *mockSendDeps) GetDiff(paths ...string) ([]byte, error) {
+	m.paths = paths
+	return m.patch, m.err
+}
+func (m *mockSendDeps) GetUntrackedDiff() ([]byte, error) { return m.untracked, nil }
+func (m *mockSendDeps) GetTextconvDiff(staged, all bool, paths ...string) ([]byte, error) {
+	m.capturedRef = fmt.Sprintf("textconv staged=%v all=%v", staged, all)
+	m.paths = paths
+	return m.patch, m.err
+}
+func (m *mockSendDeps) GetDiffFromHead() ([]byte, error) {
+	m.capturedRef = "HEAD"
+	return m.patch, m.err
+}
+func (m *mockSendDeps) CommitAll(message string) (string, error) {
+	m.commitMsg = message
+	return m.commitSHA, m.commitErr
+}
+func (m *mockSendDeps) Author() (string, error) { return m.author, nil }
+func (m *mockSendDeps) HeadCommit() (string, error) {
+	if m.head == "" {
+		return "", errors.New("resolving HEAD: no commits")
+	}
+	return m.head, nil
+}
+func (m *mockSendDeps) ReadFile(path string) ([]byte, error) { return os.ReadFile(path) }
+func (m *mockSendDeps) WriteFile(path string, data []byte) error {
+	if m.saved == nil {
+		m.saved = map[string]string{}
+	}
+	m.saved[path] = string(data)
+	return nil
+}
+func (m *mockSendDeps) GenerateCode(lang string) (string, string, string, error) {
+	m.lang = lang
+	return m.code, m.codeID, m.passphrase, nil
+}
+func (m *mockSendDeps) GenerateCodeID() (string, error) {
+	m.nextPartID++
+	return fmt.Sprintf("part%d", m.nextPartID), nil
+}
+func (m *mockSendDeps) DeriveKey(passphrase string) ([]byte, error) {
+	m.derivedFrom = passphrase
+	return []byte("key"), nil
+}
+func (m *mockSendDeps) DeriveKeyArgon2(passphrase string, params crypto.Argon2Params) ([]byte, error) {
+	m.derivedFrom = passphrase
+	m.argon2Salt = params.Salt
+	return []byte("argon2-key"), nil
+}
+func (m *mockSendDeps) Encrypt(data, key []byte) ([]byte, error) { return data, nil }
+func (m *mockSendDeps) Send(codeID, data string, ttl, maxDownloads int) (*client.SendResponse, error) {
+	m.downloads = append(m.downloads, maxDownloads)
+	if m.relay != nil {
+		m.relay[codeID] = data
+	}
+	return &client.SendResponse{Expiry: m.expiry, Size: len(data), ShortURL: m.shortURL}, nil
+}
+func (m *mockSendDeps) SendParts(codeID, data string, ttl, maxDownloads, partSize int) (*client.SendResponse, error) {
+	m.partSize = partSize
+	return m.Send(codeID, data, ttl, maxDownloads)
+}
+func (m *mockSendDeps) Status(codeID string) (*client.StatusResponse, error) {
+	if m.available == 0 {
+		return nil, m.statusErr
+	}
+	m.available--
+	return &client.StatusResponse{OK: true}, nil
+}
+func (m *mockSendDeps) Extend(codeID string, ttl int) (*client.ExtendResponse, error) {
+	m.extends = append(m.extends, ttl)
+	return &client.ExtendResponse{OK: true}, nil
+}
+func (m *mockSendDeps) Capabilities() (*client.Capabilities, error) {
+	if m.caps == nil {
+		return nil, client.ErrNoCapabilities
+	}
+	return m.caps, nil
+}
+func (m *mockSendDeps) Limits() (*client.Limits, error) {
+	if m.limits == nil {
+		return nil, client.ErrNoLimits
+	}
+	return m.limits, nil
+}
+func (m *mockSendDeps) Sleep(d time.Duration)                   { m.slept = append(m.slept, d) }
+func (m *mockSendDeps) PatchStats(patch []byte) (string, error) { return m.stats, nil }
+func (m *mockSendDeps) CheckReverse(patch []byte, cached bool) error {
+	m.checked = "tree"
+	if cached {
+		m.checked = "index"
+	}
+	return m.reverseErr
+}
+func (m *mockSendDeps) RecordSent(e ledger.Entry) error {
+	m.recorded = append(m.recorded, e)
+	return m.recordErr
+}
+
+func TestRunSendWithDeps(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		staged        bool
+		patch         string
+		wantStdout    []string
+		wantStderr    []string
+		notWantStdout []string
+		notWantStderr []string
+	}{
+		{
+			name:          "uncommitted changes",
+			args:          []string{},
+			patch:         "diff content",
+			wantStdout:    []string{"git-share receive abc-123"},
+			wantStderr:    []string{"Summary of changes:", "file.txt | 2 +"},
+			notWantStdout: []string{"--commit"},
+			notWantStderr: []string{"OR to receive as a commit instead of a patch:"},
+		},
+		{
+			name:          "staged changes",
+			args:          []string{},
+			staged:        true,
+			patch:         "diff content",
+			wantStdout:    []string{"git-share receive abc-123"},
+			wantStderr:    []string{"Summary of changes:", "file.txt | 2 +"},
+			notWantStdout: []string{"--commit"},
+			notWantStderr: []string{"OR to receive as a commit instead of a patch:"},
+		},
+		{
+			name:       "specific commit",
+			args:       []string{"HEAD"},
+			patch:      "patch content",
+			wantStdout: []string{"git-share receive abc-123", "git-share receive abc-123 --commit"},
+			wantStderr: []string{"OR to receive as a commit instead of a patch:", "Summary of changes:", "file.txt | 2 +"},
+		},
+		{
+			name:       "commit range",
+			args:       []string{"main..feature"},
+			patch:      "patch content",
+			wantStdout: []string{"git-share receive abc-123", "git-share receive abc-123 --commit"},
+			wantStderr: []string{"OR to receive as a commit instead of a patch:", "Summary of changes:", "file.txt | 2 +"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			deps := &mockSendDeps{
+				repoRoot:   "/repo",
+				patch:      []byte(tt.patch),
+				code:       "abc-123",
+				codeID:     "id",
+				passphrase: "pass",
+				expiry:     "2026-02-27T17:00:00Z",
+				stats:      "file.txt | 2 +",
+			}
+
+			_, err := runSendWithDeps(stdout, stderr, deps, tt.args, SendOptions{Staged: tt.staged, TTL: "1h"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			stdoutStr := stdout.String()
+			stderrStr := stderr.String()
+
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdoutStr, want) {
+					t.Errorf("stdout missing %q\nGOT:\n%s", want, stdoutStr)
+				}
+			}
+			for _, want := range tt.wantStderr {
+				if !strings.Contains(stderrStr, want) {
+					t.Errorf("stderr missing %q\nGOT:\n%s", want, stderrStr)
+				}
+			}
+			for _, notWant := range tt.notWantStdout {
+				if strings.Contains(stdoutStr, notWant) {
+					t.Errorf("stdout should NOT contain %q\nGOT:\n%s", notWant, stdoutStr)
+				}
+			}
+			for _, notWant := range tt.notWantStderr {
+				if strings.Contains(stderrStr, notWant) {
+					t.Errorf("stderr should NOT contain %q\nGOT:\n%s", notWant, stderrStr)
+				}
+			}
+		})
+	}
+}
+
+func TestSendCommitFirst(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	deps := &mockSendDeps{
+		patch:     []byte("patch content"),
+		code:      "abc-123",
+		commitSHA: "0123456789abcdef",
+	}
+
+	opts := SendOptions{TTL: "1h", CommitFirst: true, Message: "wip: share this"}
+	if _, err := runSendWithDeps(stdout, stderr, deps, nil, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deps.commitMsg != "wip: share this" {
+		t.Errorf("commit message %q, want %q", deps.commitMsg, "wip: share this")
+	}
+	if deps.capturedRef != "0123456789abcdef" {
+		t.Errorf("expected the new commit to be shared, got ref %q", deps.capturedRef)
+	}
+	if !strings.Contains(stderr.String(), "Created commit 0123456") {
+		t.Errorf("stderr missing new SHA\nGOT:\n%s", stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "--commit") {
+		t.Errorf("expected the --commit receive hint\nGOT:\n%s", stdout.String())
+	}
+}
+
+func TestSendAsCommit(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		opts    SendOptions
+		wantErr string
+	}{
+		{name: "working tree", opts: SendOptions{Message: "wip: share this"}},
+		{name: "staged", opts: SendOptions{Staged: true, Message: "wip"}},
+		{name: "missing message", wantErr: "requires a commit message"},
+		{name: "with ref", args: []string{"HEAD"}, opts: SendOptions{Message: "m"}, wantErr: "only applies to working tree changes"},
+		{name: "with commit-first", opts: SendOptions{CommitFirst: true, Message: "m"}, wantErr: "only applies to working tree changes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			stdout := &bytes.Buffer{}
+			deps := &mockSendDeps{patch: []byte("diff --git a/x b/x\n"), code: "main-a-b-c-d", codeID: "main", relay: relay, author: "Ada Lovelace <ada@example.com>"}
+			tt.opts.AsCommit, tt.opts.TTL = true, "1h"
+			_, err := runSendWithDeps(stdout, &bytes.Buffer{}, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if deps.commitMsg != "" {
+					t.Errorf("committed %q despite the error", deps.commitMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			plaintext, err := payload.DecodeData(relay["main"])
+			if err != nil {
+				t.Fatalf("decoding upload: %v", err)
+			}
+			_, patch, err := payload.Decode(plaintext)
+			if err != nil {
+				t.Fatalf("decoding payload: %v", err)
+			}
+			info, err := git.ParseMbox(patch)
+			if err != nil || info.Author != "Ada Lovelace <ada@example.com>" || info.Message != tt.opts.Message {
+				t.Errorf("ParseMbox = %+v, %v; want the author and message", info, err)
+			}
+			if !strings.Contains(stdout.String(), "--commit") {
+				t.Errorf("expected the --commit receive hint\nGOT:\n%s", stdout.String())
+			}
+			if deps.commitMsg != "" {
+				t.Errorf("--as-commit should not commit locally, committed %q", deps.commitMsg)
+			}
+		})
+	}
+}
+
+func TestSendCommitFirstErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		opts    SendOptions
+		err     error
+		wantErr string
+	}{
+		{name: "clean tree", opts: SendOptions{CommitFirst: true, Message: "m"}, err: errors.New("nothing to commit, working tree clean"), wantErr: "nothing to commit"},
+		{name: "missing message", opts: SendOptions{CommitFirst: true}, wantErr: "requires a commit message"},
+		{name: "with ref", args: []string{"HEAD"}, opts: SendOptions{CommitFirst: true, Message: "m"}, wantErr: "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockSendDeps{commitErr: tt.err}
+			tt.opts.TTL = "1h"
+			_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, tt.args, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSendAllowEmpty(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       SendOptions
+		err        error
+		wantErr    bool
+		wantNotice bool
+	}{
+		{name: "default errors", err: git.ErrNoChanges, wantErr: true},
+		{name: "allow empty", opts: SendOptions{AllowEmpty: true}, err: git.ErrNoChanges, wantNotice: true},
+		{name: "allow empty commit-first", opts: SendOptions{AllowEmpty: true, CommitFirst: true, Message: "m"}, wantNotice: true},
+		{name: "other errors still fail", opts: SendOptions{AllowEmpty: true}, err: errors.New("getting diff: broken"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			deps := &mockSendDeps{err: tt.err, commitErr: git.ErrNoChanges}
+			tt.opts.TTL = "1h"
+			_, err := runSendWithDeps(stdout, stderr, deps, nil, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := strings.Contains(stderr.String(), "Nothing to share"); got != tt.wantNotice {
+				t.Errorf("notice = %v, want %v\nGOT:\n%s", got, tt.wantNotice, stderr.String())
+			}
+			if stdout.Len() != 0 {
+				t.Errorf("nothing should be printed to stdout, got %q", stdout.String())
+			}
+		})
+	}
+}
+
+func TestSendAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		opts    SendOptions
+		wantErr string
+	}{
+		{name: "diff from HEAD", opts: SendOptions{All: true}},
+		{name: "with a commit", args: []string{"abc123"}, opts: SendOptions{All: true}, wantErr: "cannot be combined"},
+		{name: "with staged", opts: SendOptions{All: true, Staged: true}, wantErr: "cannot be combined"},
+		{name: "with commit-first", opts: SendOptions{All: true, CommitFirst: true, Message: "m"}, wantErr: "cannot be combined"},
+		{name: "with upstream", opts: SendOptions{All: true, Upstream: true}, wantErr: "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockSendDeps{patch: []byte("diff --git a/x b/x\n"), code: "abc-123"}
+			tt.opts.TTL = "1h"
+			_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.capturedRef != "HEAD" {
+				t.Errorf("patch was not collected with GetDiffFromHead (ref %q)", deps.capturedRef)
+			}
+		})
+	}
+}
+
+func TestSendPatchFile(t *testing.T) {
+	const diff = "diff --git a/x.txt b/x.txt\n--- a/x.txt\n+++ b/x.txt\n@@ -1 +1 @@\n-old\n+new\n"
+	const mbox = "From 1234567890abcdef Mon Sep 17 00:00:00 2001\nSubject: [PATCH] Fix x\n\n---\n" + diff
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		opts       SendOptions
+		wantCommit bool
+		wantErr    string
+	}{
+		{name: "diff", path: write("fix.diff", diff)},
+		{name: "format-patch mail", path: write("0001-fix.patch", mbox), wantCommit: true},
+		{name: "leading blank lines", path: write("blank.patch", "\n\n"+diff)},
+		{name: "not a patch", path: write("notes.txt", "just some notes\n"), wantErr: "does not look like a patch"},
+		{name: "empty", path: write("empty.patch", ""), wantErr: "is empty"},
+		{name: "missing", path: filepath.Join(dir, "missing.patch"), wantErr: "reading patch file"},
+		{name: "with staged", path: write("staged.diff", diff), opts: SendOptions{Staged: true}, wantErr: "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			stdout := &bytes.Buffer{}
+			deps := &mockSendDeps{code: "main-a-b-c-d", codeID: "main", relay: relay}
+			tt.opts.PatchFile, tt.opts.TTL = tt.path, "1h"
+			_, err := runSendWithDeps(stdout, &bytes.Buffer{}, deps, nil, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := strings.Contains(stdout.String(), "--commit"); got != tt.wantCommit {
+				t.Errorf("suggests --commit = %v, want %v\nGOT:\n%s", got, tt.wantCommit, stdout.String())
+			}
+
+			// The receiver applies the file's contents unchanged
+			want, _ := os.ReadFile(tt.path)
+			recv := &mockReceiveDeps{relay: relay}
+			if err := runReceiveWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, recv, []string{deps.code}, ReceiveOptions{}); err != nil {
+				t.Fatalf("receive failed: %v", err)
+			}
+			if !bytes.Equal(recv.applied, want) {
+				t.Errorf("applied %q, want %q", recv.applied, want)
+			}
+		})
+	}
+}
+
+func TestSendLang(t *testing.T) {
+	deps := &mockSendDeps{patch: []byte("diff"), code: "abc-123"}
+	if _, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, nil, SendOptions{TTL: "1h", Lang: "fr"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deps.lang != "fr" {
+		t.Errorf("GenerateCode got language %q, want fr", deps.lang)
+	}
+
+	_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockSendDeps{patch: []byte("diff")}, nil, SendOptions{TTL: "1h", Lang: "xx"})
+	if err == nil || !strings.Contains(err.Error(), "no built-in wordlist") {
+		t.Errorf("expected an unknown language error, got %v", err)
+	}
+}
+
+func TestSendKeepAlive(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       string
+		available int
+		statusErr error
+		wantErr   string
+		wantNote  string
+		wantPause time.Duration
+	}{
+		{name: "extends until received", ttl: "1h", available: 3, statusErr: &client.GoneError{Reason: "consumed"}, wantNote: "The patch was received.", wantPause: keepAliveInterval},
+		{name: "short TTL extends sooner", ttl: "20s", available: 1, statusErr: &client.GoneError{Reason: "consumed"}, wantNote: "The patch was received.", wantPause: 10 * time.Second},
+		{name: "tiny TTL pauses a second", ttl: "1500ms", available: 1, statusErr: &client.GoneError{Reason: "consumed"}, wantNote: "The patch was received.", wantPause: time.Second},
+		{name: "forgotten by the relay", ttl: "1h", available: 2, statusErr: client.ErrNotFound, wantNote: "no longer on the relay", wantPause: keepAliveInterval},
+		{name: "expired anyway", ttl: "1h", available: 1, statusErr: &client.GoneError{Reason: "expired"}, wantErr: "expired before it was received", wantPause: keepAliveInterval},
+		{name: "relay error", ttl: "1h", statusErr: errors.New("server error: boom"), wantErr: "boom", wantPause: keepAliveInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+			deps := &mockSendDeps{patch: []byte("diff"), code: "abc-123", codeID: "abc", available: tt.available, statusErr: tt.statusErr}
+			_, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, nil, SendOptions{TTL: tt.ttl, KeepAlive: true})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// One extend per status check that found the patch
+			if len(deps.extends) != tt.available {
+				t.Errorf("extended %d times, want %d", len(deps.extends), tt.available)
+			}
+			ttl, _ := time.ParseDuration(tt.ttl)
+			for _, got := range deps.extends {
+				if got != int(ttl.Seconds()) {
+					t.Errorf("extended by %ds, want %ds", got, int(ttl.Seconds()))
+				}
+			}
+			if len(deps.slept) != tt.available+1 || deps.slept[0] != tt.wantPause {
+				t.Errorf("slept %v, want %d pauses of %s", deps.slept, tt.available+1, tt.wantPause)
+			}
+			if !strings.Contains(stderr.String(), tt.wantNote) {
+				t.Errorf("stderr missing %q\nGOT:\n%s", tt.wantNote, stderr.String())
+			}
+		})
+	}
+
+	_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, &mockSendDeps{patch: []byte("diff")}, nil, SendOptions{TTL: "1h", KeepAlive: true, SplitSize: "1MB"})
+	if err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Errorf("expected --split-size to be rejected, got %v", err)
+	}
+
+	deps := &mockSendDeps{patch: []byte("diff"), code: "abc-123", codeID: "abc"}
+	_, err = runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, nil, SendOptions{TTL: "500ms", KeepAlive: true})
+	if err == nil || !strings.Contains(err.Error(), "at least 1s") {
+		t.Errorf("expected a sub-second TTL to be rejected, got %v", err)
+	}
+	if len(deps.downloads) != 0 {
+		t.Error("the patch was uploaded despite the rejected TTL")
+	}
+}
+
+func TestSendShortURL(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	deps := &mockSendDeps{
+		patch:      []byte("diff"),
+		code:       "abc-alpha-bravo-charlie-delta",
+		codeID:     "abc",
+		passphrase: "alpha-bravo-charlie-delta",
+		shortURL:   "https://relay.example.com/s/Xy12Ab34",
+	}
+	if _, err := runSendWithDeps(stdout, stderr, deps, nil, SendOptions{TTL: "1h"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var link string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if strings.Contains(line, deps.shortURL) {
+			link = line
+		}
+	}
+	if link == "" {
+		t.Fatalf("stdout missing the short link\nGOT:\n%s", stdout.String())
+	}
+	if strings.Contains(link, "alpha") {
+		t.Errorf("the link line includes the passphrase: %q", link)
+	}
+	for _, want := range []string{"does not include the passphrase", "Passphrase: alpha-bravo-charlie-delta"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr missing %q\nGOT:\n%s", want, stderr.String())
+		}
+	}
+}
+
+func TestSendQR(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	deps := &mockSendDeps{patch: []byte("diff"), code: "abc-alpha-bravo-charlie-delta", codeID: "abc"}
+	if _, err := runSendWithDeps(stdout, stderr, deps, nil, SendOptions{TTL: "1h", QR: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, want := stdout.String(), "   git-share receive abc-alpha-bravo-charlie-delta\n"; got != want {
+		t.Errorf("stdout = %q, want only the receive command", got)
+	}
+	code, err := qr.Encode("git-share receive abc-alpha-bravo-charlie-delta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var drawn bytes.Buffer
+	code.Render(&drawn, false)
+	if !strings.Contains(stderr.String(), drawn.String()) {
+		t.Errorf("stderr missing the QR code of the receive command\nGOT:\n%s", stderr.String())
+	}
+}
+
+func TestSendSecretsToPublicRelay(t *testing.T) {
+	const leaky = "diff --git a/.env b/.env\n--- a/.env\n+++ b/.env\n@@ -0,0 +1 @@\n+API_KEY=\"sk_live_abcdefghijklmnop1234\"\n"
+	const clean = "diff --git a/x b/x\n--- a/x\n+++ b/x\n@@ -1 +1 @@\n-old\n+new\n"
+
+	tests := []struct {
+		name     string
+		patch    string
+		server   string
+		force    bool
+		wantErr  bool
+		wantNote string
+	}{
+		{name: "public relay refuses", patch: leaky, server: DefaultServer, wantErr: true, wantNote: "a public relay run by a third party"},
+		{name: "public relay with force", patch: leaky, server: DefaultServer + "/", force: true, wantNote: DefaultServer},
+		{name: "own relay warns", patch: leaky, server: "http://localhost:3141", wantNote: "Warning: this patch looks like it contains secrets"},
+		{name: "clean patch", patch: clean, server: DefaultServer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+			relay := map[string]string{}
+			deps := &mockSendDeps{patch: []byte(tt.patch), code: "abc-123", codeID: "abc", relay: relay}
+			_, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, nil, SendOptions{TTL: "1h", Server: tt.server, Force: tt.force})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && (!strings.Contains(err.Error(), "--force") || len(relay) != 0) {
+				t.Errorf("expected nothing uploaded and a --force hint, got %v with %d uploads", err, len(relay))
+			}
+			if tt.wantNote != "" && !strings.Contains(stderr.String(), tt.wantNote) {
+				t.Errorf("stderr missing %q\nGOT:\n%s", tt.wantNote, stderr.String())
+			}
+			if tt.patch == leaky && !strings.Contains(stderr.String(), ".env:1  Stripe secret key") {
+				t.Errorf("stderr does not list the finding\nGOT:\n%s", stderr.String())
+			}
+		})
+	}
+}
+
+func TestSendAdaptsToRelay(t *testing.T) {
+	large := strings.Repeat("x", 2*largePatchSize)
+	tests := []struct {
+		name       string
+		patch      string
+		caps       *client.Capabilities
+		keepAlive  bool
+		wantErr    string
+		wantNote   string
+		wantExtend bool
+	}{
+		{name: "keep-alive on a relay without extend", patch: "diff", caps: &client.Capabilities{Features: []string{client.FeatureSpaces}}, keepAlive: true, wantNote: "--keep-alive is ignored"},
+		{name: "keep-alive on a relay with extend", patch: "diff", caps: &client.Capabilities{Features: []string{client.FeatureExtend}}, keepAlive: true, wantExtend: true},
+		{name: "keep-alive on an older relay", patch: "diff", keepAlive: true, wantExtend: true},
+		{name: "over the relay's size limit", patch: large, caps: &client.Capabilities{MaxSize: largePatchSize}, wantErr: "send it in parts with --split-size 1.0MB"},
+		{name: "size limit unknown", patch: large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+			relay := map[string]string{}
+			deps := &mockSendDeps{
+				patch: []byte(tt.patch), code: "abc-123", codeID: "abc", relay: relay, caps: tt.caps,
+				available: 1, statusErr: &client.GoneError{Reason: "consumed"},
+			}
+			_, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, nil, SendOptions{TTL: "1h", KeepAlive: tt.keepAlive})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if len(relay) != 0 {
+					t.Error("a patch over the relay's limit should not be uploaded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(stderr.String(), tt.wantNote) {
+				t.Errorf("stderr missing %q\nGOT:\n%s", tt.wantNote, stderr.String())
+			}
+			if extended := len(deps.extends) > 0; extended != tt.wantExtend {
+				t.Errorf("extended = %v, want %v", extended, tt.wantExtend)
+			}
+		})
+	}
+}
+
+func TestSendSizeLimit(t *testing.T) {
+	patch := strings.Repeat("x", 300)
+	tests := []struct {
+		name    string
+		opts    SendOptions
+		limits  *client.Limits
+		caps    *client.Capabilities
+		wantErr string
+	}{
+		{name: "under the limit", limits: &client.Limits{MaxSize: 1024}},
+		{name: "over the limit", limits: &client.Limits{MaxSize: 100}, wantErr: "over the relay's limit of 100B; send it in parts with --split-size 100B, shrink it with --compress, or use a relay with a higher limit"},
+		{name: "over the limit compressed", opts: SendOptions{Compress: true, CompressLevel: 1}, limits: &client.Limits{MaxSize: 10},
+			wantErr: "send it in parts with --split-size 10B, or use a relay with a higher limit"},
+		{name: "split", opts: SendOptions{SplitSize: "100B"}, limits: &client.Limits{MaxSize: 200}},
+		{name: "split over the limit", opts: SendOptions{SplitSize: "250B"}, limits: &client.Limits{MaxSize: 200},
+			wantErr: "parts of 250B are over the relay's limit of 200B per upload; use --split-size 200B or less"},
+		{name: "split larger than the patch", opts: SendOptions{SplitSize: "1KB"}, limits: &client.Limits{MaxSize: 200},
+			wantErr: "parts of 400B are over the relay's limit"}, // the whole patch, base64-encoded
+		{name: "split under the limit", opts: SendOptions{SplitSize: "1KB"}, limits: &client.Limits{MaxSize: 1024}},
+		{name: "limit from capabilities", caps: &client.Capabilities{MaxSize: 100}, wantErr: "over the relay's limit of 100B"},
+		{name: "limit unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			deps := &mockSendDeps{patch: []byte(patch), code: "abc-123", codeID: "abc", relay: relay, limits: tt.limits, caps: tt.caps}
+			tt.opts.TTL = "1h"
+			_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, nil, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if len(relay) != 0 {
+					t.Error("a patch over the relay's limit should not be uploaded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSendInRelayParts(t *testing.T) {
+	patch := strings.Repeat("x", 3000)
+	tests := []struct {
+		name     string
+		limits   *client.Limits
+		caps     *client.Capabilities
+		wantPart int
+		wantErr  string
+	}{
+		{name: "fits one request", limits: &client.Limits{MaxSize: 8192, MaxUploadSize: 65536}},
+		{name: "sent in parts", limits: &client.Limits{MaxSize: 2048, MaxUploadSize: 65536}, wantPart: 1024},
+		{name: "limit from capabilities", caps: &client.Capabilities{MaxSize: 2048, MaxUploadSize: 65536}, wantPart: 1024},
+		{name: "over the limit for parts", limits: &client.Limits{MaxSize: 2048, MaxUploadSize: 3072}, wantErr: "over the relay's limit of 3.0KB"},
+		{name: "no uploads in parts", limits: &client.Limits{MaxSize: 2048}, wantErr: "over the relay's limit of 2.0KB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			deps := &mockSendDeps{patch: []byte(patch), code: "abc-123", codeID: "abc", relay: relay, limits: tt.limits, caps: tt.caps}
+			var stderr bytes.Buffer
+			_, err := runSendWithDeps(&bytes.Buffer{}, &stderr, deps, nil, SendOptions{TTL: "1h"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.partSize != tt.wantPart {
+				t.Errorf("part size = %d, want %d", deps.partSize, tt.wantPart)
+			}
+			if tt.wantPart > 0 && !strings.Contains(stderr.String(), "Uploading in 4 parts") {
+				t.Errorf("stderr = %q, want the number of parts", stderr.String())
+			}
+			if relay["abc"] == "" {
+				t.Error("the patch was not uploaded")
+			}
+		})
+	}
+}
+
+func TestSendAllowRefPattern(t *testing.T) {
+	const localBranches = `^[a-z0-9/-]+$`
+	tests := []struct {
+		name     string
+		pattern  string
+		args     []string
+		opts     SendOptions
+		upstream string // the branch's upstream, for --upstream
+		late     bool   // the ref is only known once git has been asked
+		wantErr  string
+	}{
+		{name: "allowed branch", pattern: localBranches, args: []string{"feature/login"}},
+		{name: "allowed range", pattern: localBranches, args: []string{"main..feature"}},
+		{name: "open range uses HEAD", pattern: `^(main|HEAD)$`, args: []string{"main.."}},
+		{name: "no ref to check", pattern: localBranches},
+		{name: "no pattern", args: []string{"origin/main"}},
+		{name: "remote ref", pattern: `^[a-z0-9-]+$`, args: []string{"origin/main"}, wantErr: `ref "origin/main" is not allowed`},
+		{name: "bad side of a range", pattern: `^main$`, args: []string{"main...HEAD~3"}, wantErr: `ref "HEAD~3" is not allowed`},
+		{name: "invalid pattern", pattern: `[`, args: []string{"main"}, wantErr: "invalid --allow-ref-pattern"},
+		{name: "allowed base", pattern: `^(main|HEAD)$`, opts: SendOptions{Base: "main"}},
+		{name: "remote base", pattern: `^[a-z0-9-]+$`, opts: SendOptions{Base: "origin/main"}, wantErr: `ref "origin/main" is not allowed`},
+		{name: "allowed upstream", pattern: `^(origin/main|HEAD)$`, opts: SendOptions{Upstream: true}, upstream: "origin/main"},
+		{name: "remote upstream", pattern: `^[a-z0-9-]+$`, opts: SendOptions{Upstream: true}, upstream: "origin/main", late: true, wantErr: `ref "origin/main" is not allowed`},
+		{name: "last commits", pattern: `^[a-z0-9-]+$`, opts: SendOptions{Last: 3}, wantErr: `ref "HEAD~3" is not allowed`},
+		{name: "whole ref must match", pattern: `main`, args: []string{"origin/main"}, wantErr: `ref "origin/main" is not allowed`},
+		{name: "allowed stash entry", pattern: `stash@\{[0-9]+\}`, opts: SendOptions{Stash: "stash@{2}"}},
+		{name: "stash entry", pattern: localBranches, opts: SendOptions{Stash: "stash@{2}"}, wantErr: `ref "stash@{2}" is not allowed`},
+		{name: "latest stash", pattern: localBranches, opts: SendOptions{Stash: LatestStash}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content"), code: "id-a-b", expiry: "2026-02-27T17:00:00Z", upstream: tt.upstream, commits: 10}
+			opts := tt.opts
+			opts.TTL, opts.AllowRefs = "1h", tt.pattern
+			_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, tt.args, opts)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if !tt.late && (deps.gitCalled || deps.capturedRef != "") {
+				t.Error("a rejected ref should be caught before any git call")
+			}
+			if deps.capturedRef != "" {
+				t.Errorf("a rejected ref was sent: %q", deps.capturedRef)
+			}
+		})
+	}
+}
+
+func TestSendWarnsLFSPointers(t *testing.T) {
+	patch := "diff --git a/logo.png b/logo.png\nnew file mode 100644\n--- /dev/null\n+++ b/logo.png\n@@ -0,0 +1,3 @@\n" +
+		"+version https://git-lfs.github.com/spec/v1\n+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n+size 12345\n"
+
+	for _, tt := range []struct {
+		name  string
+		patch string
+		warn  bool
+	}{
+		{name: "lfs pointer", patch: patch, warn: true},
+		{name: "plain diff", patch: "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+b\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte(tt.patch), code: "id-a-b", expiry: "2026-02-27T17:00:00Z"}
+			if _, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, nil, SendOptions{TTL: "1h"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			warned := strings.Contains(stderr.String(), "Git LFS pointers") && strings.Contains(stderr.String(), "   logo.png\n")
+			if warned != tt.warn {
+				t.Errorf("LFS warning shown = %v, want %v\nGOT:\n%s", warned, tt.warn, stderr.String())
+			}
+		})
+	}
+}
+
+func TestSendCheckApply(t *testing.T) {
+	// Default 3-line context around a change in the middle of a file
+	const full = "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -4,7 +4,7 @@\n 4\n 5\n 6\n-7\n+seven\n 8\n 9\n 10\n"
+	// Generated with -U0: nothing anchors the hunks
+	const sparse = "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -7 +7 @@\n-7\n+seven\n@@ -20 +20 @@\n-20\n+twenty\n"
+
+	tests := []struct {
+		name        string
+		patch       string
+		args        []string
+		opts        SendOptions
+		reverseErr  error
+		wantChecked string
+		wantOut     []string
+	}{
+		{name: "full context", patch: full, wantChecked: "tree", wantOut: []string{"Apply risk: low (1 hunks, all with full context)"}},
+		{name: "sparse context", patch: sparse, wantChecked: "tree", wantOut: []string{"Apply risk: high (2 of 2 hunks"}},
+		{name: "staged", patch: full, opts: SendOptions{Staged: true}, wantChecked: "index"},
+		{name: "tree mismatch", patch: full, reverseErr: errors.New("patch does not apply"), wantChecked: "tree", wantOut: []string{"does not match your working tree", "patch does not apply"}},
+		{name: "commit is not checked against the tree", patch: full, args: []string{"abc123"}, wantOut: []string{"Apply risk: low"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+			deps := &mockSendDeps{patch: []byte(tt.patch), code: "abc-123", reverseErr: tt.reverseErr}
+			tt.opts.TTL, tt.opts.CheckApply = "1h", true
+			if _, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, tt.args, tt.opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.checked != tt.wantChecked {
+				t.Errorf("reverse check against %q, want %q", deps.checked, tt.wantChecked)
+			}
+			for _, want := range tt.wantOut {
+				if !strings.Contains(stderr.String(), want) {
+					t.Errorf("stderr missing %q\nGOT:\n%s", want, stderr.String())
+				}
+			}
+		})
+	}
+}
+
+func TestSendReportsStoredSize(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	deps := &mockSendDeps{patch: []byte(strings.Repeat("x", 300)), code: "abc-123"}
+
+	if _, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, nil, SendOptions{TTL: "1h"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The mock encrypts as a no-op, so 300 bytes encode to 400 bytes of base64.
+	if !strings.Contains(stderr.String(), "Patch size: 300B | Stored on relay: 400B") {
+		t.Errorf("stderr missing size report\nGOT:\n%s", stderr.String())
+	}
+}
+
+func TestSendCustomPassphrase(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+		wantWarn   bool
+	}{
+		{"strong passphrase", "correct horse battery staple", false},
+		{"weak passphrase", "hunter2", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			relay := map[string]string{}
+			deps := &mockSendDeps{
+				repoRoot:   "/repo",
+				patch:      []byte("diff content"),
+				code:       "abc-generated-words",
+				codeID:     "abc",
+				passphrase: "generated-words",
+				relay:      relay,
+			}
+
+			_, err := runSendWithDeps(stdout, stderr, deps, nil, SendOptions{TTL: "1h", Passphrase: tt.passphrase})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.derivedFrom != tt.passphrase {
+				t.Errorf("key derived from %q, want %q", deps.derivedFrom, tt.passphrase)
+			}
+			if _, ok := relay["part1"]; !ok {
+				t.Errorf("expected upload under a fresh code ID, relay has %v", relay)
+			}
+			if !strings.Contains(stdout.String(), "git-share receive part1 --passphrase <passphrase>") {
+				t.Errorf("stdout should show only the code ID\nGOT:\n%s", stdout.String())
+			}
+			if strings.Contains(stdout.String(), tt.passphrase) || strings.Contains(stdout.String(), "generated-words") {
+				t.Errorf("stdout leaks a passphrase\nGOT:\n%s", stdout.String())
+			}
+			if got := strings.Contains(stderr.String(), "Warning: the passphrase is shorter"); got != tt.wantWarn {
+				t.Errorf("weak passphrase warning = %v, want %v\nGOT:\n%s", got, tt.wantWarn, stderr.String())
+			}
+		})
+	}
+}
+
+func TestSendCompressLevelRange(t *testing.T) {
+	for _, level := range []int{0, 10} {
+		deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content")}
+		_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, nil, SendOptions{TTL: "1h", Compress: true, CompressLevel: level})
+		if err == nil || !strings.Contains(err.Error(), "--compress-level must be between 1 and 9") {
+			t.Errorf("level %d: expected a range error, got %v", level, err)
+		}
+	}
+}
+
+func TestSendLargePatchConfirmation(t *testing.T) {
+	big := "diff --git a/big.txt b/big.txt\n--- a/big.txt\n+++ b/big.txt\n@@ -0,0 +1 @@\n" +
+		strings.Repeat("+data\n", largePatchSize/6+1) +
+		"diff --git a/small.txt b/small.txt\n--- a/small.txt\n+++ b/small.txt\n@@ -0,0 +1 @@\n+x\n"
+
+	tests := []struct {
+		name        string
+		opts        SendOptions
+		wantErr     bool
+		wantPrompt  bool
+		wantUpload  bool
+		wantWarning bool
+	}{
+		{name: "declined", opts: SendOptions{Interactive: true, Stdin: strings.NewReader("n\n")}, wantErr: true, wantPrompt: true},
+		{name: "accepted", opts: SendOptions{Interactive: true, Stdin: strings.NewReader("y\n")}, wantPrompt: true, wantUpload: true},
+		{name: "--yes skips the prompt", opts: SendOptions{Interactive: true, Yes: true, Stdin: strings.NewReader("")}, wantUpload: true},
+		{name: "not a terminal", opts: SendOptions{}, wantUpload: true, wantWarning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+			relay := map[string]string{}
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte(big), code: "abc-123", codeID: "abc", relay: relay}
+			tt.opts.TTL = "1h"
+
+			_, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, nil, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := strings.Contains(stderr.String(), "Send it anyway?"); got != tt.wantPrompt {
+				t.Errorf("prompted = %v, want %v\nGOT:\n%s", got, tt.wantPrompt, stderr.String())
+			}
+			if tt.wantPrompt && !strings.Contains(stderr.String(), "big.txt") {
+				t.Errorf("prompt should list the largest files\nGOT:\n%s", stderr.String())
+			}
+			if got := len(relay) > 0; got != tt.wantUpload {
+				t.Errorf("uploaded = %v, want %v", got, tt.wantUpload)
+			}
+			if got := strings.Contains(stderr.String(), "Warning: sending a large patch"); got != tt.wantWarning {
+				t.Errorf("warning = %v, want %v", got, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestLargestFiles(t *testing.T) {
+	patch := "From abc\nSubject: x\n\n---\n" +
+		"diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n+a\n" +
+		"diff --git a/b.txt b/b.txt\n--- a/b.txt\n+++ b/b.txt\n@@ -1 +1,3 @@\n+b\n+b\n+b\n" +
+		"diff --git a/c.txt b/c.txt\n--- a/c.txt\n+++ b/c.txt\n@@ -1 +1,2 @@\n+c\n+c\n"
+
+	var got []string
+	for _, f := range largestFiles([]byte(patch), 2) {
+		got = append(got, f.path)
+	}
+	if strings.Join(got, ",") != "b.txt,c.txt" {
+		t.Errorf("largestFiles() = %v, want [b.txt c.txt]", got)
+	}
+}
+
+func TestSendPrintsSpace(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content"), code: "abc-123"}
+	if _, err := runSendWithDeps(stdout, &bytes.Buffer{}, deps, nil, SendOptions{TTL: "1h", Space: "team-a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "git-share receive abc-123 --space team-a") {
+		t.Errorf("receive command should carry the space\nGOT:\n%s", stdout.String())
+	}
+}
+
+func TestSendUpstream(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		opts     SendOptions
+		wantRef  string
+		wantErr  string
+	}{
+		{name: "upstream", upstream: "origin/main", opts: SendOptions{Upstream: true}, wantRef: "origin/main..HEAD"},
+		{name: "upstream wins over base", upstream: "origin/main", opts: SendOptions{Upstream: true, Base: "dev"}, wantRef: "origin/main..HEAD"},
+		{name: "base fallback", opts: SendOptions{Upstream: true, Base: "dev"}, wantRef: "dev..HEAD"},
+		{name: "base alone", upstream: "origin/main", opts: SendOptions{Base: "v1.0"}, wantRef: "v1.0..HEAD"},
+		{name: "no upstream", opts: SendOptions{Upstream: true}, wantErr: "pass --base <ref>"},
+		{name: "with staged", opts: SendOptions{Upstream: true, Staged: true}, wantErr: "cannot be combined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("From abc\n"), code: "abc-123", upstream: tt.upstream}
+			tt.opts.TTL = "1h"
+
+			_, err := runSendWithDeps(stdout, &bytes.Buffer{}, deps, nil, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.capturedRef != tt.wantRef {
+				t.Errorf("sent %q, want %q", deps.capturedRef, tt.wantRef)
+			}
+			if !strings.Contains(stdout.String(), "--commit") {
+				t.Errorf("expected the --commit receive hint\nGOT:\n%s", stdout.String())
+			}
+		})
+	}
+}
+
+func TestSendShow(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		opts    SendOptions
+		wantRef string
+		wantErr string
+	}{
+		{name: "defaults to HEAD", wantRef: "show HEAD"},
+		{name: "given commit", args: []string{"abc123"}, wantRef: "show abc123"},
+		{name: "range", args: []string{"HEAD~3.."}, wantErr: "not a range"},
+		{name: "with staged", opts: SendOptions{Staged: true}, wantErr: "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			stdout := &bytes.Buffer{}
+			deps := &mockSendDeps{patch: []byte("commit abc\n\n    Fix it\n\ndiff --git a/x b/x\n"), code: "main-a-b-c-d", codeID: "main", relay: relay}
+			tt.opts.Show, tt.opts.TTL = true, "1h"
+			_, err := runSendWithDeps(stdout, &bytes.Buffer{}, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.capturedRef != tt.wantRef {
+				t.Errorf("captured ref %q, want %q", deps.capturedRef, tt.wantRef)
+			}
+			plaintext, err := payload.DecodeData(relay["main"])
+			if err != nil {
+				t.Fatalf("decoding upload: %v", err)
+			}
+			header, _, err := payload.Decode(plaintext)
+			if err != nil || header.Format != payload.FormatShow {
+				t.Errorf("header = %+v, %v; want format %q", header, err, payload.FormatShow)
+			}
+			if strings.Contains(stdout.String(), "--commit") {
+				t.Errorf("a --show patch should not suggest --commit\nGOT:\n%s", stdout.String())
+			}
+		})
+	}
+}
+
+func TestSendTextconv(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    SendOptions
+		args    []string
+		wantRef string
+		wantErr string
+	}{
+		{name: "working tree", wantRef: "textconv staged=false all=false"},
+		{name: "staged", opts: SendOptions{Staged: true}, wantRef: "textconv staged=true all=false"},
+		{name: "all", opts: SendOptions{All: true}, wantRef: "textconv staged=false all=true"},
+		{name: "commit", args: []string{"HEAD~1.."}, wantErr: "only applies to working tree changes"},
+		{name: "as commit", opts: SendOptions{AsCommit: true, Message: "wip"}, wantErr: "only applies to working tree changes"},
+		{name: "check apply", opts: SendOptions{CheckApply: true}, wantErr: "can't be applied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			deps := &mockSendDeps{patch: []byte("diff --git a/doc.up b/doc.up\n-OLD\n+NEW\n"), code: "main-a-b-c-d", codeID: "main", relay: relay}
+			tt.opts.Textconv, tt.opts.TTL = true, "1h"
+			stderr := &bytes.Buffer{}
+			_, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.capturedRef != tt.wantRef {
+				t.Errorf("captured ref %q, want %q", deps.capturedRef, tt.wantRef)
+			}
+			if !strings.Contains(stderr.String(), "not apply it") {
+				t.Errorf("no warning that the patch can't be applied\nGOT:\n%s", stderr.String())
+			}
+			plaintext, _ := payload.DecodeData(relay["main"])
+			if header, _, err := payload.Decode(plaintext); err != nil || header.Format != payload.FormatTextconv {
+				t.Errorf("header = %+v, %v; want format %q", header, err, payload.FormatTextconv)
+			}
+		})
+	}
+}
+
+func TestSendIncludeUntracked(t *testing.T) {
+	const tracked = "diff --git a/a.txt b/a.txt\n"
+	const untracked = "diff --git a/new.txt b/new.txt\nnew file mode 100644\n"
+	tests := []struct {
+		name      string
+		opts      SendOptions
+		args      []string
+		patch     string
+		diffErr   error
+		untracked string
+		want      string
+		wantErr   string
+	}{
+		{name: "appended to the working tree diff", patch: tracked, untracked: untracked, want: tracked + untracked},
+		{name: "with --all", opts: SendOptions{All: true}, patch: tracked, untracked: untracked, want: tracked + untracked},
+		{name: "only untracked files", diffErr: git.ErrNoChanges, untracked: untracked, want: untracked},
+		{name: "nothing at all", diffErr: git.ErrNoChanges, wantErr: "no changes"},
+		{name: "with --staged", opts: SendOptions{Staged: true}, wantErr: "--include-untracked only applies"},
+		{name: "with a commit", args: []string{"HEAD~1"}, wantErr: "--include-untracked only applies"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte(tt.patch), err: tt.diffErr, untracked: []byte(tt.untracked), code: "main-a-b", codeID: "main", relay: relay}
+			tt.opts.TTL = "1h"
+			tt.opts.Untracked = true
+
+			_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			sent, err := payload.DecodeData(relay["main"])
+			if err != nil {
+				t.Fatalf("decoding upload: %v", err)
+			}
+			if string(sent) != tt.want {
+				t.Errorf("sent %q, want %q", sent, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    SendOptions
+		args    []string
+		wantErr string
+	}{
+		{name: "working tree", opts: SendOptions{Paths: []string{"src/foo.go", "src/bar.go"}}},
+		{name: "staged", opts: SendOptions{Staged: true, Paths: []string{"src/foo.go", "src/bar.go"}}},
+		{name: "with a commit", opts: SendOptions{Paths: []string{"src/foo.go"}}, args: []string{"HEAD"}, wantErr: "--path only applies"},
+		{name: "with --all", opts: SendOptions{All: true, Paths: []string{"src/foo.go"}}, wantErr: "--path only applies"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content"), code: "id-a-b", expiry: "2026-02-27T17:00:00Z"}
+			tt.opts.TTL = "1h"
+			_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(deps.paths, tt.opts.Paths) {
+				t.Errorf("pathspecs = %v, want %v", deps.paths, tt.opts.Paths)
+			}
+		})
+	}
+}
+
+func TestSendStash(t *testing.T) {
+	tests := []struct {
+		name    string
+		stash   string
+		args    []string
+		opts    SendOptions
+		wantRef string
+		wantErr string
+	}{
+		{name: "latest", stash: LatestStash, wantRef: "stash " + LatestStash},
+		{name: "named with =", stash: "stash@{2}", wantRef: "stash stash@{2}"},
+		{name: "named as an argument", stash: LatestStash, args: []string{"stash@{2}"}, wantRef: "stash stash@{2}"},
+		{name: "by number", stash: "3", wantRef: "stash stash@{3}"},
+		{name: "with a commit", stash: "stash@{1}", args: []string{"HEAD"}, wantErr: "--stash cannot be combined"},
+		{name: "with --staged", stash: LatestStash, opts: SendOptions{Staged: true}, wantErr: "--stash cannot be combined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content"), code: "id-a-b", expiry: "2026-02-27T17:00:00Z"}
+			tt.opts.TTL = "1h"
+			tt.opts.Stash = tt.stash
+			_, err := runSendWithDeps(&bytes.Buffer{}, &bytes.Buffer{}, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.capturedRef != tt.wantRef {
+				t.Errorf("sent %q, want %q", deps.capturedRef, tt.wantRef)
+			}
+		})
+	}
+}
+
+func TestSendLast(t *testing.T) {
+	tests := []struct {
+		name    string
+		last    int
+		commits int
+		args    []string
+		opts    SendOptions
+		wantRef string
+		wantErr string
+	}{
+		{name: "one", last: 1, commits: 5, wantRef: "HEAD~1..HEAD"},
+		{name: "three", last: 3, commits: 5, wantRef: "HEAD~3..HEAD"},
+		{name: "all but the first", last: 4, commits: 5, wantRef: "HEAD~4..HEAD"},
+		{name: "too many", last: 5, commits: 5, wantErr: "--last 5 needs more than 5 commits, but HEAD has 5"},
+		{name: "negative", last: -2, commits: 5, wantErr: "positive number"},
+		{name: "with a commit", last: 2, commits: 5, args: []string{"HEAD"}, wantErr: "--last cannot be combined"},
+		{name: "with --upstream", last: 2, commits: 5, opts: SendOptions{Upstream: true}, wantErr: "cannot be combined"},
+		{name: "with --stash", last: 2, commits: 5, opts: SendOptions{Stash: LatestStash}, wantErr: "--stash cannot be combined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content"), code: "id-a-b", expiry: "2026-02-27T17:00:00Z", commits: tt.commits}
+			tt.opts.TTL = "1h"
+			tt.opts.Last = tt.last
+			var stderr bytes.Buffer
+			_, err := runSendWithDeps(&bytes.Buffer{}, &stderr, deps, tt.args, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.capturedRef != tt.wantRef {
+				t.Errorf("sent %q, want %q", deps.capturedRef, tt.wantRef)
+			}
+			if !strings.Contains(stderr.String(), "receive as a commit") {
+				t.Errorf("expected the --commit receive hint, got:\n%s", stderr.String())
+			}
+		})
+	}
+}
+
+func TestSendMaxDownloads(t *testing.T) {
+	supported := &client.Capabilities{Features: []string{client.FeatureMaxDownloads}}
+	tests := []struct {
+		name       string
+		opts       SendOptions
+		caps       *client.Capabilities
+		want       int // maxDownloads of every upload
+		wantStderr string
+		wantErr    string
+	}{
+		{name: "default", want: 1, wantStderr: "One-time use only"},
+		{name: "three", opts: SendOptions{Downloads: 3}, caps: supported, want: 3, wantStderr: "Can be received 3 times"},
+		{name: "split", opts: SendOptions{Downloads: 2, SplitSize: "4B"}, caps: supported, want: 2},
+		{name: "relay without the feature", opts: SendOptions{Downloads: 3}, caps: &client.Capabilities{}, wantErr: "doesn't support --max-downloads"},
+		{name: "relay without capabilities", opts: SendOptions{Downloads: 3}, wantErr: "doesn't support --max-downloads"},
+		{name: "negative", opts: SendOptions{Downloads: -1}, wantErr: "must be at least 1"},
+		{name: "saved", opts: SendOptions{Downloads: 2, SaveTo: "fix.gsb"}, wantErr: "downloaded more than once"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content"), code: "id-a-b", expiry: "2026-02-27T17:00:00Z", caps: tt.caps}
+			tt.opts.TTL = "1h"
+			stderr := &bytes.Buffer{}
+			_, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, nil, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				if len(deps.downloads) > 0 {
+					t.Errorf("uploaded despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(deps.downloads) == 0 {
+				t.Fatal("nothing was uploaded")
+			}
+			for _, n := range deps.downloads {
+				if n != tt.want {
+					t.Errorf("max downloads sent = %v, want %d for every upload", deps.downloads, tt.want)
+					break
+				}
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestSendRecordsLedger(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        SendOptions
+		expiry      string
+		recordErr   error
+		wantRecords int
+		wantExpires time.Duration // from now, when the relay gives no expiry
+		wantStderr  string
+	}{
+		{name: "relay expiry", opts: SendOptions{Space: "team"}, expiry: "2026-02-27T17:00:00Z", wantRecords: 1},
+		{name: "no relay expiry", wantExpires: time.Hour, wantRecords: 1},
+		{name: "record fails", wantExpires: time.Hour, recordErr: errors.New("disk full"), wantRecords: 1, wantStderr: "Warning: can't record the code"},
+		{name: "saved blob", opts: SendOptions{SaveTo: "fix.gsb"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content"), code: "id-a-b", codeID: "id", expiry: tt.expiry, recordErr: tt.recordErr}
+			tt.opts.TTL = "1h"
+			tt.opts.Server = "https://relay.example.com"
+			stderr := &bytes.Buffer{}
+			if _, err := runSendWithDeps(&bytes.Buffer{}, stderr, deps, nil, tt.opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(deps.recorded) != tt.wantRecords {
+				t.Fatalf("recorded %d entries, want %d", len(deps.recorded), tt.wantRecords)
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.wantStderr)
+			}
+			if tt.wantRecords == 0 {
+				return
+			}
+			e := deps.recorded[0]
+			if e.Code != "id-a-b" || e.CodeID != "id" || e.Space != tt.opts.Space || e.Server != tt.opts.Server {
+				t.Errorf("recorded %+v", e)
+			}
+			if tt.expiry != "" {
+				if want, _ := time.Parse(time.RFC3339, tt.expiry); !e.Expires.Equal(want) {
+					t.Errorf("expires = %v, want the relay's %v", e.Expires, want)
+				}
+			} else if got := e.Expires.Sub(e.Created); got != tt.wantExpires {
+				t.Errorf("expires %v after creation, want %v", got, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func TestSendJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		opts    SendOptions
+		err     error
+		want    sendSummary
+		wantErr bool
+	}{
+		{
+			name: "working tree",
+			want: sendSummary{Code: "id-a-b", CodeID: "id", Expiry: "2026-02-27T17:00:00Z", Bytes: 12},
+		},
+		{
+			name: "commit",
+			args: []string{"HEAD"},
+			want: sendSummary{Code: "id-a-b", CodeID: "id", Expiry: "2026-02-27T17:00:00Z", Bytes: 12, IsCommit: true},
+		},
+		{
+			name:    "failure",
+			err:     errors.New("git diff failed"),
+			want:    sendSummary{Error: "git diff failed"},
+			wantErr: true,
+		},
+		{
+			name:    "with --keep-alive",
+			opts:    SendOptions{KeepAlive: true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := &mockSendDeps{repoRoot: "/repo", patch: []byte("diff content"), err: tt.err, code: "id-a-b", codeID: "id", expiry: "2026-02-27T17:00:00Z"}
+			tt.opts.TTL = "1h"
+			tt.opts.JSON = true
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			_, err := runSendWithDeps(stdout, stderr, deps, tt.args, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, tt.wantErr)
+			}
+			if stderr.Len() != 0 {
+				t.Errorf("--json should suppress other output, stderr:\n%s", stderr.String())
+			}
+			if tt.opts.KeepAlive {
+				if stdout.Len() != 0 {
+					t.Errorf("a rejected --json send printed %q", stdout.String())
+				}
+				return
+			}
+
+			var got sendSummary
+			dec := json.NewDecoder(stdout)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&got); err != nil {
+				t.Fatalf("stdout is not a single JSON summary: %v\nGOT:\n%s", err, stdout.String())
+			}
+			if dec.More() {
+				t.Errorf("unexpected output after the JSON summary:\n%s", stdout.String())
+			}
+			if got != tt.want {
+				t.Errorf("summary = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
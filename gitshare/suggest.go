@@ -0,0 +1,119 @@
+package gitshare
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flawiddsouza/git-share/internal/git"
+)
+
+// formatGitHubSuggestion is the receive --format that prints a patch as
+// GitHub pull request suggestions.
+const formatGitHubSuggestion = "github-suggestion"
+
+// Limits for patches that still make sense as review suggestions.
+const (
+	maxSuggestionHunks = 5
+	maxSuggestionLines = 30
+)
+
+// githubSuggestions renders a single-file patch as markdown ```suggestion
+// blocks, one per hunk, to paste into a pull request review. It returns an
+// error for patches that don't fit the format.
+func githubSuggestions(patch []byte) (string, error) {
+	files, err := git.ParseHunks(patch)
+	if err != nil {
+		return "", err
+	}
+	if len(files) != 1 {
+		return "", fmt.Errorf("suggestions cover a single file, and this patch changes %d", len(files))
+	}
+	file := files[0]
+	switch {
+	case file.Binary:
+		return "", fmt.Errorf("%s is a binary file", file.NewPath)
+	case file.OldPath == "" || file.NewPath == "":
+		return "", fmt.Errorf("suggestions can't add or delete files")
+	case file.OldPath != file.NewPath:
+		return "", fmt.Errorf("suggestions can't rename files")
+	case len(file.Hunks) == 0:
+		return "", fmt.Errorf("the patch has no line changes")
+	case len(file.Hunks) > maxSuggestionHunks:
+		return "", fmt.Errorf("the patch has %d hunks, more than %d", len(file.Hunks), maxSuggestionHunks)
+	}
+
+	var b strings.Builder
+	for i, hunk := range file.Hunks {
+		first, count, lines, err := suggestHunk(hunk)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if count == 1 {
+			fmt.Fprintf(&b, "%s line %d:\n", file.NewPath, first)
+		} else {
+			fmt.Fprintf(&b, "%s lines %d-%d:\n", file.NewPath, first, first+count-1)
+		}
+		b.WriteString("```suggestion\n")
+		for _, line := range lines {
+			b.WriteString(line + "\n")
+		}
+		b.WriteString("```\n")
+	}
+	return b.String(), nil
+}
+
+// suggestHunk returns the old lines a hunk replaces, as a first line number
+// and count, along with the lines that replace them. Surrounding context is
+// trimmed; a hunk that only adds lines is anchored to a neighboring line,
+// since a suggestion must replace at least one.
+func suggestHunk(h git.Hunk) (first, count int, lines []string, err error) {
+	start, end := -1, -1
+	for i, line := range h.Lines {
+		if line[0] != ' ' {
+			if start < 0 {
+				start = i
+			}
+			end = i
+		}
+	}
+	if start < 0 {
+		return 0, 0, nil, fmt.Errorf("hunk at line %d has no changes", h.OldStart)
+	}
+
+	removes := false
+	for _, line := range h.Lines[start : end+1] {
+		removes = removes || line[0] == '-'
+	}
+	if !removes {
+		switch {
+		case start > 0:
+			start--
+		case end < len(h.Lines)-1:
+			end++
+		default:
+			return 0, 0, nil, fmt.Errorf("hunk at line %d has no surrounding line to attach a suggestion to", h.OldStart)
+		}
+	}
+
+	first = h.OldStart
+	for _, line := range h.Lines[:start] {
+		if line[0] != '+' {
+			first++
+		}
+	}
+	for _, line := range h.Lines[start : end+1] {
+		if line[0] != '+' {
+			count++
+		}
+		if line[0] != '-' {
+			lines = append(lines, line[1:])
+		}
+	}
+	if count > maxSuggestionLines || len(lines) > maxSuggestionLines {
+		return 0, 0, nil, fmt.Errorf("hunk at line %d is longer than %d lines", h.OldStart, maxSuggestionLines)
+	}
+	return first, count, lines, nil
+}
@@ -0,0 +1,135 @@
+package gitshare
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGitHubSuggestions(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "single line change",
+			patch: "diff --git a/main.go b/main.go\n" +
+				"--- a/main.go\n" +
+				"+++ b/main.go\n" +
+				"@@ -10,3 +10,3 @@\n" +
+				" \ta := 1\n" +
+				"-\tb := 2\n" +
+				"+\tb := 3\n" +
+				" \tc := 4\n",
+			want: "main.go line 11:\n```suggestion\n\tb := 3\n```\n",
+		},
+		{
+			name: "replace two lines with three",
+			patch: "diff --git a/x.txt b/x.txt\n" +
+				"--- a/x.txt\n" +
+				"+++ b/x.txt\n" +
+				"@@ -1,3 +1,4 @@\n" +
+				" keep\n" +
+				"-old 1\n" +
+				"-old 2\n" +
+				"+new 1\n" +
+				"+new 2\n" +
+				"+new 3\n",
+			want: "x.txt lines 2-3:\n```suggestion\nnew 1\nnew 2\nnew 3\n```\n",
+		},
+		{
+			name: "addition anchored to the line before",
+			patch: "diff --git a/x.txt b/x.txt\n" +
+				"--- a/x.txt\n" +
+				"+++ b/x.txt\n" +
+				"@@ -4,2 +4,3 @@\n" +
+				" before\n" +
+				"+added\n" +
+				" after\n",
+			want: "x.txt line 4:\n```suggestion\nbefore\nadded\n```\n",
+		},
+		{
+			name: "deletion",
+			patch: "diff --git a/x.txt b/x.txt\n" +
+				"--- a/x.txt\n" +
+				"+++ b/x.txt\n" +
+				"@@ -7,2 +7 @@\n" +
+				" keep\n" +
+				"-gone\n",
+			want: "x.txt line 8:\n```suggestion\n```\n",
+		},
+		{
+			name: "two files",
+			patch: "diff --git a/a b/a\n--- a/a\n+++ b/a\n@@ -1 +1 @@\n-x\n+y\n" +
+				"diff --git a/b b/b\n--- a/b\n+++ b/b\n@@ -1 +1 @@\n-x\n+y\n",
+			wantErr: "single file",
+		},
+		{
+			name:    "new file",
+			patch:   "diff --git a/a b/a\nnew file mode 100644\n--- /dev/null\n+++ b/a\n@@ -0,0 +1 @@\n+x\n",
+			wantErr: "add or delete files",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := githubSuggestions([]byte(tt.patch))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("githubSuggestions() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceiveGitHubSuggestion(t *testing.T) {
+	tests := []struct {
+		name       string
+		patch      string
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "small patch",
+			patch:      "diff --git a/x.txt b/x.txt\n--- a/x.txt\n+++ b/x.txt\n@@ -1 +1 @@\n-old\n+new\n",
+			wantStdout: "```suggestion\nnew\n```",
+		},
+		{
+			name:       "falls back to the patch",
+			patch:      "diff --git a/x b/x\nGIT binary patch\nliteral 0\n",
+			wantStdout: "GIT binary patch",
+			wantStderr: "can't be shown as a suggestion",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := map[string]string{}
+			code := sendToRelay(t, relay, tt.patch, SendOptions{})
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			deps := &mockReceiveDeps{relay: relay, noRepo: true}
+			err := runReceiveWithDeps(stdout, stderr, deps, []string{code}, ReceiveOptions{Format: formatGitHubSuggestion})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deps.applied != nil {
+				t.Error("--format should not apply the patch")
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("stdout missing %q\nGOT:\n%s", tt.wantStdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr missing %q\nGOT:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
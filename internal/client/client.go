@@ -1,111 +1,720 @@
-package client
-
-import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"time"
-)
-
-// Client is an HTTP client for the git-share relay server.
-type Client struct {
-	baseURL    string
-	httpClient *http.Client
-}
-
-// SendRequest matches the server's expected JSON body.
-type SendRequest struct {
-	CodeID string `json:"code_id"`
-	Data   string `json:"data"`
-	TTL    int    `json:"ttl"`
-}
-
-// SendResponse matches the server's JSON response.
-type SendResponse struct {
-	OK     bool   `json:"ok"`
-	Expiry string `json:"expiry,omitempty"`
-	Error  string `json:"error,omitempty"`
-}
-
-// ReceiveResponse matches the server's JSON response.
-type ReceiveResponse struct {
-	OK    bool   `json:"ok"`
-	Data  string `json:"data,omitempty"`
-	Error string `json:"error,omitempty"`
-}
-
-// New creates a new relay client.
-func New(baseURL string) *Client {
-	return &Client{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-	}
-}
-
-// Send uploads an encrypted blob to the relay server.
-func (c *Client) Send(codeID string, data string, ttlSeconds int) (*SendResponse, error) {
-	reqBody := SendRequest{
-		CodeID: codeID,
-		Data:   data,
-		TTL:    ttlSeconds,
-	}
-
-	body, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("marshaling request: %w", err)
-	}
-
-	resp, err := c.httpClient.Post(c.baseURL+"/api/send", "application/json", bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
-	}
-
-	var sendResp SendResponse
-	if err := json.Unmarshal(respBody, &sendResp); err != nil {
-		return nil, fmt.Errorf("parsing response: %w", err)
-	}
-
-	if !sendResp.OK {
-		return nil, fmt.Errorf("server error: %s", sendResp.Error)
-	}
-
-	return &sendResp, nil
-}
-
-// Receive downloads and consumes an encrypted blob from the relay server.
-func (c *Client) Receive(codeID string) (string, error) {
-	resp, err := c.httpClient.Get(c.baseURL + "/api/receive/" + codeID)
-	if err != nil {
-		return "", fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("reading response: %w", err)
-	}
-
-	var recvResp ReceiveResponse
-	if err := json.Unmarshal(respBody, &recvResp); err != nil {
-		return "", fmt.Errorf("parsing response: %w", err)
-	}
-
-	if !recvResp.OK {
-		if resp.StatusCode == http.StatusNotFound {
-			return "", fmt.Errorf("patch not found — it may have already been received or expired")
-		}
-		return "", fmt.Errorf("server error: %s", recvResp.Error)
-	}
-
-	return recvResp.Data, nil
-}
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Client is an HTTP client for the git-share relay server.
+type Client struct {
+	baseURL    string
+	space      string
+	httpClient *http.Client
+	retries    int // for truncated receives
+
+	transientRetries int
+	retryDelay       time.Duration
+	sleep            func(time.Duration)
+	progress         func(Progress)
+
+	token string // bearer token for relays that require one
+}
+
+// SendRequest matches the server's expected JSON body.
+type SendRequest struct {
+	CodeID string `json:"code_id"`
+	Data   string `json:"data"`
+	TTL    int    `json:"ttl"`
+	// MaxDownloads is how many times the blob can be received; 0 means once.
+	MaxDownloads int `json:"max_downloads,omitempty"`
+	// Part and Parts number the pieces of a blob sent with SendParts.
+	Part  int `json:"part,omitempty"`
+	Parts int `json:"parts,omitempty"`
+}
+
+// SendResponse matches the server's JSON response.
+type SendResponse struct {
+	OK     bool   `json:"ok"`
+	Expiry string `json:"expiry,omitempty"`
+	Size   int    `json:"size,omitempty"`
+	TTL    int    `json:"ttl,omitempty"` // effective TTL in seconds
+	// ShortURL is a link to the relay's web receive page, without the
+	// passphrase, when the relay shortens links.
+	ShortURL string `json:"short_url,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ReceiveResponse matches the server's JSON response.
+type ReceiveResponse struct {
+	OK        bool   `json:"ok"`
+	Data      string `json:"data,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	ExpiredAt string `json:"expired_at,omitempty"`
+}
+
+// StatusResponse matches the server's JSON response for a status check.
+type StatusResponse struct {
+	OK        bool   `json:"ok"`
+	Expiry    string `json:"expiry,omitempty"`
+	Size      int    `json:"size,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	ExpiredAt string `json:"expired_at,omitempty"`
+
+	// ETag identifies this version of the blob, for ReceiveIfMatch.
+	ETag string `json:"-"`
+}
+
+// ExtendRequest matches the server's expected JSON body for an extension.
+type ExtendRequest struct {
+	TTL int `json:"ttl"`
+}
+
+// ExtendResponse matches the server's JSON response for an extension.
+type ExtendResponse struct {
+	OK        bool   `json:"ok"`
+	Expiry    string `json:"expiry,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	ExpiredAt string `json:"expired_at,omitempty"`
+}
+
+// Capabilities matches the server's JSON response for GET /api/capabilities.
+type Capabilities struct {
+	OK       bool     `json:"ok"`
+	Features []string `json:"features"`
+	MaxSize  int64    `json:"max_size"` // bytes, 0 if unknown
+	MaxTTL   int      `json:"max_ttl"`  // seconds
+	MinTTL   int      `json:"min_ttl"`  // seconds
+	// MaxUploadSize is the largest blob SendParts may send, in bytes.
+	MaxUploadSize int64 `json:"max_upload_size,omitempty"`
+}
+
+// Limits matches the server's JSON response for GET /api/limits.
+type Limits struct {
+	OK      bool  `json:"ok"`
+	MaxSize int64 `json:"max_size"` // bytes
+	MaxTTL  int   `json:"max_ttl"`  // seconds
+	MinTTL  int   `json:"min_ttl"`  // seconds
+	// MaxUploadSize is the largest blob SendParts may send, in bytes; 0 if
+	// the relay doesn't take blobs in parts.
+	MaxUploadSize int64 `json:"max_upload_size,omitempty"`
+}
+
+// Features a relay may report.
+const (
+	FeatureSpaces = "spaces"
+	FeatureExtend = "extend"
+	// FeatureMaxDownloads means SendMulti's maxDownloads is honored.
+	FeatureMaxDownloads = "max_downloads"
+	// FeaturePeek means Peek is allowed.
+	FeaturePeek = "peek"
+	// FeatureChunkedUpload means SendParts is accepted.
+	FeatureChunkedUpload = "chunked_upload"
+)
+
+// Has reports whether the relay supports a feature.
+func (c *Capabilities) Has(feature string) bool {
+	return slices.Contains(c.Features, feature)
+}
+
+// AdminResponse matches the server's JSON response for admin endpoints.
+type AdminResponse struct {
+	OK      bool   `json:"ok"`
+	Removed int    `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ErrNotFound is returned by Receive when the relay has no record of a code ID.
+var ErrNotFound = errors.New("patch not found — it may have already been received or expired")
+
+// ErrNoCapabilities is returned by Capabilities for relays that predate the
+// capabilities endpoint. Callers should assume only the basic send and
+// receive endpoints.
+var ErrNoCapabilities = errors.New("the relay does not report its capabilities")
+
+// ErrNoLimits is returned by Limits for relays that predate the limits
+// endpoint.
+var ErrNoLimits = errors.New("the relay does not report its limits")
+
+// ErrTruncated is returned by Receive when the relay's response was cut off
+// on every attempt.
+var ErrTruncated = errors.New("the relay's response was cut off before it was complete")
+
+// ErrUnauthorized is returned when the relay requires an auth token and
+// the client sent none or the wrong one.
+var ErrUnauthorized = errors.New("the relay needs a valid auth token; pass it with --token")
+
+// ErrChanged is returned by ReceiveIfMatch when the blob on the relay no
+// longer matches the ETag. The blob is not consumed.
+var ErrChanged = errors.New("the patch changed on the relay since it was checked")
+
+// transientError is a failure that may not happen again: the relay couldn't
+// be reached or answered with a 5xx status.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// serverError describes a 5xx response, using the relay's message if the
+// body has one. Proxies in front of the relay often answer with HTML.
+func serverError(resp *http.Response, body []byte) error {
+	var msg struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &msg) == nil && msg.Error != "" {
+		return &transientError{fmt.Errorf("server error: %s", msg.Error)}
+	}
+	return &transientError{fmt.Errorf("server error: %s", resp.Status)}
+}
+
+// retryTransient reports whether to retry after err on the given attempt,
+// counting from 0, and if so waits before returning.
+func (c *Client) retryTransient(err error, attempt int) bool {
+	var transient *transientError
+	if !errors.As(err, &transient) || attempt >= c.transientRetries {
+		return false
+	}
+	c.sleep(c.retryDelay << attempt)
+	return true
+}
+
+// GoneError is returned by Receive when the relay remembers the blob but it is
+// no longer available, because it expired or was already received.
+type GoneError struct {
+	Reason    string    // "expired" or "consumed"
+	ExpiredAt time.Time // set when Reason is "expired"
+}
+
+func (e *GoneError) Error() string {
+	if e.Reason == "expired" {
+		return fmt.Sprintf("this patch expired at %s; ask the sender to resend with a longer --ttl", e.ExpiredAt.Local().Format(time.RFC3339))
+	}
+	return "this patch has already been received (codes are one-time use); ask the sender to resend"
+}
+
+// Options configures the HTTP client used to talk to the relay.
+type Options struct {
+	Timeout             time.Duration // overall request timeout
+	MaxIdleConns        int           // idle connections kept across all hosts
+	MaxIdleConnsPerHost int           // idle connections kept per relay host, negative disables reuse
+	IdleConnTimeout     time.Duration // how long an idle connection is kept open
+
+	Space string // namespace for code IDs on a multi-tenant relay, empty for none
+
+	// Proxy, if set, is used for every request. Otherwise HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY decide.
+	Proxy *url.URL
+
+	// InsecureSkipVerify turns off TLS certificate checks, for relays with
+	// self-signed certificates. Anyone on the network path can then pose as
+	// the relay.
+	InsecureSkipVerify bool
+
+	// Token is sent as a bearer token with every request for a blob, for
+	// relays started with an auth token.
+	Token string
+
+	// ReceiveRetries is how many times Receive retries a truncated response.
+	// The relay keeps a blob whose delivery failed, so a retry can succeed.
+	ReceiveRetries int
+
+	// Retries is how many times Send and Receive retry after a connection
+	// error or a 5xx response, waiting RetryDelay before the first retry and
+	// twice as long before each one after that.
+	Retries    int
+	RetryDelay time.Duration
+
+	// Progress, if set, is called as Send uploads and Receive downloads a
+	// blob, once per read. Each attempt starts again from zero.
+	Progress func(Progress)
+}
+
+// DefaultOptions returns the options used by New.
+func DefaultOptions() Options {
+	return Options{
+		Timeout:             30 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		ReceiveRetries:      2,
+		Retries:             2,
+		RetryDelay:          time.Second,
+	}
+}
+
+// New creates a new relay client.
+func New(baseURL string) *Client {
+	return NewWithOptions(baseURL, DefaultOptions())
+}
+
+// NewWithOptions creates a new relay client with a tuned transport.
+// Reusing one client for many sends keeps connections to the relay alive.
+func NewWithOptions(baseURL string, opts Options) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = opts.MaxIdleConns
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	transport.Proxy = http.ProxyFromEnvironment
+	if opts.Proxy != nil {
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+	if opts.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &Client{
+		baseURL: baseURL,
+		space:   opts.Space,
+		retries: opts.ReceiveRetries,
+
+		transientRetries: opts.Retries,
+		retryDelay:       opts.RetryDelay,
+		sleep:            time.Sleep,
+		progress:         opts.Progress,
+		token:            opts.Token,
+		httpClient: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: transport,
+		},
+	}
+}
+
+// apiURL returns the URL of an API endpoint, inside the client's space if set.
+func (c *Client) apiURL(endpoint string) string {
+	if c.space == "" {
+		return c.baseURL + "/api/" + endpoint
+	}
+	return c.baseURL + "/api/" + url.PathEscape(c.space) + "/" + endpoint
+}
+
+// authorize adds the client's token to req, if it has one.
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+// Send uploads an encrypted blob to the relay server.
+func (c *Client) Send(codeID string, data string, ttlSeconds int) (*SendResponse, error) {
+	return c.SendMulti(codeID, data, ttlSeconds, 1)
+}
+
+// SendMulti is Send for a blob that can be received maxDownloads times
+// before the relay deletes it. Relays without FeatureMaxDownloads ignore
+// maxDownloads and delete the blob after one download.
+func (c *Client) SendMulti(codeID string, data string, ttlSeconds, maxDownloads int) (*SendResponse, error) {
+	reqBody := SendRequest{
+		CodeID: codeID,
+		Data:   data,
+		TTL:    ttlSeconds,
+	}
+	if maxDownloads > 1 {
+		reqBody.MaxDownloads = maxDownloads
+	}
+	return c.send(reqBody)
+}
+
+// SendParts is SendMulti for a blob larger than the relay's max size. It
+// uploads data in pieces of at most partSize bytes, one request each, and
+// the relay joins them under codeID once the last has arrived. The relay
+// must have FeatureChunkedUpload, and the whole blob must fit its
+// MaxUploadSize. The returned response is the last part's, with Size
+// counting every part.
+func (c *Client) SendParts(codeID string, data string, ttlSeconds, maxDownloads, partSize int) (*SendResponse, error) {
+	if partSize <= 0 {
+		return nil, fmt.Errorf("part size must be positive")
+	}
+	parts := (len(data) + partSize - 1) / partSize
+	if parts <= 1 {
+		return c.SendMulti(codeID, data, ttlSeconds, maxDownloads)
+	}
+	var last *SendResponse
+	size := 0
+	for i := range parts {
+		reqBody := SendRequest{
+			CodeID: codeID,
+			Data:   data[i*partSize : min((i+1)*partSize, len(data))],
+			TTL:    ttlSeconds,
+			Part:   i,
+			Parts:  parts,
+		}
+		if maxDownloads > 1 {
+			reqBody.MaxDownloads = maxDownloads
+		}
+		resp, err := c.send(reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("part %d/%d: %w", i+1, parts, err)
+		}
+		size += resp.Size
+		last = resp
+	}
+	last.Size = size
+	return last, nil
+}
+
+// send uploads one request body, retrying as the client's options allow.
+func (c *Client) send(reqBody SendRequest) (*SendResponse, error) {
+	codeID := reqBody.CodeID
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		sendResp, status, err := c.sendOnce(body)
+		if status == http.StatusConflict && attempt > 0 {
+			// An earlier attempt may have stored the blob before failing;
+			// code IDs are random, so if it's there now it's ours
+			if st, serr := c.Status(codeID); serr == nil {
+				return &SendResponse{OK: true, Expiry: st.Expiry, Size: st.Size}, nil
+			}
+		}
+		if !c.retryTransient(err, attempt) {
+			return sendResp, err
+		}
+	}
+}
+
+// sendOnce makes one upload attempt and returns the response status, or 0
+// if the relay couldn't be reached.
+func (c *Client) sendOnce(body []byte) (*SendResponse, int, error) {
+	upload := trackProgress(bytes.NewReader(body), true, int64(len(body)), c.progress)
+	req, err := http.NewRequest(http.MethodPost, c.apiURL("send"), upload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating request: %w", err)
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	upload.finish()
+	if err != nil {
+		return nil, 0, &transientError{fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	var sendResp SendResponse
+	if err == nil {
+		err = json.Unmarshal(respBody, &sendResp)
+	}
+	if (resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusAccepted) && (err != nil || !sendResp.OK) {
+		// The blob (or part) was stored even if the rest of the response was
+		// lost, so uploading it again would only be refused
+		return &SendResponse{OK: true}, resp.StatusCode, nil
+	}
+	if resp.StatusCode >= 500 {
+		return nil, resp.StatusCode, serverError(resp, respBody)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, resp.StatusCode, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("parsing response: %w", err)
+	}
+
+	if !sendResp.OK {
+		return nil, resp.StatusCode, fmt.Errorf("server error: %s", sendResp.Error)
+	}
+
+	return &sendResp, resp.StatusCode, nil
+}
+
+// Receive downloads and consumes an encrypted blob from the relay server.
+// A response that is cut off in transit is retried, up to the client's
+// ReceiveRetries, and connection errors and 5xx responses up to its Retries.
+// A missing blob is never retried; it may have just been received.
+func (c *Client) Receive(codeID string) (string, error) {
+	return c.ReceiveIfMatch(codeID, "")
+}
+
+// ReceiveIfMatch is Receive, but only delivers the blob if it still has the
+// given ETag from Status, returning ErrChanged otherwise. An empty etag
+// matches any version.
+func (c *Client) ReceiveIfMatch(codeID, etag string) (string, error) {
+	return c.download("receive/", codeID, etag)
+}
+
+// Peek downloads an encrypted blob like Receive, but leaves it on the
+// relay. This bypasses one-time use, so relays only allow it when started
+// with --allow-peek (FeaturePeek); others refuse with an error.
+func (c *Client) Peek(codeID string) (string, error) {
+	return c.download("peek/", codeID, "")
+}
+
+// download fetches a blob from endpoint, retrying like Receive.
+func (c *Client) download(endpoint, codeID, etag string) (string, error) {
+	truncated, transient := 0, 0
+	for {
+		data, err := c.receiveOnce(endpoint, codeID, etag)
+		switch {
+		case errors.Is(err, ErrTruncated) && truncated < c.retries:
+			truncated++
+		case c.retryTransient(err, transient):
+			transient++
+		default:
+			return data, err
+		}
+	}
+}
+
+func (c *Client) receiveOnce(endpoint, codeID, etag string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.apiURL(endpoint+url.PathEscape(codeID)), nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", &transientError{fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)}
+	}
+	defer resp.Body.Close()
+
+	download := trackProgress(resp.Body, false, resp.ContentLength, c.progress)
+	respBody, err := io.ReadAll(download)
+	download.finish()
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", fmt.Errorf("%w: %v", ErrTruncated, err)
+		}
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode >= 500 {
+		return "", serverError(resp, respBody)
+	}
+
+	var recvResp ReceiveResponse
+	if err := json.Unmarshal(respBody, &recvResp); err != nil {
+		if isTruncatedJSON(err, respBody) {
+			return "", fmt.Errorf("%w: got %d bytes", ErrTruncated, len(respBody))
+		}
+		return "", fmt.Errorf("parsing response: %w", err)
+	}
+
+	if !recvResp.OK {
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return "", ErrNotFound
+		case http.StatusGone:
+			return "", newGoneError(recvResp.Reason, recvResp.ExpiredAt)
+		case http.StatusPreconditionFailed:
+			return "", ErrChanged
+		case http.StatusUnauthorized:
+			return "", ErrUnauthorized
+		}
+		return "", fmt.Errorf("server error: %s", recvResp.Error)
+	}
+
+	return recvResp.Data, nil
+}
+
+// Status reports whether a blob is still available, without consuming it.
+// Returns ErrNotFound or a *GoneError like Receive.
+func (c *Client) Status(codeID string) (*StatusResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, c.apiURL("status/"+url.PathEscape(codeID)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	var status StatusResponse
+	if err := json.Unmarshal(respBody, &status); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+
+	if !status.OK {
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return nil, ErrNotFound
+		case http.StatusGone:
+			return nil, newGoneError(status.Reason, status.ExpiredAt)
+		case http.StatusUnauthorized:
+			return nil, ErrUnauthorized
+		}
+		return nil, fmt.Errorf("server error: %s", status.Error)
+	}
+
+	status.ETag = resp.Header.Get("ETag")
+	return &status, nil
+}
+
+// Extend pushes a blob's expiry out to ttlSeconds from now, within the
+// relay's maximum TTL. Returns ErrNotFound or a *GoneError like Receive.
+func (c *Client) Extend(codeID string, ttlSeconds int) (*ExtendResponse, error) {
+	body, err := json.Marshal(ExtendRequest{TTL: ttlSeconds})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, c.apiURL("extend/"+url.PathEscape(codeID)), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	var extendResp ExtendResponse
+	if err := json.Unmarshal(respBody, &extendResp); err != nil {
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+			return nil, errors.New("the relay does not support extending patches")
+		}
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+
+	if !extendResp.OK {
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return nil, ErrNotFound
+		case http.StatusGone:
+			return nil, newGoneError(extendResp.Reason, extendResp.ExpiredAt)
+		case http.StatusUnauthorized:
+			return nil, ErrUnauthorized
+		}
+		return nil, fmt.Errorf("server error: %s", extendResp.Error)
+	}
+	return &extendResp, nil
+}
+
+// Capabilities asks the relay which optional features it supports and what
+// its limits are.
+func (c *Client) Capabilities() (*Capabilities, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/api/capabilities")
+	if err != nil {
+		return nil, fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, ErrNoCapabilities
+	}
+	var caps Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	if !caps.OK {
+		return nil, ErrNoCapabilities
+	}
+	return &caps, nil
+}
+
+// Health checks that the relay is up, and returns how many blobs it holds.
+func (c *Client) Health() (int, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/api/health")
+	if err != nil {
+		return 0, fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		OK    bool `json:"ok"`
+		Blobs int  `json:"blobs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil || !health.OK {
+		return 0, fmt.Errorf("%s doesn't look like a git-share relay: %s", c.baseURL, resp.Status)
+	}
+	return health.Blobs, nil
+}
+
+// Limits asks the relay for the largest blob and TTL it accepts.
+func (c *Client) Limits() (*Limits, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/api/limits")
+	if err != nil {
+		return nil, fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, ErrNoLimits
+	}
+	var limits Limits
+	if err := json.NewDecoder(resp.Body).Decode(&limits); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	if !limits.OK {
+		return nil, ErrNoLimits
+	}
+	return &limits, nil
+}
+
+// Purge deletes every blob on the relay, authenticating with the relay's
+// admin token, and returns how many were removed.
+func (c *Client) Purge(adminToken string) (int, error) {
+	req, err := http.NewRequest(http.MethodDelete, c.baseURL+"/api/admin/blobs", nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("connecting to relay server at %s: %w", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, errors.New("the relay has no admin endpoints; start it with an admin token")
+	}
+	var purgeResp AdminResponse
+	if err := json.NewDecoder(resp.Body).Decode(&purgeResp); err != nil {
+		return 0, fmt.Errorf("parsing response: %w", err)
+	}
+	if !purgeResp.OK {
+		return 0, fmt.Errorf("server error: %s", purgeResp.Error)
+	}
+	return purgeResp.Removed, nil
+}
+
+// newGoneError builds a GoneError from the relay's reason and RFC 3339
+// expiry time.
+func newGoneError(reason, expiredAt string) *GoneError {
+	gone := &GoneError{Reason: reason}
+	if expiredAt != "" {
+		gone.ExpiredAt, _ = time.Parse(time.RFC3339, expiredAt)
+	}
+	return gone
+}
+
+// isTruncatedJSON reports whether a JSON parse error is caused by the input
+// ending early, as opposed to the input being malformed.
+func isTruncatedJSON(err error, body []byte) bool {
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return false
+	}
+	// encoding/json has no distinct error for this case, only the message
+	return syntaxErr.Offset >= int64(len(body)) && syntaxErr.Error() == "unexpected end of JSON input"
+}
@@ -0,0 +1,648 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/server"
+)
+
+// newTestRelay starts a relay stub that accepts every send and counts the
+// connections opened against it.
+func newTestRelay(t *testing.T) (*httptest.Server, *int32) {
+	t.Helper()
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(SendResponse{OK: true, Expiry: "2026-02-27T17:00:00Z"})
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+func TestClientReusesConnections(t *testing.T) {
+	srv, conns := newTestRelay(t)
+	c := NewWithOptions(srv.URL, DefaultOptions())
+
+	for i := 0; i < 10; i++ {
+		if _, err := c.Send("id", "data", 60); err != nil {
+			t.Fatalf("Send #%d failed: %v", i, err)
+		}
+	}
+
+	if got := atomic.LoadInt32(conns); got != 1 {
+		t.Errorf("expected 1 connection across 10 sends, got %d", got)
+	}
+}
+
+func TestClientWithoutIdleConns(t *testing.T) {
+	srv, conns := newTestRelay(t)
+	opts := DefaultOptions()
+	opts.MaxIdleConnsPerHost = -1 // disable keep-alive reuse
+	c := NewWithOptions(srv.URL, opts)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Send("id", "data", 60); err != nil {
+			t.Fatalf("Send #%d failed: %v", i, err)
+		}
+	}
+
+	if got := atomic.LoadInt32(conns); got != 3 {
+		t.Errorf("expected a new connection per send, got %d", got)
+	}
+}
+
+func BenchmarkClientSend(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(SendResponse{OK: true})
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Send("id", "data", 60); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestClientProxy(t *testing.T) {
+	relay := server.New(server.DefaultConfig()).Handler()
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.Host
+		relay.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	opts := DefaultOptions()
+	opts.Proxy, _ = url.Parse(proxy.URL)
+	if _, err := NewWithOptions("http://relay.invalid", opts).Limits(); err != nil {
+		t.Fatalf("Limits through the proxy failed: %v", err)
+	}
+	if proxied != "relay.invalid" {
+		t.Errorf("proxy saw host %q, want relay.invalid", proxied)
+	}
+}
+
+func TestClientInsecureSkipVerify(t *testing.T) {
+	srv := httptest.NewTLSServer(server.New(server.DefaultConfig()).Handler())
+	defer srv.Close()
+
+	if _, err := New(srv.URL).Limits(); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected a certificate error from a self-signed relay, got %v", err)
+	}
+	opts := DefaultOptions()
+	opts.InsecureSkipVerify = true
+	if _, err := NewWithOptions(srv.URL, opts).Limits(); err != nil {
+		t.Errorf("Limits with InsecureSkipVerify failed: %v", err)
+	}
+}
+
+func TestReceiveGoneErrors(t *testing.T) {
+	relay := server.New(server.DefaultConfig())
+	srv := httptest.NewServer(relay.Handler())
+	defer srv.Close()
+	c := New(srv.URL)
+
+	// 1. Expired between upload and receive
+	if _, err := c.Send("expiring", "data", 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	_, err := c.Receive("expiring")
+	var gone *GoneError
+	if !errors.As(err, &gone) || gone.Reason != "expired" {
+		t.Fatalf("expected an expired GoneError, got %v", err)
+	}
+	if gone.ExpiredAt.IsZero() {
+		t.Error("expected the expiry time to be reported")
+	}
+	if !strings.Contains(err.Error(), "expired at") || !strings.Contains(err.Error(), "longer --ttl") {
+		t.Errorf("unexpected message: %q", err.Error())
+	}
+
+	// 2. Received twice
+	c.Send("once", "data", 60)
+	if _, err := c.Receive("once"); err != nil {
+		t.Fatalf("first Receive failed: %v", err)
+	}
+	_, err = c.Receive("once")
+	if !errors.As(err, &gone) || gone.Reason != "consumed" {
+		t.Errorf("expected a consumed GoneError, got %v", err)
+	}
+
+	// 3. Never existed
+	if _, err := c.Receive("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStatusAndReceiveIfMatch(t *testing.T) {
+	relay := server.New(server.DefaultConfig())
+	srv := httptest.NewServer(relay.Handler())
+	defer srv.Close()
+	c := New(srv.URL)
+
+	if _, err := c.Send("abc", "data", 60); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	status, err := c.Status("abc")
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.ETag == "" || status.Size != 4 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	if _, err := c.ReceiveIfMatch("abc", `"stale"`); !errors.Is(err, ErrChanged) {
+		t.Fatalf("expected ErrChanged, got %v", err)
+	}
+	data, err := c.ReceiveIfMatch("abc", status.ETag)
+	if err != nil || data != "data" {
+		t.Fatalf("ReceiveIfMatch = %q, %v", data, err)
+	}
+
+	var gone *GoneError
+	if _, err := c.Status("abc"); !errors.As(err, &gone) || gone.Reason != "consumed" {
+		t.Errorf("expected a consumed GoneError, got %v", err)
+	}
+	if _, err := c.Status("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	relay := server.New(server.DefaultConfig())
+	srv := httptest.NewServer(relay.Handler())
+	defer srv.Close()
+	c := New(srv.URL)
+
+	sent, err := c.Send("abc", "data", 60)
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	extended, err := c.Extend("abc", 600)
+	if err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+	before, _ := time.Parse(time.RFC3339, sent.Expiry)
+	after, _ := time.Parse(time.RFC3339, extended.Expiry)
+	if !after.After(before) {
+		t.Errorf("expiry %s is not later than %s", extended.Expiry, sent.Expiry)
+	}
+
+	c.Receive("abc")
+	var gone *GoneError
+	if _, err := c.Extend("abc", 600); !errors.As(err, &gone) || gone.Reason != "consumed" {
+		t.Errorf("expected a consumed GoneError, got %v", err)
+	}
+	if _, err := c.Extend("unknown", 600); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	config := server.DefaultConfig()
+	config.AllowPeek = true
+	srv := httptest.NewServer(server.New(config).Handler())
+	defer srv.Close()
+	c := New(srv.URL)
+
+	if _, err := c.Send("abc", "data", 60); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if data, err := c.Peek("abc"); err != nil || data != "data" {
+			t.Fatalf("Peek = %q, %v; want the blob", data, err)
+		}
+	}
+	if data, err := c.Receive("abc"); err != nil || data != "data" {
+		t.Fatalf("Receive after Peek = %q, %v; want the blob", data, err)
+	}
+	var gone *GoneError
+	if _, err := c.Peek("abc"); !errors.As(err, &gone) {
+		t.Errorf("expected a GoneError after the receive, got %v", err)
+	}
+
+	closed := httptest.NewServer(server.New(server.DefaultConfig()).Handler())
+	defer closed.Close()
+	if _, err := New(closed.URL).Peek("abc"); err == nil || !strings.Contains(err.Error(), "--allow-peek") {
+		t.Errorf("expected peeking to be refused, got %v", err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	config := server.DefaultConfig()
+	config.MaxSize = 4096
+	config.MaxUploadSize = 0
+	srv := httptest.NewServer(server.New(config).Handler())
+	defer srv.Close()
+
+	caps, err := New(srv.URL).Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	if !caps.Has(FeatureExtend) || !caps.Has(FeatureSpaces) {
+		t.Errorf("features = %v, want extend and spaces", caps.Features)
+	}
+	if caps.Has("chunked_upload") {
+		t.Error("Has reported a feature the relay does not list")
+	}
+	if caps.MaxSize != 4096 {
+		t.Errorf("MaxSize = %d, want 4096", caps.MaxSize)
+	}
+	if FeatureExtend != server.FeatureExtend || FeatureSpaces != server.FeatureSpaces {
+		t.Error("client feature names differ from the server's")
+	}
+
+	// A relay from before the endpoint existed
+	legacy := httptest.NewServer(http.NotFoundHandler())
+	defer legacy.Close()
+	if _, err := New(legacy.URL).Capabilities(); !errors.Is(err, ErrNoCapabilities) {
+		t.Errorf("expected ErrNoCapabilities from an older relay, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	config := server.DefaultConfig()
+	config.MaxSize = 4096
+	srv := httptest.NewServer(server.New(config).Handler())
+	defer srv.Close()
+
+	limits, err := New(srv.URL).Limits()
+	if err != nil {
+		t.Fatalf("Limits failed: %v", err)
+	}
+	if limits.MaxSize != 4096 || limits.MaxTTL != 3600 {
+		t.Errorf("limits = %+v, want a max size of 4096 and TTL of 3600", limits)
+	}
+
+	legacy := httptest.NewServer(http.NotFoundHandler())
+	defer legacy.Close()
+	if _, err := New(legacy.URL).Limits(); !errors.Is(err, ErrNoLimits) {
+		t.Errorf("expected ErrNoLimits from an older relay, got %v", err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	srv := httptest.NewServer(server.New(server.DefaultConfig()).Handler())
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Send("abc", "data", 60)
+	if blobs, err := c.Health(); err != nil || blobs != 1 {
+		t.Errorf("Health = %d, %v; want 1 blob", blobs, err)
+	}
+
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	if _, err := New(other.URL).Health(); err == nil || !strings.Contains(err.Error(), "doesn't look like a git-share relay") {
+		t.Errorf("expected an error from a server that isn't a relay, got %v", err)
+	}
+}
+
+func TestSendParts(t *testing.T) {
+	config := server.DefaultConfig()
+	config.MaxSize = 1024
+	config.MaxUploadSize = 4096
+	srv := httptest.NewServer(server.New(config).Handler())
+	defer srv.Close()
+	c := New(srv.URL)
+
+	data := strings.Repeat("0123456789", 250)
+	resp, err := c.SendParts("abc", data, 60, 1, 900)
+	if err != nil {
+		t.Fatalf("SendParts failed: %v", err)
+	}
+	if resp.Size != len(data) || resp.Expiry == "" {
+		t.Errorf("response = %+v, want the whole size and an expiry", resp)
+	}
+	if got, err := c.Receive("abc"); err != nil || got != data {
+		t.Fatalf("Receive = %d bytes, %v; want the parts joined", len(got), err)
+	}
+
+	_, err = c.SendParts("big", strings.Repeat("x", 5000), 60, 1, 900)
+	if err == nil || !strings.Contains(err.Error(), "part 5/6") {
+		t.Errorf("expected the part over the limit to fail, got %v", err)
+	}
+	if FeatureChunkedUpload != server.FeatureChunkedUpload {
+		t.Error("client feature name differs from the server's")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	config := server.DefaultConfig()
+	config.AdminToken = "s3cret"
+	srv := httptest.NewServer(server.New(config).Handler())
+	defer srv.Close()
+	c := New(srv.URL)
+
+	c.Send("a", "data", 60)
+	if _, err := c.Purge("wrong"); err == nil {
+		t.Fatal("expected an error with the wrong token")
+	}
+	removed, err := c.Purge("s3cret")
+	if err != nil || removed != 1 {
+		t.Fatalf("Purge = %d, %v; want 1", removed, err)
+	}
+
+	disabled := httptest.NewServer(server.New(server.DefaultConfig()).Handler())
+	defer disabled.Close()
+	if _, err := New(disabled.URL).Purge("s3cret"); err == nil || !strings.Contains(err.Error(), "no admin endpoints") {
+		t.Errorf("expected a no admin endpoints error, got %v", err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	config := server.DefaultConfig()
+	config.AuthToken = "s3cret"
+	config.AuthReceive = true
+	srv := httptest.NewServer(server.New(config).Handler())
+	defer srv.Close()
+
+	if _, err := New(srv.URL).Send("a", "data", 60); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Send without a token: got %v, want ErrUnauthorized", err)
+	}
+	opts := DefaultOptions()
+	opts.Token = "s3cret"
+	c := NewWithOptions(srv.URL, opts)
+	if _, err := c.Send("a", "data", 60); err != nil {
+		t.Fatalf("Send with the token failed: %v", err)
+	}
+	if _, err := New(srv.URL).Status("a"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Status without a token: got %v, want ErrUnauthorized", err)
+	}
+	if _, err := New(srv.URL).Extend("a", 60); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Extend without a token: got %v, want ErrUnauthorized", err)
+	}
+	if _, err := c.Status("a"); err != nil {
+		t.Errorf("Status with the token failed: %v", err)
+	}
+	if _, err := c.Extend("a", 60); err != nil {
+		t.Errorf("Extend with the token failed: %v", err)
+	}
+	if _, err := New(srv.URL).Receive("a"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Receive without a token: got %v, want ErrUnauthorized", err)
+	}
+	if data, err := c.Receive("a"); err != nil || data != "data" {
+		t.Errorf("Receive with the token = %q, %v", data, err)
+	}
+}
+
+func TestClientSpaces(t *testing.T) {
+	relay := server.New(server.DefaultConfig())
+	srv := httptest.NewServer(relay.Handler())
+	defer srv.Close()
+
+	spaced := func(space string) *Client {
+		opts := DefaultOptions()
+		opts.Space = space
+		return NewWithOptions(srv.URL, opts)
+	}
+	teamA, teamB := spaced("team a"), spaced("team-b")
+
+	if _, err := teamA.Send("code", "from a", 60); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := teamB.Receive("code"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("other space: expected ErrNotFound, got %v", err)
+	}
+	if _, err := New(srv.URL).Receive("code"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("no space: expected ErrNotFound, got %v", err)
+	}
+	data, err := teamA.Receive("code")
+	if err != nil || data != "from a" {
+		t.Errorf("same space: got %q, %v", data, err)
+	}
+}
+
+// newFlakyRelay starts a relay stub whose first failures receive responses
+// are cut off mid-body, and counts the requests made.
+func newFlakyRelay(t *testing.T, failures int32, body string) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if n <= failures {
+			// Promise the full body but send only part of it
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write([]byte(body[:len(body)/2]))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestReceiveRetriesTruncatedResponse(t *testing.T) {
+	body := `{"ok":true,"data":"` + strings.Repeat("x", 1000) + `"}`
+
+	srv, requests := newFlakyRelay(t, 2, body)
+	data, err := New(srv.URL).Receive("abc")
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if len(data) != 1000 {
+		t.Errorf("got %d bytes of data, want 1000", len(data))
+	}
+	if *requests != 3 {
+		t.Errorf("made %d requests, want 3", *requests)
+	}
+
+	// Out of retries
+	srv, requests = newFlakyRelay(t, 10, body)
+	_, err = New(srv.URL).Receive("abc")
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("expected ErrTruncated, got %v", err)
+	}
+	if want := int32(DefaultOptions().ReceiveRetries + 1); *requests != want {
+		t.Errorf("made %d requests, want %d", *requests, want)
+	}
+}
+
+func TestReceiveMalformedResponseNotRetried(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"ok":true,"data":}`))
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL).Receive("abc")
+	if err == nil || errors.Is(err, ErrTruncated) || !strings.Contains(err.Error(), "parsing response") {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("made %d requests, want 1", requests)
+	}
+}
+
+// newFailingRelay starts a relay stub that answers the first failures
+// requests with status and the rest with ok, and counts the requests made.
+func newFailingRelay(t *testing.T, failures int32, status int, ok string) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if n <= failures {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"ok":false,"error":"upstream unavailable"}`))
+			return
+		}
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(ok))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestRetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		status       int
+		send         bool
+		wantErr      string
+		wantRequests int32
+		wantSleeps   []time.Duration
+	}{
+		{name: "send after 502s", failures: 2, status: http.StatusBadGateway, send: true, wantRequests: 3, wantSleeps: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "receive after a 500", failures: 1, status: http.StatusInternalServerError, wantRequests: 2, wantSleeps: []time.Duration{time.Second}},
+		{name: "out of retries", failures: 5, status: http.StatusServiceUnavailable, wantErr: "upstream unavailable", wantRequests: 3, wantSleeps: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "receive 404 is final", failures: 1, status: http.StatusNotFound, wantErr: ErrNotFound.Error(), wantRequests: 1},
+		{name: "send 4xx is final", failures: 1, status: http.StatusBadRequest, send: true, wantErr: "upstream unavailable", wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := newFailingRelay(t, tt.failures, tt.status, `{"ok":true,"data":"blob","expiry":"2026-02-27T17:00:00Z"}`)
+			c := New(srv.URL)
+			var sleeps []time.Duration
+			c.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+			var err error
+			if tt.send {
+				_, err = c.Send("abc", "blob", 60)
+			} else {
+				_, err = c.Receive("abc")
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if *requests != tt.wantRequests {
+				t.Errorf("made %d requests, want %d", *requests, tt.wantRequests)
+			}
+			if fmt.Sprint(sleeps) != fmt.Sprint(tt.wantSleeps) {
+				t.Errorf("slept %v, want %v", sleeps, tt.wantSleeps)
+			}
+		})
+	}
+}
+
+func TestProgress(t *testing.T) {
+	relay := server.New(server.DefaultConfig())
+	srv := httptest.NewServer(relay.Handler())
+	defer srv.Close()
+
+	var reports []Progress
+	opts := DefaultOptions()
+	opts.Progress = func(p Progress) { reports = append(reports, p) }
+	c := NewWithOptions(srv.URL, opts)
+
+	data := strings.Repeat("x", 100_000)
+	if _, err := c.Send("abc", data, 60); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(reports) < 3 {
+		t.Fatalf("got %d progress reports for the upload, want a start, progress and an end", len(reports))
+	}
+	first, last := reports[0], reports[len(reports)-1]
+	if !first.Sending || first.Done != 0 || first.Total <= int64(len(data)) {
+		t.Errorf("first report = %+v, want an upload of the whole request starting at 0", first)
+	}
+	if !last.Finished || last.Done != last.Total {
+		t.Errorf("last report = %+v, want a finished upload", last)
+	}
+
+	reports = nil
+	if _, err := c.Receive("abc"); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	last = reports[len(reports)-1]
+	if reports[0].Sending || !last.Finished || last.Done <= int64(len(data)) {
+		t.Errorf("download reports = %+v ... %+v, want a finished download of the response", reports[0], last)
+	}
+}
+
+func TestSendDoesNotReuploadStoredBlob(t *testing.T) {
+	relay := server.New(server.DefaultConfig()).Handler()
+
+	// The relay stores the first upload, but a proxy loses its response
+	var sends int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && atomic.AddInt32(&sends, 1) == 1 {
+			relay.ServeHTTP(httptest.NewRecorder(), r)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		relay.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.sleep = func(time.Duration) {}
+
+	resp, err := c.Send("abc", "blob", 60)
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if resp.Expiry == "" || resp.Size != len("blob") {
+		t.Errorf("response = %+v, want the stored blob's expiry and size", resp)
+	}
+	if sends != 2 {
+		t.Errorf("made %d uploads, want 2", sends)
+	}
+
+	// A 201 whose body is lost is a success, not something to retry
+	var posts int32
+	cut := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok":tr`))
+	}))
+	defer cut.Close()
+	c = New(cut.URL)
+	c.sleep = func(time.Duration) {}
+	if _, err := c.Send("abc", "blob", 60); err != nil {
+		t.Errorf("Send after a cut-off 201 failed: %v", err)
+	}
+	if posts != 1 {
+		t.Errorf("made %d uploads, want 1", posts)
+	}
+}
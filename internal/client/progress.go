@@ -0,0 +1,44 @@
+package client
+
+import "io"
+
+// Progress describes how far an upload or download has got.
+type Progress struct {
+	Sending  bool  // an upload rather than a download
+	Done     int64 // bytes transferred so far
+	Total    int64 // bytes in all, or -1 if the relay didn't say
+	Finished bool  // the transfer is over, whether or not it succeeded
+}
+
+// progressReader reports the bytes read through it.
+type progressReader struct {
+	r        io.Reader
+	progress Progress
+	report   func(Progress)
+}
+
+// trackProgress wraps r to report its progress, if report is set.
+func trackProgress(r io.Reader, sending bool, total int64, report func(Progress)) *progressReader {
+	p := &progressReader{r: r, progress: Progress{Sending: sending, Total: total}, report: report}
+	if report != nil {
+		report(p.progress)
+	}
+	return p
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 && p.report != nil {
+		p.progress.Done += int64(n)
+		p.report(p.progress)
+	}
+	return n, err
+}
+
+// finish reports the end of the transfer.
+func (p *progressReader) finish() {
+	if p.report != nil && !p.progress.Finished {
+		p.progress.Finished = true
+		p.report(p.progress)
+	}
+}
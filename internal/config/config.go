@@ -0,0 +1,152 @@
+// Package config reads the user's git-share config file.
+//
+// The file is YAML, limited to top-level "key: value" pairs with optional
+// quoting and comments, which is all the settings need:
+//
+//	# ~/.config/git-share/config.yaml
+//	server: https://relay.example.com
+//	ttl: 30m
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Dir returns git-share's config directory: $XDG_CONFIG_HOME/git-share, or
+// ~/.config/git-share if XDG_CONFIG_HOME is not set.
+func Dir() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("finding the config directory: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "git-share"), nil
+}
+
+// Path returns where the config file is looked for, config.yaml in Dir.
+func Path() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// Load reads the settings in the file at path. A missing file has no
+// settings and is not an error.
+func Load(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Parse parses "key: value" lines. Blank lines and comments are skipped,
+// and a value may be quoted with single or double quotes as in YAML.
+func Parse(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(line, "- ") {
+			return nil, fmt.Errorf("line %d: only top-level \"key: value\" settings are supported", n)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", n)
+		}
+		if value != "" && value[0] != ' ' && value[0] != '\t' {
+			return nil, fmt.Errorf("line %d: expected a space after %q", n, key+":")
+		}
+		value, err := parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: %q is set twice", n, key)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// parseValue unquotes a value and strips a trailing comment.
+func parseValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := closingQuote(s)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		if err := onlyComment(s[end+1:]); err != nil {
+			return "", err
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		// In single quotes, '' is a quote and nothing else is special
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), onlyComment(s[i+1:])
+		}
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	if strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{") || s == "|" || s == ">" {
+		return "", fmt.Errorf("only plain values are supported, not %q", s)
+	}
+	return s, nil
+}
+
+// closingQuote returns the index of the quote ending a double-quoted
+// string, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func onlyComment(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after the value", rest)
+	}
+	return nil
+}
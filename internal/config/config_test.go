@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:  "plain values",
+			input: "server: https://relay.example.com\nttl: 30m\n",
+			want:  map[string]string{"server": "https://relay.example.com", "ttl": "30m"},
+		},
+		{
+			name:  "comments and blank lines",
+			input: "# my relay\n\nserver: https://relay.example.com  # self-hosted\r\n",
+			want:  map[string]string{"server": "https://relay.example.com"},
+		},
+		{
+			name:  "quoted values",
+			input: `space: "team #1"` + "\n" + `allow-ref-pattern: '^[a-z]+''s$' # quoted` + "\nempty:\n",
+			want:  map[string]string{"space": "team #1", "allow-ref-pattern": "^[a-z]+'s$", "empty": ""},
+		},
+		{name: "nested", input: "server:\n  url: x\n", wantErr: "only top-level"},
+		{name: "list", input: "- server\n", wantErr: "only top-level"},
+		{name: "no colon", input: "server https://x\n", wantErr: `expected "key: value"`},
+		{name: "no space", input: "ttl:30m\n", wantErr: "expected a space"},
+		{name: "flow mapping", input: "server: {url: x}\n", wantErr: "only plain values"},
+		{name: "unterminated", input: `server: "https://x` + "\n", wantErr: "unterminated"},
+		{name: "duplicate", input: "ttl: 1h\nttl: 2h\n", wantErr: "line 2: \"ttl\" is set twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	values, err := Load(filepath.Join(dir, "missing.yaml"))
+	if err != nil || len(values) != 0 {
+		t.Errorf("Load(missing) = %v, %v; want no settings", values, err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("ttl 1h\n"), 0o600)
+	if _, err := Load(path); err == nil || !strings.HasPrefix(err.Error(), path+": line 1") {
+		t.Errorf("expected an error naming the file and line, got %v", err)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if got, _ := Path(); got != filepath.Join(dir, "git-share", "config.yaml") {
+		t.Errorf("Path() = %q", got)
+	}
+}
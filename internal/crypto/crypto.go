@@ -1,135 +1,284 @@
-package crypto
-
-import (
-	"crypto/rand"
-	"crypto/sha256"
-	"errors"
-	"fmt"
-	"io"
-	"math/big"
-	"strings"
-
-	"golang.org/x/crypto/chacha20poly1305"
-	"golang.org/x/crypto/hkdf"
-
-	"github.com/flawiddsouza/git-share/internal/wordlist"
-)
-
-const (
-	// CodeIDLength is the length of the random code ID used for server lookups.
-	CodeIDLength = 10
-	// PassphraseWords is the number of diceware words in a passphrase.
-	PassphraseWords = 4
-	// PassphraseSep is the separator between words in a passphrase.
-	PassphraseSep = "-"
-	// CodeSep separates the code ID from the passphrase in a combined code.
-	CodeSep = "-"
-	// hkdfSalt is a fixed salt for HKDF key derivation.
-	hkdfSalt = "git-share-v1"
-	// hkdfInfo is the context info for HKDF key derivation.
-	hkdfInfo = "encryption-key"
-)
-
-// base62 charset for generating code IDs.
-const base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-
-// GenerateCode creates a combined code: <codeId>-<word1>-<word2>-<word3>-<word4>.
-// The codeId is a random base62 string used for server lookup.
-// The passphrase is used for key derivation / encryption.
-func GenerateCode() (code string, codeID string, passphrase string, err error) {
-	codeID, err = generateCodeID()
-	if err != nil {
-		return "", "", "", fmt.Errorf("generating code ID: %w", err)
-	}
-
-	passphrase, err = wordlist.Pick(PassphraseWords, PassphraseSep)
-	if err != nil {
-		return "", "", "", fmt.Errorf("generating passphrase: %w", err)
-	}
-
-	code = codeID + CodeSep + passphrase
-	return code, codeID, passphrase, nil
-}
-
-// ParseCode splits a combined code into codeID and passphrase.
-// Format: <codeId>-<word1>-<word2>-<word3>-<word4>
-func ParseCode(code string) (codeID string, passphrase string, err error) {
-	parts := strings.SplitN(code, CodeSep, 2)
-	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		return "", "", errors.New("invalid code format: expected <codeId>-<word1>-<word2>-<word3>-<word4>")
-	}
-
-	// Validate that passphrase has the expected number of words
-	words := strings.Split(parts[1], PassphraseSep)
-	if len(words) != PassphraseWords {
-		return "", "", fmt.Errorf("invalid code format: passphrase should have %d words, got %d", PassphraseWords, len(words))
-	}
-
-	return parts[0], parts[1], nil
-}
-
-// DeriveKey derives a 256-bit encryption key from a passphrase using HKDF-SHA256.
-func DeriveKey(passphrase string) ([]byte, error) {
-	hkdfReader := hkdf.New(sha256.New, []byte(passphrase), []byte(hkdfSalt), []byte(hkdfInfo))
-	key := make([]byte, chacha20poly1305.KeySize)
-	if _, err := io.ReadFull(hkdfReader, key); err != nil {
-		return nil, fmt.Errorf("deriving key: %w", err)
-	}
-	return key, nil
-}
-
-// Encrypt encrypts plaintext using XChaCha20-Poly1305.
-// Returns: nonce || ciphertext (includes auth tag).
-func Encrypt(plaintext, key []byte) ([]byte, error) {
-	aead, err := chacha20poly1305.NewX(key)
-	if err != nil {
-		return nil, fmt.Errorf("creating cipher: %w", err)
-	}
-
-	nonce := make([]byte, aead.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return nil, fmt.Errorf("generating nonce: %w", err)
-	}
-
-	// Seal appends the ciphertext and tag to the nonce
-	ciphertext := aead.Seal(nonce, nonce, plaintext, nil)
-	return ciphertext, nil
-}
-
-// Decrypt decrypts ciphertext produced by Encrypt using XChaCha20-Poly1305.
-// Input format: nonce || ciphertext (includes auth tag).
-func Decrypt(ciphertext, key []byte) ([]byte, error) {
-	aead, err := chacha20poly1305.NewX(key)
-	if err != nil {
-		return nil, fmt.Errorf("creating cipher: %w", err)
-	}
-
-	nonceSize := aead.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return nil, errors.New("ciphertext too short")
-	}
-
-	nonce := ciphertext[:nonceSize]
-	encrypted := ciphertext[nonceSize:]
-
-	plaintext, err := aead.Open(nil, nonce, encrypted, nil)
-	if err != nil {
-		return nil, fmt.Errorf("decryption failed (wrong passphrase?): %w", err)
-	}
-
-	return plaintext, nil
-}
-
-// generateCodeID creates a random base62 string of CodeIDLength.
-func generateCodeID() (string, error) {
-	max := big.NewInt(int64(len(base62Chars)))
-	b := make([]byte, CodeIDLength)
-	for i := range b {
-		idx, err := rand.Int(rand.Reader, max)
-		if err != nil {
-			return "", err
-		}
-		b[i] = base62Chars[idx.Int64()]
-	}
-	return string(b), nil
-}
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/flawiddsouza/git-share/internal/wordlist"
+)
+
+const (
+	// CodeIDLength is the length of the random code ID used for server lookups.
+	CodeIDLength = 10
+	// PassphraseWords is the number of diceware words in a passphrase.
+	PassphraseWords = 4
+	// PassphraseSep is the separator between words in a passphrase.
+	PassphraseSep = "-"
+	// CodeSep separates the code ID from the passphrase in a combined code.
+	CodeSep = "-"
+	// MinPassphraseLength is the shortest user-supplied passphrase not
+	// reported as weak.
+	MinPassphraseLength = 12
+	// hkdfSalt is a fixed salt for HKDF key derivation.
+	hkdfSalt = "git-share-v1"
+	// hkdfInfo is the context info for HKDF key derivation.
+	hkdfInfo = "encryption-key"
+)
+
+// base62 charset for generating code IDs.
+const base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// GenerateCode creates a combined code: <codeId>-<word1>-<word2>-<word3>-<word4>.
+// The codeId is a random base62 string used for server lookup.
+// The passphrase is used for key derivation / encryption.
+func GenerateCode() (code string, codeID string, passphrase string, err error) {
+	return GenerateCodeLang(wordlist.DefaultLang)
+}
+
+// GenerateCodeLang is GenerateCode with passphrase words from the built-in
+// wordlist for lang.
+func GenerateCodeLang(lang string) (code string, codeID string, passphrase string, err error) {
+	words, err := wordlist.ForLang(lang)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	codeID, err = GenerateCodeID()
+	if err != nil {
+		return "", "", "", fmt.Errorf("generating code ID: %w", err)
+	}
+
+	passphrase, err = wordlist.PickFrom(words, PassphraseWords, PassphraseSep)
+	if err != nil {
+		return "", "", "", fmt.Errorf("generating passphrase: %w", err)
+	}
+
+	code = codeID + CodeSep + passphrase
+	return code, codeID, passphrase, nil
+}
+
+// IsWeakPassphrase reports whether a user-supplied passphrase is too short
+// to resist guessing by anyone who learns the code ID.
+func IsWeakPassphrase(passphrase string) bool {
+	return utf8.RuneCountInString(passphrase) < MinPassphraseLength
+}
+
+// Fingerprint returns the hex SHA-256 of data, so both sides can confirm they
+// have the same patch.
+func Fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrDecryptionFailed is returned by Decrypt when the key is wrong or the
+// ciphertext was modified.
+var ErrDecryptionFailed = errors.New("decryption failed (wrong passphrase?)")
+
+// ParseCode splits a combined code into codeID and passphrase.
+// Format: <codeId>-<word1>-<word2>-<word3>-<word4>
+func ParseCode(code string) (codeID string, passphrase string, err error) {
+	parts := strings.SplitN(code, CodeSep, 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("invalid code format: expected <codeId>-<word1>-<word2>-<word3>-<word4>")
+	}
+
+	// Validate that passphrase has the expected number of words
+	words := strings.Split(parts[1], PassphraseSep)
+	if len(words) != PassphraseWords {
+		return "", "", fmt.Errorf("invalid code format: passphrase should have %d words, got %d", PassphraseWords, len(words))
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// ParseCodeParts is ParseCode for a code ID and passphrase given
+// separately, e.g. because they were shared over different channels. The
+// words may be separated by PassphraseSep or by spaces.
+func ParseCodeParts(codeID, words string) (string, string, error) {
+	codeID = strings.TrimSpace(codeID)
+	if codeID == "" || strings.Contains(codeID, CodeSep) {
+		return "", "", errors.New("invalid code ID: expected just the <codeId> part of a code")
+	}
+	fields := strings.Fields(strings.ReplaceAll(words, PassphraseSep, " "))
+	if len(fields) != PassphraseWords {
+		return "", "", fmt.Errorf("invalid passphrase: expected %d words, got %d", PassphraseWords, len(fields))
+	}
+	return codeID, strings.Join(fields, PassphraseSep), nil
+}
+
+// DeriveKey derives a 256-bit encryption key from a passphrase using HKDF-SHA256.
+func DeriveKey(passphrase string) ([]byte, error) {
+	hkdfReader := hkdf.New(sha256.New, []byte(passphrase), []byte(hkdfSalt), []byte(hkdfInfo))
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdfReader, key); err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	return key, nil
+}
+
+// Argon2Params are the cost parameters and salt for DeriveKeyArgon2.
+type Argon2Params struct {
+	Time    uint32 // passes over memory
+	Memory  uint32 // KiB
+	Threads uint8
+	Salt    []byte
+}
+
+// DefaultArgon2Params returns the recommended Argon2id costs (RFC 9106's
+// second choice, 64 MiB) with a fresh random salt.
+func DefaultArgon2Params() (Argon2Params, error) {
+	salt := make([]byte, argon2SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return Argon2Params{}, fmt.Errorf("generating salt: %w", err)
+	}
+	return Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4, Salt: salt}, nil
+}
+
+// DeriveKeyArgon2 derives a 256-bit encryption key from a passphrase using
+// Argon2id.
+//
+// Unlike DeriveKey, each guess costs the attacker the full time and memory
+// of params, which matters because a generated passphrase has only about 32
+// bits of entropy. The price is that sending and receiving each take that
+// long too, and the browser receive page can't derive these keys.
+func DeriveKeyArgon2(passphrase string, params Argon2Params) ([]byte, error) {
+	if params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+		return nil, errors.New("deriving key: Argon2 time, memory, and threads must be positive")
+	}
+	if len(params.Salt) == 0 {
+		return nil, errors.New("deriving key: Argon2 needs a salt")
+	}
+	return argon2.IDKey([]byte(passphrase), params.Salt, params.Time, params.Memory, params.Threads, chacha20poly1305.KeySize), nil
+}
+
+// KDF header wire format, put in front of the ciphertext of a blob whose key
+// was not derived with DeriveKey:
+//
+//	magic    3 bytes   "GSK"
+//	version  1 byte    KDFArgon2id
+//	time     4 bytes   big-endian
+//	memory   4 bytes   big-endian, KiB
+//	threads  1 byte
+//	salt    16 bytes
+//
+// Blobs without the header are legacy blobs keyed with DeriveKey. Their
+// first bytes are a random nonce, which matches the magic by chance once in
+// 2^24 blobs, so a reader should fall back to DeriveKey if a headed blob
+// fails to decrypt.
+const (
+	// KDFMagic starts the KDF header.
+	KDFMagic = "GSK"
+	// KDFArgon2id is the KDF header version for DeriveKeyArgon2.
+	KDFArgon2id byte = 1
+
+	argon2SaltSize = 16
+	kdfHeaderSize  = len(KDFMagic) + 1 + 4 + 4 + 1 + argon2SaltSize
+
+	// maxArgon2Memory and maxArgon2Time bound the costs a received header
+	// can ask for, so a hostile sender can't make receive exhaust memory.
+	maxArgon2Memory = 1024 * 1024 // KiB
+	maxArgon2Time   = 16
+)
+
+// KDFHeader returns the header that records params for a blob encrypted
+// with a key from DeriveKeyArgon2.
+func KDFHeader(params Argon2Params) []byte {
+	h := make([]byte, 0, kdfHeaderSize)
+	h = append(h, KDFMagic...)
+	h = append(h, KDFArgon2id)
+	h = binary.BigEndian.AppendUint32(h, params.Time)
+	h = binary.BigEndian.AppendUint32(h, params.Memory)
+	h = append(h, params.Threads)
+	return append(h, params.Salt...)
+}
+
+// ParseKDFHeader reads the KDF header at the start of a blob. ok is false
+// for a legacy blob without one. rest is the ciphertext after the header.
+func ParseKDFHeader(data []byte) (params Argon2Params, rest []byte, ok bool, err error) {
+	if !bytes.HasPrefix(data, []byte(KDFMagic)) || len(data) < kdfHeaderSize {
+		return Argon2Params{}, data, false, nil
+	}
+	if v := data[len(KDFMagic)]; v != KDFArgon2id {
+		return Argon2Params{}, data, true, fmt.Errorf("unsupported key derivation version %d; a newer git-share may be needed", v)
+	}
+	h := data[len(KDFMagic)+1 : kdfHeaderSize]
+	params = Argon2Params{
+		Time:    binary.BigEndian.Uint32(h[0:4]),
+		Memory:  binary.BigEndian.Uint32(h[4:8]),
+		Threads: h[8],
+		Salt:    bytes.Clone(h[9:]),
+	}
+	if params.Time > maxArgon2Time || params.Memory > maxArgon2Memory {
+		return Argon2Params{}, data, true, fmt.Errorf("the blob asks for Argon2 costs above the limit (time %d, memory %d KiB)", params.Time, params.Memory)
+	}
+	return params, data[kdfHeaderSize:], true, nil
+}
+
+// Encrypt encrypts plaintext using XChaCha20-Poly1305.
+// Returns: nonce || ciphertext (includes auth tag).
+func Encrypt(plaintext, key []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	// Seal appends the ciphertext and tag to the nonce
+	ciphertext := aead.Seal(nonce, nonce, plaintext, nil)
+	return ciphertext, nil
+}
+
+// Decrypt decrypts ciphertext produced by Encrypt using XChaCha20-Poly1305.
+// Input format: nonce || ciphertext (includes auth tag).
+func Decrypt(ciphertext, key []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce := ciphertext[:nonceSize]
+	encrypted := ciphertext[nonceSize:]
+
+	plaintext, err := aead.Open(nil, nonce, encrypted, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+
+	return plaintext, nil
+}
+
+// GenerateCodeID creates a random base62 string of CodeIDLength.
+func GenerateCodeID() (string, error) {
+	max := big.NewInt(int64(len(base62Chars)))
+	b := make([]byte, CodeIDLength)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = base62Chars[idx.Int64()]
+	}
+	return string(b), nil
+}
@@ -1,120 +1,261 @@
-package crypto
-
-import (
-	"bytes"
-	"testing"
-)
-
-func TestGenerateCode(t *testing.T) {
-	code, codeID, passphrase, err := GenerateCode()
-	if err != nil {
-		t.Fatalf("GenerateCode() error: %v", err)
-	}
-	if len(codeID) != CodeIDLength {
-		t.Errorf("expected codeID length %d, got %d", CodeIDLength, len(codeID))
-	}
-	if passphrase == "" {
-		t.Error("expected non-empty passphrase")
-	}
-	if code == "" {
-		t.Error("expected non-empty code")
-	}
-	t.Logf("Generated code: %s", code)
-}
-
-func TestParseCode(t *testing.T) {
-	code, codeID, passphrase, err := GenerateCode()
-	if err != nil {
-		t.Fatalf("GenerateCode() error: %v", err)
-	}
-
-	parsedID, parsedPass, err := ParseCode(code)
-	if err != nil {
-		t.Fatalf("ParseCode(%q) error: %v", code, err)
-	}
-	if parsedID != codeID {
-		t.Errorf("codeID mismatch: got %q, want %q", parsedID, codeID)
-	}
-	if parsedPass != passphrase {
-		t.Errorf("passphrase mismatch: got %q, want %q", parsedPass, passphrase)
-	}
-}
-
-func TestParseCodeInvalid(t *testing.T) {
-	cases := []string{
-		"",
-		"nodelimiter",
-		"-",
-		"id-",
-		"-passphrase",
-		"id-oneword",
-	}
-	for _, c := range cases {
-		_, _, err := ParseCode(c)
-		if err == nil {
-			t.Errorf("ParseCode(%q) expected error, got nil", c)
-		}
-	}
-}
-
-func TestEncryptDecryptRoundTrip(t *testing.T) {
-	plaintext := []byte("this is a git patch\n--- a/file.go\n+++ b/file.go\n")
-
-	key, err := DeriveKey("alpha-bravo-charlie-delta")
-	if err != nil {
-		t.Fatalf("DeriveKey() error: %v", err)
-	}
-
-	ciphertext, err := Encrypt(plaintext, key)
-	if err != nil {
-		t.Fatalf("Encrypt() error: %v", err)
-	}
-
-	if bytes.Equal(plaintext, ciphertext) {
-		t.Error("ciphertext should not equal plaintext")
-	}
-
-	decrypted, err := Decrypt(ciphertext, key)
-	if err != nil {
-		t.Fatalf("Decrypt() error: %v", err)
-	}
-
-	if !bytes.Equal(plaintext, decrypted) {
-		t.Errorf("decrypted text mismatch:\ngot:  %q\nwant: %q", decrypted, plaintext)
-	}
-}
-
-func TestDecryptWrongPassphrase(t *testing.T) {
-	plaintext := []byte("secret patch data")
-
-	key1, _ := DeriveKey("correct-horse-battery-staple")
-	key2, _ := DeriveKey("wrong-passphrase-here-now")
-
-	ciphertext, err := Encrypt(plaintext, key1)
-	if err != nil {
-		t.Fatalf("Encrypt() error: %v", err)
-	}
-
-	_, err = Decrypt(ciphertext, key2)
-	if err == nil {
-		t.Error("expected decryption to fail with wrong key, but it succeeded")
-	}
-}
-
-func TestDeriveKeyDeterministic(t *testing.T) {
-	key1, _ := DeriveKey("same-passphrase-every-time")
-	key2, _ := DeriveKey("same-passphrase-every-time")
-
-	if !bytes.Equal(key1, key2) {
-		t.Error("same passphrase should produce the same key")
-	}
-}
-
-func TestDeriveKeyDifferentPassphrases(t *testing.T) {
-	key1, _ := DeriveKey("alpha-bravo-charlie-delta")
-	key2, _ := DeriveKey("echo-foxtrot-golf-hotel")
-
-	if bytes.Equal(key1, key2) {
-		t.Error("different passphrases should produce different keys")
-	}
-}
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGenerateCode(t *testing.T) {
+	code, codeID, passphrase, err := GenerateCode()
+	if err != nil {
+		t.Fatalf("GenerateCode() error: %v", err)
+	}
+	if len(codeID) != CodeIDLength {
+		t.Errorf("expected codeID length %d, got %d", CodeIDLength, len(codeID))
+	}
+	if passphrase == "" {
+		t.Error("expected non-empty passphrase")
+	}
+	if code == "" {
+		t.Error("expected non-empty code")
+	}
+	t.Logf("Generated code: %s", code)
+}
+
+func TestParseCode(t *testing.T) {
+	code, codeID, passphrase, err := GenerateCode()
+	if err != nil {
+		t.Fatalf("GenerateCode() error: %v", err)
+	}
+
+	parsedID, parsedPass, err := ParseCode(code)
+	if err != nil {
+		t.Fatalf("ParseCode(%q) error: %v", code, err)
+	}
+	if parsedID != codeID {
+		t.Errorf("codeID mismatch: got %q, want %q", parsedID, codeID)
+	}
+	if parsedPass != passphrase {
+		t.Errorf("passphrase mismatch: got %q, want %q", parsedPass, passphrase)
+	}
+}
+
+func TestGenerateCodeLang(t *testing.T) {
+	code, _, passphrase, err := GenerateCodeLang("de")
+	if err != nil {
+		t.Fatalf("GenerateCodeLang(de) error: %v", err)
+	}
+	if _, parsed, err := ParseCode(code); err != nil || parsed != passphrase {
+		t.Errorf("ParseCode(%q) = %q, %v; want %q", code, parsed, err, passphrase)
+	}
+
+	if _, _, _, err := GenerateCodeLang("xx"); err == nil {
+		t.Error("expected an error for an unknown language")
+	}
+}
+
+func TestParseCodeInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"nodelimiter",
+		"-",
+		"id-",
+		"-passphrase",
+		"id-oneword",
+	}
+	for _, c := range cases {
+		_, _, err := ParseCode(c)
+		if err == nil {
+			t.Errorf("ParseCode(%q) expected error, got nil", c)
+		}
+	}
+}
+
+func TestParseCodeParts(t *testing.T) {
+	tests := []struct {
+		codeID, words string
+		want          string
+		wantErr       string
+	}{
+		{codeID: "k7Xm9pQ2wR", words: "alpha-bravo-charlie-delta", want: "alpha-bravo-charlie-delta"},
+		{codeID: "k7Xm9pQ2wR", words: "  alpha bravo  charlie delta\n", want: "alpha-bravo-charlie-delta"},
+		{codeID: "k7Xm9pQ2wR", words: "alpha-bravo charlie-delta", want: "alpha-bravo-charlie-delta"},
+		{codeID: "k7Xm9pQ2wR", words: "alpha bravo charlie", wantErr: "expected 4 words, got 3"},
+		{codeID: "k7Xm9pQ2wR-alpha", words: "alpha bravo charlie delta", wantErr: "invalid code ID"},
+		{codeID: "", words: "alpha bravo charlie delta", wantErr: "invalid code ID"},
+	}
+	for _, tt := range tests {
+		codeID, passphrase, err := ParseCodeParts(tt.codeID, tt.words)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseCodeParts(%q, %q) error = %v, want %q", tt.codeID, tt.words, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || codeID != tt.codeID || passphrase != tt.want {
+			t.Errorf("ParseCodeParts(%q, %q) = %q, %q, %v; want %q", tt.codeID, tt.words, codeID, passphrase, err, tt.want)
+		}
+	}
+
+	// The halves of a generated code give back the same passphrase
+	code, codeID, passphrase, err := GenerateCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, got, err := ParseCodeParts(codeID, strings.TrimPrefix(code, codeID+CodeSep)); err != nil || got != passphrase {
+		t.Errorf("ParseCodeParts(%q) = %q, %v; want %q", code, got, err, passphrase)
+	}
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	plaintext := []byte("this is a git patch\n--- a/file.go\n+++ b/file.go\n")
+
+	key, err := DeriveKey("alpha-bravo-charlie-delta")
+	if err != nil {
+		t.Fatalf("DeriveKey() error: %v", err)
+	}
+
+	ciphertext, err := Encrypt(plaintext, key)
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	if bytes.Equal(plaintext, ciphertext) {
+		t.Error("ciphertext should not equal plaintext")
+	}
+
+	decrypted, err := Decrypt(ciphertext, key)
+	if err != nil {
+		t.Fatalf("Decrypt() error: %v", err)
+	}
+
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("decrypted text mismatch:\ngot:  %q\nwant: %q", decrypted, plaintext)
+	}
+}
+
+func TestDecryptWrongPassphrase(t *testing.T) {
+	plaintext := []byte("secret patch data")
+
+	key1, _ := DeriveKey("correct-horse-battery-staple")
+	key2, _ := DeriveKey("wrong-passphrase-here-now")
+
+	ciphertext, err := Encrypt(plaintext, key1)
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	_, err = Decrypt(ciphertext, key2)
+	if err == nil {
+		t.Error("expected decryption to fail with wrong key, but it succeeded")
+	} else if !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("error %v should match ErrDecryptionFailed", err)
+	}
+}
+
+func TestDeriveKeyDeterministic(t *testing.T) {
+	key1, _ := DeriveKey("same-passphrase-every-time")
+	key2, _ := DeriveKey("same-passphrase-every-time")
+
+	if !bytes.Equal(key1, key2) {
+		t.Error("same passphrase should produce the same key")
+	}
+}
+
+func TestDeriveKeyDifferentPassphrases(t *testing.T) {
+	key1, _ := DeriveKey("alpha-bravo-charlie-delta")
+	key2, _ := DeriveKey("echo-foxtrot-golf-hotel")
+
+	if bytes.Equal(key1, key2) {
+		t.Error("different passphrases should produce different keys")
+	}
+}
+
+func TestDeriveKeyArgon2(t *testing.T) {
+	params := Argon2Params{Time: 1, Memory: 64, Threads: 1, Salt: []byte("0123456789abcdef")}
+	key1, err := DeriveKeyArgon2("alpha-bravo-charlie-delta", params)
+	if err != nil {
+		t.Fatalf("DeriveKeyArgon2 failed: %v", err)
+	}
+	key2, _ := DeriveKeyArgon2("alpha-bravo-charlie-delta", params)
+	if !bytes.Equal(key1, key2) || len(key1) != 32 {
+		t.Errorf("keys %x and %x should be the same 32 bytes", key1, key2)
+	}
+
+	legacy, _ := DeriveKey("alpha-bravo-charlie-delta")
+	params.Salt = []byte("fedcba9876543210")
+	other, _ := DeriveKeyArgon2("alpha-bravo-charlie-delta", params)
+	if bytes.Equal(key1, other) || bytes.Equal(key1, legacy) {
+		t.Error("a different salt or KDF should give a different key")
+	}
+
+	if _, err := DeriveKeyArgon2("x", Argon2Params{Time: 1, Memory: 64, Threads: 1}); err == nil {
+		t.Error("expected an error without a salt")
+	}
+}
+
+func TestKDFHeader(t *testing.T) {
+	params, err := DefaultArgon2Params()
+	if err != nil {
+		t.Fatalf("DefaultArgon2Params failed: %v", err)
+	}
+	blob := append(KDFHeader(params), "ciphertext"...)
+	got, rest, ok, err := ParseKDFHeader(blob)
+	if err != nil || !ok {
+		t.Fatalf("ParseKDFHeader = %v, %v", ok, err)
+	}
+	if got.Time != params.Time || got.Memory != params.Memory || got.Threads != params.Threads || !bytes.Equal(got.Salt, params.Salt) {
+		t.Errorf("params = %+v, want %+v", got, params)
+	}
+	if string(rest) != "ciphertext" {
+		t.Errorf("rest = %q", rest)
+	}
+
+	// A legacy blob is left alone
+	legacy := []byte("a random nonce and some ciphertext")
+	if _, rest, ok, err := ParseKDFHeader(legacy); ok || err != nil || !bytes.Equal(rest, legacy) {
+		t.Errorf("legacy blob: ok = %v, err = %v", ok, err)
+	}
+
+	unknown := bytes.Clone(blob)
+	unknown[len(KDFMagic)] = 9
+	if _, _, ok, err := ParseKDFHeader(unknown); !ok || err == nil || !strings.Contains(err.Error(), "version 9") {
+		t.Errorf("unknown version: ok = %v, err = %v", ok, err)
+	}
+
+	params.Memory = 1 << 30
+	if _, _, _, err := ParseKDFHeader(append(KDFHeader(params), "x"...)); err == nil {
+		t.Error("expected costs over the limit to be rejected")
+	}
+}
+
+func TestIsWeakPassphrase(t *testing.T) {
+	tests := []struct {
+		passphrase string
+		want       bool
+	}{
+		{"", true},
+		{"hunter2", true},
+		{"elevenchars", true},
+		{"twelve chars", false},
+		{"correct horse battery staple", false},
+		{"ééééééééééé", true}, // 11 runes, 22 bytes
+	}
+	for _, tt := range tests {
+		if got := IsWeakPassphrase(tt.passphrase); got != tt.want {
+			t.Errorf("IsWeakPassphrase(%q) = %v, want %v", tt.passphrase, got, tt.want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	// SHA-256 of the empty string
+	if got := Fingerprint(nil); got != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("Fingerprint(nil) = %s", got)
+	}
+	if Fingerprint([]byte("a")) == Fingerprint([]byte("b")) {
+		t.Error("different inputs should have different fingerprints")
+	}
+}
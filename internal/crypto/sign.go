@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// publicKeyPrefix starts a public key in its one-line text form, which is
+// what "git-share keygen" prints and "receive --verify" takes.
+const publicKeyPrefix = "git-share-ed25519 "
+
+// ErrBadSignature is returned by Verify when a signature doesn't match the
+// data and key.
+var ErrBadSignature = errors.New("signature does not match")
+
+// GenerateSigningKey creates an Ed25519 key pair for signing patches.
+func GenerateSigningKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating signing key: %w", err)
+	}
+	return pub, priv, nil
+}
+
+// MarshalPrivateKey encodes a private key as a PKCS #8 PEM block, the
+// format OpenSSL also reads.
+func MarshalPrivateKey(priv ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("encoding private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKey decodes a private key written by MarshalPrivateKey.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("not a PEM private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is %T, not Ed25519", key)
+	}
+	return priv, nil
+}
+
+// FormatPublicKey returns a public key's one-line text form,
+// "git-share-ed25519 <base64>".
+func FormatPublicKey(pub ed25519.PublicKey) string {
+	return publicKeyPrefix + base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey decodes a public key from FormatPublicKey, or from its
+// base64 part alone.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), publicKeyPrefix)
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: expected %q followed by %d base64-encoded bytes", strings.TrimSpace(publicKeyPrefix), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// KeyFingerprint returns a short identifier for a public key, for showing
+// who signed a patch.
+func KeyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + hex.EncodeToString(sum[:8])
+}
+
+// Sign signs data and returns the base64-encoded signature.
+func Sign(priv ed25519.PrivateKey, data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+}
+
+// Verify checks a signature from Sign. It returns ErrBadSignature if the
+// signature is malformed or doesn't match.
+func Verify(pub ed25519.PublicKey, data []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(pub, data, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, priv, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("diff --git a/x b/x\n")
+	sig := Sign(priv, data)
+
+	if err := Verify(pub, data, sig); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	if err := Verify(pub, []byte("diff --git a/y b/y\n"), sig); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for changed data, got %v", err)
+	}
+	other, _, _ := GenerateSigningKey()
+	if err := Verify(other, data, sig); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for another key, got %v", err)
+	}
+	if err := Verify(pub, data, "not base64!"); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for a malformed signature, got %v", err)
+	}
+}
+
+func TestSigningKeyEncoding(t *testing.T) {
+	pub, priv, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pemData, err := MarshalPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParsePrivateKey(pemData)
+	if err != nil || !parsed.Equal(priv) {
+		t.Fatalf("ParsePrivateKey = %v, %v; want the original key", parsed, err)
+	}
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Error("expected an error for a file that isn't a key")
+	}
+
+	line := FormatPublicKey(pub)
+	if !strings.HasPrefix(line, "git-share-ed25519 ") {
+		t.Errorf("FormatPublicKey = %q", line)
+	}
+	for _, s := range []string{line, line + "\n", strings.TrimPrefix(line, "git-share-ed25519 ")} {
+		got, err := ParsePublicKey(s)
+		if err != nil || !got.Equal(pub) {
+			t.Errorf("ParsePublicKey(%q) = %v, %v; want the original key", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "git-share-ed25519 AAAA", "git-share-ed25519 !!"} {
+		if _, err := ParsePublicKey(s); err == nil {
+			t.Errorf("ParsePublicKey(%q) should fail", s)
+		}
+	}
+}
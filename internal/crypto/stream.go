@@ -0,0 +1,229 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Streaming wire format:
+//
+//	magic    4 bytes   "GSS1"
+//	prefix  16 bytes   random, per message
+//	frames  ...        repeated: length (4 bytes, big-endian) || ciphertext
+//
+// Each frame seals at most StreamChunkSize bytes of plaintext with
+// XChaCha20-Poly1305. The 24-byte nonce is prefix || counter, where counter
+// is the 8-byte big-endian frame index, so frames cannot be reordered or
+// dropped without failing authentication. The last frame is sealed with
+// additional data 0x01 (all others use 0x00), so truncating the stream at a
+// frame boundary is detected too.
+const (
+	// StreamMagic identifies a stream produced by EncryptStream.
+	StreamMagic = "GSS1"
+	// StreamChunkSize is the maximum plaintext size of a frame.
+	StreamChunkSize = 64 * 1024
+	// StreamThreshold is the plaintext size above which EncryptBlob
+	// encrypts as a stream.
+	StreamThreshold = 1024 * 1024
+
+	streamPrefixSize = 16
+)
+
+var (
+	adMiddle = []byte{0}
+	adFinal  = []byte{1}
+)
+
+// EncryptStream encrypts r into w using the framed format described above.
+func EncryptStream(r io.Reader, w io.Writer, key []byte) error {
+	return encryptStream(r, w, key, StreamChunkSize)
+}
+
+// DecryptStream decrypts a stream produced by EncryptStream from r into w.
+// Frames are written as they are authenticated; an error is returned if the
+// stream is tampered with or truncated, in which case the output written so
+// far must be discarded.
+func DecryptStream(r io.Reader, w io.Writer, key []byte) error {
+	return decryptStream(r, w, key, StreamChunkSize)
+}
+
+// EncryptBlob encrypts plaintext with Encrypt, or with EncryptStream if it
+// is larger than StreamThreshold, so a large patch is sealed a frame at a
+// time instead of in one more copy of the whole plaintext. DecryptBlob
+// tells the two formats apart.
+func EncryptBlob(plaintext, key []byte) ([]byte, error) {
+	if len(plaintext) <= StreamThreshold {
+		return Encrypt(plaintext, key)
+	}
+	var buf bytes.Buffer
+	buf.Grow(StreamSize(len(plaintext)))
+	if err := EncryptStream(bytes.NewReader(plaintext), &buf, key); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecryptBlob decrypts a blob from EncryptBlob, whichever format it is in.
+// A blob from Encrypt starts with a random nonce, which may happen to look
+// like the stream magic, so a blob that fails as a stream is tried as that
+// too. Failures match ErrDecryptionFailed.
+func DecryptBlob(data, key []byte) ([]byte, error) {
+	if !IsStream(data) {
+		return Decrypt(data, key)
+	}
+	var buf bytes.Buffer
+	streamErr := DecryptStream(bytes.NewReader(data), &buf, key)
+	if streamErr == nil {
+		return buf.Bytes(), nil
+	}
+	if plaintext, err := Decrypt(data, key); err == nil {
+		return plaintext, nil
+	}
+	if !errors.Is(streamErr, ErrDecryptionFailed) {
+		streamErr = fmt.Errorf("%w: %w", ErrDecryptionFailed, streamErr)
+	}
+	return nil, streamErr
+}
+
+// StreamSize returns the size of the stream EncryptStream makes from n
+// bytes of plaintext.
+func StreamSize(n int) int {
+	// Full chunks, then a shorter final one, which is empty for no plaintext
+	frames := n / StreamChunkSize
+	if n%StreamChunkSize != 0 || n == 0 {
+		frames++
+	}
+	return len(StreamMagic) + streamPrefixSize + n + frames*(4+chacha20poly1305.Overhead)
+}
+
+// IsStream reports whether data starts with the stream magic.
+func IsStream(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(StreamMagic))
+}
+
+func encryptStream(r io.Reader, w io.Writer, key []byte, chunkSize int) error {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return fmt.Errorf("creating cipher: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce[:streamPrefixSize]); err != nil {
+		return fmt.Errorf("generating nonce prefix: %w", err)
+	}
+	if _, err := io.WriteString(w, StreamMagic); err != nil {
+		return err
+	}
+	if _, err := w.Write(nonce[:streamPrefixSize]); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, chunkSize)
+	plain := make([]byte, chunkSize)
+	sealed := make([]byte, 0, chunkSize+aead.Overhead())
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(br, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("reading plaintext: %w", err)
+		}
+		final := n < chunkSize
+		if !final {
+			// A full chunk is only the last one if nothing follows it
+			if _, err := br.Peek(1); err == io.EOF {
+				final = true
+			}
+		}
+
+		ad := adMiddle
+		if final {
+			ad = adFinal
+		}
+		binary.BigEndian.PutUint64(nonce[streamPrefixSize:], counter)
+		sealed = aead.Seal(sealed[:0], nonce, plain[:n], ad)
+
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+		if _, err := w.Write(length[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+func decryptStream(r io.Reader, w io.Writer, key []byte, chunkSize int) error {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return fmt.Errorf("creating cipher: %w", err)
+	}
+
+	header := make([]byte, len(StreamMagic)+streamPrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return errors.New("stream too short")
+	}
+	if !IsStream(header) {
+		return errors.New("not an encrypted stream")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(StreamMagic):])
+
+	maxFrame := chunkSize + aead.Overhead()
+	frame := make([]byte, maxFrame)
+	plain := make([]byte, 0, chunkSize)
+	for counter := uint64(0); ; counter++ {
+		var length [4]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			if err == io.EOF {
+				return errors.New("stream truncated: missing final frame")
+			}
+			return fmt.Errorf("reading frame: %w", err)
+		}
+		n := int(binary.BigEndian.Uint32(length[:]))
+		if n < aead.Overhead() || n > maxFrame {
+			return fmt.Errorf("invalid frame length %d", n)
+		}
+		if _, err := io.ReadFull(r, frame[:n]); err != nil {
+			return fmt.Errorf("stream truncated: %w", err)
+		}
+
+		binary.BigEndian.PutUint64(nonce[streamPrefixSize:], counter)
+		final, out, err := openFrame(aead, nonce, frame[:n], plain[:0])
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		if final {
+			var extra [1]byte
+			if n, _ := r.Read(extra[:]); n > 0 {
+				return errors.New("unexpected data after final frame")
+			}
+			return nil
+		}
+	}
+}
+
+// openFrame authenticates a frame as a middle frame or, failing that, as the
+// final one.
+func openFrame(aead cipher.AEAD, nonce, frame, dst []byte) (final bool, plain []byte, err error) {
+	if plain, err = aead.Open(dst, nonce, frame, adMiddle); err == nil {
+		return false, plain, nil
+	}
+	if plain, err = aead.Open(dst, nonce, frame, adFinal); err == nil {
+		return true, plain, nil
+	}
+	return false, nil, err
+}
@@ -0,0 +1,157 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const testChunkSize = 16
+
+func encryptTestStream(t *testing.T, plaintext, key []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encryptStream(bytes.NewReader(plaintext), &buf, key, testChunkSize); err != nil {
+		t.Fatalf("encryptStream() error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// splitFrames returns the stream header and its frames, each including its length prefix.
+func splitFrames(t *testing.T, stream []byte) ([]byte, [][]byte) {
+	t.Helper()
+	header := stream[:len(StreamMagic)+streamPrefixSize]
+	rest := stream[len(header):]
+	var frames [][]byte
+	for len(rest) > 0 {
+		n := 4 + int(binary.BigEndian.Uint32(rest[:4]))
+		frames = append(frames, rest[:n])
+		rest = rest[n:]
+	}
+	return header, frames
+}
+
+func joinFrames(header []byte, frames ...[]byte) []byte {
+	return append(append([]byte{}, header...), bytes.Join(frames, nil)...)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, _ := DeriveKey("alpha-bravo-charlie-delta")
+
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 3 * testChunkSize, 100} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		stream := encryptTestStream(t, plaintext, key)
+		if !IsStream(stream) {
+			t.Fatalf("size %d: stream is missing its magic", size)
+		}
+
+		var out bytes.Buffer
+		if err := decryptStream(bytes.NewReader(stream), &out, key, testChunkSize); err != nil {
+			t.Fatalf("size %d: decryptStream() error: %v", size, err)
+		}
+		if !bytes.Equal(out.Bytes(), plaintext) {
+			t.Errorf("size %d: round trip mismatch", size)
+		}
+	}
+}
+
+func TestStreamRoundTripDefaultChunkSize(t *testing.T) {
+	key, _ := DeriveKey("alpha-bravo-charlie-delta")
+	plaintext := bytes.Repeat([]byte("diff --git a/x b/x\n"), 10000)
+
+	var stream, out bytes.Buffer
+	if err := EncryptStream(bytes.NewReader(plaintext), &stream, key); err != nil {
+		t.Fatalf("EncryptStream() error: %v", err)
+	}
+	if err := DecryptStream(&stream, &out, key); err != nil {
+		t.Fatalf("DecryptStream() error: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Error("round trip mismatch")
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key, _ := DeriveKey("alpha-bravo-charlie-delta")
+	plaintext := bytes.Repeat([]byte("0123456789abcdef"), 3) // exactly three frames
+	header, frames := splitFrames(t, encryptTestStream(t, plaintext, key))
+	if len(frames) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(frames))
+	}
+
+	wrongKey, _ := DeriveKey("echo-foxtrot-golf-hotel")
+	cases := []struct {
+		name   string
+		stream []byte
+		key    []byte
+	}{
+		{"reordered", joinFrames(header, frames[1], frames[0], frames[2]), key},
+		{"dropped middle frame", joinFrames(header, frames[0], frames[2]), key},
+		{"truncated before final frame", joinFrames(header, frames[0], frames[1]), key},
+		{"duplicated frame", joinFrames(header, frames[0], frames[0], frames[1], frames[2]), key},
+		{"trailing data", append(joinFrames(header, frames...), 0), key},
+		{"wrong key", joinFrames(header, frames...), wrongKey},
+		{"header only", header, key},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		if err := decryptStream(bytes.NewReader(c.stream), &out, c.key, testChunkSize); err == nil {
+			t.Errorf("%s: expected decryption to fail", c.name)
+		}
+	}
+}
+
+func TestEncryptBlob(t *testing.T) {
+	key, _ := DeriveKey("alpha-bravo-charlie-delta")
+	wrongKey, _ := DeriveKey("echo-foxtrot-golf-hotel")
+
+	for _, size := range []int{100, StreamThreshold, StreamThreshold + 1, 2 * StreamThreshold} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		blob, err := EncryptBlob(plaintext, key)
+		if err != nil {
+			t.Fatalf("size %d: EncryptBlob() error: %v", size, err)
+		}
+		if wantStream := size > StreamThreshold; IsStream(blob) != wantStream {
+			t.Errorf("size %d: IsStream = %v, want %v", size, !wantStream, wantStream)
+		}
+		if IsStream(blob) && len(blob) != StreamSize(size) {
+			t.Errorf("size %d: stream is %d bytes, StreamSize says %d", size, len(blob), StreamSize(size))
+		}
+		got, err := DecryptBlob(blob, key)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("size %d: DecryptBlob() = %d bytes, %v; want the plaintext", size, len(got), err)
+		}
+		if _, err := DecryptBlob(blob, wrongKey); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("size %d: DecryptBlob with the wrong key: err = %v, want ErrDecryptionFailed", size, err)
+		}
+	}
+
+	// A one-shot blob whose nonce starts like the stream magic
+	blob, _ := Encrypt([]byte("diff"), key)
+	copy(blob, StreamMagic)
+	aead, _ := chacha20poly1305.NewX(key)
+	blob = aead.Seal(blob[:aead.NonceSize()], blob[:aead.NonceSize()], []byte("diff"), nil)
+	if got, err := DecryptBlob(blob, key); err != nil || string(got) != "diff" {
+		t.Errorf("DecryptBlob of a one-shot blob with a magic-like nonce = %q, %v", got, err)
+	}
+}
+
+func TestStreamSize(t *testing.T) {
+	key, _ := DeriveKey("alpha-bravo-charlie-delta")
+	for _, size := range []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3 * StreamChunkSize} {
+		var buf bytes.Buffer
+		if err := EncryptStream(bytes.NewReader(make([]byte, size)), &buf, key); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != StreamSize(size) {
+			t.Errorf("StreamSize(%d) = %d, want %d", size, StreamSize(size), buf.Len())
+		}
+	}
+}
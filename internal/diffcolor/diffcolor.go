@@ -0,0 +1,64 @@
+package diffcolor
+
+import (
+	"bytes"
+)
+
+// ANSI escape sequences used for highlighting.
+const (
+	reset  = "\x1b[0m"
+	bold   = "\x1b[1m"
+	red    = "\x1b[31m"
+	green  = "\x1b[32m"
+	cyan   = "\x1b[36m"
+	yellow = "\x1b[33m"
+)
+
+// Colorize returns a copy of a unified diff (or format-patch mbox) with ANSI
+// colors applied: file headers in bold, hunk headers in cyan, added lines in
+// green and removed lines in red. Line endings are preserved.
+func Colorize(patch []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(patch) + len(patch)/4)
+
+	inHunk := false
+	for len(patch) > 0 {
+		line := patch
+		rest := []byte(nil)
+		if i := bytes.IndexByte(patch, '\n'); i >= 0 {
+			line, rest = patch[:i+1], patch[i+1:]
+		}
+		patch = rest
+
+		content := bytes.TrimRight(line, "\r\n")
+		eol := line[len(content):]
+
+		color := ""
+		switch {
+		case bytes.HasPrefix(content, []byte("diff --git ")):
+			inHunk = false
+			color = bold
+		case !inHunk && (bytes.HasPrefix(content, []byte("--- ")) || bytes.HasPrefix(content, []byte("+++ "))):
+			color = bold
+		case bytes.HasPrefix(content, []byte("@@")):
+			inHunk = true
+			color = cyan
+		case bytes.HasPrefix(content, []byte("From ")) && !inHunk:
+			color = yellow
+		case inHunk && bytes.HasPrefix(content, []byte("+")):
+			color = green
+		case inHunk && bytes.HasPrefix(content, []byte("-")):
+			color = red
+		}
+
+		if color == "" || len(content) == 0 {
+			out.Write(line)
+			continue
+		}
+		out.WriteString(color)
+		out.Write(content)
+		out.WriteString(reset)
+		out.Write(eol)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,62 @@
+package diffcolor
+
+import (
+	"strings"
+	"testing"
+)
+
+const samplePatch = `diff --git a/file.txt b/file.txt
+index 1234567..89abcde 100644
+--- a/file.txt
++++ b/file.txt
+@@ -1,3 +1,3 @@
+ context
+-old line
++new line
+ more context
+`
+
+func TestColorize(t *testing.T) {
+	out := string(Colorize([]byte(samplePatch)))
+	lines := strings.Split(out, "\n")
+
+	tests := []struct {
+		line int
+		want string
+	}{
+		{0, bold + "diff --git a/file.txt b/file.txt" + reset},
+		{1, "index 1234567..89abcde 100644"},
+		{2, bold + "--- a/file.txt" + reset},
+		{3, bold + "+++ b/file.txt" + reset},
+		{4, cyan + "@@ -1,3 +1,3 @@" + reset},
+		{5, " context"},
+		{6, red + "-old line" + reset},
+		{7, green + "+new line" + reset},
+		{8, " more context"},
+	}
+	for _, tt := range tests {
+		if lines[tt.line] != tt.want {
+			t.Errorf("line %d = %q, want %q", tt.line, lines[tt.line], tt.want)
+		}
+	}
+}
+
+func TestColorizeHunkLinesLookingLikeHeaders(t *testing.T) {
+	patch := "@@ -1,2 +1,2 @@\n--- removed dashes\n+++ added pluses\n"
+	out := string(Colorize([]byte(patch)))
+
+	if !strings.Contains(out, red+"--- removed dashes"+reset) {
+		t.Errorf("removed line inside a hunk should be red, got %q", out)
+	}
+	if !strings.Contains(out, green+"+++ added pluses"+reset) {
+		t.Errorf("added line inside a hunk should be green, got %q", out)
+	}
+}
+
+func TestColorizePreservesCRLF(t *testing.T) {
+	out := string(Colorize([]byte("@@ -1 +1 @@\r\n-a\r\n+b\r\n")))
+	want := cyan + "@@ -1 +1 @@" + reset + "\r\n" + red + "-a" + reset + "\r\n" + green + "+b" + reset + "\r\n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
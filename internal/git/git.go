@@ -1,165 +1,797 @@
-package git
-
-import (
-	"bytes"
-	"errors"
-	"fmt"
-	"os/exec"
-	"strings"
-)
-
-// FindRepoRoot returns the root directory of the current git repository.
-func FindRepoRoot() (string, error) {
-	out, err := runGit("rev-parse", "--show-toplevel")
-	if err != nil {
-		return "", fmt.Errorf("not a git repository (or any parent): %w", err)
-	}
-	return strings.TrimSpace(out), nil
-}
-
-// GetDiff returns the diff of uncommitted changes in the working tree.
-func GetDiff() ([]byte, error) {
-	out, err := runGit("diff", "--binary")
-	if err != nil {
-		return nil, fmt.Errorf("getting diff: %w", err)
-	}
-	if out == "" {
-		stagedOut, _ := runGit("diff", "--cached", "--name-only")
-		if stagedOut != "" {
-			return nil, errors.New("no uncommitted changes found (did you mean to use 'git-share --staged'?)")
-		}
-		return nil, errors.New("no uncommitted changes found")
-	}
-	return []byte(out), nil
-}
-
-// GetStagedDiff returns the diff of staged changes.
-func GetStagedDiff() ([]byte, error) {
-	out, err := runGit("diff", "--cached", "--binary")
-	if err != nil {
-		return nil, fmt.Errorf("getting staged diff: %w", err)
-	}
-	if out == "" {
-		unstagedOut, _ := runGit("diff", "--name-only")
-		if unstagedOut != "" {
-			return nil, errors.New("no staged changes found (did you mean to use 'git-share'?)")
-		}
-		return nil, errors.New("no staged changes found")
-	}
-	return []byte(out), nil
-}
-
-// GetCommitPatch returns the patch for a commit or commit range using format-patch.
-// Accepts: single SHA, branch name, HEAD~3.., commit1..commit2, etc.
-func GetCommitPatch(commitRef string) ([]byte, error) {
-	var out string
-	var err error
-
-	// If it looks like a range (contains ".."), use it directly
-	if strings.Contains(commitRef, "..") {
-		out, err = runGit("format-patch", "--stdout", commitRef)
-	} else {
-		// Single ref — verify it's a valid commit first
-		_, verifyErr := runGit("cat-file", "-t", commitRef)
-		if verifyErr != nil {
-			return nil, fmt.Errorf("invalid commit reference %q (not found or not a commit)", commitRef)
-		}
-		// Use -1 to get exactly that one commit as a patch
-		out, err = runGit("format-patch", "--stdout", "-1", commitRef)
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("getting commit patch for %q: %w", commitRef, err)
-	}
-	if out == "" {
-		return nil, fmt.Errorf("no commits found for %q", commitRef)
-	}
-	return []byte(out), nil
-}
-
-// ApplyPatch applies a patch to the current repository.
-// If forceAm is true, it uses `git am` to create a commit.
-// Otherwise, it uses `git apply` to only update the working tree/index.
-func ApplyPatch(patch []byte, forceAm bool) error {
-	if forceAm {
-		// Use git am to create a commit (cherry-pick style)
-		err := runGitWithStdin(patch, "am")
-		if err != nil {
-			// Abort any failed am
-			_ = runGitWithStdin(nil, "am", "--abort")
-			return fmt.Errorf("failed to apply commit via 'git am': %w", err)
-		}
-		return nil
-	}
-
-	// Use git apply (works for both simple diffs and format-patch output, but only applies changes)
-	err := runGitWithStdin(patch, "apply")
-	if err != nil {
-		return fmt.Errorf("failed to apply patch via 'git apply': %w", err)
-	}
-
-	return nil
-}
-
-// PatchStats returns a human-readable summary of what a patch would change.
-func PatchStats(patch []byte) (string, error) {
-	out, err := runGitWithStdinOutput(patch, "apply", "--stat")
-	if err != nil {
-		// Try diffstat format for format-patch output
-		out, err = runGitWithStdinOutput(patch, "apply", "--stat", "--check")
-		if err != nil {
-			return "", nil // silently ignore, stats are optional
-		}
-	}
-	return strings.TrimRight(out, "\r\n "), nil
-}
-
-func runGit(args ...string) (string, error) {
-	cmd := exec.Command("git", args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg == "" {
-			errMsg = err.Error()
-		}
-		return "", fmt.Errorf("%s", errMsg)
-	}
-	return stdout.String(), nil
-}
-
-func runGitWithStdin(stdin []byte, args ...string) error {
-	cmd := exec.Command("git", args...)
-	if stdin != nil {
-		cmd.Stdin = bytes.NewReader(stdin)
-	}
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg == "" {
-			errMsg = err.Error()
-		}
-		return fmt.Errorf("%s", errMsg)
-	}
-	return nil
-}
-
-func runGitWithStdinOutput(stdin []byte, args ...string) (string, error) {
-	cmd := exec.Command("git", args...)
-	if stdin != nil {
-		cmd.Stdin = bytes.NewReader(stdin)
-	}
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg == "" {
-			errMsg = err.Error()
-		}
-		return "", fmt.Errorf("%s", errMsg)
-	}
-	return stdout.String(), nil
-}
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ErrAlreadyApplied is returned by ApplyPatch when the patch does not apply
+// because its changes are already present in the working tree.
+var ErrAlreadyApplied = errors.New("these changes appear to already be present")
+
+// ErrNoUpstream is returned by UpstreamRef when the current branch does not
+// track a remote branch.
+var ErrNoUpstream = errors.New("the current branch has no upstream configured")
+
+// ErrUnsafePath is returned by ApplyPatchWithOptions when the patch touches
+// a path outside the repository, such as "../file", and that was not allowed.
+var ErrUnsafePath = errors.New("the patch writes outside the repository")
+
+// ErrPatchConflict is matched (with errors.Is) by ApplyPatchWithOptions
+// errors for a well-formed patch that doesn't match the files it changes,
+// including a *ConflictError.
+var ErrPatchConflict = errors.New("the patch does not apply to the current files")
+
+// ErrCorruptPatch is matched by ApplyPatchWithOptions errors for a patch git
+// can't parse, such as one that was truncated or mangled in transit.
+var ErrCorruptPatch = errors.New("the patch appears to be corrupted")
+
+// ErrEmptyPatch is returned by ApplyPatchWithOptions for a patch with no
+// changes in it.
+var ErrEmptyPatch = errors.New("the patch is empty")
+
+// ErrNoChanges is matched (with errors.Is) by the errors returned when there
+// is nothing to share: a clean tree, an empty range, or nothing to commit.
+var ErrNoChanges = errors.New("no changes to share")
+
+// noChangesError keeps a specific message while matching ErrNoChanges.
+type noChangesError string
+
+func (e noChangesError) Error() string        { return string(e) }
+func (e noChangesError) Is(target error) bool { return target == ErrNoChanges }
+
+// ConflictError is returned by ApplyPatchWithOptions when a three-way apply
+// leaves conflict markers in the working tree for the user to resolve.
+type ConflictError struct {
+	Files []string // paths with conflicts
+	Am    bool     // a git am session is waiting for "git am --continue"
+}
+
+func (e *ConflictError) Error() string {
+	msg := "the patch applied with conflicts in " + strings.Join(e.Files, ", ")
+	if e.Am {
+		return msg + "; resolve the conflict markers, git add the files, and run 'git am --continue' (or 'git am --abort')"
+	}
+	return msg + "; resolve the conflict markers and git add the files"
+}
+
+func (e *ConflictError) Is(target error) bool { return target == ErrPatchConflict }
+
+// conflictedFiles returns the paths with unmerged entries in the index.
+func conflictedFiles() []string {
+	out, err := runGit("diff", "--name-only", "--diff-filter=U")
+	if err != nil || out == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(out), "\n")
+}
+
+// FindRepoRoot returns the root directory of the current git repository.
+func FindRepoRoot() (string, error) {
+	out, err := runGit("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("not a git repository (or any parent): %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// GetDiff returns the diff of uncommitted changes in the working tree,
+// limited to the given pathspecs if there are any.
+func GetDiff(paths ...string) ([]byte, error) {
+	if err := checkPathspecs(paths); err != nil {
+		return nil, err
+	}
+	out, err := runGit(withPathspecs([]string{"diff", "--binary", "--no-textconv"}, paths)...)
+	if err != nil {
+		return nil, fmt.Errorf("getting diff: %w", err)
+	}
+	if out == "" {
+		stagedOut, _ := runGit(withPathspecs([]string{"diff", "--cached", "--name-only"}, paths)...)
+		if stagedOut != "" {
+			return nil, noChangesError("no uncommitted changes found (did you mean to use 'git-share --staged'?)")
+		}
+		return nil, noChangesError("no uncommitted changes found")
+	}
+	return []byte(out), nil
+}
+
+// GetStagedDiff returns the diff of staged changes, limited to the given
+// pathspecs if there are any.
+func GetStagedDiff(paths ...string) ([]byte, error) {
+	if err := checkPathspecs(paths); err != nil {
+		return nil, err
+	}
+	out, err := runGit(withPathspecs([]string{"diff", "--cached", "--binary", "--no-textconv"}, paths)...)
+	if err != nil {
+		return nil, fmt.Errorf("getting staged diff: %w", err)
+	}
+	if out == "" {
+		unstagedOut, _ := runGit(withPathspecs([]string{"diff", "--name-only"}, paths)...)
+		if unstagedOut != "" {
+			return nil, noChangesError("no staged changes found (did you mean to use 'git-share'?)")
+		}
+		return nil, noChangesError("no staged changes found")
+	}
+	return []byte(out), nil
+}
+
+// GetTextconvDiff returns the uncommitted changes, the staged ones, or with
+// all set both together against HEAD, like GetDiff, GetStagedDiff and
+// GetDiffFromHead, but with files that have a textconv filter in
+// .gitattributes shown through it. That makes binary formats reviewable,
+// but the diff no longer describes the files' real content, so git can't
+// apply it.
+func GetTextconvDiff(staged, all bool, paths ...string) ([]byte, error) {
+	if err := checkPathspecs(paths); err != nil {
+		return nil, err
+	}
+	args := []string{"diff", "--textconv"}
+	switch {
+	case all:
+		args = append(args, "HEAD")
+	case staged:
+		args = append(args, "--cached")
+	}
+	out, err := runGit(withPathspecs(args, paths)...)
+	if err != nil {
+		return nil, fmt.Errorf("getting diff: %w", err)
+	}
+	if out == "" {
+		return nil, noChangesError("no uncommitted changes found")
+	}
+	return []byte(out), nil
+}
+
+// withPathspecs appends pathspecs to a git command after a "--".
+func withPathspecs(args, paths []string) []string {
+	if len(paths) == 0 {
+		return args
+	}
+	return append(append(args, "--"), paths...)
+}
+
+// checkPathspecs returns an error naming the first pathspec that matches no
+// tracked file, so a typo isn't mistaken for "no changes".
+func checkPathspecs(paths []string) error {
+	for _, path := range paths {
+		if _, err := runGit("ls-files", "--error-unmatch", "--", path); err != nil {
+			return fmt.Errorf("path %q does not match any file known to git", path)
+		}
+	}
+	return nil
+}
+
+// GetDiffFromHead returns the diff of all uncommitted changes, staged and
+// unstaged, against HEAD.
+func GetDiffFromHead() ([]byte, error) {
+	out, err := runGit("diff", "HEAD", "--binary", "--no-textconv")
+	if err != nil {
+		return nil, fmt.Errorf("getting diff from HEAD: %w", err)
+	}
+	if out == "" {
+		return nil, noChangesError("no uncommitted changes found")
+	}
+	return []byte(out), nil
+}
+
+// GetUntrackedDiff returns a patch that creates the untracked files in the
+// working tree, or nil if there are none. Ignored files are left out. The
+// index is not touched, so the files stay untracked.
+func GetUntrackedDiff() ([]byte, error) {
+	root, err := FindRepoRoot()
+	if err != nil {
+		return nil, err
+	}
+	out, err := runGit("-C", root, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("listing untracked files: %w", err)
+	}
+
+	var patch []byte
+	for _, path := range strings.Split(strings.TrimSuffix(out, "\x00"), "\x00") {
+		if path == "" {
+			continue
+		}
+		diff, err := newFileDiff(root, path)
+		if err != nil {
+			return nil, fmt.Errorf("getting diff of untracked file %s: %w", path, err)
+		}
+		patch = append(patch, diff...)
+	}
+	return patch, nil
+}
+
+// newFileDiff diffs a file against /dev/null, which gives the patch that
+// creates it. git diff --no-index exits with 1 when there are differences.
+func newFileDiff(root, path string) ([]byte, error) {
+	cmd := exec.Command("git", "-C", root, "diff", "--no-index", "--binary", "--no-textconv", "--", "/dev/null", path)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// GetCommitPatch returns the patch for a commit or commit range using format-patch.
+// Accepts: single SHA, branch name, HEAD~3.., commit1..commit2, etc.
+func GetCommitPatch(commitRef string) ([]byte, error) {
+	var out string
+	var err error
+
+	// If it looks like a range (contains ".."), use it directly
+	if strings.Contains(commitRef, "..") {
+		out, err = runGit("format-patch", "--stdout", commitRef)
+	} else {
+		// Single ref — verify it's a valid commit first
+		_, verifyErr := runGit("cat-file", "-t", commitRef)
+		if verifyErr != nil {
+			return nil, fmt.Errorf("invalid commit reference %q (not found or not a commit)", commitRef)
+		}
+		// format-patch -1 on a merge would pick an older, non-merge commit
+		if mergeCount(commitRef, true) > 0 {
+			return nil, noChangesError(fmt.Sprintf("%q is a merge commit, which format-patch skips; "+
+				"to share the commits it merged, send %q, or use --base with the merge's first parent", commitRef, commitRef+"^1.."+commitRef))
+		}
+		// Use -1 to get exactly that one commit as a patch
+		out, err = runGit("format-patch", "--stdout", "-1", commitRef)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("getting commit patch for %q: %w", commitRef, err)
+	}
+	if out == "" {
+		// format-patch skips merges, so a range of only merges comes out empty
+		if merges := mergeCount(commitRef, false); merges > 0 {
+			return nil, noChangesError(fmt.Sprintf("%q only contains merge commits (%d), which format-patch skips; "+
+				"widen the range or use --base to include the commits they merged", commitRef, merges))
+		}
+		return nil, noChangesError(fmt.Sprintf("no commits found for %q", commitRef))
+	}
+	return []byte(out), nil
+}
+
+// mergeCount returns the number of merge commits in a revision range, or
+// whether a single commit is a merge (as 1 or 0) when noWalk is set.
+func mergeCount(rev string, noWalk bool) int {
+	args := []string{"rev-list", "--merges", "--count", rev}
+	if noWalk {
+		args = []string{"rev-list", "--merges", "--count", "--no-walk", rev}
+	}
+	out, err := runGit(args...)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(out))
+	return n
+}
+
+// CommitCount returns the number of commits reachable from ref, including
+// ref itself.
+func CommitCount(ref string) (int, error) {
+	out, err := runGit("rev-list", "--count", ref)
+	if err != nil {
+		return 0, fmt.Errorf("counting the commits in %q: %w", ref, err)
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+// ConfigValue returns the value of a git config key, such as
+// "git-share.server", or "" if it is not set.
+func ConfigValue(key string) (string, error) {
+	cmd := exec.Command("git", "config", "--get", key)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Exit status 1 means the key is not set
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+		errMsg := strings.TrimSpace(stderr.String())
+		if errMsg == "" {
+			errMsg = err.Error()
+		}
+		return "", fmt.Errorf("reading git config %s: %s", key, errMsg)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Author returns who new commits are authored by, as "Name <email>",
+// from git config or $GIT_AUTHOR_NAME and $GIT_AUTHOR_EMAIL.
+func Author() (string, error) {
+	out, err := runGit("var", "GIT_AUTHOR_IDENT")
+	if err != nil {
+		return "", fmt.Errorf("finding the commit author (set git config user.name and user.email): %w", err)
+	}
+	// The ident ends with a timestamp and zone: "Name <email> 1700000000 +0100"
+	ident := strings.TrimSpace(out)
+	if i := strings.LastIndex(ident, ">"); i >= 0 {
+		ident = ident[:i+1]
+	}
+	return ident, nil
+}
+
+// GetShowPatch returns "git show" output for a single commit: its message
+// followed by the diff. Use SplitShow to separate the two for applying.
+func GetShowPatch(commitRef string) ([]byte, error) {
+	if _, err := runGit("cat-file", "-t", commitRef); err != nil {
+		return nil, fmt.Errorf("invalid commit reference %q (not found or not a commit)", commitRef)
+	}
+	out, err := runGit("show", "--binary", "--no-textconv", "--no-color", "--pretty=medium", commitRef+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("showing commit %q: %w", commitRef, err)
+	}
+	return []byte(out), nil
+}
+
+// GetStashPatch returns the changes saved in a stash entry, such as
+// "stash@{0}", as a diff against the commit the stash was made on.
+// Untracked files saved with "git stash -u" are not included.
+func GetStashPatch(ref string) ([]byte, error) {
+	if _, err := runGit("rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		return nil, fmt.Errorf("invalid stash reference %q (no such stash entry)", ref)
+	}
+	out, err := runGit("stash", "show", "-p", "--binary", "--no-textconv", "--no-color", ref)
+	if err != nil {
+		return nil, fmt.Errorf("showing stash %q: %w", ref, err)
+	}
+	if out == "" {
+		return nil, noChangesError(fmt.Sprintf("stash %q has no changes to tracked files", ref))
+	}
+	return []byte(out), nil
+}
+
+// SplitShow separates "git show" output into the commit header and message,
+// and the diff that follows them. The diff is empty for a commit without
+// changes.
+func SplitShow(show []byte) (message, diff []byte) {
+	marker := []byte("diff --git ")
+	if bytes.HasPrefix(show, marker) {
+		return nil, show
+	}
+	i := bytes.Index(show, append([]byte("\n"), marker...))
+	if i < 0 {
+		return show, nil
+	}
+	return show[:i+1], show[i+1:]
+}
+
+// Version returns the version of the git on the PATH, e.g. "2.43.0".
+func Version() (string, error) {
+	out, err := runGit("version")
+	if err != nil {
+		return "", fmt.Errorf("running git: %w", err)
+	}
+	return strings.TrimPrefix(strings.TrimSpace(out), "git version "), nil
+}
+
+// HeadCommit returns the full SHA of the commit HEAD points to. It fails in
+// a repository without commits.
+func HeadCommit() (string, error) {
+	out, err := runGit("rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("resolving HEAD: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// UpstreamRef returns the name of the branch the current branch tracks,
+// e.g. "origin/main".
+func UpstreamRef() (string, error) {
+	out, err := runGit("rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}")
+	if err != nil {
+		return "", ErrNoUpstream
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// CommitAll stages every change in the working tree, including new files,
+// and commits it with the given message. Returns the new commit's SHA. If
+// the commit fails, e.g. in a pre-commit hook, the index is put back as it
+// was, so the user's staged changes are not mixed with the rest.
+func CommitAll(message string) (string, error) {
+	saved, err := runGit("write-tree")
+	if err != nil {
+		return "", fmt.Errorf("saving the index: %w", err)
+	}
+	restore := func() { runGit("read-tree", strings.TrimSpace(saved)) }
+
+	if _, err := runGit("add", "-A"); err != nil {
+		restore()
+		return "", fmt.Errorf("staging changes: %w", err)
+	}
+	if _, err := runGit("diff", "--cached", "--quiet"); err == nil {
+		return "", noChangesError("nothing to commit, working tree clean")
+	}
+	if _, err := runGit("commit", "-q", "-m", message); err != nil {
+		restore()
+		return "", fmt.Errorf("creating commit: %w", err)
+	}
+	out, err := runGit("rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("resolving new commit: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// GetNotes returns the git notes attached to a commit, or "" if it has none.
+func GetNotes(commitRef string) (string, error) {
+	out, err := runGit("notes", "show", commitRef)
+	if err != nil {
+		if strings.Contains(err.Error(), "no note found") {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading notes for %q: %w", commitRef, err)
+	}
+	return out, nil
+}
+
+// AddNotes attaches notes to a commit, replacing any existing notes.
+func AddNotes(commitRef, notes string) error {
+	if err := runGitWithStdin([]byte(notes), "notes", "add", "-f", "-F", "-", commitRef); err != nil {
+		return fmt.Errorf("adding notes to %q: %w", commitRef, err)
+	}
+	return nil
+}
+
+// ApplyOptions controls how ApplyPatchWithOptions applies a patch.
+type ApplyOptions struct {
+	Commit  bool // use git am to create commits instead of git apply
+	Signoff bool // with Commit, add a Signed-off-by trailer for the current user
+
+	// AllowOutside lets a patch write outside the repository, retrying
+	// git apply with --unsafe-paths. It has no effect with Commit.
+	AllowOutside bool
+
+	// ThreeWay falls back to a three-way merge when the patch doesn't apply
+	// cleanly and the base blobs are available locally. Conflicts are left
+	// as markers in the working tree and reported as a *ConflictError.
+	// A three-way apply updates the index as well as the working tree.
+	ThreeWay bool
+}
+
+// ApplyPatch applies a patch to the current repository.
+// If forceAm is true, it uses `git am` to create a commit.
+// Otherwise, it uses `git apply` to only update the working tree/index.
+func ApplyPatch(patch []byte, forceAm bool) error {
+	return ApplyPatchWithOptions(patch, ApplyOptions{Commit: forceAm})
+}
+
+// ApplyPatchWithOptions applies a patch to the current repository.
+// Signoff only applies to commits and is ignored otherwise.
+func ApplyPatchWithOptions(patch []byte, opts ApplyOptions) error {
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return ErrEmptyPatch
+	}
+	if opts.Commit {
+		// Use git am to create a commit (cherry-pick style)
+		args := []string{"am"}
+		if opts.Signoff {
+			args = append(args, "--signoff")
+		}
+		if opts.ThreeWay {
+			args = append(args, "--3way")
+		}
+		err := runGitWithStdin(patch, args...)
+		if err != nil {
+			// Leave a conflicted am in progress for the user to resolve
+			if opts.ThreeWay {
+				if files := conflictedFiles(); len(files) > 0 {
+					return &ConflictError{Files: files, Am: true}
+				}
+			}
+			// Abort any failed am
+			_ = runGitWithStdin(nil, "am", "--abort")
+			if IsPatchApplied(patch) {
+				return ErrAlreadyApplied
+			}
+			return applyError("commit via 'git am'", err)
+		}
+		return nil
+	}
+
+	// Use git apply (works for both simple diffs and format-patch output, but only applies changes)
+	args := []string{"apply"}
+	if opts.ThreeWay {
+		args = append(args, "--3way")
+	}
+	err := runGitWithStdin(patch, args...)
+	if err != nil && isUnsafePathError(err) {
+		if !opts.AllowOutside {
+			return fmt.Errorf("%w (%v); pass --allow-outside if this is intended", ErrUnsafePath, err)
+		}
+		err = runGitWithStdin(patch, append(args, "--unsafe-paths")...)
+	}
+	if err != nil && opts.ThreeWay {
+		if files := conflictedFiles(); len(files) > 0 {
+			return &ConflictError{Files: files}
+		}
+	}
+	if err != nil {
+		if IsPatchApplied(patch) {
+			return ErrAlreadyApplied
+		}
+		return applyError("patch via 'git apply'", err)
+	}
+
+	return nil
+}
+
+// applyError wraps a failed git apply or git am with the error for its
+// kind, when git's message says what went wrong.
+func applyError(what string, err error) error {
+	wrapped := fmt.Errorf("failed to apply %s: %w", what, err)
+	if kind := applyFailureKind(err.Error()); kind != nil {
+		return fmt.Errorf("%w: %w", kind, wrapped)
+	}
+	return wrapped
+}
+
+// applyFailureKind maps git apply and git am messages to ErrCorruptPatch,
+// ErrEmptyPatch, or ErrPatchConflict, or nil for anything else.
+func applyFailureKind(msg string) error {
+	for _, m := range []string{"corrupt patch", "No valid patches in input", "patch with only garbage", "unrecognized input", "recount: unexpected line", "lacks filename information"} {
+		if strings.Contains(msg, m) {
+			return ErrCorruptPatch
+		}
+	}
+	if strings.Contains(msg, "Patch is empty") {
+		return ErrEmptyPatch
+	}
+	for _, m := range []string{"patch does not apply", "already exists in working directory", "already exists in index", "No such file or directory", "does not exist in index", "does not match index"} {
+		if strings.Contains(msg, m) {
+			return ErrPatchConflict
+		}
+	}
+	return nil
+}
+
+// isUnsafePathError reports whether git apply refused a path outside the
+// working tree, which --unsafe-paths overrides.
+func isUnsafePathError(err error) bool {
+	return strings.Contains(err.Error(), "invalid path '")
+}
+
+// IsPatchApplied reports whether the changes in a patch are already present
+// in the working tree, i.e. the patch applies cleanly in reverse.
+func IsPatchApplied(patch []byte) bool {
+	return runGitWithStdin(patch, "apply", "--reverse", "--check") == nil
+}
+
+// CheckReverse checks that a patch is already applied to the working tree, or
+// to the index when cached is set, as a diff of local changes should be.
+// The error says which part doesn't match.
+func CheckReverse(patch []byte, cached bool) error {
+	args := []string{"apply", "--check", "--reverse"}
+	if cached {
+		args = append(args, "--cached")
+	}
+	return runGitWithStdin(patch, args...)
+}
+
+// PatchStats returns a human-readable summary of what a patch would change.
+func PatchStats(patch []byte) (string, error) {
+	out, err := runGitWithStdinOutput(patch, "apply", "--stat")
+	if err != nil {
+		// Try diffstat format for format-patch output
+		out, err = runGitWithStdinOutput(patch, "apply", "--stat", "--check")
+		if err != nil {
+			return "", nil // silently ignore, stats are optional
+		}
+	}
+	return strings.TrimRight(out, "\r\n "), nil
+}
+
+// FileStat is the line count change of one file in a patch.
+type FileStat struct {
+	Path    string
+	Added   int
+	Deleted int
+	Binary  bool // line counts are not available
+}
+
+// Summary is a structured form of PatchStats.
+type Summary struct {
+	Files   []FileStat
+	Added   int
+	Deleted int
+}
+
+// PatchSummary returns per-file and total line counts for a patch, using
+// git apply --numstat.
+func PatchSummary(patch []byte) (Summary, error) {
+	out, err := runGitWithStdinOutput(patch, "apply", "--numstat", "-z")
+	if err != nil {
+		return Summary{}, fmt.Errorf("reading patch stats: %w", err)
+	}
+	return parseNumstat(out), nil
+}
+
+// parseNumstat parses "git apply --numstat -z" output. Each record is
+// "added\tdeleted\tpath\x00", or "added\tdeleted\t\x00old\x00new\x00" for a
+// rename. Binary files have "-" for both counts.
+func parseNumstat(out string) Summary {
+	var s Summary
+	fields := strings.Split(out, "\x00")
+	for i := 0; i < len(fields); i++ {
+		counts := strings.SplitN(fields[i], "\t", 3)
+		if len(counts) != 3 {
+			continue
+		}
+		fs := FileStat{Path: counts[2]}
+		if fs.Path == "" && i+2 < len(fields) {
+			fs.Path = fields[i+2] // rename: skip the old path
+			i += 2
+		}
+		if counts[0] == "-" {
+			fs.Binary = true
+		} else {
+			fs.Added, _ = strconv.Atoi(counts[0])
+			fs.Deleted, _ = strconv.Atoi(counts[1])
+		}
+		s.Files = append(s.Files, fs)
+		s.Added += fs.Added
+		s.Deleted += fs.Deleted
+	}
+	return s
+}
+
+// PatchFiles returns the paths changed by a diff or mbox patch, in the order
+// they appear. Renamed files are listed by their new path and deleted files
+// by their old one. Unlike PatchStats it only parses the patch text.
+func PatchFiles(patch []byte) []string {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	// Paths for the current "diff --git" section, resolved when it ends
+	var header, oldPath, newPath string
+	inSection := false
+	flush := func() {
+		if !inSection {
+			return
+		}
+		switch {
+		case newPath != "":
+			add(newPath)
+		case oldPath != "":
+			add(oldPath)
+		default:
+			add(headerPath(header))
+		}
+		header, oldPath, newPath = "", "", ""
+		inSection = false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(patch))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(patch)+1)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			header = strings.TrimPrefix(line, "diff --git ")
+			inSection = true
+		case !inSection:
+			// Commit message or mbox headers
+		case strings.HasPrefix(line, "rename to "):
+			newPath = unquotePath(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "copy to "):
+			newPath = unquotePath(strings.TrimPrefix(line, "copy to "))
+		case strings.HasPrefix(line, "--- "):
+			oldPath = diffPath(strings.TrimPrefix(line, "--- "), "a/")
+		case strings.HasPrefix(line, "+++ "):
+			if p := diffPath(strings.TrimPrefix(line, "+++ "), "b/"); p != "" {
+				newPath = p
+			}
+		case strings.HasPrefix(line, "@@"), line == "-- ":
+			// Hunk bodies can contain lines that look like headers
+			flush()
+		}
+	}
+	flush()
+	return files
+}
+
+// diffPath extracts a path from a ---/+++ line, or "" for /dev/null.
+func diffPath(s, prefix string) string {
+	s = unquotePath(strings.TrimSuffix(s, "\t"))
+	if s == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(s, prefix)
+}
+
+// headerPath extracts the new path from a "diff --git a/x b/x" header, used
+// for sections without ---/+++ lines such as mode changes and binary files.
+func headerPath(header string) string {
+	if strings.HasPrefix(header, "\"") {
+		// Quoted names: "a/x" "b/x"
+		if i := strings.Index(header, "\" "); i >= 0 {
+			return strings.TrimPrefix(unquotePath(header[i+2:]), "b/")
+		}
+	}
+	if i := strings.LastIndex(header, " b/"); i >= 0 {
+		return header[i+3:]
+	}
+	return ""
+}
+
+// unquotePath decodes a C-style quoted path as written by git for names with
+// special characters.
+func unquotePath(s string) string {
+	if len(s) >= 2 && s[0] == '"' {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			return unquoted
+		}
+	}
+	return s
+}
+
+func runGit(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		errMsg := strings.TrimSpace(stderr.String())
+		if errMsg == "" {
+			errMsg = err.Error()
+		}
+		return "", fmt.Errorf("%s", errMsg)
+	}
+	return stdout.String(), nil
+}
+
+func runGitWithStdin(stdin []byte, args ...string) error {
+	cmd := exec.Command("git", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		errMsg := strings.TrimSpace(stderr.String())
+		if errMsg == "" {
+			errMsg = err.Error()
+		}
+		return fmt.Errorf("%s", errMsg)
+	}
+	return nil
+}
+
+func runGitWithStdinOutput(stdin []byte, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		errMsg := strings.TrimSpace(stderr.String())
+		if errMsg == "" {
+			errMsg = err.Error()
+		}
+		return "", fmt.Errorf("%s", errMsg)
+	}
+	return stdout.String(), nil
+}
@@ -1,470 +1,1514 @@
-package git
-
-import (
-	"bytes"
-	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
-	"testing"
-)
-
-// setupTestRepo creates a temporary git repository for testing and returns its path
-// and a cleanup function.
-func setupTestRepo(t *testing.T) (string, func()) {
-	t.Helper()
-
-	// Create temp dir
-	dir, err := os.MkdirTemp("", "git-test-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-
-	// Helper to run commands in the temp dir
-	runCmd := func(name string, args ...string) {
-		cmd := exec.Command(name, args...)
-		cmd.Dir = dir
-		if err := cmd.Run(); err != nil {
-			t.Fatalf("Failed to run %s %v: %v", name, args, err)
-		}
-	}
-
-	// Initialize git repo
-	runCmd("git", "init")
-
-	// Set user config for commits
-	runCmd("git", "config", "user.email", "test@example.com")
-	runCmd("git", "config", "user.name", "Test User")
-
-	// Create initial commit so we have a HEAD
-	testFile := filepath.Join(dir, "test.txt")
-	if err := os.WriteFile(testFile, []byte("initial\n"), 0644); err != nil {
-		t.Fatalf("Failed to write test file: %v", err)
-	}
-	runCmd("git", "add", "test.txt")
-	runCmd("git", "commit", "-m", "initial commit")
-
-	// Save original working directory to restore later
-	originalWd, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("Failed to get working directory: %v", err)
-	}
-
-	// Change to the temp repo directory
-	if err := os.Chdir(dir); err != nil {
-		t.Fatalf("Failed to chdir to temp dir: %v", err)
-	}
-
-	cleanup := func() {
-		os.Chdir(originalWd)
-		os.RemoveAll(dir)
-	}
-
-	return dir, cleanup
-}
-
-func TestFindRepoRoot(t *testing.T) {
-	dir, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	// 1. Root level
-	root, err := FindRepoRoot()
-	if err != nil {
-		t.Errorf("FindRepoRoot failed at root: %v", err)
-	}
-	evalRoot, _ := filepath.EvalSymlinks(root)
-	evalDir, _ := filepath.EvalSymlinks(dir)
-	if !strings.EqualFold(evalRoot, evalDir) {
-		t.Errorf("Expected root %q, got %q", dir, root)
-	}
-
-	// 2. Subdirectory
-	subDir := filepath.Join(dir, "sub", "deep")
-	if err := os.MkdirAll(subDir, 0755); err != nil {
-		t.Fatalf("Failed to create subdir: %v", err)
-	}
-	if err := os.Chdir(subDir); err != nil {
-		t.Fatalf("Failed to chdir to subdir: %v", err)
-	}
-	root, err = FindRepoRoot()
-	if err != nil {
-		t.Errorf("FindRepoRoot failed in subdir: %v", err)
-	}
-	evalRoot, _ = filepath.EvalSymlinks(root)
-	if !strings.EqualFold(evalRoot, evalDir) {
-		t.Errorf("Expected root %q from subdir, got %q", dir, root)
-	}
-
-	// 3. Not a git repo
-	tempDir := t.TempDir()
-	os.Chdir(tempDir)
-	_, err = FindRepoRoot()
-	if err == nil {
-		t.Error("Expected error for non-git directory, got nil")
-	}
-}
-
-func TestGetDiff(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	// 1. Clean working directory
-	_, err := GetDiff()
-	if err == nil {
-		t.Error("Expected error for clean working directory, got nil")
-	} else if err.Error() != "no uncommitted changes found" {
-		t.Errorf("Expected 'no uncommitted changes found', got %q", err.Error())
-	}
-
-	// 2. Unstaged changes only
-	if err := os.WriteFile("test.txt", []byte("unstaged\n"), 0644); err != nil {
-		t.Fatalf("Failed to write to test file: %v", err)
-	}
-	diff, err := GetDiff()
-	if err != nil {
-		t.Errorf("Expected nil error for unstaged changes, got %v", err)
-	}
-	if !bytes.Contains(diff, []byte("-initial")) || !bytes.Contains(diff, []byte("+unstaged")) {
-		t.Errorf("Diff does not contain expected changes: %s", diff)
-	}
-
-	// 3. Staged changes only hint
-	exec.Command("git", "add", "test.txt").Run()
-	_, err = GetDiff()
-	if err == nil {
-		t.Error("Expected error for staged changes only, got nil")
-	} else if !strings.Contains(err.Error(), "did you mean to use 'git-share --staged'?") {
-		t.Errorf("Expected hint for staged changes, got %q", err.Error())
-	}
-
-	// 4. Binary file
-	binData := []byte{0x00, 0x01, 0x02, 0xFF, 0xFE}
-	if err := os.WriteFile("binary.bin", binData, 0644); err != nil {
-		t.Fatalf("Failed to write binary file: %v", err)
-	}
-	// Git needs binary files to be tracked to show in diff usually
-	exec.Command("git", "add", "binary.bin").Run()
-	exec.Command("git", "commit", "-m", "add binary").Run()
-	if err := os.WriteFile("binary.bin", append(binData, 0xAA), 0644); err != nil {
-		t.Fatalf("Failed to modify binary file: %v", err)
-	}
-	diff, err = GetDiff()
-	if err != nil {
-		t.Errorf("Failed to get binary diff: %v", err)
-	}
-	if !bytes.Contains(diff, []byte("GIT binary patch")) {
-		t.Errorf("Diff does not reflect binary change: %s", diff)
-	}
-}
-
-func TestGetStagedDiff(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	// 1. Clean working directory
-	_, err := GetStagedDiff()
-	if err == nil {
-		t.Error("Expected error for clean working directory, got nil")
-	} else if err.Error() != "no staged changes found" {
-		t.Errorf("Expected 'no staged changes found', got %q", err.Error())
-	}
-
-	// 2. Staged changes only
-	if err := os.WriteFile("test.txt", []byte("staged\n"), 0644); err != nil {
-		t.Fatalf("Failed to write to test file: %v", err)
-	}
-	exec.Command("git", "add", "test.txt").Run()
-	diff, err := GetStagedDiff()
-	if err != nil {
-		t.Errorf("Expected nil error for staged changes, got %v", err)
-	}
-	if !bytes.Contains(diff, []byte("-initial")) || !bytes.Contains(diff, []byte("+staged")) {
-		t.Errorf("Diff does not contain expected changes: %s", diff)
-	}
-
-	// 3. Unstaged changes only hint
-	exec.Command("git", "reset", "--hard", "HEAD").Run()
-	if err := os.WriteFile("test.txt", []byte("unstaged\n"), 0644); err != nil {
-		t.Fatalf("Failed to write to test file: %v", err)
-	}
-	_, err = GetStagedDiff()
-	if err == nil {
-		t.Error("Expected error for unstaged changes only, got nil")
-	} else if !strings.Contains(err.Error(), "did you mean to use 'git-share'?") {
-		t.Errorf("Expected hint for unstaged changes, got %q", err.Error())
-	}
-
-	// 4. Rename and Deletion
-	exec.Command("git", "reset", "--hard", "HEAD").Run()
-	if err := os.Rename("test.txt", "renamed.txt"); err != nil {
-		t.Fatalf("Rename failed: %v", err)
-	}
-	exec.Command("git", "add", "renamed.txt").Run()
-	exec.Command("git", "rm", "test.txt").Run()
-	diff, err = GetStagedDiff()
-	if err != nil {
-		t.Errorf("Staged diff for rename/delete failed: %v", err)
-	}
-	if !bytes.Contains(diff, []byte("rename from test.txt")) || !bytes.Contains(diff, []byte("rename to renamed.txt")) {
-		t.Logf("Git version might not show as rename if content not committed yet. Checking basic rm/add...")
-		if !bytes.Contains(diff, []byte("deleted file mode")) {
-			t.Errorf("Diff missing deletion info: %s", diff)
-		}
-	}
-}
-
-func TestGetCommitPatch(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	// Create another commit
-	if err := os.WriteFile("test.txt", []byte("v2\n"), 0644); err != nil {
-		t.Fatalf("Failed to write: %v", err)
-	}
-	exec.Command("git", "add", "test.txt").Run()
-	exec.Command("git", "commit", "-m", "second commit").Run()
-
-	// 1. Test single commit (HEAD)
-	patch, err := GetCommitPatch("HEAD")
-	if err != nil {
-		t.Errorf("GetCommitPatch(HEAD) failed: %v", err)
-	}
-	if !bytes.Contains(patch, []byte("Subject: [PATCH] second commit")) {
-		t.Errorf("Patch missing subject: %s", patch)
-	}
-
-	// 2. Test range (HEAD~1..)
-	patch, err = GetCommitPatch("HEAD~1..")
-	if err != nil {
-		t.Errorf("GetCommitPatch(HEAD~1..) failed: %v", err)
-	}
-	if !bytes.Contains(patch, []byte("Subject: [PATCH] second commit")) {
-		t.Errorf("Range patch missing expected commit: %s", patch)
-	}
-
-	// 3. Test invalid ref
-	_, err = GetCommitPatch("nonexistent-ref")
-	if err == nil {
-		t.Errorf("Expected error for invalid ref, got nil")
-	}
-}
-
-func TestApplyPatchStrategies(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	// 1. Test standard 'git apply' via GetDiff output
-	if err := os.WriteFile("test.txt", []byte("modified\n"), 0644); err != nil {
-		t.Fatalf("Failed to write: %v", err)
-	}
-	diff, err := GetDiff()
-	if err != nil {
-		t.Fatalf("Failed to get diff: %v", err)
-	}
-	exec.Command("git", "checkout", "test.txt").Run()
-	if err := ApplyPatch(diff, false); err != nil {
-		t.Errorf("ApplyPatch (simple) failed: %v", err)
-	}
-	content, _ := os.ReadFile("test.txt")
-	if string(content) != "modified\n" {
-		t.Errorf("Simple patch apply verification failed: %s", content)
-	}
-
-	// 2. Test 'git am' fallback via GetCommitPatch output
-	// Create another commit first
-	if err := os.WriteFile("second.txt", []byte("second\n"), 0644); err != nil {
-		t.Fatalf("Failed to write second file: %v", err)
-	}
-	exec.Command("git", "add", "second.txt").Run()
-	exec.Command("git", "commit", "-m", "second commit").Run()
-
-	patch, _ := GetCommitPatch("HEAD")
-	// Undo the commit to test applying it back
-	exec.Command("git", "reset", "--hard", "HEAD~1").Run()
-	if err := ApplyPatch(patch, false); err != nil {
-		t.Errorf("ApplyPatch (apply) failed: %v", err)
-	}
-	// Verify file exists now
-	if _, err := os.Stat("second.txt"); os.IsNotExist(err) {
-		t.Errorf("ApplyPatch (am/apply fallback) did not restore file")
-	}
-
-	// 3. Binary patch
-	exec.Command("git", "reset", "--hard", "HEAD").Run()
-	binData := []byte{0xDE, 0xAD, 0xBE, 0xEF}
-	os.WriteFile("bin", binData, 0644)
-	exec.Command("git", "add", "bin").Run()
-	exec.Command("git", "commit", "-m", "add bin").Run()
-	os.WriteFile("bin", append(binData, 0x00), 0644)
-	binDiff, _ := GetDiff()
-	exec.Command("git", "checkout", "bin").Run()
-	if err := ApplyPatch(binDiff, false); err != nil {
-		t.Errorf("Binary ApplyPatch failed: %v", err)
-	}
-	content, _ = os.ReadFile("bin")
-	if len(content) != 5 {
-		t.Errorf("Binary patch apply verification failed")
-	}
-}
-
-func TestPatchStats(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	if err := os.WriteFile("test.txt", []byte("stats\n"), 0644); err != nil {
-		t.Fatalf("Failed to write: %v", err)
-	}
-	diff, _ := GetDiff()
-	stats, err := PatchStats(diff)
-	if err != nil {
-		t.Errorf("PatchStats failed: %v", err)
-	}
-	if !strings.Contains(stats, "test.txt") {
-		t.Errorf("Stats output unexpected: %s", stats)
-	}
-}
-
-func TestGetCommitPatchRange(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	// Create 3 additional commits
-	for i := 1; i <= 3; i++ {
-		fname := fmt.Sprintf("file%d.txt", i)
-		if err := os.WriteFile(fname, []byte(fmt.Sprintf("content %d\n", i)), 0644); err != nil {
-			t.Fatalf("Failed to write %s: %v", fname, err)
-		}
-		if err := exec.Command("git", "add", fname).Run(); err != nil {
-			t.Fatalf("Failed to git add %s: %v", fname, err)
-		}
-		if err := exec.Command("git", "commit", "-m", fmt.Sprintf("commit %d", i)).Run(); err != nil {
-			t.Fatalf("Failed to commit %d: %v", i, err)
-		}
-	}
-
-	// Get patch for last 2 commits (commit 2 and commit 3)
-	patch, err := GetCommitPatch("HEAD~2..")
-	if err != nil {
-		t.Fatalf("Failed to get range patch: %v", err)
-	}
-
-	// Verify both commit subjects are in the stdout stream
-	// Note: format-patch uses [PATCH 1/2] etc. for ranges
-	if !strings.Contains(string(patch), "Subject: [PATCH 1/2] commit 2") && !strings.Contains(string(patch), "Subject: [PATCH] commit 2") {
-		t.Errorf("Patch missing 'commit 2' or unexpected format. Patch snippet: %s", patch)
-	}
-	if !strings.Contains(string(patch), "Subject: [PATCH 2/2] commit 3") && !strings.Contains(string(patch), "Subject: [PATCH] commit 3") {
-		t.Errorf("Patch missing 'commit 3' or unexpected format. Patch snippet: %s", patch)
-	}
-}
-
-func TestSpecialFilenames(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	fname := "file with spaces.txt"
-	content := []byte("special content\n")
-	if err := os.WriteFile(fname, content, 0644); err != nil {
-		t.Fatalf("Failed to write special file: %v", err)
-	}
-
-	// 1. Test Diff
-	if err := exec.Command("git", "add", fname).Run(); err != nil {
-		t.Fatalf("Failed to git add special file: %v", err)
-	}
-	diff, err := GetStagedDiff()
-	if err != nil {
-		t.Fatalf("Failed to get diff for special filename: %v", err)
-	}
-	if !bytes.Contains(diff, []byte("file with spaces.txt")) {
-		t.Errorf("Diff missing special filename: %s", diff)
-	}
-
-	// 2. Test Apply
-	exec.Command("git", "reset", "--hard", "HEAD").Run()
-	if _, err := os.Stat(fname); err == nil {
-		t.Fatalf("File should be gone after reset")
-	}
-
-	if err := ApplyPatch(diff, false); err != nil {
-		t.Fatalf("Failed to apply patch with special filename: %v", err)
-	}
-	if _, err := os.Stat(fname); err != nil {
-		t.Errorf("File with spaces not restored: %v", err)
-	}
-}
-
-func TestApplyConflict(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	// Initial change
-	os.WriteFile("test.txt", []byte("version A\n"), 0644)
-	diff, _ := GetDiff()
-
-	// Diverge the file
-	os.WriteFile("test.txt", []byte("version B\n"), 0644)
-	exec.Command("git", "add", "test.txt").Run()
-	exec.Command("git", "commit", "-m", "diverged").Run()
-
-	// Attempt to apply the "version A" patch
-	err := ApplyPatch(diff, false)
-	if err == nil {
-		t.Error("Expected conflict error, got nil")
-	}
-}
-
-func TestTagSupport(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	exec.Command("git", "tag", "mytag").Run()
-	patch, err := GetCommitPatch("mytag")
-	if err != nil {
-		t.Errorf("Failed to use tag as ref: %v", err)
-	}
-	if !bytes.Contains(patch, []byte("Subject: [PATCH] initial commit")) {
-		t.Errorf("Tag patch missing expected content: %s", patch)
-	}
-}
-
-func TestApplyPatchCommit(t *testing.T) {
-	_, cleanup := setupTestRepo(t)
-	defer cleanup()
-
-	// 1. Create a commit to send
-	if err := os.WriteFile("commit_file.txt", []byte("commit content\n"), 0644); err != nil {
-		t.Fatalf("Failed to write: %v", err)
-	}
-	exec.Command("git", "add", "commit_file.txt").Run()
-	exec.Command("git", "commit", "-m", "explicit commit message").Run()
-
-	patch, err := GetCommitPatch("HEAD")
-	if err != nil {
-		t.Fatalf("Failed to get patch: %v", err)
-	}
-
-	// 2. Reset to previous state
-	exec.Command("git", "reset", "--hard", "HEAD~1").Run()
-	if _, err := os.Stat("commit_file.txt"); err == nil {
-		t.Fatalf("File should be gone after reset")
-	}
-
-	// 3. Apply with forceAm=true
-	if err := ApplyPatch(patch, true); err != nil {
-		t.Fatalf("ApplyPatch(forceAm=true) failed: %v", err)
-	}
-
-	// 4. Verify commit exists
-	out, err := exec.Command("git", "log", "-1", "--pretty=%s").Output()
-	if err != nil {
-		t.Fatalf("Failed to run git log: %v", err)
-	}
-	if strings.TrimSpace(string(out)) != "explicit commit message" {
-		t.Errorf("Expected commit message 'explicit commit message', got %q", string(out))
-	}
-	if _, err := os.Stat("commit_file.txt"); err != nil {
-		t.Errorf("File not restored after commit apply: %v", err)
-	}
-}
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/flawiddsouza/git-share/internal/gittest"
+)
+
+// setupTestRepo creates a temporary git repository for testing and returns its path
+// and a cleanup function.
+func setupTestRepo(t *testing.T) (string, func()) {
+	t.Helper()
+
+	// Create temp dir
+	dir, err := os.MkdirTemp("", "git-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	// Helper to run commands in the temp dir
+	runCmd := func(name string, args ...string) {
+		cmd := exec.Command(name, args...)
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Failed to run %s %v: %v", name, args, err)
+		}
+	}
+
+	// Initialize git repo
+	runCmd("git", "init")
+
+	// Set user config for commits
+	runCmd("git", "config", "user.email", "test@example.com")
+	runCmd("git", "config", "user.name", "Test User")
+
+	// Create initial commit so we have a HEAD
+	testFile := filepath.Join(dir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("initial\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	runCmd("git", "add", "test.txt")
+	runCmd("git", "commit", "-m", "initial commit")
+
+	// Save original working directory to restore later
+	originalWd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+
+	// Change to the temp repo directory
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to chdir to temp dir: %v", err)
+	}
+
+	cleanup := func() {
+		os.Chdir(originalWd)
+		os.RemoveAll(dir)
+	}
+
+	return dir, cleanup
+}
+
+func TestFindRepoRoot(t *testing.T) {
+	dir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// 1. Root level
+	root, err := FindRepoRoot()
+	if err != nil {
+		t.Errorf("FindRepoRoot failed at root: %v", err)
+	}
+	evalRoot, _ := filepath.EvalSymlinks(root)
+	evalDir, _ := filepath.EvalSymlinks(dir)
+	if !strings.EqualFold(evalRoot, evalDir) {
+		t.Errorf("Expected root %q, got %q", dir, root)
+	}
+
+	// 2. Subdirectory
+	subDir := filepath.Join(dir, "sub", "deep")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatalf("Failed to create subdir: %v", err)
+	}
+	if err := os.Chdir(subDir); err != nil {
+		t.Fatalf("Failed to chdir to subdir: %v", err)
+	}
+	root, err = FindRepoRoot()
+	if err != nil {
+		t.Errorf("FindRepoRoot failed in subdir: %v", err)
+	}
+	evalRoot, _ = filepath.EvalSymlinks(root)
+	if !strings.EqualFold(evalRoot, evalDir) {
+		t.Errorf("Expected root %q from subdir, got %q", dir, root)
+	}
+
+	// 3. Not a git repo
+	tempDir := t.TempDir()
+	os.Chdir(tempDir)
+	_, err = FindRepoRoot()
+	if err == nil {
+		t.Error("Expected error for non-git directory, got nil")
+	}
+}
+
+func TestGetDiff(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// 1. Clean working directory
+	_, err := GetDiff()
+	if err == nil {
+		t.Error("Expected error for clean working directory, got nil")
+	} else if err.Error() != "no uncommitted changes found" {
+		t.Errorf("Expected 'no uncommitted changes found', got %q", err.Error())
+	} else if !errors.Is(err, ErrNoChanges) {
+		t.Error("Expected the clean tree error to match ErrNoChanges")
+	}
+
+	// 2. Unstaged changes only
+	if err := os.WriteFile("test.txt", []byte("unstaged\n"), 0644); err != nil {
+		t.Fatalf("Failed to write to test file: %v", err)
+	}
+	diff, err := GetDiff()
+	if err != nil {
+		t.Errorf("Expected nil error for unstaged changes, got %v", err)
+	}
+	if !bytes.Contains(diff, []byte("-initial")) || !bytes.Contains(diff, []byte("+unstaged")) {
+		t.Errorf("Diff does not contain expected changes: %s", diff)
+	}
+
+	// 3. Staged changes only hint
+	exec.Command("git", "add", "test.txt").Run()
+	_, err = GetDiff()
+	if err == nil {
+		t.Error("Expected error for staged changes only, got nil")
+	} else if !strings.Contains(err.Error(), "did you mean to use 'git-share --staged'?") {
+		t.Errorf("Expected hint for staged changes, got %q", err.Error())
+	}
+
+	// 4. Binary file
+	binData := []byte{0x00, 0x01, 0x02, 0xFF, 0xFE}
+	if err := os.WriteFile("binary.bin", binData, 0644); err != nil {
+		t.Fatalf("Failed to write binary file: %v", err)
+	}
+	// Git needs binary files to be tracked to show in diff usually
+	exec.Command("git", "add", "binary.bin").Run()
+	exec.Command("git", "commit", "-m", "add binary").Run()
+	if err := os.WriteFile("binary.bin", append(binData, 0xAA), 0644); err != nil {
+		t.Fatalf("Failed to modify binary file: %v", err)
+	}
+	diff, err = GetDiff()
+	if err != nil {
+		t.Errorf("Failed to get binary diff: %v", err)
+	}
+	if !bytes.Contains(diff, []byte("GIT binary patch")) {
+		t.Errorf("Diff does not reflect binary change: %s", diff)
+	}
+}
+
+func TestGetDiffPathspecs(t *testing.T) {
+	repo := gittest.New(t)
+	repo.Commit("add files", map[string]string{"src/foo.go": "foo\n", "src/bar.go": "bar\n"})
+	repo.WriteFile("test.txt", "changed\n")
+	repo.WriteFile("src/foo.go", "foo2\n")
+	repo.WriteFile("src/bar.go", "bar2\n")
+
+	diff, err := GetDiff("src/foo.go", "test.txt")
+	if err != nil {
+		t.Fatalf("GetDiff failed: %v", err)
+	}
+	if got, want := PatchFiles(diff), []string{"src/foo.go", "test.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PatchFiles = %v, want %v", got, want)
+	}
+
+	repo.Git("add", "src/bar.go")
+	diff, err = GetStagedDiff("src")
+	if err != nil {
+		t.Fatalf("GetStagedDiff failed: %v", err)
+	}
+	if got, want := PatchFiles(diff), []string{"src/bar.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PatchFiles = %v, want %v", got, want)
+	}
+
+	// A typo is named rather than reported as "no changes"
+	for _, get := range []func(...string) ([]byte, error){GetDiff, GetStagedDiff} {
+		_, err := get("src/foo.go", "src/baz.go")
+		if err == nil || !strings.Contains(err.Error(), `"src/baz.go"`) || errors.Is(err, ErrNoChanges) {
+			t.Errorf("error = %v, want one naming src/baz.go", err)
+		}
+	}
+
+	// A matching path without changes is still "no changes"
+	repo.Git("checkout", "--", "test.txt")
+	if _, err := GetDiff("test.txt"); !errors.Is(err, ErrNoChanges) {
+		t.Errorf("expected ErrNoChanges, got %v", err)
+	}
+}
+
+func TestGetStagedDiff(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// 1. Clean working directory
+	_, err := GetStagedDiff()
+	if err == nil {
+		t.Error("Expected error for clean working directory, got nil")
+	} else if err.Error() != "no staged changes found" {
+		t.Errorf("Expected 'no staged changes found', got %q", err.Error())
+	}
+
+	// 2. Staged changes only
+	if err := os.WriteFile("test.txt", []byte("staged\n"), 0644); err != nil {
+		t.Fatalf("Failed to write to test file: %v", err)
+	}
+	exec.Command("git", "add", "test.txt").Run()
+	diff, err := GetStagedDiff()
+	if err != nil {
+		t.Errorf("Expected nil error for staged changes, got %v", err)
+	}
+	if !bytes.Contains(diff, []byte("-initial")) || !bytes.Contains(diff, []byte("+staged")) {
+		t.Errorf("Diff does not contain expected changes: %s", diff)
+	}
+
+	// 3. Unstaged changes only hint
+	exec.Command("git", "reset", "--hard", "HEAD").Run()
+	if err := os.WriteFile("test.txt", []byte("unstaged\n"), 0644); err != nil {
+		t.Fatalf("Failed to write to test file: %v", err)
+	}
+	_, err = GetStagedDiff()
+	if err == nil {
+		t.Error("Expected error for unstaged changes only, got nil")
+	} else if !strings.Contains(err.Error(), "did you mean to use 'git-share'?") {
+		t.Errorf("Expected hint for unstaged changes, got %q", err.Error())
+	}
+
+	// 4. Rename and Deletion
+	exec.Command("git", "reset", "--hard", "HEAD").Run()
+	if err := os.Rename("test.txt", "renamed.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	exec.Command("git", "add", "renamed.txt").Run()
+	exec.Command("git", "rm", "test.txt").Run()
+	diff, err = GetStagedDiff()
+	if err != nil {
+		t.Errorf("Staged diff for rename/delete failed: %v", err)
+	}
+	if !bytes.Contains(diff, []byte("rename from test.txt")) || !bytes.Contains(diff, []byte("rename to renamed.txt")) {
+		t.Logf("Git version might not show as rename if content not committed yet. Checking basic rm/add...")
+		if !bytes.Contains(diff, []byte("deleted file mode")) {
+			t.Errorf("Diff missing deletion info: %s", diff)
+		}
+	}
+}
+
+func TestGetDiffFromHead(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if _, err := GetDiffFromHead(); !errors.Is(err, ErrNoChanges) {
+		t.Errorf("expected ErrNoChanges for a clean working directory, got %v", err)
+	}
+
+	// A staged new file and an unstaged edit
+	if err := os.WriteFile("staged.txt", []byte("staged\n"), 0644); err != nil {
+		t.Fatalf("Failed to write staged file: %v", err)
+	}
+	exec.Command("git", "add", "staged.txt").Run()
+	if err := os.WriteFile("test.txt", []byte("unstaged\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	diff, err := GetDiffFromHead()
+	if err != nil {
+		t.Fatalf("GetDiffFromHead failed: %v", err)
+	}
+	for _, want := range []string{"+staged", "-initial", "+unstaged"} {
+		if !bytes.Contains(diff, []byte(want)) {
+			t.Errorf("diff missing %q:\n%s", want, diff)
+		}
+	}
+
+	// Staging part of it doesn't change the result
+	exec.Command("git", "add", "test.txt").Run()
+	if again, _ := GetDiffFromHead(); !bytes.Equal(again, diff) {
+		t.Errorf("diff changed after staging:\n%s", again)
+	}
+}
+
+func TestGetUntrackedDiff(t *testing.T) {
+	repo := gittest.New(t)
+	if diff, err := GetUntrackedDiff(); err != nil || diff != nil {
+		t.Fatalf("GetUntrackedDiff() = %q, %v for a clean tree", diff, err)
+	}
+
+	repo.WriteFile(".gitignore", "*.log\n")
+	repo.WriteFile("dir/new.txt", "new\n")
+	repo.WriteFile("debug.log", "ignored\n")
+	diff, err := GetUntrackedDiff()
+	if err != nil {
+		t.Fatalf("GetUntrackedDiff failed: %v", err)
+	}
+	if got, want := PatchFiles(diff), []string{".gitignore", "dir/new.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PatchFiles = %v, want %v", got, want)
+	}
+	if status := repo.Git("status", "--porcelain"); strings.Contains(status, "A ") {
+		t.Errorf("the index was touched:\n%s", status)
+	}
+
+	// The patch recreates the files once they are gone
+	repo.Git("clean", "-q", "-f", "-d")
+	if err := ApplyPatch(diff, false); err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if got, _ := os.ReadFile(repo.Path("dir/new.txt")); string(got) != "new\n" {
+		t.Errorf("dir/new.txt = %q", got)
+	}
+}
+
+func TestGetStashPatch(t *testing.T) {
+	repo := gittest.New(t)
+	repo.Commit("base", map[string]string{"a.txt": "one\n", "b.txt": "one\n"})
+	if _, err := GetStashPatch("stash@{0}"); err == nil || !strings.Contains(err.Error(), "no such stash entry") {
+		t.Errorf("expected an error without stashes, got %v", err)
+	}
+
+	repo.WriteFile("a.txt", "two\n")
+	repo.Git("stash")
+	repo.WriteFile("b.txt", "two\n")
+	repo.Git("stash")
+
+	for ref, want := range map[string]string{"stash@{0}": "b.txt", "stash@{1}": "a.txt"} {
+		patch, err := GetStashPatch(ref)
+		if err != nil {
+			t.Fatalf("GetStashPatch(%s) failed: %v", ref, err)
+		}
+		if got := PatchFiles(patch); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("GetStashPatch(%s) files = %v, want [%s]", ref, got, want)
+		}
+	}
+
+	// The receiver applies it like any working tree diff
+	patch, _ := GetStashPatch("stash@{1}")
+	if err := ApplyPatch(patch, false); err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if got, _ := os.ReadFile(repo.Path("a.txt")); string(got) != "two\n" {
+		t.Errorf("a.txt = %q", got)
+	}
+}
+
+func TestGetCommitPatch(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// Create another commit
+	if err := os.WriteFile("test.txt", []byte("v2\n"), 0644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	exec.Command("git", "add", "test.txt").Run()
+	exec.Command("git", "commit", "-m", "second commit").Run()
+
+	// 1. Test single commit (HEAD)
+	patch, err := GetCommitPatch("HEAD")
+	if err != nil {
+		t.Errorf("GetCommitPatch(HEAD) failed: %v", err)
+	}
+	if !bytes.Contains(patch, []byte("Subject: [PATCH] second commit")) {
+		t.Errorf("Patch missing subject: %s", patch)
+	}
+
+	// 2. Test range (HEAD~1..)
+	patch, err = GetCommitPatch("HEAD~1..")
+	if err != nil {
+		t.Errorf("GetCommitPatch(HEAD~1..) failed: %v", err)
+	}
+	if !bytes.Contains(patch, []byte("Subject: [PATCH] second commit")) {
+		t.Errorf("Range patch missing expected commit: %s", patch)
+	}
+
+	// 3. Test invalid ref
+	_, err = GetCommitPatch("nonexistent-ref")
+	if err == nil {
+		t.Errorf("Expected error for invalid ref, got nil")
+	}
+}
+
+func TestApplyPatchStrategies(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// 1. Test standard 'git apply' via GetDiff output
+	if err := os.WriteFile("test.txt", []byte("modified\n"), 0644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	diff, err := GetDiff()
+	if err != nil {
+		t.Fatalf("Failed to get diff: %v", err)
+	}
+	exec.Command("git", "checkout", "test.txt").Run()
+	if err := ApplyPatch(diff, false); err != nil {
+		t.Errorf("ApplyPatch (simple) failed: %v", err)
+	}
+	content, _ := os.ReadFile("test.txt")
+	if string(content) != "modified\n" {
+		t.Errorf("Simple patch apply verification failed: %s", content)
+	}
+
+	// 2. Test 'git am' fallback via GetCommitPatch output
+	// Create another commit first
+	if err := os.WriteFile("second.txt", []byte("second\n"), 0644); err != nil {
+		t.Fatalf("Failed to write second file: %v", err)
+	}
+	exec.Command("git", "add", "second.txt").Run()
+	exec.Command("git", "commit", "-m", "second commit").Run()
+
+	patch, _ := GetCommitPatch("HEAD")
+	// Undo the commit to test applying it back
+	exec.Command("git", "reset", "--hard", "HEAD~1").Run()
+	if err := ApplyPatch(patch, false); err != nil {
+		t.Errorf("ApplyPatch (apply) failed: %v", err)
+	}
+	// Verify file exists now
+	if _, err := os.Stat("second.txt"); os.IsNotExist(err) {
+		t.Errorf("ApplyPatch (am/apply fallback) did not restore file")
+	}
+
+	// 3. Binary patch
+	exec.Command("git", "reset", "--hard", "HEAD").Run()
+	binData := []byte{0xDE, 0xAD, 0xBE, 0xEF}
+	os.WriteFile("bin", binData, 0644)
+	exec.Command("git", "add", "bin").Run()
+	exec.Command("git", "commit", "-m", "add bin").Run()
+	os.WriteFile("bin", append(binData, 0x00), 0644)
+	binDiff, _ := GetDiff()
+	exec.Command("git", "checkout", "bin").Run()
+	if err := ApplyPatch(binDiff, false); err != nil {
+		t.Errorf("Binary ApplyPatch failed: %v", err)
+	}
+	content, _ = os.ReadFile("bin")
+	if len(content) != 5 {
+		t.Errorf("Binary patch apply verification failed")
+	}
+}
+
+func TestPatchStats(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.WriteFile("test.txt", []byte("stats\n"), 0644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	diff, _ := GetDiff()
+	stats, err := PatchStats(diff)
+	if err != nil {
+		t.Errorf("PatchStats failed: %v", err)
+	}
+	if !strings.Contains(stats, "test.txt") {
+		t.Errorf("Stats output unexpected: %s", stats)
+	}
+}
+
+func TestGetCommitPatchMergesOnly(t *testing.T) {
+	repo := gittest.New(t)
+	repo.Branch("feature")
+	repo.Commit("feature work", map[string]string{"feature.txt": "feature\n"})
+	repo.Git("checkout", "-")
+	repo.Git("merge", "--no-ff", "feature", "-m", "merge feature")
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "HEAD", want: "is a merge commit"},
+		{ref: "HEAD^2..HEAD", want: "only contains merge commits (1)"},
+		{ref: "HEAD..HEAD", want: "no commits found"},
+	}
+	for _, tt := range tests {
+		_, err := GetCommitPatch(tt.ref)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("GetCommitPatch(%q) = %v, want an error containing %q", tt.ref, err, tt.want)
+		}
+		if !errors.Is(err, ErrNoChanges) {
+			t.Errorf("GetCommitPatch(%q) error should match ErrNoChanges", tt.ref)
+		}
+	}
+
+	// The suggested range shares the merged commit
+	patch, err := GetCommitPatch("HEAD^1..HEAD")
+	if err != nil || !bytes.Contains(patch, []byte("feature work")) {
+		t.Errorf("GetCommitPatch(HEAD^1..HEAD) = %v; want the feature commit", err)
+	}
+}
+
+func TestCommitPatchKeepsAuthor(t *testing.T) {
+	repo := gittest.New(t)
+	repo.WriteFile("test.txt", "changed\n")
+	repo.WriteFile("new.txt", "new\n")
+	repo.Git("add", "-A")
+	repo.Git("commit", "-q", "-m", "Fix the thing\n\nLonger explanation.",
+		"--author", "Ada Lovelace <ada@example.com>", "--date", "Mon, 10 Dec 1990 12:00:00 +0100")
+	patch, err := GetCommitPatch("HEAD")
+	if err != nil {
+		t.Fatalf("GetCommitPatch failed: %v", err)
+	}
+	repo.Git("reset", "-q", "--hard", "HEAD~1")
+
+	// An unrelated staged change stays out of the commit
+	repo.WriteFile("other.txt", "other\n")
+	repo.Git("add", "other.txt")
+
+	info, err := ParseMbox(patch)
+	if err != nil {
+		t.Fatalf("ParseMbox failed: %v", err)
+	}
+	if err := ApplyPatch(patch, false); err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if _, err := CommitPatch(patch, info); err != nil {
+		t.Fatalf("CommitPatch failed: %v", err)
+	}
+
+	got := repo.Git("log", "-1", "--format=%an <%ae>|%ad|%B", "--date=rfc")
+	want := "Ada Lovelace <ada@example.com>|Mon, 10 Dec 1990 12:00:00 +0100|Fix the thing\n\nLonger explanation.\n"
+	if strings.TrimSpace(got) != strings.TrimSpace(want) {
+		t.Errorf("commit = %q, want %q", got, want)
+	}
+	files := repo.Git("show", "--name-only", "--format=", "HEAD")
+	if files != "new.txt\ntest.txt" {
+		t.Errorf("committed files = %q, want new.txt and test.txt", files)
+	}
+	if staged := repo.Git("diff", "--cached", "--name-only"); staged != "other.txt" {
+		t.Errorf("staged after commit = %q, want other.txt", staged)
+	}
+}
+
+func TestParseMbox(t *testing.T) {
+	mbox := "From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001\n" +
+		"From: =?UTF-8?q?Ren=C3=A9e=20Dupont?= <renee@example.com>\n" +
+		"Date: Tue, 2 Jan 2024 09:30:00 -0500\n" +
+		"Subject: [PATCH 1/1] Handle accents in\n names\n\n" +
+		"Body line.\n---\n a.txt | 2 +-\n\ndiff --git a/a.txt b/a.txt\n"
+	info, err := ParseMbox([]byte(mbox))
+	if err != nil {
+		t.Fatalf("ParseMbox failed: %v", err)
+	}
+	want := CommitInfo{
+		Author:  "Renée Dupont <renee@example.com>",
+		Date:    "Tue, 2 Jan 2024 09:30:00 -0500",
+		Message: "Handle accents in names\n\nBody line.",
+	}
+	if info != want {
+		t.Errorf("ParseMbox = %+v, want %+v", info, want)
+	}
+
+	if _, err := ParseMbox([]byte("diff --git a/a.txt b/a.txt\n")); err == nil {
+		t.Error("expected an error for a plain diff")
+	}
+	if _, err := ParseMbox([]byte(mbox + mbox)); err == nil || !strings.Contains(err.Error(), "2 commits") {
+		t.Errorf("expected an error for two commits, got %v", err)
+	}
+}
+
+func TestDiffToCommitPatch(t *testing.T) {
+	repo := gittest.New(t)
+	repo.WriteFile("test.txt", "changed\n")
+	diff, err := GetDiff()
+	if err != nil {
+		t.Fatalf("GetDiff failed: %v", err)
+	}
+	author, err := Author()
+	if err != nil || author != "Test User <test@example.com>" {
+		t.Fatalf("Author() = %q, %v; want the repo's user", author, err)
+	}
+
+	patch, err := DiffToCommitPatch(diff, "Fix the thing\n\nLonger explanation.", "Renée Dupont <renee@example.com>")
+	if err != nil {
+		t.Fatalf("DiffToCommitPatch failed: %v", err)
+	}
+	info, err := ParseMbox(patch)
+	if err != nil {
+		t.Fatalf("ParseMbox failed: %v", err)
+	}
+	if info.Author != "Renée Dupont <renee@example.com>" || info.Message != "Fix the thing\n\nLonger explanation." {
+		t.Errorf("ParseMbox = %+v", info)
+	}
+
+	// git am turns it into a commit with that author
+	repo.Git("checkout", "-q", "--", "test.txt")
+	if err := ApplyPatch(patch, true); err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if got := repo.Git("log", "-1", "--format=%an <%ae>|%B"); strings.TrimSpace(got) != "Renée Dupont <renee@example.com>|Fix the thing\n\nLonger explanation." {
+		t.Errorf("commit = %q", got)
+	}
+	if got, _ := os.ReadFile(repo.Path("test.txt")); string(got) != "changed\n" {
+		t.Errorf("test.txt = %q after git am", got)
+	}
+
+	for _, tt := range []struct{ subject, author string }{
+		{"", "A <a@example.com>"},
+		{"Fix", "no email"},
+		{"Fix", "<a@example.com>"},
+	} {
+		if _, err := DiffToCommitPatch(diff, tt.subject, tt.author); err == nil {
+			t.Errorf("DiffToCommitPatch(%q, %q) should fail", tt.subject, tt.author)
+		}
+	}
+}
+
+func TestCommitCount(t *testing.T) {
+	repo := gittest.New(t)
+	repo.Commit("one", map[string]string{"a.txt": "1\n"})
+	repo.Commit("two", map[string]string{"a.txt": "2\n"})
+	repo.Commit("three", map[string]string{"a.txt": "3\n"})
+
+	// gittest.New makes the first commit
+	for ref, want := range map[string]int{"HEAD": 4, "HEAD~1": 3} {
+		if got, err := CommitCount(ref); err != nil || got != want {
+			t.Errorf("CommitCount(%s) = %d, %v; want %d", ref, got, err, want)
+		}
+	}
+	if _, err := CommitCount("nonexistent-ref"); err == nil {
+		t.Error("expected an error for a missing ref")
+	}
+}
+
+func TestGetCommitPatchRange(t *testing.T) {
+	repo := gittest.New(t)
+
+	// Create 3 additional commits
+	for i := 1; i <= 3; i++ {
+		fname := fmt.Sprintf("file%d.txt", i)
+		repo.Commit(fmt.Sprintf("commit %d", i), map[string]string{fname: fmt.Sprintf("content %d\n", i)})
+	}
+
+	// Get patch for last 2 commits (commit 2 and commit 3)
+	patch, err := GetCommitPatch("HEAD~2..")
+	if err != nil {
+		t.Fatalf("Failed to get range patch: %v", err)
+	}
+
+	// Verify both commit subjects are in the stdout stream
+	// Note: format-patch uses [PATCH 1/2] etc. for ranges
+	if !strings.Contains(string(patch), "Subject: [PATCH 1/2] commit 2") && !strings.Contains(string(patch), "Subject: [PATCH] commit 2") {
+		t.Errorf("Patch missing 'commit 2' or unexpected format. Patch snippet: %s", patch)
+	}
+	if !strings.Contains(string(patch), "Subject: [PATCH 2/2] commit 3") && !strings.Contains(string(patch), "Subject: [PATCH] commit 3") {
+		t.Errorf("Patch missing 'commit 3' or unexpected format. Patch snippet: %s", patch)
+	}
+}
+
+func TestSpecialFilenames(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	fname := "file with spaces.txt"
+	content := []byte("special content\n")
+	if err := os.WriteFile(fname, content, 0644); err != nil {
+		t.Fatalf("Failed to write special file: %v", err)
+	}
+
+	// 1. Test Diff
+	if err := exec.Command("git", "add", fname).Run(); err != nil {
+		t.Fatalf("Failed to git add special file: %v", err)
+	}
+	diff, err := GetStagedDiff()
+	if err != nil {
+		t.Fatalf("Failed to get diff for special filename: %v", err)
+	}
+	if !bytes.Contains(diff, []byte("file with spaces.txt")) {
+		t.Errorf("Diff missing special filename: %s", diff)
+	}
+
+	// 2. Test Apply
+	exec.Command("git", "reset", "--hard", "HEAD").Run()
+	if _, err := os.Stat(fname); err == nil {
+		t.Fatalf("File should be gone after reset")
+	}
+
+	if err := ApplyPatch(diff, false); err != nil {
+		t.Fatalf("Failed to apply patch with special filename: %v", err)
+	}
+	if _, err := os.Stat(fname); err != nil {
+		t.Errorf("File with spaces not restored: %v", err)
+	}
+}
+
+func TestApplyConflict(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// Initial change
+	os.WriteFile("test.txt", []byte("version A\n"), 0644)
+	diff, _ := GetDiff()
+
+	// Diverge the file
+	os.WriteFile("test.txt", []byte("version B\n"), 0644)
+	exec.Command("git", "add", "test.txt").Run()
+	exec.Command("git", "commit", "-m", "diverged").Run()
+
+	// Attempt to apply the "version A" patch
+	err := ApplyPatch(diff, false)
+	if err == nil {
+		t.Error("Expected conflict error, got nil")
+	}
+}
+
+func TestApplyPatchErrorKinds(t *testing.T) {
+	const diff = "diff --git a/test.txt b/test.txt\n--- a/test.txt\n+++ b/test.txt\n"
+	tests := []struct {
+		name   string
+		patch  string
+		commit bool
+		want   error
+	}{
+		{name: "empty", patch: " \n", want: ErrEmptyPatch},
+		{name: "garbage", patch: "this is not a patch\n", want: ErrCorruptPatch},
+		{name: "truncated hunk", patch: diff + "@@ -1 +1,5 @@\n-initial\n+changed\n", want: ErrCorruptPatch},
+		{name: "different base", patch: diff + "@@ -1 +1 @@\n-something else\n+changed\n", want: ErrPatchConflict},
+		{name: "missing file", patch: "diff --git a/gone.txt b/gone.txt\n--- a/gone.txt\n+++ b/gone.txt\n@@ -1 +1 @@\n-a\n+b\n", want: ErrPatchConflict},
+		{name: "am with a different base", patch: "From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001\nFrom: A <a@example.com>\nSubject: s\n\n---\n" + diff + "@@ -1 +1 @@\n-something else\n+changed\n", commit: true, want: ErrPatchConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gittest.New(t)
+			err := ApplyPatchWithOptions([]byte(tt.patch), ApplyOptions{Commit: tt.commit})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want one matching %v", err, tt.want)
+			}
+		})
+	}
+
+	if !errors.Is(&ConflictError{Files: []string{"a"}}, ErrPatchConflict) {
+		t.Error("a *ConflictError should match ErrPatchConflict")
+	}
+}
+
+func TestApplyPatchThreeWay(t *testing.T) {
+	for _, commit := range []bool{false, true} {
+		t.Run(fmt.Sprintf("commit=%v", commit), func(t *testing.T) {
+			repo := gittest.New(t)
+			repo.Commit("lines", map[string]string{"f.txt": "one\ntwo\nthree\n"})
+			repo.Commit("theirs", map[string]string{"f.txt": "one\nTWO\nthree\n"})
+			patch, err := GetCommitPatch("HEAD")
+			if err != nil {
+				t.Fatalf("GetCommitPatch failed: %v", err)
+			}
+			repo.Git("reset", "-q", "--hard", "HEAD~1")
+			repo.Commit("ours", map[string]string{"f.txt": "one\nzwei\nthree\n"})
+
+			err = ApplyPatchWithOptions(patch, ApplyOptions{Commit: commit, ThreeWay: true})
+			var conflict *ConflictError
+			if !errors.As(err, &conflict) {
+				t.Fatalf("error = %v, want a *ConflictError", err)
+			}
+			if !reflect.DeepEqual(conflict.Files, []string{"f.txt"}) || conflict.Am != commit {
+				t.Errorf("conflict = %+v", conflict)
+			}
+			got, _ := os.ReadFile(repo.Path("f.txt"))
+			if !strings.Contains(string(got), "<<<<<<<") || !strings.Contains(string(got), "TWO") {
+				t.Errorf("f.txt has no conflict markers:\n%s", got)
+			}
+		})
+	}
+
+	// A patch that applies cleanly is unaffected
+	repo := gittest.New(t)
+	repo.WriteFile("test.txt", "changed\n")
+	patch, _ := GetDiff()
+	repo.Git("checkout", "--", "test.txt")
+	if err := ApplyPatchWithOptions(patch, ApplyOptions{ThreeWay: true}); err != nil {
+		t.Fatalf("clean three-way apply failed: %v", err)
+	}
+}
+
+func TestTagSupport(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	exec.Command("git", "tag", "mytag").Run()
+	patch, err := GetCommitPatch("mytag")
+	if err != nil {
+		t.Errorf("Failed to use tag as ref: %v", err)
+	}
+	if !bytes.Contains(patch, []byte("Subject: [PATCH] initial commit")) {
+		t.Errorf("Tag patch missing expected content: %s", patch)
+	}
+}
+
+func TestApplyPatchCommit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// 1. Create a commit to send
+	if err := os.WriteFile("commit_file.txt", []byte("commit content\n"), 0644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	exec.Command("git", "add", "commit_file.txt").Run()
+	exec.Command("git", "commit", "-m", "explicit commit message").Run()
+
+	patch, err := GetCommitPatch("HEAD")
+	if err != nil {
+		t.Fatalf("Failed to get patch: %v", err)
+	}
+
+	// 2. Reset to previous state
+	exec.Command("git", "reset", "--hard", "HEAD~1").Run()
+	if _, err := os.Stat("commit_file.txt"); err == nil {
+		t.Fatalf("File should be gone after reset")
+	}
+
+	// 3. Apply with forceAm=true
+	if err := ApplyPatch(patch, true); err != nil {
+		t.Fatalf("ApplyPatch(forceAm=true) failed: %v", err)
+	}
+
+	// 4. Verify commit exists
+	out, err := exec.Command("git", "log", "-1", "--pretty=%s").Output()
+	if err != nil {
+		t.Fatalf("Failed to run git log: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "explicit commit message" {
+		t.Errorf("Expected commit message 'explicit commit message', got %q", string(out))
+	}
+	if _, err := os.Stat("commit_file.txt"); err != nil {
+		t.Errorf("File not restored after commit apply: %v", err)
+	}
+}
+
+func TestApplyPatchAlreadyApplied(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.WriteFile("test.txt", []byte("changed\n"), 0644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	diff, err := GetDiff()
+	if err != nil {
+		t.Fatalf("Failed to get diff: %v", err)
+	}
+	exec.Command("git", "checkout", "test.txt").Run()
+
+	// 1. First apply succeeds
+	if err := ApplyPatch(diff, false); err != nil {
+		t.Fatalf("first ApplyPatch failed: %v", err)
+	}
+	if !IsPatchApplied(diff) {
+		t.Error("IsPatchApplied should report true after applying")
+	}
+
+	// 2. Second apply reports the changes as already present
+	err = ApplyPatch(diff, false)
+	if !errors.Is(err, ErrAlreadyApplied) {
+		t.Fatalf("Expected ErrAlreadyApplied, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "appear to already be present") {
+		t.Errorf("Unexpected message: %q", err.Error())
+	}
+
+	// 3. A genuine conflict is not reported as already applied
+	os.WriteFile("test.txt", []byte("something else\n"), 0644)
+	err = ApplyPatch(diff, false)
+	if err == nil || errors.Is(err, ErrAlreadyApplied) {
+		t.Errorf("Expected conflict error, got %v", err)
+	}
+}
+
+func TestCommitAll(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// 1. Clean tree
+	if _, err := CommitAll("nothing"); err == nil {
+		t.Error("Expected error for clean working tree, got nil")
+	}
+
+	// 2. Modified and new files are committed together
+	os.WriteFile("test.txt", []byte("modified\n"), 0644)
+	os.WriteFile("new.txt", []byte("new\n"), 0644)
+	sha, err := CommitAll("commit everything")
+	if err != nil {
+		t.Fatalf("CommitAll failed: %v", err)
+	}
+
+	out, _ := exec.Command("git", "rev-parse", "HEAD").Output()
+	if strings.TrimSpace(string(out)) != sha {
+		t.Errorf("Returned SHA %q is not HEAD (%q)", sha, out)
+	}
+	patch, err := GetCommitPatch(sha)
+	if err != nil {
+		t.Fatalf("GetCommitPatch failed: %v", err)
+	}
+	if !bytes.Contains(patch, []byte("Subject: [PATCH] commit everything")) || !bytes.Contains(patch, []byte("new.txt")) {
+		t.Errorf("Commit patch missing expected content: %s", patch)
+	}
+}
+
+func TestCommitAllRestoresIndex(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	os.WriteFile("test.txt", []byte("staged\n"), 0644)
+	exec.Command("git", "add", "test.txt").Run()
+	os.WriteFile("untracked.txt", []byte("new\n"), 0644)
+	os.WriteFile(filepath.Join(".git", "hooks", "pre-commit"), []byte("#!/bin/sh\nexit 1\n"), 0755)
+
+	if _, err := CommitAll("blocked by the hook"); err == nil {
+		t.Fatal("Expected an error from the failing pre-commit hook, got nil")
+	}
+	out, _ := exec.Command("git", "status", "--porcelain").Output()
+	if got := string(out); got != "M  test.txt\n?? untracked.txt\n" {
+		t.Errorf("After a failed commit, git status = %q; want only test.txt staged", got)
+	}
+}
+
+func TestNotesRoundTrip(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// 1. A commit without notes
+	notes, err := GetNotes("HEAD")
+	if err != nil || notes != "" {
+		t.Fatalf("Expected no notes and no error, got %q, %v", notes, err)
+	}
+
+	// 2. Share a commit that has a note
+	os.WriteFile("noted.txt", []byte("noted\n"), 0644)
+	exec.Command("git", "add", "noted.txt").Run()
+	exec.Command("git", "commit", "-m", "noted commit").Run()
+	if err := exec.Command("git", "notes", "add", "-m", "Reviewed-by: Jane", "HEAD").Run(); err != nil {
+		t.Fatalf("Failed to add note: %v", err)
+	}
+
+	patch, err := GetCommitPatch("HEAD")
+	if err != nil {
+		t.Fatalf("GetCommitPatch failed: %v", err)
+	}
+	notes, err = GetNotes("HEAD")
+	if err != nil {
+		t.Fatalf("GetNotes failed: %v", err)
+	}
+
+	// 3. Apply it as a new commit and re-attach the note
+	exec.Command("git", "reset", "--hard", "HEAD~1").Run()
+	if err := ApplyPatch(patch, true); err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if err := AddNotes("HEAD", notes); err != nil {
+		t.Fatalf("AddNotes failed: %v", err)
+	}
+
+	got, err := GetNotes("HEAD")
+	if err != nil {
+		t.Fatalf("GetNotes on applied commit failed: %v", err)
+	}
+	if strings.TrimSpace(got) != "Reviewed-by: Jane" {
+		t.Errorf("Expected note to reappear, got %q", got)
+	}
+}
+
+func TestPatchFiles(t *testing.T) {
+	diff := `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,2 @@
+ package main
+--- not a header, just a removed line
++++ not a header, just an added line
+diff --git a/old.txt b/new.txt
+similarity index 90%
+rename from old.txt
+rename to new.txt
+index 3333333..4444444 100644
+--- a/old.txt
++++ b/new.txt
+@@ -1 +1 @@
+-a
++b
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+index 5555555..0000000
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+diff --git a/moved.txt b/renamed.txt
+similarity index 100%
+rename from moved.txt
+rename to renamed.txt
+diff --git a/script.sh b/script.sh
+old mode 100644
+new mode 100755
+diff --git "a/caf\303\251.txt" "b/caf\303\251.txt"
+new file mode 100644
+index 0000000..6666666
+--- /dev/null
++++ "b/caf\303\251.txt"
+@@ -0,0 +1 @@
++hi
+`
+	mbox := "From 1234 Mon Sep 17 00:00:00 2001\nSubject: [PATCH] change\n\n---\n a.txt | 1 +\n\n" +
+		"diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1,2 @@\n a\n+b\n-- \n2.39.5\n"
+
+	tests := []struct {
+		name  string
+		patch string
+		want  []string
+	}{
+		{"multi-file diff", diff, []string{"main.go", "new.txt", "gone.txt", "renamed.txt", "script.sh", "café.txt"}},
+		{"mbox", mbox, []string{"a.txt"}},
+		{"crlf", strings.ReplaceAll(mbox, "\n", "\r\n"), []string{"a.txt"}},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PatchFiles([]byte(tt.patch))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("PatchFiles() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchFilesFromGit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	exec.Command("git", "mv", "test.txt", "moved.txt").Run()
+	os.WriteFile("extra.txt", []byte("extra\n"), 0644)
+	exec.Command("git", "add", "-A").Run()
+	exec.Command("git", "commit", "-m", "rename").Run()
+
+	patch, err := GetCommitPatch("HEAD")
+	if err != nil {
+		t.Fatalf("GetCommitPatch failed: %v", err)
+	}
+	got := strings.Join(PatchFiles(patch), ",")
+	if got != "extra.txt,moved.txt" {
+		t.Errorf("PatchFiles() = %q, want %q", got, "extra.txt,moved.txt")
+	}
+}
+
+func TestPatchSummary(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	os.WriteFile("test.txt", []byte("changed\nadded\n"), 0644)
+	os.WriteFile("bin", []byte{0x00, 0x01}, 0644)
+	exec.Command("git", "add", "-A").Run()
+	patch, err := GetStagedDiff()
+	if err != nil {
+		t.Fatalf("GetStagedDiff failed: %v", err)
+	}
+
+	summary, err := PatchSummary(patch)
+	if err != nil {
+		t.Fatalf("PatchSummary failed: %v", err)
+	}
+	want := map[string]FileStat{
+		"bin":      {Path: "bin", Binary: true},
+		"test.txt": {Path: "test.txt", Added: 2, Deleted: 1},
+	}
+	if len(summary.Files) != len(want) {
+		t.Fatalf("got %d files, want %d: %+v", len(summary.Files), len(want), summary.Files)
+	}
+	for _, fs := range summary.Files {
+		if fs != want[fs.Path] {
+			t.Errorf("file %q = %+v, want %+v", fs.Path, fs, want[fs.Path])
+		}
+	}
+	if summary.Added != 2 || summary.Deleted != 1 {
+		t.Errorf("totals = +%d -%d, want +2 -1", summary.Added, summary.Deleted)
+	}
+}
+
+func TestParseNumstatRename(t *testing.T) {
+	s := parseNumstat("1\t0\t\x00old.txt\x00new.txt\x003\t2\tother.txt\x00")
+	if len(s.Files) != 2 || s.Files[0].Path != "new.txt" || s.Files[1].Path != "other.txt" {
+		t.Fatalf("unexpected files: %+v", s.Files)
+	}
+	if s.Added != 4 || s.Deleted != 2 {
+		t.Errorf("totals = +%d -%d, want +4 -2", s.Added, s.Deleted)
+	}
+}
+
+func TestApplyPatchSignoff(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := exec.Command("git", "am", "--help").Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			t.Skipf("git am not available: %v", err)
+		}
+	}
+
+	os.WriteFile("signed.txt", []byte("signed\n"), 0644)
+	exec.Command("git", "add", "signed.txt").Run()
+	exec.Command("git", "commit", "-m", "needs a signoff").Run()
+	patch, err := GetCommitPatch("HEAD")
+	if err != nil {
+		t.Fatalf("Failed to get patch: %v", err)
+	}
+	exec.Command("git", "reset", "--hard", "HEAD~1").Run()
+
+	// Apply as someone else, who should be the one signing off
+	exec.Command("git", "config", "user.name", "Receiving Dev").Run()
+	exec.Command("git", "config", "user.email", "receiver@example.com").Run()
+	if err := ApplyPatchWithOptions(patch, ApplyOptions{Commit: true, Signoff: true}); err != nil {
+		t.Fatalf("ApplyPatchWithOptions failed: %v", err)
+	}
+
+	out, err := exec.Command("git", "log", "-1", "--pretty=%B").Output()
+	if err != nil {
+		t.Fatalf("Failed to run git log: %v", err)
+	}
+	if !strings.Contains(string(out), "Signed-off-by: Receiving Dev <receiver@example.com>") {
+		t.Errorf("commit message lacks the receiver's signoff:\n%s", out)
+	}
+}
+
+func TestHeadCommit(t *testing.T) {
+	repo := gittest.New(t)
+	sha := repo.Commit("second", map[string]string{"b.txt": "b\n"})
+	if got, err := HeadCommit(); err != nil || got != sha {
+		t.Errorf("HeadCommit() = %q, %v; want %q", got, err, sha)
+	}
+
+	repo.Git("checkout", "-q", "--orphan", "empty")
+	if _, err := HeadCommit(); err == nil {
+		t.Error("HeadCommit succeeded on a branch without commits")
+	}
+}
+
+func TestTextconv(t *testing.T) {
+	repo := gittest.New(t)
+	repo.Git("config", "diff.upper.textconv", "tr a-z A-Z <")
+	repo.Commit("textconv", map[string]string{".gitattributes": "*.up diff=upper\n", "doc.up": "old\n"})
+	repo.WriteFile("doc.up", "new\n")
+
+	// Patches meant for applying show the real content
+	for name, get := range map[string]func() ([]byte, error){
+		"GetDiff":         func() ([]byte, error) { return GetDiff() },
+		"GetDiffFromHead": GetDiffFromHead,
+	} {
+		if patch, err := get(); err != nil || !strings.Contains(string(patch), "+new") {
+			t.Errorf("%s = %q, %v; want the unconverted change", name, patch, err)
+		}
+	}
+
+	patch, err := GetTextconvDiff(false, false)
+	if err != nil || !strings.Contains(string(patch), "+NEW") || !strings.Contains(string(patch), "-OLD") {
+		t.Errorf("GetTextconvDiff = %q, %v; want the change through the filter", patch, err)
+	}
+	if _, err := GetTextconvDiff(true, false); !errors.Is(err, ErrNoChanges) {
+		t.Errorf("staged GetTextconvDiff with nothing staged: err = %v, want ErrNoChanges", err)
+	}
+	repo.Git("add", "doc.up")
+	if patch, err := GetTextconvDiff(true, false, "doc.up"); err != nil || !strings.Contains(string(patch), "+NEW") {
+		t.Errorf("staged GetTextconvDiff = %q, %v", patch, err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	v, err := Version()
+	if err != nil {
+		t.Fatalf("Version failed: %v", err)
+	}
+	if v == "" || v[0] < '0' || v[0] > '9' || strings.HasPrefix(v, "git") {
+		t.Errorf("Version() = %q, want just the version number", v)
+	}
+}
+
+func TestUpstreamRef(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if _, err := UpstreamRef(); !errors.Is(err, ErrNoUpstream) {
+		t.Fatalf("expected ErrNoUpstream without tracking, got %v", err)
+	}
+
+	// A feature branch tracking the initial branch, two commits ahead
+	base, _ := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	baseBranch := strings.TrimSpace(string(base))
+	if err := exec.Command("git", "checkout", "-q", "--track", "-b", "feature", baseBranch).Run(); err != nil {
+		t.Fatalf("Failed to create tracking branch: %v", err)
+	}
+	for _, name := range []string{"one.txt", "two.txt"} {
+		os.WriteFile(name, []byte(name+"\n"), 0644)
+		exec.Command("git", "add", name).Run()
+		exec.Command("git", "commit", "-m", "add "+name).Run()
+	}
+
+	upstream, err := UpstreamRef()
+	if err != nil {
+		t.Fatalf("UpstreamRef failed: %v", err)
+	}
+	if upstream != baseBranch {
+		t.Errorf("UpstreamRef() = %q, want %q", upstream, baseBranch)
+	}
+
+	patch, err := GetCommitPatch(upstream + "..HEAD")
+	if err != nil {
+		t.Fatalf("GetCommitPatch failed: %v", err)
+	}
+	if got := strings.Count(string(patch), "\nSubject: "); got != 2 {
+		t.Errorf("patch has %d commits, want 2", got)
+	}
+	if strings.Contains(string(patch), "initial commit") {
+		t.Error("patch should not include commits already upstream")
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if v, err := ConfigValue("git-share.server"); err != nil || v != "" {
+		t.Errorf("unset key: got %q, %v; want empty", v, err)
+	}
+	exec.Command("git", "config", "git-share.server", "https://relay.example.com").Run()
+	if v, err := ConfigValue("git-share.server"); err != nil || v != "https://relay.example.com" {
+		t.Errorf("got %q, %v", v, err)
+	}
+}
+
+func TestApplyPatchOutsideRepo(t *testing.T) {
+	dir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// A path next to the temporary repository, so the test stays contained
+	name := filepath.Base(dir) + "-outside.txt"
+	outside := filepath.Join(filepath.Dir(dir), name)
+	defer os.Remove(outside)
+
+	patch := []byte("diff --git a/../" + name + " b/../" + name + "\n" +
+		"new file mode 100644\n" +
+		"--- /dev/null\n" +
+		"+++ b/../" + name + "\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+hello\n")
+
+	err := ApplyPatchWithOptions(patch, ApplyOptions{})
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Fatalf("expected ErrUnsafePath, got %v", err)
+	}
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Fatal("file outside the repository was written without --allow-outside")
+	}
+
+	if err := ApplyPatchWithOptions(patch, ApplyOptions{AllowOutside: true}); err != nil {
+		t.Fatalf("ApplyPatchWithOptions with AllowOutside failed: %v", err)
+	}
+	if data, err := os.ReadFile(outside); err != nil || string(data) != "hello\n" {
+		t.Errorf("outside file = %q, %v; want %q", data, err, "hello\n")
+	}
+}
+
+func TestGetShowPatch(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.WriteFile("test.txt", []byte("shown\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exec.Command("git", "commit", "-qam", "Show this message").Run()
+
+	show, err := GetShowPatch("HEAD")
+	if err != nil {
+		t.Fatalf("GetShowPatch failed: %v", err)
+	}
+	message, diff := SplitShow(show)
+	if !strings.Contains(string(message), "Show this message") {
+		t.Errorf("message missing from %q", message)
+	}
+	if !strings.HasPrefix(string(diff), "diff --git a/test.txt b/test.txt") {
+		t.Errorf("diff does not start at the diff header: %q", diff)
+	}
+
+	// The diff portion applies to the working tree
+	exec.Command("git", "reset", "-q", "--hard", "HEAD~1").Run()
+	if err := ApplyPatch(diff, false); err != nil {
+		t.Fatalf("applying the diff failed: %v", err)
+	}
+	if data, _ := os.ReadFile("test.txt"); string(data) != "shown\n" {
+		t.Errorf("test.txt = %q after apply", data)
+	}
+
+	if _, err := GetShowPatch("nonexistent"); err == nil {
+		t.Error("expected an error for an invalid ref")
+	}
+}
+
+func TestSplitShow(t *testing.T) {
+	tests := []struct {
+		name        string
+		show        string
+		wantMessage string
+		wantDiff    string
+	}{
+		{name: "message and diff", show: "commit abc\n\n    msg\n\ndiff --git a/x b/x\n", wantMessage: "commit abc\n\n    msg\n\n", wantDiff: "diff --git a/x b/x\n"},
+		{name: "no diff", show: "commit abc\n\n    empty\n", wantMessage: "commit abc\n\n    empty\n"},
+		{name: "diff only", show: "diff --git a/x b/x\n", wantDiff: "diff --git a/x b/x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, diff := SplitShow([]byte(tt.show))
+			if string(message) != tt.wantMessage || string(diff) != tt.wantDiff {
+				t.Errorf("SplitShow() = %q, %q; want %q, %q", message, diff, tt.wantMessage, tt.wantDiff)
+			}
+		})
+	}
+}
+
+func TestParseHunks(t *testing.T) {
+	patch := "From abc Mon Sep 17 00:00:00 2001\n" +
+		"Subject: [PATCH] fix\n" +
+		"\n" +
+		"diff --git a/main.go b/main.go\n" +
+		"index 111..222 100644\n" +
+		"--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -10,3 +10,3 @@ func main() {\n" +
+		" \ta := 1\n" +
+		"-\tb := 2\n" +
+		"+\tb := 3\n" +
+		" \n" +
+		"@@ -20 +20,2 @@\n" +
+		"--- not a header\n" +
+		"+++ not a header either\n" +
+		"+added\n" +
+		"\\ No newline at end of file\n" +
+		"diff --git a/new.txt b/new.txt\n" +
+		"new file mode 100644\n" +
+		"--- /dev/null\n" +
+		"+++ b/new.txt\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+hello\n" +
+		"-- \n" +
+		"2.43.0\n"
+
+	files, err := ParseHunks([]byte(patch))
+	if err != nil {
+		t.Fatalf("ParseHunks failed: %v", err)
+	}
+	want := []FileDiff{
+		{
+			OldPath: "main.go", NewPath: "main.go",
+			Header: []string{"diff --git a/main.go b/main.go", "index 111..222 100644", "--- a/main.go", "+++ b/main.go"},
+			Hunks: []Hunk{
+				{OldStart: 10, OldLines: 3, NewStart: 10, NewLines: 3, Section: " func main() {", Lines: []string{" \ta := 1", "-\tb := 2", "+\tb := 3", " "}},
+				{OldStart: 20, OldLines: 1, NewStart: 20, NewLines: 2, Lines: []string{"--- not a header", "+++ not a header either", "+added"}, NoEOL: []int{2}},
+			},
+		},
+		{
+			OldPath: "", NewPath: "new.txt",
+			Header: []string{"diff --git a/new.txt b/new.txt", "new file mode 100644", "--- /dev/null", "+++ b/new.txt"},
+			Hunks: []Hunk{
+				{OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 1, Lines: []string{"+hello"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("ParseHunks() =\n%+v\nwant\n%+v", files, want)
+	}
+
+	if _, err := ParseHunks([]byte("diff --git a/x b/x\n@@ -1,2 +1,2 @@\n-a\n")); err == nil {
+		t.Error("expected an error for a truncated hunk")
+	}
+}
+
+func TestContextRisk(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  Risk
+	}{
+		{
+			name:  "full context",
+			patch: "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -4,7 +4,7 @@\n 4\n 5\n 6\n-7\n+seven\n 8\n 9\n 10\n",
+			want:  Risk{Level: RiskLow, Hunks: 1},
+		},
+		{
+			name:  "short context at the edges of a file",
+			patch: "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+			want:  Risk{Level: RiskLow, Hunks: 2},
+		},
+		{
+			name:  "one of three hunks without context",
+			patch: "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -4,7 +4,7 @@\n 4\n 5\n 6\n-7\n+seven\n 8\n 9\n 10\n@@ -20 +20 @@\n-20\n+twenty\n@@ -30,7 +30,7 @@\n 30\n 31\n 32\n-33\n+thirty-three\n 34\n 35\n 36\n",
+			want:  Risk{Level: RiskMedium, Hunks: 3, Sparse: 1},
+		},
+		{
+			name:  "no context",
+			patch: "diff --git a/f b/f\n--- a/f\n+++ b/f\n@@ -7 +7 @@\n-7\n+seven\n@@ -20 +20 @@\n-20\n+twenty\n",
+			want:  Risk{Level: RiskHigh, Hunks: 2, Sparse: 2},
+		},
+		{
+			name:  "new file",
+			patch: "diff --git a/n b/n\nnew file mode 100644\n--- /dev/null\n+++ b/n\n@@ -0,0 +1 @@\n+new\n",
+			want:  Risk{Level: RiskLow},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ContextRisk([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("ContextRisk failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ContextRisk = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLFSPointers(t *testing.T) {
+	pointer := "diff --git a/logo.png b/logo.png\nnew file mode 100644\nindex 0000000..b1c2d3e\n--- /dev/null\n+++ b/logo.png\n@@ -0,0 +1,3 @@\n" +
+		"+version https://git-lfs.github.com/spec/v1\n+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n+size 12345\n"
+	updated := "diff --git a/model.bin b/model.bin\n--- a/model.bin\n+++ b/model.bin\n@@ -1,3 +1,3 @@\n version https://git-lfs.github.com/spec/v1\n" +
+		"-oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n-size 12345\n" +
+		"+oid sha256:b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c\n+size 23456\n"
+	text := "diff --git a/README.md b/README.md\n--- a/README.md\n+++ b/README.md\n@@ -1 +1,2 @@\n # Docs\n" +
+		"+version https://git-lfs.github.com/spec/v1 is the pointer format\n"
+
+	got, err := LFSPointers([]byte(pointer + text + updated))
+	if err != nil {
+		t.Fatalf("LFSPointers failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"logo.png", "model.bin"}) {
+		t.Errorf("LFSPointers = %v, want [logo.png model.bin]", got)
+	}
+
+	if got, _ := LFSPointers([]byte(text)); got != nil {
+		t.Errorf("LFSPointers = %v for a file that only mentions the format", got)
+	}
+}
+
+func TestFormatDiffAndSelectHunks(t *testing.T) {
+	repo := gittest.New(t)
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	original := strings.Join(lines, "\n") + "\n"
+	repo.Commit("numbers", map[string]string{"numbers.txt": original, "tail.txt": "no newline"})
+
+	// Two hunks in numbers.txt, one that changes the line count
+	changed := strings.Replace(original, "line 2\n", "line 2a\nline 2b\n", 1)
+	changed = strings.Replace(changed, "line 18\n", "line eighteen\n", 1)
+	repo.WriteFile("numbers.txt", changed)
+	repo.WriteFile("tail.txt", "still no newline")
+	if err := os.WriteFile(repo.Path("image.bin"), []byte{0, 1, 2, 3, 0, 255}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo.Git("add", "-A")
+	patch, err := GetStagedDiff()
+	if err != nil {
+		t.Fatalf("GetStagedDiff failed: %v", err)
+	}
+	repo.Git("reset", "-q", "--hard")
+
+	files, err := ParseHunks(patch)
+	if err != nil {
+		t.Fatalf("ParseHunks failed: %v", err)
+	}
+	if got := FormatDiff(files); !bytes.Equal(got, patch) {
+		t.Fatalf("FormatDiff did not round-trip\nGOT:\n%s\nWANT:\n%s", got, patch)
+	}
+
+	// Keep only the second numbers.txt hunk and the binary file
+	var selected []FileDiff
+	for _, file := range files {
+		switch file.NewPath {
+		case "numbers.txt":
+			if len(file.Hunks) != 2 {
+				t.Fatalf("numbers.txt has %d hunks, want 2", len(file.Hunks))
+			}
+			f, ok := SelectHunks(file, []bool{false, true})
+			if !ok {
+				t.Fatal("SelectHunks dropped the file")
+			}
+			selected = append(selected, f)
+		case "image.bin":
+			selected = append(selected, file)
+		}
+	}
+	if err := ApplyPatch(FormatDiff(selected), false); err != nil {
+		t.Fatalf("applying the selected hunks failed: %v", err)
+	}
+
+	want := strings.Replace(original, "line 18\n", "line eighteen\n", 1)
+	if got, _ := os.ReadFile(repo.Path("numbers.txt")); string(got) != want {
+		t.Errorf("numbers.txt =\n%s\nwant only the second hunk applied", got)
+	}
+	if got, _ := os.ReadFile(repo.Path("tail.txt")); string(got) != "no newline" {
+		t.Errorf("tail.txt = %q, want it unchanged", got)
+	}
+	if got, _ := os.ReadFile(repo.Path("image.bin")); !bytes.Equal(got, []byte{0, 1, 2, 3, 0, 255}) {
+		t.Errorf("image.bin = %v, want the binary file applied", got)
+	}
+
+	if _, ok := SelectHunks(files[0], make([]bool, len(files[0].Hunks))); ok {
+		t.Error("SelectHunks should report a file with no hunks kept")
+	}
+}
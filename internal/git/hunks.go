@@ -0,0 +1,323 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hunk is one "@@" section of a file diff.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Section            string // text after the closing "@@", e.g. the function name
+	// Lines are the hunk body, each starting with ' ', '-' or '+'.
+	Lines []string
+	// NoEOL holds the indexes of lines followed by "\ No newline at end
+	// of file".
+	NoEOL []int
+}
+
+// FileDiff holds the hunks for one file in a patch.
+type FileDiff struct {
+	OldPath string // "" for a new file
+	NewPath string // "" for a deleted file
+	Binary  bool
+	// Header holds the lines from "diff --git" up to the first hunk, and
+	// the whole patch of a binary file, so the file can be written back
+	// out by FormatDiff.
+	Header []string
+	Hunks  []Hunk
+}
+
+// ParseHunks parses the file diffs of a diff or mbox patch. Hunk bodies are
+// read by their line counts, so lines inside them that look like headers
+// are not misread.
+func ParseHunks(patch []byte) ([]FileDiff, error) {
+	var files []FileDiff
+	var file *FileDiff
+	var hunk *Hunk
+	oldLeft, newLeft := 0, 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(patch))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(patch)+1)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		if hunk != nil && (oldLeft > 0 || newLeft > 0) {
+			switch {
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+				hunk.NoEOL = append(hunk.NoEOL, len(hunk.Lines)-1)
+				continue
+			case strings.HasPrefix(line, "-"):
+				oldLeft--
+			case strings.HasPrefix(line, "+"):
+				newLeft--
+			case strings.HasPrefix(line, " "), line == "":
+				// Some editors strip the space from empty context lines
+				line = " " + strings.TrimPrefix(line, " ")
+				oldLeft--
+				newLeft--
+			default:
+				return nil, fmt.Errorf("malformed hunk in %s: unexpected line %q", file.path(), line)
+			}
+			hunk.Lines = append(hunk.Lines, line)
+			continue
+		}
+		if hunk != nil && strings.HasPrefix(line, `\`) {
+			// "\ No newline at end of file" after the last line
+			hunk.NoEOL = append(hunk.NoEOL, len(hunk.Lines)-1)
+			continue
+		}
+		hunk = nil
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			header := strings.TrimPrefix(line, "diff --git ")
+			files = append(files, FileDiff{OldPath: headerPath(header), NewPath: headerPath(header)})
+			file = &files[len(files)-1]
+		case file == nil:
+			// Commit message or mbox headers
+		case line == "-- " || mboxFromLine.MatchString(line):
+			// The mbox signature, or the next commit's headers
+			file = nil
+			continue
+		case strings.HasPrefix(line, "@@ "):
+			h, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file.path(), err)
+			}
+			file.Hunks = append(file.Hunks, h)
+			hunk = &file.Hunks[len(file.Hunks)-1]
+			oldLeft, newLeft = h.OldLines, h.NewLines
+			continue
+		case len(file.Hunks) > 0:
+			// Trailing lines after the hunks, e.g. blank mbox lines
+			continue
+		case strings.HasPrefix(line, "--- "):
+			file.OldPath = diffPath(strings.TrimPrefix(line, "--- "), "a/")
+		case strings.HasPrefix(line, "+++ "):
+			file.NewPath = diffPath(strings.TrimPrefix(line, "+++ "), "b/")
+		case strings.HasPrefix(line, "new file mode "):
+			file.OldPath = ""
+		case strings.HasPrefix(line, "deleted file mode "):
+			file.NewPath = ""
+		case strings.HasPrefix(line, "GIT binary patch"), strings.HasPrefix(line, "Binary files "):
+			file.Binary = true
+		}
+		if file != nil {
+			file.Header = append(file.Header, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if hunk != nil && (oldLeft > 0 || newLeft > 0) {
+		return nil, fmt.Errorf("truncated hunk in %s", file.path())
+	}
+	return files, nil
+}
+
+// path returns the file's new path, or its old one if it was deleted.
+func (f *FileDiff) path() string {
+	if f.NewPath != "" {
+		return f.NewPath
+	}
+	return f.OldPath
+}
+
+// parseHunkHeader parses "@@ -oldStart,oldLines +newStart,newLines @@".
+// A missing line count means one line.
+func parseHunkHeader(line string) (Hunk, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[3] != "@@" {
+		return Hunk{}, fmt.Errorf("malformed hunk header %q", line)
+	}
+	var h Hunk
+	var err error
+	if h.OldStart, h.OldLines, err = parseRange(fields[1], "-"); err != nil {
+		return Hunk{}, fmt.Errorf("malformed hunk header %q", line)
+	}
+	if h.NewStart, h.NewLines, err = parseRange(fields[2], "+"); err != nil {
+		return Hunk{}, fmt.Errorf("malformed hunk header %q", line)
+	}
+	_, h.Section, _ = strings.Cut(line[2:], "@@")
+	return h, nil
+}
+
+func parseRange(s, sign string) (start, lines int, err error) {
+	s, ok := strings.CutPrefix(s, sign)
+	if !ok {
+		return 0, 0, fmt.Errorf("missing %q", sign)
+	}
+	startStr, linesStr, hasLines := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(startStr); err != nil {
+		return 0, 0, err
+	}
+	lines = 1
+	if hasLines {
+		if lines, err = strconv.Atoi(linesStr); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, lines, nil
+}
+
+// FormatDiff writes file diffs back out as a patch that git apply accepts.
+func FormatDiff(files []FileDiff) []byte {
+	var b bytes.Buffer
+	for _, file := range files {
+		for _, line := range file.Header {
+			b.WriteString(line + "\n")
+		}
+		for _, h := range file.Hunks {
+			fmt.Fprintf(&b, "@@ -%s +%s @@%s\n", formatRange(h.OldStart, h.OldLines), formatRange(h.NewStart, h.NewLines), h.Section)
+			noEOL := 0
+			for i, line := range h.Lines {
+				b.WriteString(line + "\n")
+				if noEOL < len(h.NoEOL) && h.NoEOL[noEOL] == i {
+					b.WriteString("\\ No newline at end of file\n")
+					noEOL++
+				}
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// formatRange writes a hunk range the way git does, leaving out a count of 1.
+func formatRange(start, lines int) string {
+	if lines == 1 {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// SelectHunks returns file with only the hunks that keep marks, shifting
+// the new line numbers of later hunks to account for the ones left out.
+// It returns false if no hunks are kept.
+func SelectHunks(file FileDiff, keep []bool) (FileDiff, bool) {
+	var hunks []Hunk
+	shift := 0
+	for i, h := range file.Hunks {
+		if !keep[i] {
+			shift += h.NewLines - h.OldLines
+			continue
+		}
+		h.NewStart -= shift
+		hunks = append(hunks, h)
+	}
+	if len(hunks) == 0 {
+		return FileDiff{}, false
+	}
+	file.Hunks = hunks
+	return file, true
+}
+
+// minSafeContext is the number of context lines git produces by default. A
+// hunk with fewer has less to anchor it, so it is more likely to apply in
+// the wrong place or conflict on a tree that has moved on.
+const minSafeContext = 3
+
+// Risk levels reported by ContextRisk.
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+// Risk rates how likely a patch is to apply cleanly on a tree that differs
+// from the one it was made on, judging by how much context its hunks carry.
+type Risk struct {
+	Level  string
+	Hunks  int // hunks that modify existing lines
+	Sparse int // of those, hunks with less than the default context
+}
+
+// ContextRisk estimates a patch's apply risk. Hunks at the start of a file,
+// or last in a file, may have short context only because the file ends
+// there, so only the side that could have had more context is counted. New
+// and deleted files carry no risk.
+func ContextRisk(patch []byte) (Risk, error) {
+	files, err := ParseHunks(patch)
+	if err != nil {
+		return Risk{}, err
+	}
+
+	var r Risk
+	for _, file := range files {
+		if file.OldPath == "" || file.NewPath == "" {
+			continue
+		}
+		for i, h := range file.Hunks {
+			r.Hunks++
+			leading, trailing := contextLines(h)
+			last := i == len(file.Hunks)-1
+			if (h.OldStart > 1 && leading < minSafeContext) || (!last && trailing < minSafeContext) {
+				r.Sparse++
+			}
+		}
+	}
+
+	switch {
+	case r.Sparse == 0:
+		r.Level = RiskLow
+	case r.Sparse*2 < r.Hunks:
+		r.Level = RiskMedium
+	default:
+		r.Level = RiskHigh
+	}
+	return r, nil
+}
+
+// contextLines counts the unchanged lines before the first change and after
+// the last one in a hunk.
+func contextLines(h Hunk) (leading, trailing int) {
+	for leading < len(h.Lines) && h.Lines[leading][0] == ' ' {
+		leading++
+	}
+	for trailing < len(h.Lines)-leading && h.Lines[len(h.Lines)-1-trailing][0] == ' ' {
+		trailing++
+	}
+	return leading, trailing
+}
+
+// lfsPointerHeader starts every Git LFS pointer file.
+const lfsPointerHeader = "version https://git-lfs.github.com/spec/"
+
+// LFSPointers returns the files a patch writes Git LFS pointers to, new or
+// updated. The patch then carries the pointers, not the objects they point
+// at.
+func LFSPointers(patch []byte) ([]string, error) {
+	files, err := ParseHunks(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var pointers []string
+	for _, file := range files {
+		if file.NewPath == "" || len(file.Hunks) == 0 || file.Hunks[0].NewStart > 1 {
+			continue
+		}
+		// A pointer file starts with the version line and names an oid
+		var lines []string
+		for _, line := range file.Hunks[0].Lines {
+			if line[0] != '-' {
+				lines = append(lines, line[1:])
+			}
+		}
+		if len(lines) == 0 || !strings.HasPrefix(lines[0], lfsPointerHeader) {
+			continue
+		}
+		for _, line := range lines[1:] {
+			if strings.HasPrefix(line, "oid sha256:") {
+				pointers = append(pointers, file.NewPath)
+				break
+			}
+		}
+	}
+	return pointers, nil
+}
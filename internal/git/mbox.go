@@ -0,0 +1,166 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CommitInfo is the authorship and message of a commit in an mbox patch.
+type CommitInfo struct {
+	Author  string // "Name <email>"
+	Date    string // the author date as written in the mbox (RFC 2822)
+	Message string
+}
+
+// mboxFromLine matches the separator format-patch writes before each commit.
+var mboxFromLine = regexp.MustCompile(`^From [0-9a-f]{40,64} `)
+
+// subjectPrefix matches the "[PATCH n/m]" tag format-patch adds to subjects.
+var subjectPrefix = regexp.MustCompile(`^(\[[^]]*\]\s*)+`)
+
+// ParseMbox reads the author, date, and message of a single-commit
+// format-patch mbox. It returns an error for plain diffs and for mboxes with
+// more than one commit.
+func ParseMbox(patch []byte) (CommitInfo, error) {
+	first, rest, _ := bytes.Cut(patch, []byte("\n"))
+	if !mboxFromLine.Match(first) {
+		return CommitInfo{}, fmt.Errorf("the patch is a plain diff, not a commit")
+	}
+	if n := mboxCommits(patch); n > 1 {
+		return CommitInfo{}, fmt.Errorf("the patch contains %d commits; apply them with --commit", n)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(rest))
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("reading mbox headers: %w", err)
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("reading mbox author: %w", err)
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		return CommitInfo{}, fmt.Errorf("reading mbox date: %w", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("reading mbox subject: %w", err)
+	}
+	subject = subjectPrefix.ReplaceAllString(subject, "")
+
+	// The body runs up to the "---" line before the diffstat
+	var body []string
+	scanner := bufio.NewScanner(msg.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), len(patch)+1)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "---" || strings.HasPrefix(line, "diff --git ") {
+			break
+		}
+		body = append(body, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return CommitInfo{}, err
+	}
+
+	message := subject
+	if b := strings.TrimSpace(strings.Join(body, "\n")); b != "" {
+		message += "\n\n" + b
+	}
+	name := from.Name
+	if name == "" {
+		// git refuses an author without a name
+		name = from.Address
+	}
+	return CommitInfo{Author: fmt.Sprintf("%s <%s>", name, from.Address), Date: msg.Header.Get("Date"), Message: message}, nil
+}
+
+// DiffToCommitPatch wraps a plain diff in a single-commit mbox like
+// format-patch writes, so it can be applied with git am. subject is the
+// commit message, whose first line becomes the subject, and author is
+// "Name <email>". The commit is dated now.
+func DiffToCommitPatch(diff []byte, subject, author string) ([]byte, error) {
+	name, email, ok := strings.Cut(author, "<")
+	email, closed := strings.CutSuffix(strings.TrimSpace(email), ">")
+	name = strings.TrimSpace(name)
+	if !ok || !closed || name == "" || email == "" {
+		return nil, fmt.Errorf("invalid author %q: expected \"Name <email>\"", author)
+	}
+	title, body, _ := strings.Cut(strings.TrimSpace(subject), "\n")
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, fmt.Errorf("the commit message is empty")
+	}
+
+	var b bytes.Buffer
+	b.WriteString("From 0000000000000000000000000000000000000000 Mon Sep 17 00:00:00 2001\n")
+	fmt.Fprintf(&b, "From: %s\n", (&mail.Address{Name: name, Address: email}).String())
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Subject: [PATCH] %s\n", mime.QEncoding.Encode("utf-8", title))
+	b.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n\n")
+	if body = strings.TrimSpace(body); body != "" {
+		b.WriteString(body + "\n\n")
+	}
+	b.WriteString("---\n")
+	b.Write(diff)
+	if len(diff) > 0 && diff[len(diff)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+// mboxCommits counts the commits in a format-patch mbox.
+func mboxCommits(patch []byte) int {
+	n := 0
+	scanner := bufio.NewScanner(bytes.NewReader(patch))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(patch)+1)
+	for scanner.Scan() {
+		if mboxFromLine.Match(scanner.Bytes()) {
+			n++
+		}
+	}
+	return n
+}
+
+// CommitPatch commits the files a patch changed, which must already be
+// applied to the working tree, with the given authorship and message.
+// Other staged changes are left out of the commit. Returns the new commit's
+// SHA.
+func CommitPatch(patch []byte, info CommitInfo) (string, error) {
+	paths := append(PatchFiles(patch), renameSources(patch)...)
+	if len(paths) == 0 {
+		return "", noChangesError("the patch changes no files")
+	}
+	if _, err := runGit(append([]string{"add", "-A", "--"}, paths...)...); err != nil {
+		return "", fmt.Errorf("staging changes: %w", err)
+	}
+	args := append([]string{"commit", "-q", "--author", info.Author, "--date", info.Date, "-F", "-", "--"}, paths...)
+	if err := runGitWithStdin([]byte(info.Message), args...); err != nil {
+		return "", fmt.Errorf("creating commit: %w", err)
+	}
+	out, err := runGit("rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("resolving new commit: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// renameSources returns the old paths of files a patch renames, which
+// PatchFiles leaves out.
+func renameSources(patch []byte) []string {
+	var paths []string
+	scanner := bufio.NewScanner(bytes.NewReader(patch))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(patch)+1)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if from, ok := strings.CutPrefix(line, "rename from "); ok {
+			paths = append(paths, unquotePath(from))
+		}
+	}
+	return paths
+}
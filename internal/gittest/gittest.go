@@ -0,0 +1,78 @@
+package gittest
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Repo is a throwaway git repository for a test.
+type Repo struct {
+	t   testing.TB
+	Dir string
+}
+
+// New creates a repository in a temporary directory, with test.txt
+// ("initial\n") committed so HEAD exists, and changes into it for the rest
+// of the test, since the git package works on the current directory. Tests
+// using it cannot run in parallel.
+func New(t testing.TB) *Repo {
+	t.Helper()
+	r := &Repo{t: t, Dir: t.TempDir()}
+	r.Git("init")
+	r.Git("config", "user.email", "test@example.com")
+	r.Git("config", "user.name", "Test User")
+	r.Commit("initial commit", map[string]string{"test.txt": "initial\n"})
+	t.Chdir(r.Dir)
+	return r
+}
+
+// Git runs git in the repository and returns its trimmed output, failing
+// the test if it exits with an error.
+func (r *Repo) Git(args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Path returns the absolute path of a file in the repository.
+func (r *Repo) Path(name string) string {
+	return filepath.Join(r.Dir, filepath.FromSlash(name))
+}
+
+// WriteFile writes a file in the working tree, creating its directories.
+func (r *Repo) WriteFile(name, content string) {
+	r.t.Helper()
+	path := r.Path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		r.t.Fatalf("creating directories for %s: %v", name, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		r.t.Fatalf("writing %s: %v", name, err)
+	}
+}
+
+// Commit writes files, stages them, commits with message, and returns the
+// new commit's SHA.
+func (r *Repo) Commit(message string, files map[string]string) string {
+	r.t.Helper()
+	for name, content := range files {
+		r.WriteFile(name, content)
+		r.Git("add", "--", name)
+	}
+	r.Git("commit", "--allow-empty", "-m", message)
+	return r.Git("rev-parse", "HEAD")
+}
+
+// Branch creates a branch at HEAD and checks it out.
+func (r *Repo) Branch(name string) {
+	r.t.Helper()
+	r.Git("checkout", "-b", name)
+}
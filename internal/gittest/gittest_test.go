@@ -0,0 +1,46 @@
+package gittest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	r := New(t)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	if resolved, _ := filepath.EvalSymlinks(r.Dir); wd != r.Dir && wd != resolved {
+		t.Errorf("working directory is %s, want the repo at %s", wd, r.Dir)
+	}
+	if got := r.Git("log", "--format=%s"); got != "initial commit" {
+		t.Errorf("history is %q, want the initial commit", got)
+	}
+	if status := r.Git("status", "--porcelain"); status != "" {
+		t.Errorf("working tree is not clean:\n%s", status)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	r := New(t)
+
+	sha := r.Commit("add nested file", map[string]string{"dir/sub/file.txt": "hello\n"})
+	if head := r.Git("rev-parse", "HEAD"); head != sha {
+		t.Errorf("Commit returned %s, HEAD is %s", sha, head)
+	}
+	if got := r.Git("show", "HEAD:dir/sub/file.txt"); got != "hello" {
+		t.Errorf("committed content is %q", got)
+	}
+
+	r.Branch("feature")
+	r.Commit("on feature", nil)
+	if got := r.Git("rev-list", "--count", "HEAD"); got != "3" {
+		t.Errorf("feature has %s commits, want 3", got)
+	}
+	if got := r.Git("rev-parse", "--abbrev-ref", "HEAD"); got != "feature" {
+		t.Errorf("on branch %q, want feature", got)
+	}
+}
@@ -0,0 +1,119 @@
+// Package ledger keeps a local record of the codes a user has sent, so they
+// can see which may still be waiting on the relay. Only metadata is kept,
+// never the patch.
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Entry records one send.
+type Entry struct {
+	Code    string    `json:"code"` // what the receiver types; just the code ID with a custom passphrase
+	CodeID  string    `json:"code_id"`
+	Server  string    `json:"server,omitempty"`
+	Space   string    `json:"space,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// Expired reports whether the entry's TTL has run out at now. The patch
+// may also be gone sooner, once it is received.
+func (e Entry) Expired(now time.Time) bool {
+	return !now.Before(e.Expires)
+}
+
+// Path returns where the ledger is kept: $XDG_STATE_HOME/git-share/sent.json,
+// or ~/.local/state/git-share/sent.json if XDG_STATE_HOME is not set.
+func Path() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("finding the ledger: %w", err)
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "git-share", "sent.json"), nil
+}
+
+// Load reads the entries in the ledger at path, oldest first. A missing
+// ledger has no entries.
+func Load(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading ledger: %w", err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// Add appends an entry to the ledger at path, creating it if needed.
+func Add(path string, e Entry) error {
+	entries, err := Load(path)
+	if err != nil {
+		return err
+	}
+	return save(path, append(entries, e))
+}
+
+// Prune removes the entries that expired before now and returns how many
+// were removed.
+func Prune(path string, now time.Time) (int, error) {
+	entries, err := Load(path)
+	if err != nil {
+		return 0, err
+	}
+	var kept []Entry
+	for _, e := range entries {
+		if !e.Expired(now) {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == len(entries) {
+		return 0, nil
+	}
+	return len(entries) - len(kept), save(path, kept)
+}
+
+// save replaces the ledger atomically. Codes include their passphrases, so
+// only the user can read it.
+func save(path string, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding ledger: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("creating ledger directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sent-*.json")
+	if err != nil {
+		return fmt.Errorf("writing ledger: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing ledger: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing ledger: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing ledger: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAddLoadPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "sent.json")
+	if entries, err := Load(path); err != nil || len(entries) != 0 {
+		t.Fatalf("Load of a missing ledger = %v, %v; want no entries", entries, err)
+	}
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	old := Entry{Code: "aaa-alpha-bravo-charlie-delta", CodeID: "aaa", Created: now.Add(-2 * time.Hour), Expires: now.Add(-time.Hour)}
+	live := Entry{Code: "bbb", CodeID: "bbb", Space: "team", Server: "https://relay.example.com", Created: now, Expires: now.Add(time.Hour)}
+	for _, e := range []Entry{old, live} {
+		if err := Add(path, e); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	entries, err := Load(path)
+	if err != nil || !reflect.DeepEqual(entries, []Entry{old, live}) {
+		t.Fatalf("Load = %+v, %v; want both entries in order", entries, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("ledger mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	if !old.Expired(now) || live.Expired(now) {
+		t.Error("Expired disagrees with the entries' expiry")
+	}
+	if n, err := Prune(path, now); err != nil || n != 1 {
+		t.Fatalf("Prune = %d, %v; want 1 removed", n, err)
+	}
+	if entries, _ := Load(path); !reflect.DeepEqual(entries, []Entry{live}) {
+		t.Errorf("after Prune, entries = %+v", entries)
+	}
+	if n, err := Prune(path, now); err != nil || n != 0 {
+		t.Errorf("second Prune = %d, %v; want nothing removed", n, err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+	if got, _ := Path(); got != filepath.Join(dir, "git-share", "sent.json") {
+		t.Errorf("Path() = %q", got)
+	}
+}
@@ -0,0 +1,175 @@
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// magic marks plaintext that carries a payload header. Plaintext without it is
+// a bare patch, which is what older versions of git-share send.
+const magic = "git-share-payload/1\n"
+
+// Payload kinds.
+const (
+	// KindPatch is a patch, optionally with metadata.
+	KindPatch = "patch"
+	// KindManifest lists the code IDs of the parts of a split blob.
+	KindManifest = "manifest"
+)
+
+// FormatShow marks a patch body that is "git show" output: the commit
+// message followed by the diff.
+const FormatShow = "show"
+
+// FormatTextconv marks a diff made with textconv filters, for reading: files
+// with a filter are shown converted, so the diff can't be applied.
+const FormatTextconv = "textconv"
+
+// EncodingGzip marks a body compressed with Compress.
+const EncodingGzip = "gzip"
+
+// Compression levels accepted by Compress.
+const (
+	MinCompressLevel     = gzip.BestSpeed
+	MaxCompressLevel     = gzip.BestCompression
+	DefaultCompressLevel = 6
+)
+
+// Header describes the contents of a decrypted payload.
+type Header struct {
+	Kind  string   `json:"kind"`
+	Parts []string `json:"parts,omitempty"` // manifest only: part code IDs, in order
+	Notes string   `json:"notes,omitempty"` // git notes of the shared commit
+
+	Encoding string `json:"encoding,omitempty"` // body encoding, e.g. EncodingGzip
+	Format   string `json:"format,omitempty"`   // patch format, e.g. FormatShow; empty for a plain diff or mbox
+
+	// Base is the commit a working tree diff was made against, so the
+	// receiver can tell when it is applying onto a different one.
+	Base string `json:"base,omitempty"`
+
+	// A signed patch carries the sender's Ed25519 signature over SignedData
+	// and the public key to check it with.
+	Signature string `json:"signature,omitempty"`
+	SignerKey string `json:"signer_key,omitempty"`
+}
+
+// HasMetadata reports whether a patch header carries anything beyond the
+// patch itself. Patches without metadata are sent bare, so older versions
+// can still receive them.
+func (h Header) HasMetadata() bool {
+	return h.Notes != "" || h.Encoding != "" || h.Format != "" || h.Signature != "" || h.Base != ""
+}
+
+// SignedData returns what a patch's signature covers: the uncompressed
+// patch, and the metadata that changes how it is shown or applied. Base is
+// left out when empty, so patches signed before it was covered still verify.
+func SignedData(h Header, patch []byte) []byte {
+	meta, _ := json.Marshal(struct {
+		Format string `json:"format"`
+		Notes  string `json:"notes"`
+		Base   string `json:"base,omitempty"`
+	}{h.Format, h.Notes, h.Base})
+	return append(append(meta, '\n'), patch...)
+}
+
+// Compress gzips body at the given level, from MinCompressLevel (fastest)
+// to MaxCompressLevel (smallest). Set the header's Encoding to EncodingGzip
+// so Decode reverses it.
+func Compress(body []byte, level int) ([]byte, error) {
+	if level < MinCompressLevel || level > MaxCompressLevel {
+		return nil, fmt.Errorf("compression level must be between %d and %d, got %d", MinCompressLevel, MaxCompressLevel, level)
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(body); err != nil {
+		return nil, fmt.Errorf("compressing payload: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compressing payload: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode wraps body in an envelope carrying the given header.
+func Encode(h Header, body []byte) ([]byte, error) {
+	if h.Kind == "" {
+		h.Kind = KindPatch
+	}
+	hdr, err := json.Marshal(h)
+	if err != nil {
+		return nil, fmt.Errorf("encoding payload header: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(magic) + len(hdr) + 1 + len(body))
+	buf.WriteString(magic)
+	buf.Write(hdr)
+	buf.WriteByte('\n')
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// Decode splits decrypted plaintext into its header and body.
+// Plaintext without an envelope is returned as a bare patch. A compressed
+// body is decompressed, so the body returned is always the original.
+func Decode(data []byte) (Header, []byte, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return Header{Kind: KindPatch}, data, nil
+	}
+
+	rest := data[len(magic):]
+	end := bytes.IndexByte(rest, '\n')
+	if end < 0 {
+		return Header{}, nil, errors.New("malformed payload: missing header terminator")
+	}
+
+	var h Header
+	if err := json.Unmarshal(rest[:end], &h); err != nil {
+		return Header{}, nil, fmt.Errorf("malformed payload header: %w", err)
+	}
+	body := rest[end+1:]
+
+	switch h.Encoding {
+	case "":
+	case EncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return Header{}, nil, fmt.Errorf("decompressing payload: %w", err)
+		}
+		body, err = io.ReadAll(zr)
+		if err != nil {
+			return Header{}, nil, fmt.Errorf("decompressing payload: %w", err)
+		}
+	default:
+		return Header{}, nil, fmt.Errorf("unsupported payload encoding %q; try upgrading git-share", h.Encoding)
+	}
+	return h, body, nil
+}
+
+// EncodeData base64-encodes an encrypted blob for the relay's data field.
+// With urlSafe, the URL-safe alphabet ("-_" instead of "+/") is used, for
+// proxies that mangle the standard one. The relay stores the string as is.
+func EncodeData(blob []byte, urlSafe bool) string {
+	if urlSafe {
+		return base64.URLEncoding.EncodeToString(blob)
+	}
+	return base64.StdEncoding.EncodeToString(blob)
+}
+
+// DecodeData decodes a data field written by EncodeData in either alphabet.
+// The alphabets only differ in two characters, so mapping the URL-safe ones
+// onto the standard ones is enough to detect and decode both.
+func DecodeData(data string) ([]byte, error) {
+	data = strings.NewReplacer("-", "+", "_", "/").Replace(data)
+	return base64.StdEncoding.DecodeString(data)
+}
@@ -0,0 +1,168 @@
+package payload
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	body := []byte("diff --git a/x b/x\n")
+	h := Header{Kind: KindManifest, Parts: []string{"aaa", "bbb"}}
+
+	data, err := Encode(h, body)
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+
+	got, gotBody, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, h) {
+		t.Errorf("header mismatch: got %+v, want %+v", got, h)
+	}
+	if !bytes.Equal(gotBody, body) {
+		t.Errorf("body mismatch: got %q, want %q", gotBody, body)
+	}
+}
+
+func TestDecodeBarePatch(t *testing.T) {
+	patch := []byte("diff --git a/x b/x\n")
+
+	h, body, err := Decode(patch)
+	if err != nil {
+		t.Fatalf("Decode() error: %v", err)
+	}
+	if h.Kind != KindPatch {
+		t.Errorf("expected kind %q, got %q", KindPatch, h.Kind)
+	}
+	if !bytes.Equal(body, patch) {
+		t.Errorf("bare patch should be returned unchanged, got %q", body)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	cases := [][]byte{
+		[]byte(magic + "{no terminator"),
+		[]byte(magic + "not json\nbody"),
+	}
+	for _, c := range cases {
+		if _, _, err := Decode(c); err == nil {
+			t.Errorf("Decode(%q) expected error, got nil", c)
+		}
+	}
+}
+
+func TestHasMetadata(t *testing.T) {
+	if (Header{Kind: KindPatch}).HasMetadata() {
+		t.Error("plain patch header should have no metadata")
+	}
+	if !(Header{Kind: KindPatch, Notes: "Reviewed-by: someone"}).HasMetadata() {
+		t.Error("header with notes should have metadata")
+	}
+	if !(Header{Kind: KindPatch, Signature: "c2ln"}).HasMetadata() {
+		t.Error("signed header should have metadata")
+	}
+	if !(Header{Kind: KindPatch, Base: "0123abcd"}).HasMetadata() {
+		t.Error("header with a base commit should have metadata")
+	}
+}
+
+func TestSignedData(t *testing.T) {
+	patch := []byte("diff --git a/x b/x\n")
+	plain := SignedData(Header{}, patch)
+	// The signature covers how the patch is applied, not how it travels
+	if !bytes.Equal(plain, SignedData(Header{Encoding: EncodingGzip, Signature: "c2ln"}, patch)) {
+		t.Error("signed data should not depend on the encoding or signature")
+	}
+	for _, h := range []Header{{Format: FormatShow}, {Notes: "Reviewed-by: someone"}, {Base: "abc123"}} {
+		if bytes.Equal(plain, SignedData(h, patch)) {
+			t.Errorf("signed data should cover %+v", h)
+		}
+	}
+	// Patches signed before Base was covered keep verifying
+	if want := `{"format":"","notes":""}` + "\n" + string(patch); string(plain) != want {
+		t.Errorf("signed data = %q, want %q", plain, want)
+	}
+}
+
+func TestCompressLevels(t *testing.T) {
+	body := bytes.Repeat([]byte("+\tfmt.Println(\"hello, world\") // a fairly compressible line\n"), 2000)
+
+	sizes := map[int]int{}
+	for level := MinCompressLevel; level <= MaxCompressLevel; level++ {
+		compressed, err := Compress(body, level)
+		if err != nil {
+			t.Fatalf("Compress(level %d) error: %v", level, err)
+		}
+		sizes[level] = len(compressed)
+
+		data, err := Encode(Header{Kind: KindPatch, Encoding: EncodingGzip}, compressed)
+		if err != nil {
+			t.Fatalf("Encode() error: %v", err)
+		}
+		_, got, err := Decode(data)
+		if err != nil {
+			t.Fatalf("Decode(level %d) error: %v", level, err)
+		}
+		if !bytes.Equal(got, body) {
+			t.Errorf("level %d did not round-trip", level)
+		}
+	}
+
+	if sizes[MaxCompressLevel] > sizes[MinCompressLevel] {
+		t.Errorf("level %d produced %d bytes, more than level %d's %d", MaxCompressLevel, sizes[MaxCompressLevel], MinCompressLevel, sizes[MinCompressLevel])
+	}
+	if sizes[MinCompressLevel] >= len(body) {
+		t.Errorf("compressed size %d is not smaller than input %d", sizes[MinCompressLevel], len(body))
+	}
+}
+
+func TestCompressInvalidLevel(t *testing.T) {
+	for _, level := range []int{-1, 0, 10} {
+		if _, err := Compress([]byte("x"), level); err == nil {
+			t.Errorf("Compress(level %d) expected an error", level)
+		}
+	}
+}
+
+func TestDecodeUnknownEncoding(t *testing.T) {
+	data, _ := Encode(Header{Kind: KindPatch, Encoding: "zstd"}, []byte("x"))
+	if _, _, err := Decode(data); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+}
+
+func TestEncodeDataRoundTrip(t *testing.T) {
+	// 0xfb 0xff encodes to characters that differ between the alphabets
+	blob := []byte{0xfb, 0xff, 0xbf, 'p', 'a', 't', 'c', 'h', 0xfe}
+
+	tests := []struct {
+		name    string
+		urlSafe bool
+		want    string
+	}{
+		{name: "standard", urlSafe: false, want: "+/+/cGF0Y2j+"},
+		{name: "url-safe", urlSafe: true, want: "-_-_cGF0Y2j-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := EncodeData(blob, tt.urlSafe)
+			if data != tt.want {
+				t.Errorf("EncodeData() = %q, want %q", data, tt.want)
+			}
+			got, err := DecodeData(data)
+			if err != nil {
+				t.Fatalf("DecodeData() error: %v", err)
+			}
+			if !bytes.Equal(got, blob) {
+				t.Errorf("round trip = %x, want %x", got, blob)
+			}
+		})
+	}
+
+	if _, err := DecodeData("not base64!"); err == nil {
+		t.Error("expected an error for invalid data")
+	}
+}
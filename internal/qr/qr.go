@@ -0,0 +1,509 @@
+// Package qr encodes short text as a QR code and draws it in a terminal.
+//
+// It implements just enough of ISO/IEC 18004 for share codes: byte mode,
+// error correction level M, and versions 1 to 10 (up to 213 bytes).
+package qr
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrTooLong is returned by Encode for text that doesn't fit in the largest
+// supported version.
+var ErrTooLong = errors.New("text is too long for a QR code")
+
+// Code is an encoded QR symbol.
+type Code struct {
+	Size    int // modules per side
+	modules [][]bool
+}
+
+// Dark reports whether the module in column x, row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// version describes the error correction blocks of a version at level M.
+type version struct {
+	ecPerBlock int
+	blocks     []int // data codewords in each block, short blocks first
+	alignment  []int // alignment pattern centers, in both directions
+}
+
+var versions = []version{
+	1:  {10, []int{16}, nil},
+	2:  {16, []int{28}, []int{6, 18}},
+	3:  {26, []int{44}, []int{6, 22}},
+	4:  {18, []int{32, 32}, []int{6, 26}},
+	5:  {24, []int{43, 43}, []int{6, 30}},
+	6:  {16, []int{27, 27, 27, 27}, []int{6, 34}},
+	7:  {18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	8:  {22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	9:  {22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	10: {26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+func (v version) dataCodewords() int {
+	n := 0
+	for _, b := range v.blocks {
+		n += b
+	}
+	return n
+}
+
+// countBits is the length of the byte mode character count.
+func countBits(ver int) int {
+	if ver < 10 {
+		return 8
+	}
+	return 16
+}
+
+// Encode encodes text in the smallest version that holds it.
+func Encode(text string) (*Code, error) {
+	for ver := 1; ver < len(versions); ver++ {
+		if 4+countBits(ver)+8*len(text) <= 8*versions[ver].dataCodewords() {
+			return encode([]byte(text), ver), nil
+		}
+	}
+	return nil, ErrTooLong
+}
+
+func encode(data []byte, ver int) *Code {
+	codewords := interleave(dataCodewords(data, ver), versions[ver])
+
+	c := newSymbol(ver)
+	c.drawCodewords(codewords)
+
+	// Use the mask that leaves the fewest patterns that confuse scanners
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // masks are their own inverse
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+	return &Code{Size: c.size, modules: c.modules}
+}
+
+// dataCodewords returns the byte mode bit stream for data, padded to the
+// version's capacity.
+func dataCodewords(data []byte, ver int) []byte {
+	var bits bitWriter
+	bits.write(0b0100, 4) // byte mode
+	bits.write(len(data), countBits(ver))
+	for _, b := range data {
+		bits.write(int(b), 8)
+	}
+
+	capacity := 8 * versions[ver].dataCodewords()
+	bits.write(0, min(4, capacity-bits.n)) // terminator
+	bits.write(0, (8-bits.n%8)%8)
+	for pad := 0xEC; bits.n < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.write(pad, 8)
+	}
+	return bits.bytes
+}
+
+type bitWriter struct {
+	bytes []byte
+	n     int // bits written
+}
+
+func (w *bitWriter) write(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.bytes = append(w.bytes, 0)
+		}
+		if value>>i&1 == 1 {
+			w.bytes[w.n/8] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
+
+// interleave splits data into the version's blocks, adds error correction
+// to each, and interleaves them in the order they are placed.
+func interleave(data []byte, v version) []byte {
+	divisor := rsDivisor(v.ecPerBlock)
+	var blocks, ecs [][]byte
+	for _, n := range v.blocks {
+		blocks = append(blocks, data[:n])
+		ecs = append(ecs, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+
+	var out []byte
+	for i := 0; i < v.blocks[len(v.blocks)-1]; i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, ec := range ecs {
+			out = append(out, ec[i])
+		}
+	}
+	return out
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given
+// degree, highest coefficient first, without its leading 1.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords for data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// symbol is a QR symbol being built. function marks the modules of fixed
+// patterns, which data and masks skip.
+type symbol struct {
+	ver      int
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func newSymbol(ver int) *symbol {
+	size := 17 + 4*ver
+	s := &symbol{ver: ver, size: size}
+	s.modules = make([][]bool, size)
+	s.function = make([][]bool, size)
+	for y := range s.modules {
+		s.modules[y] = make([]bool, size)
+		s.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		s.set(6, i, i%2 == 0)
+		s.set(i, 6, i%2 == 0)
+	}
+	s.drawFinder(3, 3)
+	s.drawFinder(size-4, 3)
+	s.drawFinder(3, size-4)
+
+	align := versions[ver].alignment
+	for i, x := range align {
+		for j, y := range align {
+			// Skip the three corners taken by finders
+			if (i == 0 && j == 0) || (i == 0 && j == len(align)-1) || (i == len(align)-1 && j == 0) {
+				continue
+			}
+			s.drawAlignment(x, y)
+		}
+	}
+
+	s.drawFormat(0) // reserve the format areas; redrawn once masked
+	if ver >= 7 {
+		s.drawVersion()
+	}
+	return s
+}
+
+// set sets a function module.
+func (s *symbol) set(x, y int, dark bool) {
+	s.modules[y][x] = dark
+	s.function[y][x] = true
+}
+
+// drawFinder draws a finder pattern and its separator around (x, y).
+func (s *symbol) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= s.size || yy < 0 || yy >= s.size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			s.set(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+func (s *symbol) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			s.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for level M and
+// mask, and the dark module beside them.
+func (s *symbol) drawFormat(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		s.set(8, i, bit(i))
+	}
+	s.set(8, 7, bit(6))
+	s.set(8, 8, bit(7))
+	s.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		s.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		s.set(s.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		s.set(8, s.size-15+i, bit(i))
+	}
+	s.set(8, s.size-8, true)
+}
+
+// formatBits returns the 15 format bits for level M and mask, with their
+// BCH error correction.
+func formatBits(mask int) int {
+	data := 0b00<<3 | mask // level M
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawVersion draws both copies of the version information.
+func (s *symbol) drawVersion() {
+	bits := versionBits(s.ver)
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 == 1
+		a, b := s.size-11+i%3, i/3
+		s.set(a, b, dark)
+		s.set(b, a, dark)
+	}
+}
+
+// versionBits returns the 18 version bits, with their BCH error correction.
+func versionBits(ver int) int {
+	rem := ver
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	return ver<<12 | rem
+}
+
+// drawCodewords places codewords in the zigzag order: up and down pairs of
+// columns from the right, skipping the vertical timing pattern. Modules
+// left over are the remainder bits, which stay light.
+func (s *symbol) drawCodewords(data []byte) {
+	i := 0
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < s.size; vert++ {
+			y := vert
+			if upward {
+				y = s.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if s.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				s.modules[y][x] = data[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by mask.
+func (s *symbol) applyMask(mask int) {
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			if !s.function[y][x] && masked(mask, x, y) {
+				s.modules[y][x] = !s.modules[y][x]
+			}
+		}
+	}
+}
+
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// penalty scores how hard the symbol is to scan, by the rules of the
+// standard: long runs, 2x2 blocks, finder-like patterns, and imbalance
+// between dark and light.
+func (s *symbol) penalty() int {
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return s.modules[x][y]
+		}
+		return s.modules[y][x]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	score := 0
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < s.size; y++ {
+			run := 1
+			for x := 1; x <= s.size; x++ {
+				if x < s.size && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+
+			// A finder-like pattern with four light modules on one side
+			for x := 0; x+7 <= s.size; x++ {
+				match := true
+				for i, dark := range finderLike {
+					if at(x+i, y, transpose) != dark {
+						match = false
+						break
+					}
+				}
+				if match && (s.light(x-4, x, y, transpose) || s.light(x+7, x+11, y, transpose)) {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			if s.modules[y][x] {
+				dark++
+			}
+			if x+1 < s.size && y+1 < s.size {
+				c := s.modules[y][x]
+				if s.modules[y][x+1] == c && s.modules[y+1][x] == c && s.modules[y+1][x+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	total := s.size * s.size
+	score += abs(dark*20-total*10) / total * 10
+	return score
+}
+
+// light reports whether modules from..to-1 of a row (or column, transposed)
+// are light. Modules outside the symbol count as light.
+func (s *symbol) light(from, to, y int, transpose bool) bool {
+	for x := from; x < to; x++ {
+		if x < 0 || x >= s.size {
+			continue
+		}
+		if (transpose && s.modules[x][y]) || (!transpose && s.modules[y][x]) {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// quietZone is the light border around a symbol, in modules. The standard
+// asks for 4; scanners manage with less, and it keeps the code small.
+const quietZone = 2
+
+// Render draws the code with Unicode half blocks, two rows of modules per
+// line. Light modules are drawn and dark ones left blank, which suits the
+// usual light text on a dark terminal; invert swaps them for dark text on
+// a light background.
+func (c *Code) Render(w io.Writer, invert bool) error {
+	lit := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+			return !invert
+		}
+		return c.modules[y][x] == invert
+	}
+
+	var b strings.Builder
+	for y := -quietZone; y < c.Size+quietZone; y += 2 {
+		for x := -quietZone; x < c.Size+quietZone; x++ {
+			top, bottom := lit(x, y), lit(x, y+1)
+			if y+1 >= c.Size+quietZone {
+				bottom = false
+			}
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
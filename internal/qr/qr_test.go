@@ -0,0 +1,196 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatBits(t *testing.T) {
+	// Level M rows of the format information table in ISO/IEC 18004
+	want := []int{
+		0b101010000010010, 0b101000100100101, 0b101111001111100, 0b101101101001011,
+		0b100010111111001, 0b100000011001110, 0b100111110010111, 0b100101010100000,
+	}
+	for mask, w := range want {
+		if got := formatBits(mask); got != w {
+			t.Errorf("formatBits(%d) = %015b, want %015b", mask, got, w)
+		}
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	want := map[int]int{7: 0x07C94, 8: 0x085BC, 9: 0x09A99, 10: 0x0A4D3}
+	for ver, w := range want {
+		if got := versionBits(ver); got != w {
+			t.Errorf("versionBits(%d) = %018b, want %018b", ver, got, w)
+		}
+	}
+}
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, the standard worked example
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !reflect.DeepEqual(got, want) {
+		t.Errorf("error correction = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 14, 15, 60, 84, 122, 180, 181, 213} {
+		text := strings.Repeat("git-share receive k7Xm9pQ2wR-alpha-bravo ", 6)[:n]
+		code, err := Encode(text)
+		if err != nil {
+			t.Fatalf("Encode(%d bytes) failed: %v", n, err)
+		}
+		if got := decode(t, code); got != text {
+			t.Errorf("decoded %d bytes as %q, want %q", n, got, text)
+		}
+	}
+
+	if _, err := Encode(strings.Repeat("x", 214)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("expected ErrTooLong, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := code.Render(&out, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	height := code.Size + 2*quietZone
+	if len(lines) != (height+1)/2 {
+		t.Errorf("rendered %d lines, want %d", len(lines), (height+1)/2)
+	}
+	for _, line := range lines {
+		if n := len([]rune(line)); n != height {
+			t.Fatalf("line is %d columns, want %d", n, height)
+		}
+	}
+	// The top-left finder's dark corner is blank on a dark terminal
+	if r := []rune(lines[1])[quietZone]; r != ' ' {
+		t.Errorf("finder corner rendered as %q, want blank", r)
+	}
+}
+
+// decode reads a symbol back without error correction, independently of
+// the encoder's bookkeeping: it finds the mask in the format information and
+// the function modules from the layout rules.
+func decode(t *testing.T, c *Code) string {
+	t.Helper()
+	ver := (c.Size - 17) / 4
+	s := newSymbol(ver) // for the function module layout only
+
+	format := 0
+	for i := 14; i >= 0; i-- {
+		var x, y int
+		switch {
+		case i <= 5:
+			x, y = 8, i
+		case i == 6:
+			x, y = 8, 7
+		case i == 7:
+			x, y = 8, 8
+		case i == 8:
+			x, y = 7, 8
+		default:
+			x, y = 14-i, 8
+		}
+		if c.Dark(x, y) {
+			format |= 1 << i
+		}
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("format information %015b is not level M", format)
+	}
+
+	var raw []byte
+	var cur byte
+	n := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if s.function[y][x] {
+					continue
+				}
+				cur = cur<<1 | boolBit(c.Dark(x, y) != masked(mask, x, y))
+				if n++; n%8 == 0 {
+					raw = append(raw, cur)
+				}
+			}
+		}
+	}
+
+	// Undo the interleaving of the data codewords
+	v := versions[ver]
+	blocks := make([][]byte, len(v.blocks))
+	i := 0
+	for col := 0; col < v.blocks[len(v.blocks)-1]; col++ {
+		for b, size := range v.blocks {
+			if col < size {
+				blocks[b] = append(blocks[b], raw[i])
+				i++
+			}
+		}
+	}
+	for col := 0; col < v.ecPerBlock; col++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], raw[i])
+			i++
+		}
+	}
+	var data []byte
+	for b, size := range v.blocks {
+		block, ec := blocks[b][:size], blocks[b][size:]
+		if want := rsRemainder(block, rsDivisor(v.ecPerBlock)); !bytes.Equal(ec, want) {
+			t.Fatalf("block %d error correction = %v, want %v", b, ec, want)
+		}
+		data = append(data, block...)
+	}
+
+	if data[0]>>4 != 0b0100 {
+		t.Fatalf("mode = %04b, want byte mode", data[0]>>4)
+	}
+	bits := func(from, length int) int {
+		v := 0
+		for i := from; i < from+length; i++ {
+			v = v<<1 | int(data[i/8]>>(7-i%8)&1)
+		}
+		return v
+	}
+	length := bits(4, countBits(ver))
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = byte(bits(4+countBits(ver)+8*i, 8))
+	}
+	return string(out)
+}
+
+func boolBit(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package secrets
+
+import (
+	"regexp"
+
+	"github.com/flawiddsouza/git-share/internal/git"
+)
+
+// Finding is an added line that looks like it contains a secret.
+type Finding struct {
+	File string
+	Line int    // line number in the new version of the file
+	Kind string // what the line looks like, e.g. "AWS access key"
+}
+
+type rule struct {
+	kind    string
+	pattern *regexp.Regexp
+}
+
+// rules are deliberately specific: a false alarm costs the sender a flag,
+// but a noisy scanner gets ignored.
+var rules = []rule{
+	{"private key", regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY-----`)},
+	{"AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"Stripe secret key", regexp.MustCompile(`\bsk_live_[A-Za-z0-9]{16,}`)},
+	{"password or API key assignment", regexp.MustCompile(`(?i)(password|passwd|secret|api[_-]?key|access[_-]?token)["']?\s*[:=]\s*["'][^"'\s]{8,}["']`)},
+}
+
+// Scan returns the lines a patch adds that look like secrets. Removed and
+// context lines are not reported, since they are already in the receiver's
+// history.
+func Scan(patch []byte) ([]Finding, error) {
+	files, err := git.ParseHunks(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, file := range files {
+		for _, hunk := range file.Hunks {
+			line := hunk.NewStart
+			for _, text := range hunk.Lines {
+				switch text[0] {
+				case '-':
+					continue
+				case '+':
+					if kind := match(text[1:]); kind != "" {
+						findings = append(findings, Finding{File: file.NewPath, Line: line, Kind: kind})
+					}
+				}
+				line++
+			}
+		}
+	}
+	return findings, nil
+}
+
+// match returns the kind of secret a line looks like, or "".
+func match(line string) string {
+	for _, r := range rules {
+		if r.pattern.MatchString(line) {
+			return r.kind
+		}
+	}
+	return ""
+}
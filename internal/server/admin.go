@@ -0,0 +1,33 @@
+package server
+
+import (
+	"log"
+	"net/http"
+)
+
+// AdminResponse is the JSON response for admin endpoints.
+type AdminResponse struct {
+	OK      bool   `json:"ok"`
+	Removed int    `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// requireAdmin only lets requests through that carry the admin token as a
+// bearer token.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearer(r, s.config.AdminToken) {
+			log.Printf("⛔ Rejected admin request %s %s from %s", r.Method, r.URL.Path, clientIP(r, s.config.TrustProxy))
+			writeJSON(w, http.StatusUnauthorized, AdminResponse{Error: "invalid or missing admin token"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handlePurge deletes every stored blob.
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	removed := s.store.Purge()
+	log.Printf("🧹 Purged %d blobs", removed)
+	writeJSON(w, http.StatusOK, AdminResponse{OK: true, Removed: removed})
+}
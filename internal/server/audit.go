@@ -0,0 +1,68 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// missCounter counts receives of code IDs the relay doesn't have, in total
+// and per client within a fixed window.
+type missCounter struct {
+	mu       sync.Mutex
+	window   time.Duration
+	start    time.Time
+	total    int64
+	byClient map[string]int
+}
+
+func newMissCounter(window time.Duration) *missCounter {
+	return &missCounter{
+		window:   window,
+		start:    time.Now(),
+		byClient: make(map[string]int),
+	}
+}
+
+// Add records a miss from client and returns the client's misses in the
+// current window.
+func (c *missCounter) Add(client string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.start) > c.window {
+		c.start = time.Now()
+		c.byClient = make(map[string]int)
+	}
+	c.total++
+	c.byClient[client]++
+	return c.byClient[client]
+}
+
+// Total returns the number of misses since the relay started.
+func (c *missCounter) Total() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// auditMiss records a receive for a code ID that is not stored. Only the
+// aggregate count is kept unless auditing is enabled, and client IPs are
+// only logged with AuditIPs.
+func (s *Server) auditMiss(r *http.Request, id string) {
+	ip := clientIP(r, s.config.TrustProxy)
+	n := s.misses.Add(ip)
+
+	who := ""
+	if s.config.AuditIPs {
+		who = " from " + ip
+	}
+	if s.config.AuditMisses {
+		log.Printf("🔍 Receive miss for %s%s", id, who)
+	}
+	// Warn once per window, when the client crosses the threshold
+	if s.config.MissAlert > 0 && n == s.config.MissAlert {
+		log.Printf("⚠️  %d receive misses%s within %s, possible code ID probing", n, who, s.misses.window)
+	}
+}
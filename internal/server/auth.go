@@ -0,0 +1,35 @@
+package server
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// requireAuth only lets requests through that carry the relay's auth token
+// as a bearer token. Without an AuthToken it lets everything through.
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	if s.config.AuthToken == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearer(r, s.config.AuthToken) {
+			log.Printf("⛔ Rejected unauthenticated request %s %s from %s", r.Method, r.URL.Path, clientIP(r, s.config.TrustProxy))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+				"ok":    false,
+				"error": "invalid or missing auth token",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// hasBearer reports whether r carries token as a bearer token, comparing in
+// constant time so the response time says nothing about the token.
+func hasBearer(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,33 @@
+package server
+
+import "time"
+
+// expiryItem is a store key that is due to expire at a given time.
+type expiryItem struct {
+	key string
+	at  time.Time
+}
+
+// expiryQueue is a min-heap of expiryItems ordered by time, for use with
+// container/heap. Items are never removed early: when a blob is taken or its
+// expiry changes, its old item stays until popped, and is then checked
+// against the store and discarded.
+type expiryQueue []expiryItem
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *expiryQueue) Push(x any) { *q = append(*q, x.(expiryItem)) }
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// due reports whether the earliest item expires at or before now.
+func (q expiryQueue) due(now time.Time) bool {
+	return len(q) > 0 && !q[0].at.After(now)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// blobFileExt is the extension of the files a disk-backed store keeps blobs in.
+const blobFileExt = ".blob"
+
+// NewFileStore creates a store that also keeps each blob in a file in dir, so
+// pending blobs survive a restart. Blobs already in dir are loaded, and
+// expired ones are removed. Reads are still served from memory.
+func NewFileStore(dir string) (*Store, error) {
+	s := NewStore()
+	if _, err := s.openDir(dir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// openDir makes the store persist its blobs to dir, loading the blobs
+// already there, and returns how many it loaded.
+func (s *Store) openDir(dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return 0, fmt.Errorf("creating store directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("reading store directory: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dir = dir
+
+	loaded := 0
+	now := time.Now()
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if strings.Contains(name, blobFileExt+".tmp") {
+			// Left behind by a crash mid-write; the rename never happened
+			os.Remove(path)
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, blobFileExt) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return 0, fmt.Errorf("reading %s: %w", path, err)
+		}
+		var b snapshotBlob
+		if err := json.Unmarshal(data, &b); err != nil {
+			return 0, fmt.Errorf("decoding %s: %w", path, err)
+		}
+		blob := b.blob()
+		if blob.expired(now) {
+			os.Remove(path)
+			continue
+		}
+		s.blobs[b.CodeID] = blob
+		heap.Push(&s.blobExpiries, expiryItem{key: b.CodeID, at: blob.ExpiresAt()})
+		loaded++
+	}
+	return loaded, nil
+}
+
+// blobPath returns the file a blob is kept in. Keys in a space contain "/",
+// so they are escaped.
+func (s *Store) blobPath(codeID string) string {
+	return filepath.Join(s.dir, url.PathEscape(codeID)+blobFileExt)
+}
+
+// persist writes a blob to its file, replacing the file atomically. Callers
+// must hold the lock. It does nothing for an in-memory store. A failed write
+// is logged; the blob is still served from memory.
+func (s *Store) persist(codeID string, blob *Blob) {
+	if s.dir == "" {
+		return
+	}
+	if err := s.writeBlobFile(codeID, blob); err != nil {
+		log.Printf("Persisting blob %s failed: %v", codeID, err)
+	}
+}
+
+func (s *Store) writeBlobFile(codeID string, blob *Blob) error {
+	data, err := json.Marshal(newSnapshotBlob(codeID, blob))
+	if err != nil {
+		return err
+	}
+	path := s.blobPath(codeID)
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// unpersist removes a blob's file. Callers must hold the lock. Removing a
+// file is atomic, so a one-time blob can't be served again after a restart.
+func (s *Store) unpersist(codeID string) {
+	if s.dir == "" {
+		return
+	}
+	if err := os.Remove(s.blobPath(codeID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Removing blob %s failed: %v", codeID, err)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestScheme returns the scheme the client used to reach the relay.
+// X-Forwarded-Proto is only honored when the relay trusts its proxy, since
+// any client can set it.
+func requestScheme(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			first, _, _ := strings.Cut(proto, ",")
+			return strings.ToLower(strings.TrimSpace(first))
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requireHTTPS rejects API requests that did not arrive over HTTPS.
+// The health check stays reachable for load balancer probes.
+func (s *Server) requireHTTPS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/health" && requestScheme(r, s.config.TrustProxy) != "https" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"ok":    false,
+				"error": "this relay requires HTTPS; use an https:// server URL",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitConns answers 503 while MaxConns requests are already in flight, so a
+// small relay sheds load instead of running out of connections. The health
+// check is exempt so probes can tell a busy relay from a dead one.
+func (s *Server) limitConns(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case s.conns <- struct{}{}:
+			defer func() { <-s.conns }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+				"ok":    false,
+				"error": "relay is busy; try again shortly",
+			})
+		}
+	})
+}
+
+// clientIP returns the address of the client that made the request.
+// X-Forwarded-For is only honored when the relay trusts its proxy.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type sampledKey struct{}
+
+// logRequests decides whether each request is logged, per LogSampleRate.
+// Handlers log successes with logSampled; rejected requests (4xx other than
+// misses, which --audit-misses covers) and server errors are always logged.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rate := s.config.LogSampleRate
+		sampled := rate >= 1 || (rate > 0 && rand.Float64() < rate)
+		r = r.WithContext(context.WithValue(r.Context(), sampledKey{}, sampled))
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		switch {
+		case rec.status == http.StatusNotFound || rec.status == http.StatusGone:
+		case rec.status >= 400:
+			log.Printf("⛔ %s %s -> %d (%s)", r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+		}
+	})
+}
+
+// logSampled logs a message about a successful request if the request was
+// picked for logging.
+func logSampled(r *http.Request, format string, args ...interface{}) {
+	if sampled, ok := r.Context().Value(sampledKey{}).(bool); ok && !sampled {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
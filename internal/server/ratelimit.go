@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per client. Each bucket holds up to a
+// minute's worth of requests and refills continuously, so a client can burst
+// up to the limit and then make requests at the limit's pace.
+type rateLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time // when tokens was last brought up to date
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		perMinute: perMinute,
+		buckets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow takes a token from client's bucket. If the bucket is empty it
+// returns false and how long until a token is available.
+func (l *rateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	capacity := float64(l.perMinute)
+	perSecond := capacity / 60
+
+	// Forget clients whose buckets have refilled, so the map stays small
+	if now.Sub(l.lastPrune) > time.Minute {
+		for key, b := range l.buckets {
+			if b.tokens+now.Sub(b.at).Seconds()*perSecond >= capacity {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: capacity, at: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.at).Seconds()*perSecond)
+	b.at = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimited answers 429 to clients over RateLimit requests per minute.
+func (s *Server) rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	if s.limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := s.limiter.Allow(clientIP(r, s.config.TrustProxy))
+		if !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+				"ok":    false,
+				"error": fmt.Sprintf("too many requests; try again in %ds", seconds),
+			})
+			return
+		}
+		next(w, r)
+	}
+}
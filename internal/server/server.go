@@ -1,187 +1,813 @@
-package server
-
-import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"log"
-	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
-)
-
-// Config holds the relay server configuration.
-type Config struct {
-	Port    int
-	MaxSize int64         // max blob size in bytes
-	MaxTTL  time.Duration // maximum TTL allowed
-}
-
-// DefaultConfig returns sensible defaults for the relay server.
-func DefaultConfig() Config {
-	return Config{
-		Port:    3141,
-		MaxSize: 10 * 1024 * 1024, // 10MB
-		MaxTTL:  time.Hour,
-	}
-}
-
-// SendRequest is the JSON body for POST /api/send.
-type SendRequest struct {
-	CodeID string `json:"code_id"`
-	Data   string `json:"data"` // base64-encoded encrypted blob
-	TTL    int    `json:"ttl"`  // TTL in seconds, 0 = use server default
-}
-
-// SendResponse is the JSON response for POST /api/send.
-type SendResponse struct {
-	OK     bool   `json:"ok"`
-	Expiry string `json:"expiry,omitempty"`
-	Error  string `json:"error,omitempty"`
-}
-
-// ReceiveResponse is the JSON response for GET /api/receive/:id.
-type ReceiveResponse struct {
-	OK    bool   `json:"ok"`
-	Data  string `json:"data,omitempty"`
-	Error string `json:"error,omitempty"`
-}
-
-// Server is the relay HTTP server.
-type Server struct {
-	config Config
-	store  *Store
-	mux    *http.ServeMux
-}
-
-// New creates a new relay server.
-func New(config Config) *Server {
-	s := &Server{
-		config: config,
-		store:  NewStore(),
-		mux:    http.NewServeMux(),
-	}
-	s.mux.HandleFunc("POST /api/send", s.handleSend)
-	s.mux.HandleFunc("GET /api/receive/{id}", s.handleReceive)
-	s.mux.HandleFunc("GET /api/health", s.handleHealth)
-	return s
-}
-
-// Start starts the relay server and blocks until an OS signal or error.
-func (s *Server) Start() error {
-	done := make(chan struct{})
-	s.store.StartCleanupLoop(30*time.Second, done)
-
-	addr := fmt.Sprintf(":%d", s.config.Port)
-	log.Printf(" git-share relay server listening on %s", addr)
-	log.Printf(" Max blob size: %s", formatBytes(s.config.MaxSize))
-	log.Printf(" Max TTL: %s", s.config.MaxTTL)
-
-	httpServer := &http.Server{
-		Addr:    addr,
-		Handler: s.mux,
-	}
-
-	// Listen for OS shutdown signals
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	serveErr := make(chan error, 1)
-	go func() {
-		serveErr <- httpServer.ListenAndServe()
-	}()
-
-	select {
-	case err := <-serveErr:
-		close(done) // stop cleanup goroutine
-		if err != nil && err != http.ErrServerClosed {
-			return err
-		}
-		return nil
-	case <-quit:
-		log.Printf("Shutting down server...")
-		close(done) // stop cleanup goroutine
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		return httpServer.Shutdown(ctx)
-	}
-}
-
-func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
-	// Enforce size limit
-	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxSize)
-
-	var req SendRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, SendResponse{Error: "invalid request body"})
-		return
-	}
-
-	if req.CodeID == "" || req.Data == "" {
-		writeJSON(w, http.StatusBadRequest, SendResponse{Error: "code_id and data are required"})
-		return
-	}
-
-	// Determine TTL
-	ttl := s.config.MaxTTL
-	if req.TTL > 0 {
-		requested := time.Duration(req.TTL) * time.Second
-		if requested < ttl {
-			ttl = requested
-		}
-	}
-
-	if !s.store.Put(req.CodeID, []byte(req.Data), ttl) {
-		writeJSON(w, http.StatusConflict, SendResponse{Error: "code ID already exists, try again"})
-		return
-	}
-
-	expiry := time.Now().Add(ttl)
-	log.Printf("📦 Stored blob %s (size: %d bytes, TTL: %s)", req.CodeID, len(req.Data), ttl)
-	writeJSON(w, http.StatusCreated, SendResponse{OK: true, Expiry: expiry.Format(time.RFC3339)})
-}
-
-func (s *Server) handleReceive(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	if id == "" {
-		writeJSON(w, http.StatusBadRequest, ReceiveResponse{Error: "missing code ID"})
-		return
-	}
-
-	data := s.store.GetAndDelete(id)
-	if data == nil {
-		writeJSON(w, http.StatusNotFound, ReceiveResponse{Error: "not found or expired"})
-		return
-	}
-
-	log.Printf("📤 Delivered and deleted blob %s", id)
-	writeJSON(w, http.StatusOK, ReceiveResponse{OK: true, Data: string(data)})
-}
-
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"ok":    true,
-		"blobs": s.store.Count(),
-	})
-}
-
-func writeJSON(w http.ResponseWriter, status int, v interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(v)
-}
-
-func formatBytes(b int64) string {
-	const unit = 1024
-	if b < unit {
-		return fmt.Sprintf("%d B", b)
-	}
-	div, exp := int64(unit), 0
-	for n := b / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
-}
+package server
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Config holds the relay server configuration.
+type Config struct {
+	Port    int
+	MaxSize int64         // max blob size in bytes
+	MaxTTL  time.Duration // maximum TTL allowed
+	MinTTL  time.Duration // minimum TTL; shorter requests are raised to it
+	// RejectShortTTL rejects requests below MinTTL instead of raising them.
+	RejectShortTTL bool
+	// StrictTTL rejects requests above MaxTTL instead of capping them.
+	StrictTTL bool
+	WebUI     bool // serve the browser receive page at /
+
+	TLSCert      string // certificate file; serve HTTPS when set with TLSKey
+	TLSKey       string // private key file
+	RequireHTTPS bool   // reject API requests that did not arrive over HTTPS
+	TrustProxy   bool   // trust X-Forwarded-* headers from a reverse proxy
+
+	AuditMisses bool // log receives of unknown or expired code IDs
+	AuditIPs    bool // include client IPs in audit logs
+	// MissAlert logs a warning when one client misses this many times in
+	// a minute, a sign of someone probing for code IDs. 0 disables it.
+	MissAlert int
+
+	// LogSampleRate is the fraction of successful requests that are logged,
+	// from 0 to 1. Errors and rejections are always logged.
+	LogSampleRate float64
+
+	// AllowPeek enables GET /api/peek/{id}, which returns a blob without
+	// consuming it. This bypasses one-time use, so it is meant for debugging
+	// failed receives on a relay you run.
+	AllowPeek bool
+
+	// AdminToken enables the /api/admin endpoints for requests that send it
+	// as a bearer token. Empty disables them.
+	AdminToken string
+
+	// AuthToken, if set, must be sent as a bearer token with every send
+	// and extend, so only those who know it can upload or keep blobs
+	// alive. AuthReceive requires it for receives, peeks and status checks
+	// as well.
+	AuthToken   string
+	AuthReceive bool
+
+	// Shorten returns a short link to the web receive page with each send.
+	// It needs WebUI.
+	Shorten bool
+
+	// MaxConns caps the requests handled at once; more get a 503 until one
+	// finishes. 0 means no limit.
+	MaxConns int
+
+	// SnapshotFile is where the store is saved on SIGUSR1. Empty disables
+	// snapshots.
+	SnapshotFile string
+	// RestoreFile is a snapshot to load at startup.
+	RestoreFile string
+	// RateLimit caps sends, receives, status checks and extends per client IP, in requests per
+	// minute. 0 means no limit. Behind a proxy, TrustProxy makes the limit
+	// apply to the X-Forwarded-For client rather than the proxy.
+	RateLimit int
+
+	// StoreDir keeps every blob in a file in this directory as well as in
+	// memory, so restarts don't lose them. Empty keeps blobs in memory only.
+	StoreDir string
+
+	// TTLMode is TTLAbsolute (expire a TTL after the blob was stored) or
+	// TTLSliding (a TTL after it was last read). Empty means TTLAbsolute.
+	TTLMode string
+
+	// CleanupInterval is how often expired blobs are removed. Until then
+	// they can no longer be received but still take up memory. 0 means
+	// DefaultCleanupInterval.
+	CleanupInterval time.Duration
+
+	// MaxUploadSize caps a blob sent in parts, each of at most MaxSize
+	// bytes, that the relay joins under one code. 0 disables uploads in
+	// parts.
+	MaxUploadSize int64
+
+	// MaxPendingUploads caps the total size of the parts held for all
+	// unfinished uploads in parts, which stay in memory until they finish
+	// or expire; parts over it get a 507. 0 means no limit.
+	MaxPendingUploads int64
+}
+
+// DefaultCleanupInterval is how often expired blobs are removed unless
+// Config.CleanupInterval says otherwise.
+const DefaultCleanupInterval = 30 * time.Second
+
+// TTL modes for Config.TTLMode.
+const (
+	TTLAbsolute = "absolute"
+	TTLSliding  = "sliding"
+)
+
+// webUI is a single-page receiver that decrypts patches in the browser.
+//
+//go:embed web/index.html
+var webUI []byte
+
+// DefaultConfig returns sensible defaults for the relay server.
+func DefaultConfig() Config {
+	return Config{
+		Port:    3141,
+		MaxSize: 10 * 1024 * 1024, // 10MB
+		MaxTTL:  time.Hour,
+
+		MaxUploadSize:     100 * 1024 * 1024, // 100MB
+		MaxPendingUploads: 512 * 1024 * 1024, // 512MB
+
+		LogSampleRate:   1,
+		CleanupInterval: DefaultCleanupInterval,
+	}
+}
+
+// SendRequest is the JSON body for POST /api/send.
+type SendRequest struct {
+	CodeID string `json:"code_id"`
+	Data   string `json:"data"` // base64-encoded encrypted blob
+	TTL    int    `json:"ttl"`  // TTL in seconds, 0 = use server default
+	// MaxDownloads is how many times the blob can be received before it is
+	// deleted; 0 means once.
+	MaxDownloads int `json:"max_downloads,omitempty"`
+	// Part and Parts send a blob larger than the relay's max size in
+	// several requests under one code ID: Data is part number Part, from 0,
+	// of Parts. The relay joins the parts in order once all have arrived.
+	// Parts of 0 or 1 sends the whole blob.
+	Part  int `json:"part,omitempty"`
+	Parts int `json:"parts,omitempty"`
+}
+
+// SendResponse is the JSON response for POST /api/send.
+type SendResponse struct {
+	OK     bool   `json:"ok"`
+	Expiry string `json:"expiry,omitempty"`
+	Size   int    `json:"size,omitempty"` // stored bytes, i.e. the length of the base64 data
+	TTL    int    `json:"ttl,omitempty"`  // effective TTL in seconds, after the relay's limits
+	// ShortURL links to the web receive page for this blob, with --shorten.
+	// It carries neither the code ID nor the passphrase.
+	ShortURL string `json:"short_url,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ReceiveResponse is the JSON response for GET /api/receive/:id.
+type ReceiveResponse struct {
+	OK        bool   `json:"ok"`
+	Data      string `json:"data,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Reason    string `json:"reason,omitempty"`     // why a blob is gone: "expired" or "consumed"
+	ExpiredAt string `json:"expired_at,omitempty"` // set when reason is "expired"
+}
+
+// ExtendRequest is the JSON body for PUT /api/extend/:id.
+type ExtendRequest struct {
+	TTL int `json:"ttl"` // seconds from now, 0 = the server's maximum
+}
+
+// ExtendResponse is the JSON response for PUT /api/extend/:id.
+type ExtendResponse struct {
+	OK     bool   `json:"ok"`
+	Expiry string `json:"expiry,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StatusResponse is the JSON response for GET /api/status/:id. The blob's
+// ETag is sent in the ETag header.
+type StatusResponse struct {
+	OK     bool   `json:"ok"`
+	Expiry string `json:"expiry,omitempty"`
+	Size   int    `json:"size,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Features a relay can report in CapabilitiesResponse.
+const (
+	FeatureSpaces        = "spaces"         // /api/{space}/... routes
+	FeatureStatus        = "status"         // GET /api/status/:id
+	FeatureExtend        = "extend"         // PUT /api/extend/:id
+	FeatureWebUI         = "web_ui"         // browser receive page at /
+	FeatureShortLinks    = "short_links"    // SendResponse.ShortURL
+	FeatureSlidingTTL    = "sliding_ttl"    // reads restart a blob's TTL
+	FeatureAdmin         = "admin"          // /api/admin endpoints
+	FeatureStats         = "stats"          // GET /api/stats
+	FeatureMaxDownloads  = "max_downloads"  // SendRequest.MaxDownloads
+	FeaturePeek          = "peek"           // GET /api/peek/:id
+	FeatureChunkedUpload = "chunked_upload" // SendRequest.Part and Parts
+)
+
+// CapabilitiesResponse is the JSON response for GET /api/capabilities. It
+// lets clients adapt to relays that lack optional features.
+type CapabilitiesResponse struct {
+	OK       bool     `json:"ok"`
+	Features []string `json:"features"`
+	MaxSize  int64    `json:"max_size"` // bytes
+	MaxTTL   int      `json:"max_ttl"`  // seconds
+	MinTTL   int      `json:"min_ttl"`  // seconds
+	// MaxUploadSize is the largest blob sent in parts, in bytes; 0 if the
+	// relay doesn't take them.
+	MaxUploadSize int64 `json:"max_upload_size,omitempty"`
+}
+
+// LimitsResponse is the JSON response for GET /api/limits: the largest
+// blob and longest TTL a send may ask for, so clients can check before
+// uploading.
+type LimitsResponse struct {
+	OK      bool  `json:"ok"`
+	MaxSize int64 `json:"max_size"` // bytes
+	MaxTTL  int   `json:"max_ttl"`  // seconds
+	MinTTL  int   `json:"min_ttl"`  // seconds
+	// MaxUploadSize is the largest blob sent in parts, in bytes; 0 if the
+	// relay doesn't take them.
+	MaxUploadSize int64 `json:"max_upload_size,omitempty"`
+}
+
+// StatsResponse is the JSON response for GET /api/stats: usage since the
+// relay started or was last purged, and what it holds now.
+type StatsResponse struct {
+	OK          bool  `json:"ok"`
+	Stored      int64 `json:"stored"`       // blobs uploaded
+	StoredBytes int64 `json:"stored_bytes"` // total size of the uploads
+	Delivered   int64 `json:"delivered"`    // blobs received
+	Expired     int64 `json:"expired"`      // blobs that expired unreceived
+	Blobs       int   `json:"blobs"`        // blobs held now
+	Bytes       int64 `json:"bytes"`        // total size of the blobs held now
+}
+
+// Server is the relay HTTP server.
+type Server struct {
+	config Config
+	store  *Store
+	mux    *http.ServeMux
+	misses *missCounter
+	conns  chan struct{} // semaphore for MaxConns; nil when unlimited
+
+	limiter *rateLimiter // nil unless RateLimit is set
+
+	shortLinks *shortLinks // nil unless Shorten is set
+}
+
+// New creates a new relay server.
+func New(config Config) *Server {
+	s := &Server{
+		config: config,
+		store:  NewStore(),
+		mux:    http.NewServeMux(),
+		misses: newMissCounter(time.Minute),
+	}
+	if config.MaxConns > 0 {
+		s.conns = make(chan struct{}, config.MaxConns)
+	}
+	if config.TTLMode == TTLSliding {
+		s.store.SetSlidingTTL(true)
+	}
+	s.store.SetUploadLimit(config.MaxPendingUploads)
+	if config.RateLimit > 0 {
+		s.limiter = newRateLimiter(config.RateLimit)
+	}
+	// Extending keeps a blob alive, so it is guarded and limited like
+	// sending one, and a status check tells whether a code ID exists, like
+	// receiving one
+	send, extend := s.requireAuth(s.handleSend), s.requireAuth(s.handleExtend)
+	var receive, peek, status http.HandlerFunc = s.handleReceive, s.handlePeek, s.handleStatus
+	if config.AuthReceive {
+		receive, peek, status = s.requireAuth(receive), s.requireAuth(peek), s.requireAuth(status)
+	}
+	// Rate limits come first, so they also slow down guessing the token
+	send, extend = s.rateLimited(send), s.rateLimited(extend)
+	receive, peek, status = s.rateLimited(receive), s.rateLimited(peek), s.rateLimited(status)
+	s.mux.HandleFunc("POST /api/send", send)
+	s.mux.HandleFunc("GET /api/receive/{id}", allowOwnOrigin(receive))
+	s.mux.HandleFunc("GET /api/status/{id}", status)
+	s.mux.HandleFunc("PUT /api/extend/{id}", extend)
+	s.mux.HandleFunc("GET /api/peek/{id}", peek)
+	// Spaced routes keep each team's code IDs separate on a shared relay
+	s.mux.HandleFunc("POST /api/{space}/send", send)
+	s.mux.HandleFunc("GET /api/{space}/receive/{id}", allowOwnOrigin(receive))
+	s.mux.HandleFunc("GET /api/{space}/status/{id}", status)
+	s.mux.HandleFunc("PUT /api/{space}/extend/{id}", extend)
+	s.mux.HandleFunc("GET /api/{space}/peek/{id}", peek)
+	s.mux.HandleFunc("GET /api/health", s.handleHealth)
+	s.mux.HandleFunc("GET /api/capabilities", s.handleCapabilities)
+	s.mux.HandleFunc("GET /api/stats", s.handleStats)
+	s.mux.HandleFunc("GET /api/limits", s.handleLimits)
+	if config.AdminToken != "" {
+		s.mux.HandleFunc("DELETE /api/admin/blobs", s.requireAdmin(s.handlePurge))
+	}
+	if config.WebUI {
+		s.mux.HandleFunc("GET /{$}", s.handleWebUI)
+	}
+	if config.Shorten {
+		s.shortLinks = newShortLinks(s.store)
+		s.mux.HandleFunc("GET /s/{token}", s.handleShortLink)
+	}
+	return s
+}
+
+// Handler returns the HTTP handler for the relay.
+func (s *Server) Handler() http.Handler {
+	var h http.Handler = s.mux
+	if s.config.RequireHTTPS {
+		h = s.requireHTTPS(h)
+	}
+	if s.conns != nil {
+		h = s.limitConns(h)
+	}
+	return s.logRequests(h)
+}
+
+// Start starts the relay server and blocks until an OS signal or error.
+func (s *Server) Start() error {
+	if s.config.StoreDir != "" {
+		n, err := s.store.openDir(s.config.StoreDir)
+		if err != nil {
+			return err
+		}
+		log.Printf(" Loaded %d blobs from %s", n, s.config.StoreDir)
+	}
+	if s.config.RestoreFile != "" {
+		n, err := s.store.LoadSnapshot(s.config.RestoreFile)
+		if err != nil {
+			return fmt.Errorf("restoring snapshot: %w", err)
+		}
+		log.Printf(" Restored %d blobs from %s", n, s.config.RestoreFile)
+	}
+
+	cleanupInterval := s.config.CleanupInterval
+	if cleanupInterval <= 0 {
+		cleanupInterval = DefaultCleanupInterval
+	}
+	done := make(chan struct{})
+	s.store.StartCleanupLoop(cleanupInterval, done)
+
+	addr := fmt.Sprintf(":%d", s.config.Port)
+	log.Printf(" git-share relay server listening on %s", addr)
+	log.Printf(" Max blob size: %s", formatBytes(s.config.MaxSize))
+	if s.config.MaxUploadSize > 0 {
+		log.Printf(" Max blob size sent in parts: %s", formatBytes(s.config.MaxUploadSize))
+		if s.config.MaxPendingUploads > 0 {
+			log.Printf(" Max unfinished uploads held at once: %s", formatBytes(s.config.MaxPendingUploads))
+		}
+	}
+	log.Printf(" Max TTL: %s", s.config.MaxTTL)
+	if s.config.StrictTTL {
+		log.Printf(" Longer TTLs are rejected, not capped")
+	}
+	if s.config.TTLMode == TTLSliding {
+		log.Printf(" TTLs restart whenever a blob is read")
+	}
+	if s.config.MinTTL > 0 {
+		log.Printf(" Min TTL: %s", s.config.MinTTL)
+	}
+	log.Printf(" Removing expired blobs every %s", cleanupInterval)
+	if s.config.RateLimit > 0 {
+		log.Printf(" Rate limit: %d sends, receives, status checks and extends per minute per client", s.config.RateLimit)
+	}
+	if s.config.WebUI {
+		log.Printf(" Web receive page enabled at /")
+	}
+	if s.config.RequireHTTPS {
+		log.Printf(" Requiring HTTPS (trust proxy: %v)", s.config.TrustProxy)
+	}
+	if s.config.AdminToken != "" {
+		log.Printf(" Admin endpoints enabled")
+	}
+	if s.config.AuthReceive {
+		log.Printf(" All blob requests need the auth token")
+	} else if s.config.AuthToken != "" {
+		log.Printf(" Sends and extends need the auth token")
+	}
+	if s.config.AllowPeek {
+		log.Printf(" ⚠️  Peeking is enabled: /api/peek returns blobs without deleting them")
+	}
+	if s.config.LogSampleRate < 1 {
+		log.Printf(" Logging %.0f%% of successful requests", s.config.LogSampleRate*100)
+	}
+	snapshot := make(chan os.Signal, 1)
+	if s.config.SnapshotFile != "" && len(snapshotSignals) > 0 {
+		signal.Notify(snapshot, snapshotSignals...)
+		log.Printf(" Snapshotting to %s on SIGUSR1 (pid %d)", s.config.SnapshotFile, os.Getpid())
+	}
+
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: s.Handler(),
+	}
+
+	// Listen for OS shutdown signals
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.config.TLSCert != "" {
+			serveErr <- httpServer.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
+			return
+		}
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	for {
+		select {
+		case <-snapshot:
+			n, err := s.store.SaveSnapshot(s.config.SnapshotFile)
+			if err != nil {
+				log.Printf("Snapshot failed: %v", err)
+				continue
+			}
+			log.Printf("Saved %d blobs to %s", n, s.config.SnapshotFile)
+		case err := <-serveErr:
+			close(done) // stop cleanup goroutine
+			if err != nil && err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		case <-quit:
+			log.Printf("Shutting down server...")
+			close(done) // stop cleanup goroutine
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return httpServer.Shutdown(ctx)
+		}
+	}
+}
+
+func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+	// Enforce size limit
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxSize)
+
+	var req SendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, SendResponse{Error: "invalid request body"})
+		return
+	}
+
+	if req.CodeID == "" || req.Data == "" {
+		writeJSON(w, http.StatusBadRequest, SendResponse{Error: "code_id and data are required"})
+		return
+	}
+	if strings.Contains(req.CodeID, "/") {
+		writeJSON(w, http.StatusBadRequest, SendResponse{Error: "code_id must not contain '/'"})
+		return
+	}
+	if req.MaxDownloads < 0 {
+		writeJSON(w, http.StatusBadRequest, SendResponse{Error: "max_downloads must not be negative"})
+		return
+	}
+	key := storeKey(r.PathValue("space"), req.CodeID)
+
+	// Determine TTL
+	ttl := s.config.MaxTTL
+	if req.TTL > 0 {
+		requested := time.Duration(req.TTL) * time.Second
+		if requested > ttl && s.config.StrictTTL {
+			writeJSON(w, http.StatusBadRequest, SendResponse{Error: fmt.Sprintf("ttl %s is longer than this relay's maximum of %s", requested, s.config.MaxTTL)})
+			return
+		}
+		if requested < ttl {
+			ttl = requested
+		}
+	}
+	if ttl < s.config.MinTTL {
+		if s.config.RejectShortTTL {
+			writeJSON(w, http.StatusBadRequest, SendResponse{Error: fmt.Sprintf("ttl must be at least %s", s.config.MinTTL)})
+			return
+		}
+		ttl = s.config.MinTTL
+	}
+
+	size := len(req.Data)
+	if req.Parts > 1 {
+		if s.config.MaxUploadSize <= 0 {
+			writeJSON(w, http.StatusBadRequest, SendResponse{Error: "this relay doesn't take uploads in parts"})
+			return
+		}
+		if req.Part < 0 || req.Part >= req.Parts || req.Parts > maxUploadParts {
+			writeJSON(w, http.StatusBadRequest, SendResponse{Error: fmt.Sprintf("part must be from 0 to parts-1, and parts at most %d", maxUploadParts)})
+			return
+		}
+		complete, err := s.store.PutPart(key, req.Part, req.Parts, []byte(req.Data), ttl, req.MaxDownloads, s.config.MaxUploadSize)
+		switch {
+		case errors.Is(err, ErrUploadTooLarge):
+			writeJSON(w, http.StatusRequestEntityTooLarge, SendResponse{Error: fmt.Sprintf("upload is larger than this relay's maximum of %s", formatBytes(s.config.MaxUploadSize))})
+			return
+		case errors.Is(err, ErrUploadsFull):
+			log.Printf("⚠️  Refused a part of blob %s: unfinished uploads are at the limit of %s", req.CodeID, formatBytes(s.config.MaxPendingUploads))
+			writeJSON(w, http.StatusInsufficientStorage, SendResponse{Error: "the relay is holding too many unfinished uploads; try again later"})
+			return
+		case errors.Is(err, ErrCodeIDExists):
+			writeJSON(w, http.StatusConflict, SendResponse{Error: "code ID already exists, try again"})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusConflict, SendResponse{Error: err.Error()})
+			return
+		case !complete:
+			writeJSON(w, http.StatusAccepted, SendResponse{OK: true, Size: size})
+			return
+		}
+		logSampled(r, "📦 Joined %d parts of blob %s", req.Parts, req.CodeID)
+	} else if !s.store.Put(key, []byte(req.Data), ttl, req.MaxDownloads) {
+		writeJSON(w, http.StatusConflict, SendResponse{Error: "code ID already exists, try again"})
+		return
+	}
+
+	expiry := time.Now().Add(ttl)
+	resp := SendResponse{OK: true, Expiry: expiry.Format(time.RFC3339), Size: size, TTL: int(ttl.Seconds())}
+	if s.shortLinks != nil {
+		token, err := s.shortLinks.add(r.PathValue("space"), req.CodeID, expiry)
+		if err != nil {
+			log.Printf("⚠️  Could not create a short link for blob %s: %v", req.CodeID, err)
+		} else {
+			resp.ShortURL = s.shortURL(r, token)
+		}
+	}
+	logSampled(r, "📦 Stored blob %s (size: %d bytes, TTL: %s)", req.CodeID, len(req.Data), ttl)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) handleReceive(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, ReceiveResponse{Error: "missing code ID"})
+		return
+	}
+
+	// The blob is only deleted once it has been written to the receiver,
+	// so a dropped connection doesn't lose it
+	key := storeKey(r.PathValue("space"), id)
+	blob, ok := s.store.Claim(key)
+	if !ok {
+		s.auditMiss(r, id)
+		s.writeMissing(w, key)
+		return
+	}
+	if blob == nil {
+		writeJSON(w, http.StatusConflict, ReceiveResponse{Error: "this patch is being received right now, try again shortly"})
+		return
+	}
+
+	// A conditional receive only delivers the version the client checked
+	etag := blob.ETag()
+	if match := r.Header.Get("If-Match"); match != "" && !etagMatches(match, etag) {
+		s.store.Release(key)
+		writeJSON(w, http.StatusPreconditionFailed, ReceiveResponse{Error: "the patch changed since it was checked"})
+		return
+	}
+	w.Header().Set("ETag", etag)
+
+	if err := writeJSONFlush(w, http.StatusOK, ReceiveResponse{OK: true, Data: string(blob.Data)}); err != nil {
+		s.store.Release(key)
+		log.Printf("⚠️  Delivery of blob %s failed, kept for retry: %v", id, err)
+		return
+	}
+	left := blob.Downloads - 1
+	s.store.Commit(key)
+	if left == 0 {
+		logSampled(r, "📤 Delivered and deleted blob %s", id)
+	} else {
+		logSampled(r, "📤 Delivered blob %s (%d downloads left)", id, left)
+	}
+}
+
+// handlePeek returns a blob like handleReceive but leaves it on the relay,
+// so a failed receive can be retried. It is refused unless AllowPeek is set.
+func (s *Server) handlePeek(w http.ResponseWriter, r *http.Request) {
+	if !s.config.AllowPeek {
+		writeJSON(w, http.StatusForbidden, ReceiveResponse{Error: "peeking is disabled on this relay (serve --allow-peek)"})
+		return
+	}
+	id := r.PathValue("id")
+	key := storeKey(r.PathValue("space"), id)
+	blob, ok := s.store.Stat(key)
+	if !ok {
+		s.auditMiss(r, id)
+		s.writeMissing(w, key)
+		return
+	}
+	w.Header().Set("ETag", blob.ETag())
+	writeJSON(w, http.StatusOK, ReceiveResponse{OK: true, Data: string(blob.Data)})
+	log.Printf("👀 Peeked at blob %s", id)
+}
+
+// handleStatus reports whether a blob is available without consuming it.
+// The ETag lets pollers use If-None-Match, and receivers If-Match.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	key := storeKey(r.PathValue("space"), id)
+	blob, ok := s.store.Stat(key)
+	if !ok {
+		s.auditMiss(r, id)
+		s.writeMissing(w, key)
+		return
+	}
+
+	etag := blob.ETag()
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{
+		OK:     true,
+		Expiry: blob.ExpiresAt().UTC().Format(time.RFC3339),
+		Size:   len(blob.Data),
+	})
+}
+
+// handleExtend keeps a blob from expiring while its sender waits for the
+// receiver. Each extension is capped at MaxTTL from now.
+func (s *Server) handleExtend(w http.ResponseWriter, r *http.Request) {
+	var req ExtendRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ExtendResponse{Error: "invalid request body"})
+		return
+	}
+	ttl := s.config.MaxTTL
+	if requested := time.Duration(req.TTL) * time.Second; req.TTL > 0 && requested < ttl {
+		ttl = requested
+	}
+
+	key := storeKey(r.PathValue("space"), r.PathValue("id"))
+	blob, ok := s.store.Extend(key, ttl)
+	if !ok {
+		s.auditMiss(r, r.PathValue("id"))
+		s.writeMissing(w, key)
+		return
+	}
+	logSampled(r, "⏳ Extended blob %s to %s", r.PathValue("id"), blob.ExpiresAt().UTC().Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, ExtendResponse{OK: true, Expiry: blob.ExpiresAt().UTC().Format(time.RFC3339)})
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value
+// lists etag, or is "*". Weak validators compare by their opaque part.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// storeKey returns the store key for a code ID in a space. Code IDs cannot
+// contain "/", so keys from different spaces never collide.
+func storeKey(space, id string) string {
+	if space == "" {
+		return id
+	}
+	return space + "/" + id
+}
+
+// writeMissing responds to a receive for a blob that is not stored, using its
+// tombstone to tell "expired" and "already received" apart from "not found".
+func (s *Server) writeMissing(w http.ResponseWriter, key string) {
+	t, ok := s.store.Tombstone(key)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, ReceiveResponse{Error: "not found or expired"})
+		return
+	}
+
+	switch t.Reason {
+	case TombstoneExpired:
+		writeJSON(w, http.StatusGone, ReceiveResponse{
+			Error:     "expired",
+			Reason:    t.Reason,
+			ExpiredAt: t.At.UTC().Format(time.RFC3339),
+		})
+	default:
+		writeJSON(w, http.StatusGone, ReceiveResponse{Error: "already received", Reason: t.Reason})
+	}
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ok":             true,
+		"blobs":          s.store.Count(),
+		"receive_misses": s.misses.Total(),
+	})
+}
+
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, LimitsResponse{
+		OK:      true,
+		MaxSize: s.config.MaxSize,
+		MaxTTL:  int(s.config.MaxTTL.Seconds()),
+		MinTTL:  int(s.config.MinTTL.Seconds()),
+
+		MaxUploadSize: s.config.MaxUploadSize,
+	})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats := s.store.Stats()
+	writeJSON(w, http.StatusOK, StatsResponse{
+		OK:          true,
+		Stored:      stats.Stored,
+		StoredBytes: stats.StoredBytes,
+		Delivered:   stats.Delivered,
+		Expired:     stats.Expired,
+		Blobs:       stats.Blobs,
+		Bytes:       stats.Bytes,
+	})
+}
+
+func (s *Server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	features := []string{FeatureSpaces, FeatureStatus, FeatureExtend, FeatureStats, FeatureMaxDownloads}
+	if s.config.WebUI {
+		features = append(features, FeatureWebUI)
+	}
+	if s.config.Shorten {
+		features = append(features, FeatureShortLinks)
+	}
+	if s.config.TTLMode == TTLSliding {
+		features = append(features, FeatureSlidingTTL)
+	}
+	if s.config.AdminToken != "" {
+		features = append(features, FeatureAdmin)
+	}
+	if s.config.AllowPeek {
+		features = append(features, FeaturePeek)
+	}
+	if s.config.MaxUploadSize > 0 {
+		features = append(features, FeatureChunkedUpload)
+	}
+	writeJSON(w, http.StatusOK, CapabilitiesResponse{
+		OK:       true,
+		Features: features,
+		MaxSize:  s.config.MaxSize,
+		MaxTTL:   int(s.config.MaxTTL.Seconds()),
+		MinTTL:   int(s.config.MinTTL.Seconds()),
+
+		MaxUploadSize: s.config.MaxUploadSize,
+	})
+}
+
+func (s *Server) handleWebUI(w http.ResponseWriter, r *http.Request) {
+	// The page only ever talks to this relay, and never loads remote code.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(webUI)
+}
+
+// allowOwnOrigin adds CORS headers for requests whose Origin is the relay
+// itself, so the web receive page keeps working behind proxies that rewrite
+// the scheme or port, without opening the API to other sites.
+func allowOwnOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONFlush writes a JSON response and flushes it to the connection,
+// reporting whether the client could be written to.
+func writeJSONFlush(w http.ResponseWriter, status int, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)+1))
+	w.WriteHeader(status)
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		return err
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
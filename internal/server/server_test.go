@@ -0,0 +1,1085 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// do sends a request to the relay and returns the recorded response.
+func do(t *testing.T, srv *Server, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebUI(t *testing.T) {
+	config := DefaultConfig()
+	config.WebUI = true
+	rec := do(t, New(config), "GET", "/", "")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET / with web UI enabled: status %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "<title>git-share</title>") {
+		t.Error("response does not look like the web receive page")
+	}
+}
+
+func TestWebUIDisabled(t *testing.T) {
+	rec := do(t, New(DefaultConfig()), "GET", "/", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET / with web UI disabled: status %d, want 404", rec.Code)
+	}
+}
+
+func TestReceiveCORSOwnOriginOnly(t *testing.T) {
+	srv := New(DefaultConfig())
+
+	req := httptest.NewRequest("GET", "http://relay.example/api/receive/missing", nil)
+	req.Header.Set("Origin", "https://relay.example")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://relay.example" {
+		t.Errorf("own origin: Access-Control-Allow-Origin = %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "http://relay.example/api/receive/missing", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("foreign origin should not be allowed, got %q", got)
+	}
+}
+
+func TestReceiveExpiredBlob(t *testing.T) {
+	srv := New(DefaultConfig())
+	srv.store.Put("abc123", []byte("data"), 1*time.Millisecond, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	rec := do(t, srv, "GET", "/api/receive/abc123", "")
+	if rec.Code != http.StatusGone {
+		t.Fatalf("status %d, want 410", rec.Code)
+	}
+	var resp ReceiveResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Reason != TombstoneExpired || resp.ExpiredAt == "" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	if rec := do(t, srv, "GET", "/api/receive/never-existed", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown code ID: status %d, want 404", rec.Code)
+	}
+}
+
+func TestSendReportsStoredSize(t *testing.T) {
+	rec := do(t, New(DefaultConfig()), "POST", "/api/send", `{"code_id":"abc123","data":"aGVsbG8gd29ybGQ=","ttl":60}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201", rec.Code)
+	}
+	var resp SendResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Size != len("aGVsbG8gd29ybGQ=") {
+		t.Errorf("size %d, want %d", resp.Size, len("aGVsbG8gd29ybGQ="))
+	}
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		tls        bool
+		forwarded  string
+		path       string
+		want       int
+	}{
+		{name: "direct http", want: http.StatusBadRequest},
+		{name: "direct https", tls: true, want: http.StatusNotFound},
+		{name: "proxied https", trustProxy: true, forwarded: "https", want: http.StatusNotFound},
+		{name: "proxied http", trustProxy: true, forwarded: "http", want: http.StatusBadRequest},
+		{name: "proxied https with multiple hops", trustProxy: true, forwarded: "https, http", want: http.StatusNotFound},
+		{name: "untrusted forwarded header", forwarded: "https", want: http.StatusBadRequest},
+		{name: "health check over http", path: "/api/health", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.RequireHTTPS = true
+			config.TrustProxy = tt.trustProxy
+			srv := New(config)
+
+			path := tt.path
+			if path == "" {
+				path = "/api/receive/missing"
+			}
+			req := httptest.NewRequest("GET", path, nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwarded)
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d (body: %s)", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "requires HTTPS") {
+				t.Errorf("expected guidance in body, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSpacesAreIsolated(t *testing.T) {
+	srv := New(DefaultConfig())
+
+	for _, space := range []string{"team-a", "team-b"} {
+		rec := do(t, srv, "POST", "/api/"+space+"/send", `{"code_id":"same","data":"`+space+`","ttl":60}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("send to %s: status %d, body %s", space, rec.Code, rec.Body.String())
+		}
+	}
+
+	// Neither the unspaced route nor another space can see a spaced blob
+	if rec := do(t, srv, "GET", "/api/receive/same", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unspaced receive: status %d, want 404", rec.Code)
+	}
+	if rec := do(t, srv, "GET", "/api/team-c/receive/same", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("other space receive: status %d, want 404", rec.Code)
+	}
+
+	for _, space := range []string{"team-a", "team-b"} {
+		rec := do(t, srv, "GET", "/api/"+space+"/receive/same", "")
+		var resp ReceiveResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusOK || resp.Data != space {
+			t.Errorf("receive from %s: status %d, data %q", space, rec.Code, resp.Data)
+		}
+	}
+
+	// A consumed blob in one space does not leave a tombstone in another
+	if rec := do(t, srv, "GET", "/api/team-a/receive/same", ""); rec.Code != http.StatusGone {
+		t.Errorf("second receive in team-a: status %d, want 410", rec.Code)
+	}
+	if rec := do(t, srv, "GET", "/api/team-c/receive/same", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("receive in team-c: status %d, want 404", rec.Code)
+	}
+}
+
+func TestSendRejectsSlashInCodeID(t *testing.T) {
+	srv := New(DefaultConfig())
+	rec := do(t, srv, "POST", "/api/send", `{"code_id":"team-a/same","data":"x","ttl":60}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
+
+func TestMinTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		reject   bool
+		strict   bool
+		ttl      int
+		wantCode int
+		wantTTL  int
+		wantErr  string
+	}{
+		{name: "below minimum is raised", ttl: 10, wantCode: http.StatusCreated, wantTTL: 300},
+		{name: "valid request is untouched", ttl: 600, wantCode: http.StatusCreated, wantTTL: 600},
+		{name: "above maximum is capped", ttl: 7200, wantCode: http.StatusCreated, wantTTL: 3600},
+		{name: "default uses maximum", ttl: 0, wantCode: http.StatusCreated, wantTTL: 3600},
+		{name: "below minimum is rejected", reject: true, ttl: 10, wantCode: http.StatusBadRequest, wantErr: "at least 5m0s"},
+		{name: "above maximum is rejected", strict: true, ttl: 7200, wantCode: http.StatusBadRequest, wantErr: "2h0m0s is longer than this relay's maximum of 1h0m0s"},
+		{name: "maximum is allowed when strict", strict: true, ttl: 3600, wantCode: http.StatusCreated, wantTTL: 3600},
+		{name: "default is allowed when strict", strict: true, ttl: 0, wantCode: http.StatusCreated, wantTTL: 3600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.MinTTL = 5 * time.Minute
+			config.RejectShortTTL = tt.reject
+			config.StrictTTL = tt.strict
+			srv := New(config)
+
+			body, _ := json.Marshal(SendRequest{CodeID: "abc", Data: "x", TTL: tt.ttl})
+			rec := do(t, srv, "POST", "/api/send", string(body))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d (body: %s)", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			var resp SendResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.TTL != tt.wantTTL {
+				t.Errorf("effective TTL %d, want %d", resp.TTL, tt.wantTTL)
+			}
+			if !strings.Contains(resp.Error, tt.wantErr) {
+				t.Errorf("unexpected error %q", resp.Error)
+			}
+		})
+	}
+}
+
+// failingWriter is a ResponseWriter whose connection has gone away.
+type failingWriter struct {
+	header http.Header
+}
+
+func (w *failingWriter) Header() http.Header       { return w.header }
+func (w *failingWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset by peer") }
+func (w *failingWriter) WriteHeader(int)           {}
+
+func TestReceiveKeepsBlobOnFailedWrite(t *testing.T) {
+	srv := New(DefaultConfig())
+	do(t, srv, "POST", "/api/send", `{"code_id":"abc","data":"payload","ttl":60}`)
+
+	req := httptest.NewRequest("GET", "/api/receive/abc", nil)
+	srv.Handler().ServeHTTP(&failingWriter{header: http.Header{}}, req)
+
+	rec := do(t, srv, "GET", "/api/receive/abc", "")
+	var resp ReceiveResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp.Data != "payload" {
+		t.Fatalf("retry after failed write: status %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	// A successful delivery consumes it
+	if rec := do(t, srv, "GET", "/api/receive/abc", ""); rec.Code != http.StatusGone {
+		t.Errorf("receive after delivery: status %d, want 410", rec.Code)
+	}
+}
+
+func TestReceiveWhileClaimed(t *testing.T) {
+	srv := New(DefaultConfig())
+	do(t, srv, "POST", "/api/send", `{"code_id":"abc","data":"payload","ttl":60}`)
+	srv.store.Claim("abc")
+
+	if rec := do(t, srv, "GET", "/api/receive/abc", ""); rec.Code != http.StatusConflict {
+		t.Errorf("status %d, want 409", rec.Code)
+	}
+}
+
+// captureLog redirects the standard logger for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestAuditMisses(t *testing.T) {
+	tests := []struct {
+		name      string
+		audit     bool
+		ips       bool
+		wantLog   bool
+		wantIP    bool
+		wantAlert bool
+	}{
+		{name: "aggregate only by default"},
+		{name: "audit without IPs", audit: true, wantLog: true, wantAlert: true},
+		{name: "audit with IPs", audit: true, ips: true, wantLog: true, wantIP: true, wantAlert: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			config := DefaultConfig()
+			config.AuditMisses = tt.audit
+			config.AuditIPs = tt.ips
+			if tt.audit {
+				config.MissAlert = 3
+			}
+			srv := New(config)
+
+			do(t, srv, "POST", "/api/send", `{"code_id":"real","data":"x","ttl":60}`)
+			do(t, srv, "GET", "/api/receive/real", "")
+			// Status checks and extends reveal whether a code ID exists too
+			for _, target := range []string{"/api/receive/guess1", "/api/receive/guess2", "/api/status/guess3"} {
+				do(t, srv, "GET", target, "")
+			}
+			do(t, srv, "PUT", "/api/extend/guess4", `{"ttl":60}`)
+
+			var health map[string]interface{}
+			json.Unmarshal(do(t, srv, "GET", "/api/health", "").Body.Bytes(), &health)
+			if health["receive_misses"] != float64(4) {
+				t.Errorf("receive_misses = %v, want 4", health["receive_misses"])
+			}
+
+			out := logs.String()
+			if got := strings.Contains(out, "Receive miss for guess2"); got != tt.wantLog {
+				t.Errorf("miss logged = %v, want %v\nLOG:\n%s", got, tt.wantLog, out)
+			}
+			if strings.Contains(out, "miss for real") {
+				t.Error("a successful receive should not be audited")
+			}
+			// httptest requests come from 192.0.2.1
+			if got := strings.Contains(out, "192.0.2.1"); got != tt.wantIP {
+				t.Errorf("IP logged = %v, want %v\nLOG:\n%s", got, tt.wantIP, out)
+			}
+			if got := strings.Count(out, "possible code ID probing"); got != map[bool]int{true: 1}[tt.wantAlert] {
+				t.Errorf("got %d probing alerts\nLOG:\n%s", got, out)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.5:51234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+
+	if got := clientIP(req, false); got != "10.0.0.5" {
+		t.Errorf("untrusted: clientIP = %q, want the peer address", got)
+	}
+	if got := clientIP(req, true); got != "203.0.113.9" {
+		t.Errorf("trusted: clientIP = %q, want the first forwarded address", got)
+	}
+}
+
+func TestLogSampleRate(t *testing.T) {
+	tests := []struct {
+		rate        float64
+		wantSuccess bool
+	}{
+		{rate: 0, wantSuccess: false},
+		{rate: 1, wantSuccess: true},
+	}
+
+	for _, tt := range tests {
+		logs := captureLog(t)
+		config := DefaultConfig()
+		config.LogSampleRate = tt.rate
+		config.MaxSize = 64
+		srv := New(config)
+
+		do(t, srv, "POST", "/api/send", `{"code_id":"abc","data":"x","ttl":60}`)
+		do(t, srv, "GET", "/api/receive/abc", "")
+		do(t, srv, "POST", "/api/send", `{"code_id":"dup","data":"x","ttl":60}`)
+		do(t, srv, "POST", "/api/send", `{"code_id":"dup","data":"x","ttl":60}`)                   // conflict
+		do(t, srv, "POST", "/api/send", `{"code_id":"big","data":"`+strings.Repeat("x", 100)+`"}`) // too large
+
+		out := logs.String()
+		for _, success := range []string{"Stored blob abc", "Delivered and deleted blob abc"} {
+			if got := strings.Contains(out, success); got != tt.wantSuccess {
+				t.Errorf("rate %v: logged %q = %v, want %v", tt.rate, success, got, tt.wantSuccess)
+			}
+		}
+		for _, rejection := range []string{"POST /api/send -> 409", "POST /api/send -> 400"} {
+			if !strings.Contains(out, rejection) {
+				t.Errorf("rate %v: rejection %q not logged\nLOG:\n%s", tt.rate, rejection, out)
+			}
+		}
+	}
+}
+
+func TestStatusETag(t *testing.T) {
+	srv := New(DefaultConfig())
+	do(t, srv, "POST", "/api/send", `{"code_id":"abc","data":"payload","ttl":60}`)
+
+	rec := do(t, srv, "GET", "/api/status/abc", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", rec.Code)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("status response has no ETag")
+	}
+	if !strings.Contains(rec.Body.String(), `"size":7`) {
+		t.Errorf("unexpected status body %s", rec.Body.String())
+	}
+
+	// Polling with the ETag is cheap and doesn't consume the blob
+	req := httptest.NewRequest("GET", "/api/status/abc", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match with the current ETag: got %d, want 304", rec.Code)
+	}
+
+	if rec := do(t, srv, "GET", "/api/status/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("status of an unknown blob: got %d, want 404", rec.Code)
+	}
+}
+
+func TestConditionalReceive(t *testing.T) {
+	srv := New(DefaultConfig())
+	do(t, srv, "POST", "/api/send", `{"code_id":"abc","data":"payload","ttl":60}`)
+	etag := do(t, srv, "GET", "/api/status/abc", "").Header().Get("ETag")
+
+	receive := func(ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/receive/abc", nil)
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A mismatched ETag is refused and the blob is kept
+	if rec := receive(`"stale"`); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("mismatched If-Match: got %d, want 412", rec.Code)
+	}
+	if rec := do(t, srv, "GET", "/api/status/abc", ""); rec.Code != http.StatusOK {
+		t.Fatalf("blob was lost after a mismatched receive: status %d", rec.Code)
+	}
+
+	rec := receive(etag)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("matching If-Match: got %d, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"data":"payload"`) {
+		t.Errorf("unexpected body %s", rec.Body.String())
+	}
+	if rec := do(t, srv, "GET", "/api/status/abc", ""); rec.Code != http.StatusGone {
+		t.Errorf("status after receive: got %d, want 410", rec.Code)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: `"abc"`, want: true},
+		{header: `W/"abc"`, want: true},
+		{header: `"xyz", "abc"`, want: true},
+		{header: `*`, want: true},
+		{header: `"xyz"`, want: false},
+		{header: ``, want: false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"abc"`); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAdminPurge(t *testing.T) {
+	config := DefaultConfig()
+	config.AdminToken = "s3cret"
+	srv := New(config)
+	do(t, srv, "POST", "/api/send", `{"code_id":"a","data":"x","ttl":60}`)
+	do(t, srv, "POST", "/api/team/send", `{"code_id":"b","data":"x","ttl":60}`)
+
+	purge := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", "/api/admin/blobs", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	captureLog(t)
+	for _, auth := range []string{"", "Bearer wrong", "s3cret"} {
+		if rec := purge(auth); rec.Code != http.StatusUnauthorized {
+			t.Errorf("purge with Authorization %q: got %d, want 401", auth, rec.Code)
+		}
+	}
+	if srv.store.Count() != 2 {
+		t.Fatalf("unauthorized purge removed blobs: %d left", srv.store.Count())
+	}
+
+	rec := purge("Bearer s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("purge: got %d, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"removed":2`) {
+		t.Errorf("unexpected purge body %s", rec.Body.String())
+	}
+	if srv.store.Count() != 0 {
+		t.Errorf("store still holds %d blobs after purge", srv.store.Count())
+	}
+	if rec := do(t, srv, "GET", "/api/receive/a", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("receive after purge: got %d, want 404", rec.Code)
+	}
+	var stats StatsResponse
+	json.Unmarshal(do(t, srv, "GET", "/api/stats", "").Body.Bytes(), &stats)
+	if stats != (StatsResponse{OK: true}) {
+		t.Errorf("stats after purge = %+v, want zeros", stats)
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	request := func(srv *Server, method, path, body, auth string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	send := func(id string) string { return `{"code_id":"` + id + `","data":"x","ttl":60}` }
+	captureLog(t)
+
+	config := DefaultConfig()
+	config.AuthToken = "s3cret"
+	srv := New(config)
+	for _, auth := range []string{"", "Bearer wrong", "s3cret", "Bearer s3cret "} {
+		if code := request(srv, "POST", "/api/send", send("a"), auth); code != http.StatusUnauthorized {
+			t.Errorf("send with Authorization %q: got %d, want 401", auth, code)
+		}
+	}
+	if code := request(srv, "POST", "/api/team/send", send("a"), ""); code != http.StatusUnauthorized {
+		t.Errorf("spaced send without a token: got %d, want 401", code)
+	}
+	if srv.store.Count() != 0 {
+		t.Fatalf("unauthorized sends stored %d blobs", srv.store.Count())
+	}
+	if code := request(srv, "POST", "/api/send", send("a"), "Bearer s3cret"); code != http.StatusCreated {
+		t.Fatalf("send with the token: got %d, want 201", code)
+	}
+	for _, target := range []string{"/api/extend/a", "/api/team/extend/a"} {
+		if code := request(srv, "PUT", target, `{"ttl":60}`, ""); code != http.StatusUnauthorized {
+			t.Errorf("PUT %s without a token: got %d, want 401", target, code)
+		}
+	}
+	if code := request(srv, "PUT", "/api/extend/a", `{"ttl":60}`, "Bearer s3cret"); code != http.StatusOK {
+		t.Errorf("extend with the token: got %d, want 200", code)
+	}
+	if code := request(srv, "GET", "/api/status/a", "", ""); code != http.StatusOK {
+		t.Errorf("status without a token: got %d, want 200", code)
+	}
+	if code := request(srv, "GET", "/api/receive/a", "", ""); code != http.StatusOK {
+		t.Errorf("receive without a token: got %d, want 200", code)
+	}
+
+	config.AuthReceive = true
+	srv = New(config)
+	request(srv, "POST", "/api/send", send("b"), "Bearer s3cret")
+	for _, target := range []string{"/api/status/b", "/api/team/status/b"} {
+		if code := request(srv, "GET", target, "", ""); code != http.StatusUnauthorized {
+			t.Errorf("GET %s without a token under AuthReceive: got %d, want 401", target, code)
+		}
+	}
+	if code := request(srv, "GET", "/api/status/b", "", "Bearer s3cret"); code != http.StatusOK {
+		t.Errorf("status with the token under AuthReceive: got %d, want 200", code)
+	}
+	if code := request(srv, "GET", "/api/receive/b", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("receive without a token under AuthReceive: got %d, want 401", code)
+	}
+	if code := request(srv, "GET", "/api/receive/b", "", "Bearer s3cret"); code != http.StatusOK {
+		t.Errorf("receive with the token under AuthReceive: got %d, want 200", code)
+	}
+}
+
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	if rec := do(t, New(DefaultConfig()), "DELETE", "/api/admin/blobs", ""); rec.Code == http.StatusOK {
+		t.Errorf("purge without an admin token configured: got %d", rec.Code)
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConns = 2
+	srv := New(config)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// Slow uploads hold their slots until their bodies finish
+	var writers []*io.PipeWriter
+	results := make(chan int, config.MaxConns)
+	for i := 0; i < config.MaxConns; i++ {
+		body, w := io.Pipe()
+		writers = append(writers, w)
+		go func() {
+			resp, err := http.Post(ts.URL+"/api/send", "application/json", body)
+			if err != nil {
+				results <- 0
+				return
+			}
+			resp.Body.Close()
+			results <- resp.StatusCode
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.conns) < config.MaxConns {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d slow requests arrived", len(srv.conns), config.MaxConns)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	resp, err := http.Get(ts.URL + "/api/status/abc123")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("excess request got %d, want 503", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("503 is missing Retry-After")
+	}
+	if rec := do(t, srv, "GET", "/api/health", ""); rec.Code != http.StatusOK {
+		t.Errorf("health check got %d while saturated, want 200", rec.Code)
+	}
+
+	for i, w := range writers {
+		fmt.Fprintf(w, `{"code_id":"slow%d","data":"aGVsbG8=","ttl":60}`, i)
+		w.Close()
+	}
+	for range writers {
+		if code := <-results; code != http.StatusCreated {
+			t.Errorf("slow request got %d, want 201", code)
+		}
+	}
+
+	// Finished requests free their slots
+	resp, err = http.Get(ts.URL + "/api/status/slow0")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("request after the slow ones finished got %d, want 200", resp.StatusCode)
+	}
+}
+
+// TestReceiveWhileExtending is meant for go test -race: a receive reads the
+// blob it claimed while extends and sliding-TTL status checks change it.
+func TestReceiveWhileExtending(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxTTL = 2 * time.Hour
+	config.TTLMode = TTLSliding
+	srv := New(config)
+	captureLog(t)
+	const receives = 2000
+	do(t, srv, "POST", "/api/send", fmt.Sprintf(`{"code_id":"abc","data":"aGVsbG8=","ttl":60,"max_downloads":%d}`, receives))
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ttl := 3600; ; ttl++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				do(t, srv, "PUT", "/api/extend/abc", fmt.Sprintf(`{"ttl":%d}`, ttl))
+				do(t, srv, "GET", "/api/status/abc", "")
+			}
+		}()
+	}
+	for range receives {
+		if rec := do(t, srv, "GET", "/api/receive/abc", ""); rec.Code != http.StatusOK && rec.Code != http.StatusConflict {
+			t.Errorf("receive got %d: %s", rec.Code, rec.Body)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestExtend(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxTTL = 2 * time.Hour
+	srv := New(config)
+	do(t, srv, "POST", "/api/send", `{"code_id":"abc123","data":"aGVsbG8=","ttl":60}`)
+
+	rec := do(t, srv, "PUT", "/api/extend/abc123", `{"ttl":7200000}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("extend got %d: %s", rec.Code, rec.Body)
+	}
+	var resp ExtendResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	expiry, err := time.Parse(time.RFC3339, resp.Expiry)
+	if err != nil {
+		t.Fatalf("bad expiry %q: %v", resp.Expiry, err)
+	}
+	if until := time.Until(expiry); until < time.Hour || until > config.MaxTTL {
+		t.Errorf("extended to %s from now, want it capped at %s", until, config.MaxTTL)
+	}
+
+	if rec := do(t, srv, "PUT", "/api/extend/abc123", `not json`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid body got %d, want 400", rec.Code)
+	}
+	if rec := do(t, srv, "PUT", "/api/extend/missing", `{}`); rec.Code != http.StatusNotFound {
+		t.Errorf("missing blob got %d, want 404", rec.Code)
+	}
+	do(t, srv, "GET", "/api/receive/abc123", "")
+	if rec := do(t, srv, "PUT", "/api/extend/abc123", `{}`); rec.Code != http.StatusGone {
+		t.Errorf("received blob got %d, want 410", rec.Code)
+	}
+}
+
+func TestShortLinks(t *testing.T) {
+	config := DefaultConfig()
+	config.WebUI = true
+	config.Shorten = true
+	srv := New(config)
+
+	send := func(target, codeID string) string {
+		t.Helper()
+		rec := do(t, srv, "POST", target, `{"code_id":"`+codeID+`","data":"aGVsbG8=","ttl":60}`)
+		var resp SendResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if !strings.HasPrefix(resp.ShortURL, "http://example.com/s/") {
+			t.Fatalf("short_url = %q, want a link on the relay", resp.ShortURL)
+		}
+		if strings.Contains(resp.ShortURL, codeID) || strings.Contains(resp.ShortURL, "aGVsbG8") {
+			t.Errorf("short_url %q leaks the code ID or data", resp.ShortURL)
+		}
+		return strings.TrimPrefix(resp.ShortURL, "http://example.com")
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		wantLocation string
+	}{
+		{name: "default space", path: send("/api/send", "abc123"), wantLocation: "/?id=abc123"},
+		{name: "spaced", path: send("/api/team/send", "def456"), wantLocation: "/?id=def456&space=team"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, srv, "GET", tt.path, "")
+			if rec.Code != http.StatusFound {
+				t.Fatalf("got %d, want a redirect", rec.Code)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+
+	if rec := do(t, srv, "GET", "/s/unknown1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown token got %d, want 404", rec.Code)
+	}
+
+	// Without --shorten there are no links
+	plain := New(DefaultConfig())
+	rec := do(t, plain, "POST", "/api/send", `{"code_id":"abc123","data":"aGVsbG8=","ttl":60}`)
+	if strings.Contains(rec.Body.String(), "short_url") {
+		t.Errorf("short_url returned without --shorten: %s", rec.Body)
+	}
+}
+
+func TestShortLinkExpires(t *testing.T) {
+	store := NewStore()
+	links := newShortLinks(store)
+	token, err := links.add("", "abc123", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if _, ok := links.resolve(token); ok {
+		t.Error("an expired link still resolves")
+	}
+
+	// Adding a link forgets expired ones
+	links.add("", "def456", time.Now().Add(time.Hour))
+	if _, ok := links.links[token]; ok {
+		t.Error("the expired link was not forgotten")
+	}
+
+	// An extended blob keeps its link, however it was first made to expire
+	store.Put("team/ghi789", []byte("x"), 20*time.Millisecond, 1)
+	extended, err := links.add("team", "ghi789", time.Now().Add(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	store.Extend("team/ghi789", time.Hour)
+	time.Sleep(30 * time.Millisecond)
+	links.add("", "jkl012", time.Now().Add(time.Hour))
+	if link, ok := links.resolve(extended); !ok || link.codeID != "ghi789" {
+		t.Errorf("the link to an extended blob = %+v, %v; want it to resolve", link, ok)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(*Config)
+		want   []string
+	}{
+		{name: "defaults", config: func(*Config) {}, want: []string{FeatureSpaces, FeatureStatus, FeatureExtend, FeatureStats, FeatureMaxDownloads, FeatureChunkedUpload}},
+		{
+			name: "optional features",
+			config: func(c *Config) {
+				c.WebUI, c.Shorten, c.TTLMode, c.AdminToken, c.AllowPeek = true, true, TTLSliding, "s3cret", true
+			},
+			want: []string{FeatureSpaces, FeatureStatus, FeatureExtend, FeatureStats, FeatureMaxDownloads, FeatureWebUI, FeatureShortLinks, FeatureSlidingTTL, FeatureAdmin, FeaturePeek, FeatureChunkedUpload},
+		},
+		{name: "no uploads in parts", config: func(c *Config) { c.MaxUploadSize = 0 }, want: []string{FeatureSpaces, FeatureStatus, FeatureExtend, FeatureStats, FeatureMaxDownloads}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.MaxSize = 2048
+			config.MinTTL = time.Minute
+			tt.config(&config)
+			rec := do(t, New(config), "GET", "/api/capabilities", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			var got CapabilitiesResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			want := CapabilitiesResponse{OK: true, Features: tt.want, MaxSize: 2048, MaxTTL: 3600, MinTTL: 60, MaxUploadSize: config.MaxUploadSize}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("capabilities = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestPeek(t *testing.T) {
+	srv := New(DefaultConfig())
+	do(t, srv, "POST", "/api/send", `{"code_id":"abc","data":"payload","ttl":3600}`)
+	if rec := do(t, srv, "GET", "/api/peek/abc", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("peek without --allow-peek: status %d, want 403", rec.Code)
+	}
+
+	config := DefaultConfig()
+	config.AllowPeek = true
+	srv = New(config)
+	do(t, srv, "POST", "/api/send", `{"code_id":"abc","data":"payload","ttl":3600}`)
+	do(t, srv, "POST", "/api/team/send", `{"code_id":"abc","data":"team payload","ttl":3600}`)
+	for _, tt := range []struct{ target, want string }{
+		{"/api/peek/abc", "payload"},
+		{"/api/peek/abc", "payload"},
+		{"/api/team/peek/abc", "team payload"},
+		{"/api/receive/abc", "payload"},
+	} {
+		rec := do(t, srv, "GET", tt.target, "")
+		var resp ReceiveResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusOK || resp.Data != tt.want {
+			t.Fatalf("GET %s = %d %q, want 200 %q", tt.target, rec.Code, resp.Data, tt.want)
+		}
+	}
+	// Peeks don't count as deliveries, so the receive above consumed it
+	if rec := do(t, srv, "GET", "/api/peek/abc", ""); rec.Code == http.StatusOK {
+		t.Errorf("peek after receive: status %d, want the blob gone", rec.Code)
+	}
+}
+
+func TestMaxDownloads(t *testing.T) {
+	srv := New(DefaultConfig())
+	rec := do(t, srv, "POST", "/api/send", `{"code_id":"abc","data":"payload","ttl":3600,"max_downloads":2}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("send status = %d, want 201", rec.Code)
+	}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusGone} {
+		if rec := do(t, srv, "GET", "/api/receive/abc", ""); rec.Code != want {
+			t.Errorf("receive %d status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+
+	rec = do(t, srv, "POST", "/api/send", `{"code_id":"neg","data":"payload","ttl":3600,"max_downloads":-1}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("negative max_downloads status = %d, want 400", rec.Code)
+	}
+}
+
+func TestStats(t *testing.T) {
+	srv := New(DefaultConfig())
+	do(t, srv, "POST", "/api/send", `{"code_id":"abc","data":"payload","ttl":3600}`)
+	do(t, srv, "POST", "/api/send", `{"code_id":"def","data":"xy","ttl":3600}`)
+	do(t, srv, "GET", "/api/receive/abc", "")
+
+	rec := do(t, srv, "GET", "/api/stats", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var got StatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	want := StatsResponse{OK: true, Stored: 2, StoredBytes: 9, Delivered: 1, Blobs: 1, Bytes: 2}
+	if got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxSize = 2048
+	config.MinTTL = time.Minute
+	rec := do(t, New(config), "GET", "/api/limits", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var got LimitsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	want := LimitsResponse{OK: true, MaxSize: 2048, MaxTTL: 3600, MinTTL: 60, MaxUploadSize: config.MaxUploadSize}
+	if got != want {
+		t.Errorf("limits = %+v, want %+v", got, want)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	config := DefaultConfig()
+	config.RateLimit = 2
+	config.TrustProxy = true
+	srv := New(config)
+
+	receive := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/receive/missing", nil)
+		req.Header.Set("X-Forwarded-For", client)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	for i := 0; i < config.RateLimit; i++ {
+		if rec := receive("203.0.113.1"); rec.Code != http.StatusNotFound {
+			t.Fatalf("request %d: status %d, want 404", i+1, rec.Code)
+		}
+	}
+
+	rec := receive("203.0.113.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the limit: status %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("a 429 should say when to retry")
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp["ok"] != false || !strings.Contains(resp["error"].(string), "too many requests") {
+		t.Errorf("body = %s, want a JSON error", rec.Body.String())
+	}
+
+	// Extends and status checks share the client's budget
+	for _, req := range []*http.Request{
+		httptest.NewRequest("PUT", "/api/extend/missing", strings.NewReader(`{"ttl":60}`)),
+		httptest.NewRequest("GET", "/api/status/missing", nil),
+	} {
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		rec = httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s %s over the limit: status %d, want 429", req.Method, req.URL.Path, rec.Code)
+		}
+	}
+	// Other clients have their own
+	if rec := do(t, srv, "POST", "/api/send", `{}`); rec.Code == http.StatusTooManyRequests {
+		t.Error("a different client should not be limited")
+	}
+	if rec := receive("203.0.113.2"); rec.Code != http.StatusNotFound {
+		t.Errorf("another client: status %d, want 404", rec.Code)
+	}
+	// The health check is never limited
+	req := httptest.NewRequest("GET", "/api/health", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("health check: status %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	l := newRateLimiter(60)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 60; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("Allow = %v, %s; want a wait of up to a second", ok, wait)
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("a token should refill after a second")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("only one token should have refilled")
+	}
+
+	// Idle clients are forgotten once their buckets are full again
+	now = now.Add(2 * time.Minute)
+	l.Allow("b")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("a refilled bucket should be pruned")
+	}
+}
+
+func TestSendInParts(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxSize = 256
+	config.MaxUploadSize = 200
+	srv := New(config)
+	for i, part := range []string{"first-", "second-", "third"} {
+		body := fmt.Sprintf(`{"code_id":"abc","data":%q,"ttl":3600,"part":%d,"parts":3}`, part, i)
+		rec := do(t, srv, "POST", "/api/send", body)
+		want := http.StatusAccepted
+		if i == 2 {
+			want = http.StatusCreated
+		}
+		var resp SendResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != want || resp.Size != len(part) {
+			t.Fatalf("part %d: status %d, size %d; want %d, %d", i, rec.Code, resp.Size, want, len(part))
+		}
+		if i == 2 && resp.Expiry == "" {
+			t.Error("the last part's response should carry the expiry")
+		}
+	}
+	rec := do(t, srv, "GET", "/api/receive/abc", "")
+	var resp ReceiveResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp.Data != "first-second-third" {
+		t.Fatalf("receive = %d %q, want the parts joined", rec.Code, resp.Data)
+	}
+
+	big := strings.Repeat("x", 80)
+	for i, want := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusRequestEntityTooLarge} {
+		body := fmt.Sprintf(`{"code_id":"big","data":%q,"ttl":3600,"part":%d,"parts":4}`, big, i)
+		if rec := do(t, srv, "POST", "/api/send", body); rec.Code != want {
+			t.Errorf("big part %d: status %d, want %d", i, rec.Code, want)
+		}
+	}
+	if rec := do(t, srv, "POST", "/api/send", `{"code_id":"bad","data":"x","part":3,"parts":3}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("part out of range: status %d, want 400", rec.Code)
+	}
+
+	// Unfinished uploads share a cap across code IDs
+	config.MaxPendingUploads = 100
+	srv = New(config)
+	captureLog(t)
+	for i, want := range []int{http.StatusAccepted, http.StatusInsufficientStorage} {
+		body := fmt.Sprintf(`{"code_id":"up%d","data":%q,"ttl":3600,"part":0,"parts":2}`, i, big)
+		if rec := do(t, srv, "POST", "/api/send", body); rec.Code != want {
+			t.Errorf("upload %d: status %d, want %d", i, rec.Code, want)
+		}
+	}
+
+	config.MaxUploadSize = 0
+	if rec := do(t, New(config), "POST", "/api/send", `{"code_id":"abc","data":"x","part":0,"parts":2}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("parts on a relay without uploads in parts: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"container/heap"
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// shortTokenLength is the length of a short link token. Tokens are random
+// and unrelated to the code ID, so a link reveals neither it nor the
+// passphrase.
+const shortTokenLength = 8
+
+const shortTokenChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// shortLink is where a short link token points.
+type shortLink struct {
+	space   string
+	codeID  string
+	expires time.Time
+}
+
+// shortLinks maps short link tokens to blobs, for --shorten. Links are
+// forgotten once their blob would have expired. A link follows its blob's
+// expiry while the blob is stored, so extending it keeps the link working.
+type shortLinks struct {
+	mu       sync.Mutex
+	store    *Store
+	links    map[string]shortLink
+	expiries expiryQueue
+}
+
+func newShortLinks(store *Store) *shortLinks {
+	return &shortLinks{store: store, links: make(map[string]shortLink)}
+}
+
+// add creates a token for a blob that expires at expires.
+func (l *shortLinks) add(space, codeID string, expires time.Time) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var later []expiryItem
+	for l.expiries.due(now) {
+		item := heap.Pop(&l.expiries).(expiryItem)
+		if link, ok := l.refresh(item.key); ok && link.expires.After(now) {
+			// The blob was extended since
+			later = append(later, expiryItem{key: item.key, at: link.expires})
+			continue
+		}
+		delete(l.links, item.key)
+	}
+	for _, item := range later {
+		heap.Push(&l.expiries, item)
+	}
+
+	for {
+		token, err := randomToken()
+		if err != nil {
+			return "", err
+		}
+		if _, taken := l.links[token]; taken {
+			continue
+		}
+		l.links[token] = shortLink{space: space, codeID: codeID, expires: expires}
+		heap.Push(&l.expiries, expiryItem{key: token, at: expires})
+		return token, nil
+	}
+}
+
+// resolve returns the blob a token points to, if it has not expired.
+func (l *shortLinks) resolve(token string) (shortLink, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	link, ok := l.refresh(token)
+	if !ok || time.Now().After(link.expires) {
+		return shortLink{}, false
+	}
+	return link, true
+}
+
+// refresh updates a link's expiry to its blob's, which Extend or a sliding
+// TTL may have moved, and returns the link. A blob that is gone, e.g.
+// received, leaves the expiry as it was, so the link still leads to the
+// receive page to say so. Callers must hold the lock.
+func (l *shortLinks) refresh(token string) (shortLink, bool) {
+	link, ok := l.links[token]
+	if !ok {
+		return shortLink{}, false
+	}
+	if expires, ok := l.store.Expiry(storeKey(link.space, link.codeID)); ok {
+		link.expires = expires
+		l.links[token] = link
+	}
+	return link, true
+}
+
+func randomToken() (string, error) {
+	max := big.NewInt(int64(len(shortTokenChars)))
+	b := make([]byte, shortTokenLength)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = shortTokenChars[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// shortURL returns the absolute short link for token, on the host the
+// sender reached the relay at.
+func (s *Server) shortURL(r *http.Request, token string) string {
+	return requestScheme(r, s.config.TrustProxy) + "://" + r.Host + "/s/" + token
+}
+
+// handleShortLink redirects a short link to the web receive page with the
+// code ID filled in. The receiver types the passphrase there.
+func (s *Server) handleShortLink(w http.ResponseWriter, r *http.Request) {
+	link, ok := s.shortLinks.resolve(r.PathValue("token"))
+	if !ok {
+		http.Error(w, "This link has expired or does not exist.", http.StatusNotFound)
+		return
+	}
+	query := url.Values{"id": {link.codeID}}
+	if link.space != "" {
+		query.Set("space", link.space)
+	}
+	http.Redirect(w, r, "/?"+query.Encode(), http.StatusFound)
+}
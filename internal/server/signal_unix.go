@@ -0,0 +1,11 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// snapshotSignals ask a running relay to snapshot its store.
+var snapshotSignals = []os.Signal{syscall.SIGUSR1}
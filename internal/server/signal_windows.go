@@ -0,0 +1,6 @@
+package server
+
+import "os"
+
+// snapshotSignals is empty on Windows, which has no SIGUSR1.
+var snapshotSignals []os.Signal
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotVersion is bumped when the snapshot format changes incompatibly.
+const snapshotVersion = 1
+
+// snapshotFile is the on-disk form of a store: its blobs and their expiries.
+// Tombstones are not kept; they only refine "not found" errors.
+type snapshotFile struct {
+	Version int            `json:"version"`
+	Blobs   []snapshotBlob `json:"blobs"`
+}
+
+type snapshotBlob struct {
+	CodeID    string        `json:"code_id"`
+	Data      string        `json:"data"` // the base64 blob as sent
+	CreatedAt time.Time     `json:"created_at"`
+	TTL       time.Duration `json:"ttl"`
+	// LastAccess restarts the TTL window under a sliding TTL
+	LastAccess time.Time `json:"last_access,omitzero"`
+	// Downloads left; absent in files from before multiple downloads
+	Downloads int `json:"downloads,omitempty"`
+	// Version counts extensions, for the ETag
+	Version int `json:"version,omitempty"`
+}
+
+func newSnapshotBlob(codeID string, blob *Blob) snapshotBlob {
+	return snapshotBlob{
+		CodeID:     codeID,
+		Data:       string(blob.Data),
+		CreatedAt:  blob.CreatedAt,
+		TTL:        blob.TTL,
+		LastAccess: blob.LastAccess,
+		Downloads:  blob.Downloads,
+		Version:    blob.version,
+	}
+}
+
+func (b snapshotBlob) blob() *Blob {
+	return &Blob{
+		Data:       []byte(b.Data),
+		CreatedAt:  b.CreatedAt,
+		TTL:        b.TTL,
+		LastAccess: b.LastAccess,
+		Downloads:  max(b.Downloads, 1),
+		version:    b.Version,
+	}
+}
+
+// WriteSnapshot writes every live blob to w and returns how many it wrote.
+// Blobs being delivered are included, since the delivery may still fail.
+func (s *Store) WriteSnapshot(w io.Writer) (int, error) {
+	s.mu.RLock()
+	snap := snapshotFile{Version: snapshotVersion, Blobs: []snapshotBlob{}}
+	now := time.Now()
+	for codeID, blob := range s.blobs {
+		if blob.expired(now) {
+			continue
+		}
+		snap.Blobs = append(snap.Blobs, newSnapshotBlob(codeID, blob))
+	}
+	s.mu.RUnlock()
+
+	if err := json.NewEncoder(w).Encode(snap); err != nil {
+		return 0, err
+	}
+	return len(snap.Blobs), nil
+}
+
+// ReadSnapshot loads blobs written by WriteSnapshot, keeping their original
+// expiries, and returns how many it loaded. Blobs that have expired since
+// the snapshot was taken, or whose code ID is already in use, are skipped.
+func (s *Store) ReadSnapshot(r io.Reader) (int, error) {
+	var snap snapshotFile
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return 0, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loaded := 0
+	now := time.Now()
+	for _, b := range snap.Blobs {
+		blob := b.blob()
+		if blob.expired(now) {
+			continue
+		}
+		if _, exists := s.blobs[b.CodeID]; exists {
+			continue
+		}
+		delete(s.tombstones, b.CodeID)
+		s.blobs[b.CodeID] = blob
+		heap.Push(&s.blobExpiries, expiryItem{key: b.CodeID, at: blob.ExpiresAt()})
+		s.persist(b.CodeID, blob)
+		loaded++
+	}
+	return loaded, nil
+}
+
+// SaveSnapshot writes a snapshot to path. It writes to a temporary file
+// first and renames it into place, so a crash never leaves a partial file.
+func (s *Store) SaveSnapshot(path string) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := s.WriteSnapshot(tmp)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// LoadSnapshot reads a snapshot saved by SaveSnapshot; see ReadSnapshot.
+func (s *Store) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return s.ReadSnapshot(f)
+}
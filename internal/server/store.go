@@ -1,105 +1,452 @@
-package server
-
-import (
-	"sync"
-	"time"
-)
-
-// Blob represents an encrypted patch stored on the relay server.
-type Blob struct {
-	Data      []byte
-	CreatedAt time.Time
-	TTL       time.Duration
-}
-
-// Store is a thread-safe in-memory blob store with TTL and one-time-use semantics.
-type Store struct {
-	mu    sync.RWMutex
-	blobs map[string]*Blob
-}
-
-// NewStore creates a new empty blob store.
-func NewStore() *Store {
-	return &Store{
-		blobs: make(map[string]*Blob),
-	}
-}
-
-// Put stores an encrypted blob with the given TTL.
-// Returns false if the code ID already exists.
-func (s *Store) Put(codeID string, data []byte, ttl time.Duration) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, exists := s.blobs[codeID]; exists {
-		return false
-	}
-
-	s.blobs[codeID] = &Blob{
-		Data:      data,
-		CreatedAt: time.Now(),
-		TTL:       ttl,
-	}
-	return true
-}
-
-// GetAndDelete atomically retrieves and deletes a blob (one-time use).
-// Returns nil if the blob doesn't exist or has expired.
-func (s *Store) GetAndDelete(codeID string) []byte {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	blob, exists := s.blobs[codeID]
-	if !exists {
-		return nil
-	}
-
-	// Check TTL
-	if time.Since(blob.CreatedAt) > blob.TTL {
-		delete(s.blobs, codeID)
-		return nil
-	}
-
-	data := blob.Data
-	delete(s.blobs, codeID)
-	return data
-}
-
-// Cleanup removes all expired blobs. Should be called periodically.
-func (s *Store) Cleanup() int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	removed := 0
-	now := time.Now()
-	for id, blob := range s.blobs {
-		if now.Sub(blob.CreatedAt) > blob.TTL {
-			delete(s.blobs, id)
-			removed++
-		}
-	}
-	return removed
-}
-
-// Count returns the number of currently stored blobs.
-func (s *Store) Count() int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return len(s.blobs)
-}
-
-// StartCleanupLoop starts a background goroutine that periodically cleans up expired blobs.
-func (s *Store) StartCleanupLoop(interval time.Duration, done <-chan struct{}) {
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				s.Cleanup()
-			case <-done:
-				return
-			}
-		}
-	}()
-}
+package server
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Blob represents an encrypted patch stored on the relay server.
+type Blob struct {
+	Data      []byte
+	CreatedAt time.Time
+	TTL       time.Duration
+	// LastAccess is when the blob was last read under a sliding TTL, which
+	// restarts its TTL window. Zero if it never was.
+	LastAccess time.Time
+	// Downloads is how many more times the blob can be received; it is
+	// removed after the last. Always at least 1.
+	Downloads int
+
+	version int  // bumped by Extend; see ETag
+	claimed bool // being delivered; see Claim
+}
+
+// windowStart returns when the blob's current TTL window began.
+func (b *Blob) windowStart() time.Time {
+	if b.LastAccess.After(b.CreatedAt) {
+		return b.LastAccess
+	}
+	return b.CreatedAt
+}
+
+// ExpiresAt returns the time at which the blob expires.
+func (b *Blob) ExpiresAt() time.Time {
+	return b.windowStart().Add(b.TTL)
+}
+
+// expired reports whether the blob has expired as of now.
+func (b *Blob) expired(now time.Time) bool {
+	return now.After(b.ExpiresAt())
+}
+
+// ETag identifies this version of the blob for conditional requests. It
+// covers the data and the expiry it was stored or extended with, so it
+// changes if either does, but not when a read restarts the TTL window under
+// a sliding TTL; otherwise every poll would see a new version.
+func (b *Blob) ETag() string {
+	h := sha256.New()
+	h.Write(b.Data)
+	fmt.Fprintf(h, "\x00%d\x00%d\x00%d", b.CreatedAt.UnixNano(), b.TTL, b.version)
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// Tombstone reasons.
+const (
+	TombstoneExpired  = "expired"
+	TombstoneConsumed = "consumed"
+)
+
+// tombstoneTTL is how long the store remembers why a blob is gone.
+const tombstoneTTL = time.Hour
+
+// Tombstone records why a blob is no longer available, so a late receiver
+// can be told "expired" or "already received" rather than "not found".
+type Tombstone struct {
+	Reason string    // TombstoneExpired or TombstoneConsumed
+	At     time.Time // when the blob expired or was received
+}
+
+// Store is a thread-safe in-memory blob store with TTL and one-time-use
+// semantics. A store made with NewFileStore also keeps its blobs on disk.
+type Store struct {
+	mu         sync.RWMutex
+	blobs      map[string]*Blob
+	tombstones map[string]Tombstone
+
+	// Expiry queues let Cleanup visit only what is due instead of
+	// scanning every blob and tombstone.
+	blobExpiries      expiryQueue
+	tombstoneExpiries expiryQueue
+
+	uploads        map[string]*upload // blobs still arriving in parts; see PutPart
+	uploadExpiries expiryQueue
+	uploadBytes    int64 // total size of the parts in uploads
+	uploadLimit    int64 // cap on uploadBytes, 0 for none; see SetUploadLimit
+
+	sliding bool   // reads restart a blob's TTL; see SetSlidingTTL
+	dir     string // where blobs are persisted; empty for memory only
+
+	// Counters for Stats, cleared by Purge
+	stored, delivered, expired int64
+	storedBytes                int64
+}
+
+// StoreStats counts what a store has done since it was created or last
+// purged.
+type StoreStats struct {
+	Stored      int64 // blobs put
+	StoredBytes int64 // total size of the blobs put
+	Delivered   int64 // downloads of blobs, counting each of a blob's downloads
+	Expired     int64 // blobs removed because they expired
+	Blobs       int   // blobs stored now
+	Bytes       int64 // total size of the blobs stored now
+}
+
+// Stats returns the store's counters and current size.
+func (s *Store) Stats() StoreStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := StoreStats{
+		Stored:      s.stored,
+		StoredBytes: s.storedBytes,
+		Delivered:   s.delivered,
+		Expired:     s.expired,
+		Blobs:       len(s.blobs),
+	}
+	for _, blob := range s.blobs {
+		stats.Bytes += int64(len(blob.Data))
+	}
+	return stats
+}
+
+// NewStore creates a new empty blob store.
+func NewStore() *Store {
+	return &Store{
+		blobs:      make(map[string]*Blob),
+		tombstones: make(map[string]Tombstone),
+		uploads:    make(map[string]*upload),
+	}
+}
+
+// Put stores an encrypted blob with the given TTL that can be received
+// maxDownloads times; values below 1 mean once.
+// Returns false if the code ID already exists.
+func (s *Store) Put(codeID string, data []byte, ttl time.Duration, maxDownloads int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.blobs[codeID]; exists {
+		return false
+	}
+	if _, uploading := s.uploads[codeID]; uploading {
+		return false
+	}
+	s.put(codeID, data, ttl, maxDownloads)
+	return true
+}
+
+// put stores a blob under a code ID that is not in use. Callers must hold
+// the lock.
+func (s *Store) put(codeID string, data []byte, ttl time.Duration, maxDownloads int) {
+	delete(s.tombstones, codeID)
+	blob := &Blob{
+		Data:      data,
+		CreatedAt: time.Now(),
+		TTL:       ttl,
+		Downloads: max(maxDownloads, 1),
+	}
+	s.blobs[codeID] = blob
+	heap.Push(&s.blobExpiries, expiryItem{key: codeID, at: blob.ExpiresAt()})
+	s.persist(codeID, blob)
+	s.stored++
+	s.storedBytes += int64(len(data))
+}
+
+// SetSlidingTTL switches the store between expiring blobs a TTL after they
+// were stored (the default) and a TTL after they were last read, so a blob
+// still in use doesn't expire mid-use. Reads are Stat and Claim.
+func (s *Store) SetSlidingTTL(sliding bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sliding = sliding
+}
+
+// touch restarts a blob's TTL window under a sliding TTL. Callers must hold
+// the lock. The blob's expiry queue item is left alone, and requeued by
+// Cleanup when it comes due, so frequent reads don't grow the queue.
+func (s *Store) touch(codeID string, blob *Blob) {
+	if !s.sliding {
+		return
+	}
+	blob.LastAccess = time.Now()
+	s.persist(codeID, blob)
+}
+
+// setTombstone records why a blob went away. Callers must hold the lock.
+func (s *Store) setTombstone(codeID string, t Tombstone) {
+	s.tombstones[codeID] = t
+	heap.Push(&s.tombstoneExpiries, expiryItem{key: codeID, at: t.At.Add(tombstoneTTL)})
+}
+
+// GetAndDelete atomically retrieves a blob, deleting it on its last
+// download. Returns nil if the blob doesn't exist or has expired.
+func (s *Store) GetAndDelete(codeID string) []byte {
+	blob := s.Take(codeID)
+	if blob == nil {
+		return nil
+	}
+	return blob.Data
+}
+
+// Take atomically returns a copy of a blob and counts the download, removing
+// the blob if it was the last one. Returns nil if the blob doesn't exist or
+// has expired.
+func (s *Store) Take(codeID string) *Blob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, exists := s.blobs[codeID]
+	if !exists || blob.claimed {
+		return nil
+	}
+
+	// Check TTL
+	if blob.expired(time.Now()) {
+		delete(s.blobs, codeID)
+		s.unpersist(codeID)
+		s.setTombstone(codeID, Tombstone{Reason: TombstoneExpired, At: blob.ExpiresAt()})
+		s.expired++
+		return nil
+	}
+
+	taken := *blob
+	s.download(codeID, blob)
+	return &taken
+}
+
+// download counts a delivery of a blob, removing it after its last
+// download. Callers must hold the lock.
+func (s *Store) download(codeID string, blob *Blob) {
+	s.delivered++
+	if blob.Downloads > 1 {
+		blob.Downloads--
+		blob.claimed = false
+		s.persist(codeID, blob)
+		return
+	}
+	delete(s.blobs, codeID)
+	s.unpersist(codeID)
+	s.setTombstone(codeID, Tombstone{Reason: TombstoneConsumed, At: time.Now()})
+}
+
+// Stat returns a copy of a blob without consuming it.
+// Returns false if the blob doesn't exist or has expired.
+func (s *Store) Stat(codeID string) (Blob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, exists := s.blobs[codeID]
+	if !exists || blob.expired(time.Now()) {
+		return Blob{}, false
+	}
+	s.touch(codeID, blob)
+	return *blob, true
+}
+
+// Extend pushes a blob's expiry out to at least ttl from now and returns a
+// copy of it. Expiries are never shortened. Returns false if the blob
+// doesn't exist or has expired.
+func (s *Store) Extend(codeID string, ttl time.Duration) (Blob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, exists := s.blobs[codeID]
+	if !exists || blob.expired(time.Now()) {
+		return Blob{}, false
+	}
+	if expiry := time.Now().Add(ttl); expiry.After(blob.ExpiresAt()) {
+		blob.TTL = expiry.Sub(blob.windowStart())
+		blob.version++
+		s.persist(codeID, blob)
+	}
+	return *blob, true
+}
+
+// Claim reserves a blob for delivery without removing it, so a failed
+// delivery can be retried. The caller must follow up with Commit once the
+// blob is delivered, or Release if delivery failed. While claimed, the blob
+// cannot be claimed or taken by anyone else.
+//
+// The blob returned is a copy, since Extend and Stat go on changing the
+// stored one while it is delivered. Returns ok=false if the blob doesn't
+// exist or has expired, and a nil blob with ok=true if it is already
+// claimed.
+func (s *Store) Claim(codeID string) (blob *Blob, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, exists := s.blobs[codeID]
+	if !exists {
+		return nil, false
+	}
+	if blob.claimed {
+		return nil, true
+	}
+
+	// Check TTL
+	if blob.expired(time.Now()) {
+		delete(s.blobs, codeID)
+		s.unpersist(codeID)
+		s.setTombstone(codeID, Tombstone{Reason: TombstoneExpired, At: blob.ExpiresAt()})
+		s.expired++
+		return nil, false
+	}
+
+	s.touch(codeID, blob)
+	blob.claimed = true
+	claimed := *blob
+	return &claimed, true
+}
+
+// Commit counts the delivery of a claimed blob, deleting it if that was its
+// last download and releasing it otherwise.
+func (s *Store) Commit(codeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if blob, exists := s.blobs[codeID]; exists && blob.claimed {
+		s.download(codeID, blob)
+	}
+}
+
+// Release returns a claimed blob to the store after a failed delivery.
+func (s *Store) Release(codeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if blob, exists := s.blobs[codeID]; exists {
+		blob.claimed = false
+	}
+}
+
+// Tombstone reports why a blob that is no longer stored went away.
+// Returns false if the code ID was never seen or its tombstone has lapsed.
+func (s *Store) Tombstone(codeID string) (Tombstone, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tombstones[codeID]
+	return t, ok
+}
+
+// Cleanup removes all expired blobs. Should be called periodically.
+// It only visits blobs and tombstones that are due, so its cost does not
+// grow with the number of live blobs.
+func (s *Store) Cleanup() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	now := time.Now()
+	var claimed, later []expiryItem
+	for s.blobExpiries.due(now) {
+		item := heap.Pop(&s.blobExpiries).(expiryItem)
+		blob, exists := s.blobs[item.key]
+		if !exists {
+			// Already taken
+			continue
+		}
+		if at := blob.ExpiresAt(); at.After(now) {
+			// Read under a sliding TTL, extended, or replaced since
+			// it was queued
+			later = append(later, expiryItem{key: item.key, at: at})
+			continue
+		}
+		if blob.claimed {
+			// A blob being delivered is left for Commit or Release
+			claimed = append(claimed, item)
+			continue
+		}
+		delete(s.blobs, item.key)
+		s.unpersist(item.key)
+		s.setTombstone(item.key, Tombstone{Reason: TombstoneExpired, At: blob.ExpiresAt()})
+		removed++
+		s.expired++
+	}
+	for _, item := range append(claimed, later...) {
+		heap.Push(&s.blobExpiries, item)
+	}
+
+	for s.tombstoneExpiries.due(now) {
+		item := heap.Pop(&s.tombstoneExpiries).(expiryItem)
+		if t, ok := s.tombstones[item.key]; ok && t.At.Add(tombstoneTTL).Equal(item.at) {
+			delete(s.tombstones, item.key)
+		}
+	}
+	s.cleanupUploads(now)
+	return removed
+}
+
+// Purge removes every blob and tombstone at once, and returns the number of
+// blobs removed. Blobs being delivered are removed too. The counters Stats
+// reports start again from zero.
+func (s *Store) Purge() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := len(s.blobs)
+	for codeID := range s.blobs {
+		s.unpersist(codeID)
+	}
+	s.blobs = make(map[string]*Blob)
+	s.tombstones = make(map[string]Tombstone)
+	s.blobExpiries = nil
+	s.tombstoneExpiries = nil
+	s.uploads = make(map[string]*upload)
+	s.uploadExpiries = nil
+	s.uploadBytes = 0
+	s.stored, s.delivered, s.expired, s.storedBytes = 0, 0, 0, 0
+	return removed
+}
+
+// Expiry returns when a blob expires. Unlike Stat it doesn't count as a
+// read, so it leaves a sliding TTL alone. Returns false if the blob doesn't
+// exist or has expired.
+func (s *Store) Expiry(codeID string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, exists := s.blobs[codeID]
+	if !exists || blob.expired(time.Now()) {
+		return time.Time{}, false
+	}
+	return blob.ExpiresAt(), true
+}
+
+// Count returns the number of currently stored blobs.
+func (s *Store) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.blobs)
+}
+
+// StartCleanupLoop starts a background goroutine that periodically cleans up expired blobs.
+func (s *Store) StartCleanupLoop(interval time.Duration, done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Cleanup()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
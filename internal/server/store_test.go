@@ -1,88 +1,744 @@
-package server
-
-import (
-	"testing"
-	"time"
-)
-
-func TestStorePutAndGet(t *testing.T) {
-	s := NewStore()
-	data := []byte("encrypted-blob")
-
-	ok := s.Put("abc123", data, time.Hour)
-	if !ok {
-		t.Fatal("Put should succeed")
-	}
-
-	got := s.GetAndDelete("abc123")
-	if got == nil {
-		t.Fatal("GetAndDelete should return data")
-	}
-	if string(got) != string(data) {
-		t.Errorf("got %q, want %q", got, data)
-	}
-}
-
-func TestStoreOneTimeUse(t *testing.T) {
-	s := NewStore()
-	s.Put("abc123", []byte("data"), time.Hour)
-
-	// First get should succeed
-	got := s.GetAndDelete("abc123")
-	if got == nil {
-		t.Fatal("first GetAndDelete should return data")
-	}
-
-	// Second get should return nil
-	got = s.GetAndDelete("abc123")
-	if got != nil {
-		t.Error("second GetAndDelete should return nil (one-time use)")
-	}
-}
-
-func TestStoreTTLExpiry(t *testing.T) {
-	s := NewStore()
-	s.Put("abc123", []byte("data"), 1*time.Millisecond)
-
-	// Wait for expiry
-	time.Sleep(10 * time.Millisecond)
-
-	got := s.GetAndDelete("abc123")
-	if got != nil {
-		t.Error("GetAndDelete should return nil after TTL expiry")
-	}
-}
-
-func TestStoreDuplicateCodeID(t *testing.T) {
-	s := NewStore()
-	s.Put("abc123", []byte("data1"), time.Hour)
-
-	ok := s.Put("abc123", []byte("data2"), time.Hour)
-	if ok {
-		t.Error("duplicate Put should return false")
-	}
-}
-
-func TestStoreCleanup(t *testing.T) {
-	s := NewStore()
-	s.Put("expired", []byte("data"), 1*time.Millisecond)
-	s.Put("fresh", []byte("data"), time.Hour)
-
-	time.Sleep(10 * time.Millisecond)
-	removed := s.Cleanup()
-
-	if removed != 1 {
-		t.Errorf("cleanup should remove 1 blob, removed %d", removed)
-	}
-	if s.Count() != 1 {
-		t.Errorf("should have 1 blob remaining, got %d", s.Count())
-	}
-}
-
-func TestStoreNotFound(t *testing.T) {
-	s := NewStore()
-	got := s.GetAndDelete("nonexistent")
-	if got != nil {
-		t.Error("GetAndDelete for nonexistent key should return nil")
-	}
-}
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorePutAndGet(t *testing.T) {
+	s := NewStore()
+	data := []byte("encrypted-blob")
+
+	ok := s.Put("abc123", data, time.Hour, 1)
+	if !ok {
+		t.Fatal("Put should succeed")
+	}
+
+	got := s.GetAndDelete("abc123")
+	if got == nil {
+		t.Fatal("GetAndDelete should return data")
+	}
+	if string(got) != string(data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+}
+
+func TestStoreOneTimeUse(t *testing.T) {
+	s := NewStore()
+	s.Put("abc123", []byte("data"), time.Hour, 1)
+
+	// First get should succeed
+	got := s.GetAndDelete("abc123")
+	if got == nil {
+		t.Fatal("first GetAndDelete should return data")
+	}
+
+	// Second get should return nil
+	got = s.GetAndDelete("abc123")
+	if got != nil {
+		t.Error("second GetAndDelete should return nil (one-time use)")
+	}
+}
+
+func TestStoreMaxDownloads(t *testing.T) {
+	s := NewStore()
+	s.Put("taken", []byte("data"), time.Hour, 3)
+	for i := 0; i < 3; i++ {
+		if got := s.GetAndDelete("taken"); string(got) != "data" {
+			t.Fatalf("download %d = %q, want the data", i+1, got)
+		}
+	}
+	if got := s.GetAndDelete("taken"); got != nil {
+		t.Error("fourth download should return nil")
+	}
+	if ts, ok := s.Tombstone("taken"); !ok || ts.Reason != TombstoneConsumed {
+		t.Errorf("tombstone = %+v, %v; want consumed", ts, ok)
+	}
+
+	s.Put("claimed", []byte("data"), time.Hour, 2)
+	for i := 0; i < 2; i++ {
+		if blob, ok := s.Claim("claimed"); !ok || blob == nil {
+			t.Fatalf("claim %d failed", i+1)
+		}
+		s.Commit("claimed")
+	}
+	if _, ok := s.Claim("claimed"); ok {
+		t.Error("blob should be gone after its last download")
+	}
+	if got := s.Stats().Delivered; got != 5 {
+		t.Errorf("delivered = %d, want 5", got)
+	}
+}
+
+func TestStoreTTLExpiry(t *testing.T) {
+	s := NewStore()
+	s.Put("abc123", []byte("data"), 1*time.Millisecond, 1)
+
+	// Wait for expiry
+	time.Sleep(10 * time.Millisecond)
+
+	got := s.GetAndDelete("abc123")
+	if got != nil {
+		t.Error("GetAndDelete should return nil after TTL expiry")
+	}
+}
+
+func TestStoreDuplicateCodeID(t *testing.T) {
+	s := NewStore()
+	s.Put("abc123", []byte("data1"), time.Hour, 1)
+
+	ok := s.Put("abc123", []byte("data2"), time.Hour, 1)
+	if ok {
+		t.Error("duplicate Put should return false")
+	}
+}
+
+func TestStoreCleanup(t *testing.T) {
+	s := NewStore()
+	s.Put("expired", []byte("data"), 1*time.Millisecond, 1)
+	s.Put("fresh", []byte("data"), time.Hour, 1)
+
+	time.Sleep(10 * time.Millisecond)
+	removed := s.Cleanup()
+
+	if removed != 1 {
+		t.Errorf("cleanup should remove 1 blob, removed %d", removed)
+	}
+	if s.Count() != 1 {
+		t.Errorf("should have 1 blob remaining, got %d", s.Count())
+	}
+}
+
+func TestStoreStats(t *testing.T) {
+	s := NewStore()
+	s.Put("taken", []byte("12345"), time.Hour, 1)
+	s.Put("expired", []byte("123"), time.Millisecond, 1)
+	s.Put("claimed", []byte("12"), time.Hour, 1)
+	s.Put("pending", []byte("1"), time.Hour, 1)
+
+	s.GetAndDelete("taken")
+	if _, ok := s.Claim("claimed"); !ok {
+		t.Fatal("Claim should succeed")
+	}
+	s.Commit("claimed")
+	time.Sleep(10 * time.Millisecond)
+	s.Cleanup()
+
+	want := StoreStats{Stored: 4, StoredBytes: 11, Delivered: 2, Expired: 1, Blobs: 1, Bytes: 1}
+	if got := s.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStoreNotFound(t *testing.T) {
+	s := NewStore()
+	got := s.GetAndDelete("nonexistent")
+	if got != nil {
+		t.Error("GetAndDelete for nonexistent key should return nil")
+	}
+}
+
+func TestStoreTombstones(t *testing.T) {
+	s := NewStore()
+	s.Put("consumed", []byte("data"), time.Hour, 1)
+	s.Put("expired", []byte("data"), 1*time.Millisecond, 1)
+	s.Put("cleaned", []byte("data"), 1*time.Millisecond, 1)
+
+	s.GetAndDelete("consumed")
+	time.Sleep(10 * time.Millisecond)
+	s.GetAndDelete("expired")
+	s.Cleanup()
+
+	tests := []struct {
+		id     string
+		reason string
+	}{
+		{"consumed", TombstoneConsumed},
+		{"expired", TombstoneExpired},
+		{"cleaned", TombstoneExpired},
+	}
+	for _, tt := range tests {
+		got, ok := s.Tombstone(tt.id)
+		if !ok {
+			t.Errorf("%s: expected a tombstone", tt.id)
+			continue
+		}
+		if got.Reason != tt.reason {
+			t.Errorf("%s: reason %q, want %q", tt.id, got.Reason, tt.reason)
+		}
+	}
+
+	if _, ok := s.Tombstone("never-existed"); ok {
+		t.Error("unknown code ID should have no tombstone")
+	}
+}
+
+func TestStoreClaim(t *testing.T) {
+	s := NewStore()
+	s.Put("abc123", []byte("data"), time.Hour, 1)
+
+	blob, ok := s.Claim("abc123")
+	if !ok || blob == nil || string(blob.Data) != "data" {
+		t.Fatalf("Claim = %v, %v; want the blob", blob, ok)
+	}
+
+	// A claimed blob is held for the first receiver
+	if blob, ok := s.Claim("abc123"); !ok || blob != nil {
+		t.Errorf("second Claim = %v, %v; want nil, true", blob, ok)
+	}
+	if s.GetAndDelete("abc123") != nil {
+		t.Error("GetAndDelete should not take a claimed blob")
+	}
+
+	// A failed delivery leaves the blob retrievable
+	s.Release("abc123")
+	if _, ok := s.Tombstone("abc123"); ok {
+		t.Error("a released blob should not have a tombstone")
+	}
+	if _, ok := s.Claim("abc123"); !ok {
+		t.Fatal("released blob should be claimable again")
+	}
+
+	s.Commit("abc123")
+	if _, ok := s.Claim("abc123"); ok {
+		t.Error("committed blob should be gone")
+	}
+	if ts, ok := s.Tombstone("abc123"); !ok || ts.Reason != TombstoneConsumed {
+		t.Errorf("tombstone = %+v, %v; want consumed", ts, ok)
+	}
+}
+
+func TestStoreCleanupSkipsClaimed(t *testing.T) {
+	s := NewStore()
+	s.Put("abc123", []byte("data"), time.Millisecond, 1)
+	s.mu.Lock()
+	s.blobs["abc123"].claimed = true
+	s.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+
+	if removed := s.Cleanup(); removed != 0 {
+		t.Errorf("Cleanup removed %d claimed blobs", removed)
+	}
+	s.Commit("abc123")
+	if s.Count() != 0 {
+		t.Error("Commit should remove the blob")
+	}
+}
+
+func TestBlobETag(t *testing.T) {
+	store := NewStore()
+	store.Put("a", []byte("same"), time.Hour, 1)
+	store.Put("b", []byte("same"), 2*time.Hour, 1)
+
+	a, ok := store.Stat("a")
+	if !ok {
+		t.Fatal("Stat did not find blob a")
+	}
+	if again, _ := store.Stat("a"); again.ETag() != a.ETag() {
+		t.Error("ETag is not stable for an unchanged blob")
+	}
+	if b, _ := store.Stat("b"); b.ETag() == a.ETag() {
+		t.Error("blobs with different expiries share an ETag")
+	}
+	if store.Count() != 2 {
+		t.Error("Stat consumed a blob")
+	}
+
+	// Under a sliding TTL reads restart the expiry but keep the ETag, so a
+	// poller isn't told of a change; an extension is a change
+	store.SetSlidingTTL(true)
+	time.Sleep(5 * time.Millisecond)
+	if read, _ := store.Stat("a"); read.ETag() != a.ETag() {
+		t.Error("a read under a sliding TTL changed the ETag")
+	}
+	extended, _ := store.Extend("a", 3*time.Hour)
+	if extended.ETag() == a.ETag() {
+		t.Error("Extend kept the ETag")
+	}
+	if read, _ := store.Stat("a"); read.ETag() != extended.ETag() {
+		t.Error("a read after Extend changed the ETag")
+	}
+}
+
+func TestStorePurge(t *testing.T) {
+	store := NewStore()
+	store.Put("a", []byte("1"), time.Hour, 1)
+	store.Put("b", []byte("2"), time.Hour, 1)
+	store.Put("c", []byte("3"), time.Hour, 1)
+	store.Claim("c")
+	store.GetAndDelete("a")
+
+	if removed := store.Purge(); removed != 2 {
+		t.Errorf("Purge() = %d, want 2", removed)
+	}
+	if store.Count() != 0 {
+		t.Errorf("Count() = %d after purge", store.Count())
+	}
+	if _, ok := store.Tombstone("a"); ok {
+		t.Error("tombstones should be purged too")
+	}
+
+	// A delivery that was in flight finishes without resurrecting the blob
+	store.Commit("c")
+	store.Release("c")
+	if store.Count() != 0 {
+		t.Error("a purged blob came back")
+	}
+	// The counters start again, without the finished delivery
+	if stats := store.Stats(); stats != (StoreStats{}) {
+		t.Errorf("Stats() after purge = %+v, want zeros", stats)
+	}
+}
+
+func TestStoreCleanupMixedTTLs(t *testing.T) {
+	s := NewStore()
+	s.Put("slow", []byte("data"), time.Hour, 1)
+	s.Put("fast", []byte("data"), time.Millisecond, 1)
+	s.Put("medium", []byte("data"), 30*time.Millisecond, 1)
+	s.Put("fast2", []byte("data"), 2*time.Millisecond, 1)
+
+	time.Sleep(10 * time.Millisecond)
+	if removed := s.Cleanup(); removed != 2 {
+		t.Errorf("first Cleanup removed %d blobs, want 2", removed)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if removed := s.Cleanup(); removed != 1 {
+		t.Errorf("second Cleanup removed %d blobs, want 1", removed)
+	}
+	if s.Count() != 1 {
+		t.Fatalf("should have 1 blob remaining, got %d", s.Count())
+	}
+	if _, ok := s.Stat("slow"); !ok {
+		t.Error("the long-lived blob was removed")
+	}
+}
+
+func TestStoreCleanupIgnoresStaleEntries(t *testing.T) {
+	s := NewStore()
+	s.Put("abc123", []byte("old"), time.Millisecond, 1)
+	s.GetAndDelete("abc123")
+
+	// The code is reused before the first blob's expiry entry is popped
+	s.Put("abc123", []byte("new"), time.Hour, 1)
+	time.Sleep(5 * time.Millisecond)
+
+	if removed := s.Cleanup(); removed != 0 {
+		t.Errorf("Cleanup removed %d blobs through a stale entry", removed)
+	}
+	if got := s.GetAndDelete("abc123"); string(got) != "new" {
+		t.Errorf("got %q, want the re-sent blob", got)
+	}
+}
+
+func TestStoreCleanupAfterRelease(t *testing.T) {
+	s := NewStore()
+	s.Put("abc123", []byte("data"), time.Millisecond, 1)
+	s.mu.Lock()
+	s.blobs["abc123"].claimed = true
+	s.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+
+	if removed := s.Cleanup(); removed != 0 {
+		t.Fatalf("Cleanup removed %d claimed blobs", removed)
+	}
+	// The delivery failed, so the next Cleanup expires it
+	s.Release("abc123")
+	if removed := s.Cleanup(); removed != 1 {
+		t.Errorf("Cleanup after Release removed %d blobs, want 1", removed)
+	}
+}
+
+func TestStoreCleanupTombstones(t *testing.T) {
+	s := NewStore()
+	s.mu.Lock()
+	s.setTombstone("old", Tombstone{Reason: TombstoneConsumed, At: time.Now().Add(-tombstoneTTL - time.Second)})
+	s.setTombstone("recent", Tombstone{Reason: TombstoneConsumed, At: time.Now()})
+	s.mu.Unlock()
+
+	s.Cleanup()
+	if _, ok := s.Tombstone("old"); ok {
+		t.Error("an old tombstone survived Cleanup")
+	}
+	if _, ok := s.Tombstone("recent"); !ok {
+		t.Error("a recent tombstone was removed")
+	}
+}
+
+func TestStoreExtend(t *testing.T) {
+	s := NewStore()
+	s.Put("abc123", []byte("data"), 5*time.Millisecond, 1)
+	original, _ := s.Stat("abc123")
+
+	blob, ok := s.Extend("abc123", time.Hour)
+	if !ok || !blob.ExpiresAt().After(original.ExpiresAt()) {
+		t.Fatalf("Extend = %v, %v; want a later expiry", blob.ExpiresAt(), ok)
+	}
+	if shorter, _ := s.Extend("abc123", time.Minute); !shorter.ExpiresAt().Equal(blob.ExpiresAt()) {
+		t.Error("Extend shortened the expiry")
+	}
+
+	// The entry for the original expiry no longer applies
+	time.Sleep(10 * time.Millisecond)
+	if removed := s.Cleanup(); removed != 0 {
+		t.Errorf("Cleanup removed %d extended blobs", removed)
+	}
+	if _, ok := s.Extend("missing", time.Hour); ok {
+		t.Error("Extend should fail for a missing blob")
+	}
+}
+
+// benchmarkBlobs is the relay size the Cleanup benchmarks run against.
+const benchmarkBlobs = 100_000
+
+// cleanupScan is the previous Cleanup, which visited every blob, kept for
+// comparison in benchmarks.
+func (s *Store) cleanupScan() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	now := time.Now()
+	for id, blob := range s.blobs {
+		if now.Sub(blob.CreatedAt) > blob.TTL && !blob.claimed {
+			delete(s.blobs, id)
+			s.tombstones[id] = Tombstone{Reason: TombstoneExpired, At: blob.ExpiresAt()}
+			removed++
+		}
+	}
+	for id, t := range s.tombstones {
+		if now.Sub(t.At) > tombstoneTTL {
+			delete(s.tombstones, id)
+		}
+	}
+	return removed
+}
+
+// newBenchmarkStore fills a store with long-lived blobs, as on a busy relay
+// where only a few blobs expire between cleanups.
+func newBenchmarkStore(b *testing.B) *Store {
+	b.Helper()
+	s := NewStore()
+	data := []byte("data")
+	for i := 0; i < benchmarkBlobs; i++ {
+		s.Put(fmt.Sprintf("code-%d", i), data, time.Hour+time.Duration(i)*time.Millisecond, 1)
+	}
+	return s
+}
+
+func BenchmarkCleanupScan(b *testing.B) {
+	s := newBenchmarkStore(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.cleanupScan()
+	}
+}
+
+func BenchmarkCleanupHeap(b *testing.B) {
+	s := newBenchmarkStore(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Cleanup()
+	}
+}
+
+func TestStoreSnapshotRoundTrip(t *testing.T) {
+	s := NewStore()
+	s.Put("live", []byte("live-blob"), time.Hour, 1)
+	s.Put("short", []byte("short-blob"), 50*time.Millisecond, 1)
+	live, _ := s.Stat("live")
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	n, err := s.SaveSnapshot(path)
+	if err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("SaveSnapshot saved %d blobs, want 2", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	restored := NewStore()
+	n, err = restored.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("LoadSnapshot loaded %d blobs, want 1", n)
+	}
+	got, ok := restored.Stat("live")
+	if !ok {
+		t.Fatal("live blob should be restored")
+	}
+	if string(got.Data) != "live-blob" {
+		t.Errorf("restored data = %q, want %q", got.Data, "live-blob")
+	}
+	if !got.ExpiresAt().Equal(live.ExpiresAt()) {
+		t.Errorf("restored expiry = %s, want %s", got.ExpiresAt(), live.ExpiresAt())
+	}
+	if _, ok := restored.Stat("short"); ok {
+		t.Error("blob that expired after the snapshot should be dropped")
+	}
+
+}
+
+func TestStoreReadSnapshotKeepsExisting(t *testing.T) {
+	s := NewStore()
+	s.Put("abc", []byte("old"), time.Hour, 1)
+	var buf bytes.Buffer
+	if _, err := s.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot failed: %v", err)
+	}
+
+	other := NewStore()
+	other.Put("abc", []byte("new"), time.Hour, 1)
+	if n, err := other.ReadSnapshot(&buf); err != nil || n != 0 {
+		t.Fatalf("ReadSnapshot = %d, %v; want 0, nil", n, err)
+	}
+	if got := other.GetAndDelete("abc"); string(got) != "new" {
+		t.Errorf("existing blob was replaced: got %q", got)
+	}
+
+	if _, err := other.ReadSnapshot(strings.NewReader(`{"version":99}`)); err == nil {
+		t.Error("expected an error for an unknown snapshot version")
+	}
+}
+
+func TestStoreSlidingTTL(t *testing.T) {
+	for _, sliding := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sliding=%v", sliding), func(t *testing.T) {
+			s := NewStore()
+			s.SetSlidingTTL(sliding)
+			s.Put("busy", []byte("busy"), 100*time.Millisecond, 1)
+			s.Put("idle", []byte("idle"), 100*time.Millisecond, 1)
+			original, _ := s.Stat("busy")
+
+			// Read the busy blob well within each TTL window, for longer than its TTL
+			for range 4 {
+				time.Sleep(40 * time.Millisecond)
+				s.Stat("busy")
+			}
+
+			if _, ok := s.Stat("idle"); ok {
+				t.Error("idle blob should have expired")
+			}
+			busy, ok := s.Stat("busy")
+			if ok != sliding {
+				t.Fatalf("busy blob available = %v, want %v", ok, sliding)
+			}
+			if sliding && !busy.ExpiresAt().After(original.ExpiresAt()) {
+				t.Errorf("expiry %s should have moved past the original %s", busy.ExpiresAt(), original.ExpiresAt())
+			}
+			if n := len(s.blobExpiries); n != 2 {
+				t.Errorf("%d expiries queued after the reads, want 2", n)
+			}
+
+			want := 2
+			if sliding {
+				want = 1
+			}
+			if removed := s.Cleanup(); removed != want {
+				t.Errorf("Cleanup removed %d blobs, want %d", removed, want)
+			}
+		})
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	s.Put("live", []byte("live-blob"), time.Hour, 1)
+	s.Put("team/spaced", []byte("spaced-blob"), time.Hour, 1)
+	s.Put("short", []byte("short-blob"), 50*time.Millisecond, 1)
+	s.Put("taken", []byte("taken-blob"), time.Hour, 1)
+	if s.GetAndDelete("taken") == nil {
+		t.Fatal("taken blob should be there")
+	}
+	s.Put("shared", []byte("shared-blob"), time.Hour, 2)
+	if s.GetAndDelete("shared") == nil {
+		t.Fatal("shared blob should be there")
+	}
+	live, _ := s.Extend("live", 2*time.Hour)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// A new store over the same directory sees what a restart would
+	restarted, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	if n := restarted.Count(); n != 3 {
+		t.Errorf("restarted store has %d blobs, want 3", n)
+	}
+	if shared, _ := restarted.Stat("shared"); shared.Downloads != 1 {
+		t.Errorf("shared blob has %d downloads left, want 1", shared.Downloads)
+	}
+	if restarted.GetAndDelete("shared") == nil {
+		t.Error("shared blob should have a download left")
+	}
+	got, ok := restarted.Stat("live")
+	if !ok || string(got.Data) != "live-blob" || !got.ExpiresAt().Equal(live.ExpiresAt()) || got.ETag() != live.ETag() {
+		t.Errorf("live blob = %+v, %v; want it with its extended expiry and ETag", got, ok)
+	}
+	if data := restarted.GetAndDelete("team/spaced"); string(data) != "spaced-blob" {
+		t.Errorf("spaced blob = %q", data)
+	}
+	for _, id := range []string{"short", "taken"} {
+		if _, ok := restarted.Stat(id); ok {
+			t.Errorf("%s should not survive a restart", id)
+		}
+	}
+
+	// Only the live blob's file is left
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 || filepath.Base(files[0]) != "live.blob" {
+		t.Errorf("files left = %v, want only live.blob", files)
+	}
+
+	restarted.Purge()
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("Purge left %v", files)
+	}
+}
+
+func TestFileStoreExtendAndCleanup(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	s.Put("extended", []byte("a"), 50*time.Millisecond, 1)
+	s.Put("expiring", []byte("b"), 50*time.Millisecond, 1)
+	s.Extend("extended", time.Hour)
+
+	time.Sleep(100 * time.Millisecond)
+	if n := s.Cleanup(); n != 1 {
+		t.Errorf("Cleanup removed %d blobs, want 1", n)
+	}
+
+	restarted, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	if _, ok := restarted.Stat("extended"); !ok {
+		t.Error("the extended expiry should be persisted")
+	}
+	if _, ok := restarted.Stat("expiring"); ok {
+		t.Error("a cleaned up blob should not come back")
+	}
+}
+
+func TestStorePutPart(t *testing.T) {
+	s := NewStore()
+	parts := []string{"one-", "two-", "three"}
+	// Out of order, with the middle part sent twice
+	for i, part := range []int{2, 1, 1} {
+		complete, err := s.PutPart("abc", part, 3, []byte(parts[part]), time.Hour, 1, 100)
+		if err != nil || complete {
+			t.Fatalf("PutPart %d = %v, %v; want incomplete", i, complete, err)
+		}
+	}
+	if s.Count() != 0 || s.Uploads() != 1 {
+		t.Fatalf("before the last part: %d blobs, %d uploads; want 0 and 1", s.Count(), s.Uploads())
+	}
+	if s.Put("abc", []byte("other"), time.Hour, 1) {
+		t.Error("Put should refuse the code ID of an unfinished upload")
+	}
+	complete, err := s.PutPart("abc", 0, 3, []byte(parts[0]), time.Hour, 1, 100)
+	if err != nil || !complete {
+		t.Fatalf("last PutPart = %v, %v; want complete", complete, err)
+	}
+	if got := s.GetAndDelete("abc"); string(got) != "one-two-three" {
+		t.Errorf("joined blob = %q", got)
+	}
+	if s.Uploads() != 0 {
+		t.Errorf("%d uploads left after the last part", s.Uploads())
+	}
+
+	s.Put("taken", []byte("data"), time.Hour, 1)
+	if _, err := s.PutPart("taken", 0, 2, []byte("x"), time.Hour, 1, 100); err != ErrCodeIDExists {
+		t.Errorf("PutPart on a stored code ID: err = %v, want ErrCodeIDExists", err)
+	}
+	s.PutPart("mixed", 0, 2, []byte("x"), time.Hour, 1, 100)
+	if _, err := s.PutPart("mixed", 1, 3, []byte("y"), time.Hour, 1, 100); err != ErrPartMismatch {
+		t.Errorf("PutPart with another part count: err = %v, want ErrPartMismatch", err)
+	}
+	if _, err := s.PutPart("range", 2, 2, []byte("x"), time.Hour, 1, 100); err != ErrPartMismatch {
+		t.Errorf("PutPart past the last part: err = %v, want ErrPartMismatch", err)
+	}
+}
+
+func TestStorePutPartTooLarge(t *testing.T) {
+	s := NewStore()
+	if _, err := s.PutPart("big", 0, 3, []byte("12345"), time.Hour, 1, 8); err != nil {
+		t.Fatalf("first part: %v", err)
+	}
+	if _, err := s.PutPart("big", 1, 3, []byte("6789"), time.Hour, 1, 8); err != ErrUploadTooLarge {
+		t.Fatalf("part over the limit: err = %v, want ErrUploadTooLarge", err)
+	}
+	if s.Uploads() != 0 || s.UploadBytes() != 0 {
+		t.Error("an upload over the limit should be dropped")
+	}
+}
+
+func TestStoreUploadLimit(t *testing.T) {
+	s := NewStore()
+	s.SetUploadLimit(10)
+	put := func(codeID string, part int, data string) error {
+		_, err := s.PutPart(codeID, part, 2, []byte(data), time.Hour, 1, 100)
+		return err
+	}
+	if err := put("a", 0, "123456"); err != nil {
+		t.Fatalf("first upload: %v", err)
+	}
+	if err := put("b", 0, "12345"); err != ErrUploadsFull {
+		t.Fatalf("upload over the total limit: err = %v, want ErrUploadsFull", err)
+	}
+	if s.Uploads() != 1 || s.UploadBytes() != 6 {
+		t.Errorf("after a refused part: %d uploads of %d bytes, want 1 of 6", s.Uploads(), s.UploadBytes())
+	}
+	// Resending a part counts only the difference
+	if err := put("a", 0, "1234567890"); err != nil {
+		t.Fatalf("resent part: %v", err)
+	}
+	if s.UploadBytes() != 10 {
+		t.Errorf("after a resent part: %d bytes held, want 10", s.UploadBytes())
+	}
+
+	// A finished upload no longer counts, making room for others
+	s.SetUploadLimit(20)
+	if err := put("a", 1, "x"); err != nil {
+		t.Fatalf("last part: %v", err)
+	}
+	if s.UploadBytes() != 0 {
+		t.Errorf("after the upload finished: %d bytes held, want 0", s.UploadBytes())
+	}
+	if err := put("b", 0, "12345"); err != nil {
+		t.Errorf("upload after room was made: %v", err)
+	}
+	s.Purge()
+	if s.UploadBytes() != 0 {
+		t.Errorf("after Purge: %d bytes held, want 0", s.UploadBytes())
+	}
+}
+
+func TestStoreCleanupUploads(t *testing.T) {
+	s := NewStore()
+	s.PutPart("stale", 0, 2, []byte("x"), time.Millisecond, 1, 100)
+	s.PutPart("fresh", 0, 2, []byte("x"), time.Hour, 1, 100)
+	time.Sleep(10 * time.Millisecond)
+	s.Cleanup()
+	if s.Uploads() != 1 || s.UploadBytes() != 1 {
+		t.Errorf("%d uploads of %d bytes after cleanup, want only the fresh one", s.Uploads(), s.UploadBytes())
+	}
+	if complete, err := s.PutPart("fresh", 1, 2, []byte("y"), time.Hour, 1, 100); err != nil || !complete {
+		t.Errorf("finishing the fresh upload = %v, %v", complete, err)
+	}
+}
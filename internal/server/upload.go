@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"time"
+)
+
+// maxUploadParts caps how many parts one upload may be split into.
+const maxUploadParts = 1000
+
+// Errors returned by PutPart.
+var (
+	ErrCodeIDExists   = errors.New("code ID already exists")
+	ErrPartMismatch   = errors.New("part doesn't match the rest of the upload")
+	ErrUploadTooLarge = errors.New("upload is over the size limit")
+	ErrUploadsFull    = errors.New("unfinished uploads are over the relay's limit")
+)
+
+// upload collects the parts of a blob uploaded in parts until the last one
+// arrives.
+type upload struct {
+	parts     [][]byte // by index; nil until that part arrives
+	received  int
+	size      int64
+	ttl       time.Duration
+	downloads int
+	expires   time.Time // when an unfinished upload is dropped
+}
+
+// PutPart stores part number part (from 0) of a blob sent in parts parts.
+// Parts may arrive in any order, and a part sent again replaces the earlier
+// copy, so a client can safely retry one. When the last part arrives the
+// parts are joined and stored as one blob, as Put would store it, and
+// complete is true.
+//
+// The parts held for one upload may total at most maxSize bytes; an upload
+// that goes over is dropped. So is one not finished within ttl of its first
+// part. Unfinished uploads are kept in memory only, so the parts held for
+// all of them are capped too, by SetUploadLimit; a part that would go over
+// is refused with ErrUploadsFull, and the upload can go on once others
+// finish or expire.
+func (s *Store) PutPart(codeID string, part, parts int, data []byte, ttl time.Duration, maxDownloads int, maxSize int64) (complete bool, err error) {
+	if parts < 1 || parts > maxUploadParts || part < 0 || part >= parts || len(data) == 0 {
+		return false, ErrPartMismatch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.blobs[codeID]; exists {
+		return false, ErrCodeIDExists
+	}
+	up, ok := s.uploads[codeID]
+	if ok && len(up.parts) != parts {
+		return false, ErrPartMismatch
+	}
+	var held int64
+	if ok {
+		held = int64(len(up.parts[part]))
+	}
+	if s.uploadLimit > 0 && s.uploadBytes-held+int64(len(data)) > s.uploadLimit {
+		return false, ErrUploadsFull
+	}
+	if !ok {
+		up = &upload{
+			parts:     make([][]byte, parts),
+			ttl:       ttl,
+			downloads: maxDownloads,
+			expires:   time.Now().Add(ttl),
+		}
+		s.uploads[codeID] = up
+		heap.Push(&s.uploadExpiries, expiryItem{key: codeID, at: up.expires})
+	}
+
+	size := up.size - held + int64(len(data))
+	if size > maxSize {
+		s.dropUpload(codeID, up)
+		return false, ErrUploadTooLarge
+	}
+	if up.parts[part] == nil {
+		up.received++
+	}
+	up.parts[part] = data
+	s.uploadBytes += size - up.size
+	up.size = size
+	if up.received < parts {
+		return false, nil
+	}
+
+	s.dropUpload(codeID, up)
+	s.put(codeID, bytes.Join(up.parts, nil), up.ttl, up.downloads)
+	return true, nil
+}
+
+// SetUploadLimit caps the total size of the parts held for unfinished
+// uploads at limit bytes. 0, the default, means no limit.
+func (s *Store) SetUploadLimit(limit int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploadLimit = limit
+}
+
+// dropUpload forgets an unfinished upload. Callers must hold the lock.
+func (s *Store) dropUpload(codeID string, up *upload) {
+	delete(s.uploads, codeID)
+	s.uploadBytes -= up.size
+}
+
+// UploadBytes returns the total size of the parts held for unfinished
+// uploads.
+func (s *Store) UploadBytes() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.uploadBytes
+}
+
+// Uploads returns the number of uploads waiting for more parts.
+func (s *Store) Uploads() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.uploads)
+}
+
+// cleanupUploads drops the unfinished uploads that are due. Callers must
+// hold the lock.
+func (s *Store) cleanupUploads(now time.Time) {
+	for s.uploadExpiries.due(now) {
+		item := heap.Pop(&s.uploadExpiries).(expiryItem)
+		if up, ok := s.uploads[item.key]; ok && up.expires.Equal(item.at) {
+			s.dropUpload(item.key, up)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+)
+
+// A transfer's progress is only shown once it has run for progressDelay, so
+// quick ones print nothing, and then redrawn at most every progressInterval.
+const (
+	progressDelay    = 500 * time.Millisecond
+	progressInterval = 200 * time.Millisecond
+)
+
+// ProgressMeter draws a transfer's progress on one terminal line.
+type ProgressMeter struct {
+	w   io.Writer
+	now func() time.Time
+
+	mu    sync.Mutex
+	start time.Time // when the current transfer started
+	drawn time.Time // when the line was last drawn; zero if it wasn't
+}
+
+func NewProgressMeter(w io.Writer) *ProgressMeter {
+	return &ProgressMeter{w: w, now: time.Now}
+}
+
+// Update is a client.Options.Progress callback.
+func (m *ProgressMeter) Update(p client.Progress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if p.Done == 0 && !p.Finished {
+		m.start, m.drawn = now, time.Time{}
+		return
+	}
+	if p.Finished {
+		if !m.drawn.IsZero() {
+			fmt.Fprintf(m.w, "\r%s\n", progressLine(p))
+			m.drawn = time.Time{}
+		}
+		return
+	}
+	if now.Sub(m.start) < progressDelay || now.Sub(m.drawn) < progressInterval {
+		return
+	}
+	fmt.Fprintf(m.w, "\r%s", progressLine(p))
+	m.drawn = now
+}
+
+func progressLine(p client.Progress) string {
+	verb := "Downloaded"
+	if p.Sending {
+		verb = "Uploaded"
+	}
+	if p.Total <= 0 {
+		return fmt.Sprintf("   %s %s", verb, FormatByteSize(p.Done))
+	}
+	return fmt.Sprintf("   %s %s of %s (%d%%)", verb, FormatByteSize(p.Done), FormatByteSize(p.Total), p.Done*100/p.Total)
+}
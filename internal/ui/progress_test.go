@@ -0,0 +1,50 @@
+package ui
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/flawiddsouza/git-share/internal/client"
+)
+
+func TestProgressMeter(t *testing.T) {
+	clock := time.Date(2026, 2, 27, 17, 0, 0, 0, time.UTC)
+	var out bytes.Buffer
+	m := NewProgressMeter(&out)
+	m.now = func() time.Time { return clock }
+
+	step := func(after time.Duration, p client.Progress) {
+		clock = clock.Add(after)
+		m.Update(p)
+	}
+
+	// A quick transfer prints nothing
+	step(0, client.Progress{Sending: true, Total: 2048})
+	step(100*time.Millisecond, client.Progress{Sending: true, Done: 2048, Total: 2048})
+	step(0, client.Progress{Sending: true, Done: 2048, Total: 2048, Finished: true})
+	if out.Len() != 0 {
+		t.Fatalf("quick transfer printed %q", out.String())
+	}
+
+	// A slow one is redrawn at most every progressInterval, then ended
+	step(0, client.Progress{Total: 4 << 20})
+	step(progressDelay, client.Progress{Done: 1 << 20, Total: 4 << 20})
+	step(progressInterval/2, client.Progress{Done: 2 << 20, Total: 4 << 20})
+	step(progressInterval, client.Progress{Done: 3 << 20, Total: 4 << 20})
+	step(0, client.Progress{Done: 4 << 20, Total: 4 << 20, Finished: true})
+	want := "\r   Downloaded 1.0MB of 4.0MB (25%)" +
+		"\r   Downloaded 3.0MB of 4.0MB (75%)" +
+		"\r   Downloaded 4.0MB of 4.0MB (100%)\n"
+	if got := out.String(); got != want {
+		t.Errorf("printed %q, want %q", got, want)
+	}
+
+	// Without a Content-Length only the bytes so far are known
+	out.Reset()
+	step(0, client.Progress{Total: -1})
+	step(time.Second, client.Progress{Done: 512, Total: -1})
+	if got, want := out.String(), "\r   Downloaded 512B"; got != want {
+		t.Errorf("printed %q, want %q", got, want)
+	}
+}
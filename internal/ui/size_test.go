@@ -1,4 +1,4 @@
-package cmd
+package ui
 
 import (
 	"testing"
@@ -55,19 +55,39 @@ func TestParseByteSize(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseByteSize(tt.input)
+			got, err := ParseByteSize(tt.input)
 			if tt.wantErr {
 				if err == nil {
-					t.Errorf("parseByteSize(%q) expected error, got %d", tt.input, got)
+					t.Errorf("ParseByteSize(%q) expected error, got %d", tt.input, got)
 				}
 				return
 			}
 			if err != nil {
-				t.Fatalf("parseByteSize(%q) unexpected error: %v", tt.input, err)
+				t.Fatalf("ParseByteSize(%q) unexpected error: %v", tt.input, err)
 			}
 			if got != tt.want {
-				t.Errorf("parseByteSize(%q) = %d, want %d", tt.input, got, tt.want)
+				t.Errorf("ParseByteSize(%q) = %d, want %d", tt.input, got, tt.want)
 			}
 		})
 	}
 }
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		input int64
+		want  string
+	}{
+		{0, "0B"},
+		{512, "512B"},
+		{1024, "1.0KB"},
+		{1536, "1.5KB"},
+		{10 * 1024 * 1024, "10.0MB"},
+		{3 * 1024 * 1024 * 1024, "3.0GB"},
+		{2048 * 1024 * 1024 * 1024, "2048.0GB"},
+	}
+	for _, tt := range tests {
+		if got := FormatByteSize(tt.input); got != tt.want {
+			t.Errorf("FormatByteSize(%d) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package ui holds the terminal helpers the commands share: prompts,
+// transfer progress, and byte sizes.
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// IsTerminal reports whether f is attached to an interactive terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// UseColor reports whether ANSI colors should be written to f.
+func UseColor(f *os.File, noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return IsTerminal(f)
+}
+
+// Confirm asks a yes/no question on out and reads the answer from in.
+// Anything other than "y" or "yes" counts as no.
+func Confirm(in io.Reader, out io.Writer, question string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, fmt.Errorf("reading answer: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// ReadTTYPassphrase asks for a passphrase on the terminal, reading it without
+// echoing it. It uses the terminal directly, so it works when stdin is piped.
+func ReadTTYPassphrase(prompt string) (string, error) {
+	in, out, err := openTTY()
+	if err != nil {
+		return "", fmt.Errorf("asking for the passphrase needs a terminal: %w", err)
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+
+	fmt.Fprint(out, prompt)
+	if err := setEcho(in, false); err != nil {
+		return "", fmt.Errorf("hiding the passphrase: %w", err)
+	}
+	line, err := bufio.NewReader(in).ReadString('\n')
+	setEcho(in, true)
+	fmt.Fprintln(out)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("reading passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// ReadPassphrase reads a passphrase from the first line of in, without the
+// trailing newline.
+func ReadPassphrase(in io.Reader) (string, error) {
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("reading passphrase: %w", err)
+	}
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("no passphrase on stdin")
+	}
+	return passphrase, nil
+}
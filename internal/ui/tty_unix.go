@@ -0,0 +1,29 @@
+//go:build !windows
+
+package ui
+
+import (
+	"os"
+	"os/exec"
+)
+
+// openTTY opens the controlling terminal for prompts that must not come from
+// a piped stdin.
+func openTTY() (in, out *os.File, err error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tty, tty, nil
+}
+
+// setEcho turns the terminal's echo of typed characters on or off.
+func setEcho(tty *os.File, on bool) error {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	c := exec.Command("stty", mode)
+	c.Stdin = tty
+	return c.Run()
+}
@@ -0,0 +1,37 @@
+package ui
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// openTTY opens the console for prompts that must not come from a piped
+// stdin.
+func openTTY() (in, out *os.File, err error) {
+	in, err = os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err = os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
+
+// setEcho turns the console's echo of typed characters on or off.
+func setEcho(tty *os.File, on bool) error {
+	h := windows.Handle(tty.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return err
+	}
+	if on {
+		mode |= windows.ENABLE_ECHO_INPUT
+	} else {
+		mode &^= windows.ENABLE_ECHO_INPUT
+	}
+	return windows.SetConsoleMode(h, mode)
+}
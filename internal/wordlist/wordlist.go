@@ -1,59 +1,372 @@
-package wordlist
-
-import (
-	"crypto/rand"
-	"math/big"
-	"strings"
-)
-
-// Words is a curated subset of the EFF short diceware wordlist (256 words).
-// 4 words from 256 = 256^4 = ~4 billion combinations (~32 bits),
-// combined with a random codeId this provides strong security.
-var Words = []string{
-	"acid", "acme", "aged", "also", "arch", "aqua", "area", "atom",
-	"aunt", "avid", "axis", "back", "bald", "band", "bark", "barn",
-	"base", "bath", "bean", "bear", "beat", "belt", "bend", "bike",
-	"bird", "bite", "blow", "blue", "blur", "boat", "bold", "bolt",
-	"bomb", "bond", "bone", "book", "boot", "bore", "boss", "bowl",
-	"bulk", "bump", "burn", "buzz", "cafe", "cage", "cake", "calm",
-	"came", "camp", "cape", "card", "care", "cart", "case", "cash",
-	"cast", "cave", "chat", "chip", "chop", "city", "clad", "clam",
-	"clan", "claw", "clay", "clip", "club", "clue", "coal", "coat",
-	"code", "coil", "coin", "cold", "colt", "cone", "cook", "cool",
-	"cope", "copy", "cord", "core", "corn", "cost", "cozy", "crew",
-	"crop", "crow", "cube", "curl", "cute", "damp", "dare", "dark",
-	"dart", "dash", "dawn", "deal", "dear", "deck", "deed", "deep",
-	"deer", "demo", "dent", "desk", "dial", "dice", "dime", "dock",
-	"dome", "door", "dose", "dove", "down", "draw", "drip", "drop",
-	"drum", "dull", "dune", "dusk", "dust", "each", "earl", "earn",
-	"ease", "east", "echo", "edge", "edit", "else", "epic", "even",
-	"ever", "evil", "exam", "exit", "face", "fact", "fade", "fail",
-	"fair", "fall", "fame", "fang", "farm", "fast", "fate", "fawn",
-	"fear", "feat", "feed", "feel", "file", "fill", "film", "find",
-	"fine", "fire", "firm", "fish", "fist", "five", "flag", "flat",
-	"fled", "flex", "flip", "flow", "foam", "fold", "folk", "fond",
-	"font", "food", "foot", "ford", "fork", "form", "fort", "foul",
-	"four", "free", "frog", "from", "fuel", "full", "fund", "fury",
-	"fuse", "gain", "gait", "gale", "game", "gang", "gate", "gave",
-	"gaze", "gear", "gene", "gift", "glad", "glow", "glue", "goat",
-	"gold", "golf", "gone", "good", "grab", "gray", "grew", "grid",
-	"grim", "grin", "grip", "grit", "grow", "gulf", "guru", "gust",
-	"half", "hall", "halt", "hand", "hang", "hard", "harm", "harp",
-	"hash", "haste", "hate", "haul", "hawk", "haze", "head", "heal",
-	"heap", "heat", "held", "helm", "help", "herb", "herd", "hero",
-	"hide", "high", "hike", "hill", "hint", "hire", "hold", "hole",
-}
-
-// Pick returns n random words from the wordlist, joined by the given separator.
-func Pick(n int, sep string) (string, error) {
-	words := make([]string, n)
-	max := big.NewInt(int64(len(Words)))
-	for i := 0; i < n; i++ {
-		idx, err := rand.Int(rand.Reader, max)
-		if err != nil {
-			return "", err
-		}
-		words[i] = Words[idx.Int64()]
-	}
-	return strings.Join(words, sep), nil
-}
+package wordlist
+
+import (
+	"bufio"
+	"crypto/rand"
+	"embed"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultLang is the language of the built-in Words list.
+const DefaultLang = "en"
+
+// MinWords is the smallest list passphrases may be picked from: the
+// built-in lists of the other languages have at least this many words, and
+// SetWordlist refuses shorter ones.
+const MinWords = 256
+
+// lists holds the other built-in languages, one lists/<lang>.txt file each.
+//
+//go:embed lists/*.txt
+var lists embed.FS
+
+// Words is a curated list of 1024 short words from the EFF diceware
+// wordlists. 4 words from 1024 = 1024^4 = ~1 trillion combinations
+// (~40 bits), combined with a random codeId this provides strong security.
+//
+// The size is kept a power of two so each word is exactly 10 bits; the tests
+// check that, and that no word appears twice, which would quietly weaken it.
+var Words = []string{
+	"abide", "able", "acid", "acme", "acre", "afar", "affix", "aged",
+	"agent", "aging", "agony", "ahoy", "aids", "ajar", "album", "alibi",
+	"alike", "aloe", "aloha", "alone", "also", "alto", "amber", "amigo",
+	"amino", "among", "amply", "amuck", "anger", "ankle", "annex", "anvil",
+	"apple", "apply", "apron", "aqua", "arch", "area", "arena", "argue",
+	"armed", "aroma", "array", "arson", "ashes", "aside", "askew", "atom",
+	"atop", "audio", "aunt", "avert", "avid", "await", "aware", "awoke",
+	"axis", "back", "bacon", "badly", "bagel", "baked", "bald", "band",
+	"banjo", "barge", "bark", "barn", "base", "basil", "basis", "bath",
+	"baton", "bats", "bean", "bear", "beat", "belt", "bend", "bike",
+	"bird", "bite", "blah", "blank", "blast", "bleep", "bless", "blimp",
+	"blip", "blob", "blog", "blow", "blue", "bluff", "blur", "blurb",
+	"blurt", "boat", "body", "boil", "bold", "bolt", "bomb", "bond",
+	"bone", "boney", "bonus", "book", "boot", "booth", "boozy", "borax",
+	"bore", "boss", "both", "bowl", "boxy", "briar", "brick", "brim",
+	"bring", "brook", "brunt", "brush", "buddy", "bulb", "bulk", "bully",
+	"bump", "bunch", "bunt", "burn", "bust", "buzz", "cable", "cacti",
+	"cadet", "cafe", "cage", "cake", "calm", "came", "cameo", "camp",
+	"candy", "canon", "cape", "carat", "card", "care", "carol", "cart",
+	"carve", "case", "cash", "cast", "catty", "cave", "cedar", "chain",
+	"chair", "chaos", "charm", "chat", "cheek", "cheer", "chemo", "chest",
+	"chevy", "chief", "chill", "chimp", "chip", "chomp", "chop", "chug",
+	"chump", "churn", "cider", "cinch", "city", "civil", "clad", "clam",
+	"clamp", "clan", "clash", "clasp", "claw", "clay", "clean", "cleat",
+	"cleft", "cling", "clip", "clock", "clone", "club", "clue", "clump",
+	"coal", "coat", "cocoa", "code", "coil", "coin", "coke", "cold",
+	"colt", "coma", "comfy", "comic", "conch", "cone", "cook", "cool",
+	"cope", "copy", "cord", "core", "cork", "corn", "cost", "couch",
+	"cough", "cover", "cozy", "crane", "crank", "crave", "creed", "creme",
+	"crept", "crew", "cried", "crier", "croak", "crook", "croon", "crop",
+	"crow", "crowd", "crumb", "crust", "cube", "cupid", "curl", "curly",
+	"curse", "curve", "cushy", "cute", "cycle", "daily", "daisy", "damp",
+	"dandy", "dare", "dares", "dark", "dart", "dash", "data", "dawn",
+	"deal", "dean", "dear", "debit", "decaf", "decay", "deck", "decoy",
+	"deed", "deep", "deer", "defog", "deity", "delta", "demo", "denim",
+	"dent", "deny", "derby", "desk", "deuce", "dial", "dice", "dill",
+	"dime", "diner", "dingo", "dish", "ditch", "ditto", "dizzy", "dock",
+	"dodgy", "doily", "dole", "dome", "donor", "door", "doozy", "dork",
+	"dose", "dove", "down", "doze", "drank", "draw", "dress", "dried",
+	"drift", "drip", "drone", "droop", "drop", "drown", "drum", "ducky",
+	"dude", "dull", "duly", "dune", "dupe", "dusk", "dust", "duvet",
+	"dweeb", "each", "eagle", "earl", "earn", "ease", "easel", "east",
+	"eats", "ebony", "ebook", "echo", "edge", "edgy", "edit", "elbow",
+	"elite", "else", "elude", "email", "ember", "emit", "empty", "ended",
+	"envy", "epic", "error", "erupt", "ether", "even", "ever", "evict",
+	"evil", "exact", "exam", "exert", "exile", "exit", "fable", "face",
+	"fact", "fade", "fail", "fair", "fall", "false", "fame", "fang",
+	"farm", "fast", "fate", "fawn", "fear", "feast", "feat", "feed",
+	"feel", "fence", "ferry", "fetch", "fiber", "fifth", "file", "fill",
+	"film", "filth", "find", "fine", "finer", "fire", "firm", "fish",
+	"fist", "five", "flag", "flail", "flame", "flask", "flat", "fled",
+	"flex", "flier", "fling", "flip", "flirt", "flock", "flop", "flow",
+	"flyer", "foam", "fold", "folic", "folk", "fond", "font", "food",
+	"fool", "foot", "ford", "fork", "form", "fort", "foul", "four",
+	"frail", "frays", "free", "fresh", "frill", "frog", "from", "front",
+	"froth", "fruit", "fuel", "full", "fund", "fury", "fuse", "gain",
+	"gains", "gait", "gala", "gale", "game", "gang", "gate", "gauze",
+	"gave", "gaze", "gear", "gecko", "gene", "genre", "gents", "giant",
+	"gift", "gills", "given", "gizmo", "glad", "glare", "glass", "gloss",
+	"glow", "glue", "gnat", "goal", "goat", "going", "gold", "golf",
+	"gone", "gong", "good", "gooey", "goon", "gory", "gown", "grab",
+	"grain", "grape", "grasp", "grass", "gray", "green", "grew", "grid",
+	"grill", "grim", "grime", "grin", "grip", "grit", "groin", "grope",
+	"grout", "grow", "growl", "grunt", "guide", "gulf", "gully", "gummy",
+	"guru", "gush", "gust", "gusty", "haiku", "half", "hall", "halt",
+	"hand", "hang", "happy", "hard", "hardy", "harm", "harp", "hash",
+	"haste", "hasty", "hate", "haul", "haven", "hawk", "haze", "hazy",
+	"head", "heal", "heap", "heat", "hedge", "held", "helm", "help",
+	"hence", "henna", "herb", "herd", "hero", "hertz", "hide", "high",
+	"hike", "hill", "hint", "hire", "hold", "hole", "hula", "hulk",
+	"human", "hunk", "hurry", "hush", "icky", "idiom", "idly", "image",
+	"ipad", "ipod", "iron", "item", "ivory", "java", "jaws", "jazz",
+	"jelly", "jimmy", "jinx", "jolly", "judge", "juice", "juicy", "jumbo",
+	"june", "juror", "kabob", "kebab", "keep", "kept", "kiln", "kilt",
+	"kite", "kiwi", "knee", "knoll", "kooky", "kudos", "ladle", "lake",
+	"lanky", "lapel", "large", "lash", "lasso", "latch", "lazy", "left",
+	"lego", "lend", "lens", "level", "lilac", "lilly", "limb", "limit",
+	"lingo", "lint", "lisp", "lived", "liver", "lunar", "lurch", "lure",
+	"lusty", "macaw", "mace", "maker", "mama", "mango", "manly", "many",
+	"mardi", "marry", "mauve", "mocha", "molar", "moody", "mossy", "most",
+	"motto", "mousy", "mouth", "movie", "much", "muck", "mule", "mummy",
+	"mumps", "murky", "music", "musty", "mute", "myth", "nail", "name",
+	"nape", "navy", "neon", "nervy", "never", "next", "nifty", "ninth",
+	"nutty", "oasis", "ocean", "oink", "okay", "omega", "omit", "onion",
+	"onset", "onyx", "oops", "oozy", "open", "opium", "otter", "ought",
+	"ounce", "oval", "oven", "paced", "pagan", "palm", "pang", "panic",
+	"paper", "party", "pasta", "path", "paver", "payee", "pecan", "penny",
+	"perch", "perm", "peso", "petal", "petty", "photo", "plant", "plaza",
+	"plod", "plot", "plow", "pluck", "plus", "poach", "poet", "poise",
+	"poker", "polio", "polo", "pond", "pope", "pork", "posh", "pouch",
+	"pout", "press", "pried", "print", "prism", "prize", "prone", "props",
+	"proud", "prude", "pull", "pulp", "puma", "punk", "puppy", "purge",
+	"purse", "putt", "quack", "quake", "query", "quiet", "quilt", "quit",
+	"quote", "race", "radar", "radio", "rage", "rally", "ramp", "rants",
+	"rash", "reach", "ream", "rehab", "relay", "relic", "remix", "reps",
+	"rerun", "retry", "rhyme", "rice", "rift", "rigor", "rind", "rinse",
+	"rise", "ritzy", "rival", "robe", "rocky", "rogue", "romp", "rosy",
+	"rover", "ruby", "rumor", "runny", "rural", "rush", "rust", "saga",
+	"saggy", "said", "sake", "salon", "salt", "same", "sank", "sappy",
+	"sash", "satin", "sauna", "saved", "scale", "scant", "scarf", "scion",
+	"scone", "scoop", "scorn", "scuba", "sedan", "self", "sepia", "setup",
+	"shack", "shaft", "shale", "shame", "shape", "shawl", "shed", "sheet",
+	"shell", "shine", "ship", "shock", "shore", "shout", "shown", "shrug",
+	"shun", "shut", "silk", "silly", "silt", "sixth", "size", "skied",
+	"skies", "skirt", "slab", "slam", "slang", "slaw", "sleek", "sleet",
+	"slept", "slimy", "slit", "slot", "slum", "slush", "small", "smile",
+	"smite", "smith", "smog", "snack", "snap", "snarl", "sneer", "sniff",
+	"snore", "snout", "snub", "snuff", "speed", "spew", "spied", "spilt",
+	"spoof", "spool", "spore", "spray", "spree", "spry", "spur", "squid",
+	"stack", "stage", "stand", "stank", "stash", "stays", "steam", "steep",
+	"step", "stew", "stilt", "stock", "stoic", "stole", "stony", "stood",
+	"stoop", "stout", "straw", "stray", "strum", "stuck", "study", "stung",
+	"suave", "such", "sugar", "sulk", "sushi", "swan", "sway", "swear",
+	"sweep", "swept", "swirl", "swoop", "sworn", "sync", "syrup", "tacky",
+	"take", "tall", "tamer", "taps", "tarot", "taste", "taunt", "thank",
+	"thaw", "theft", "these", "thigh", "think", "thorn", "those", "thumb",
+	"tiara", "tibia", "tidy", "tile", "till", "timid", "tiny", "trace",
+	"trade", "traps", "trash", "treat", "trend", "tried", "trio", "truce",
+	"trump", "truth", "tulip", "turf", "tusk", "tutu", "tweed", "tweet",
+	"twice", "twine", "twins", "tying", "udder", "uncle", "uncut", "union",
+	"unlit", "untie", "unwed", "upon", "upper", "usage", "user", "usher",
+	"utter", "value", "veal", "venue", "verse", "vest", "veto", "vice",
+	"viper", "viral", "visa", "vista", "vixen", "void", "vowed", "vowel",
+	"waged", "wages", "wagon", "walk", "wand", "wasp", "water", "wham",
+	"wheat", "whiff", "whole", "widen", "widow", "wife", "wilt", "wimp",
+	"wind", "wipe", "wired", "wise", "wispy", "wolf", "woof", "woozy",
+	"work", "worry", "wound", "wrath", "wrist", "xerox", "yard", "yarn",
+	"yeast", "yield", "yodel", "yoyo", "zebra", "zero", "zippy", "zone",
+}
+
+// english is the built-in English list, which SetWordlist leaves alone.
+var english = Words
+
+// builtIn parses the built-in lists once, by language.
+var builtIn = sync.OnceValues(func() (map[string][]string, error) {
+	byLang := map[string][]string{DefaultLang: english}
+	entries, _ := lists.ReadDir("lists")
+	for _, e := range entries {
+		f, err := lists.Open("lists/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		words, err := parse(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		byLang[strings.TrimSuffix(e.Name(), ".txt")] = words
+	}
+	return byLang, nil
+})
+
+// builtInWords is the set of words in the built-in lists, of every
+// language, for Contains and Near.
+var builtInWords = sync.OnceValue(func() map[string]bool {
+	byLang, _ := builtIn()
+	set := make(map[string]bool)
+	for _, words := range byLang {
+		for _, w := range words {
+			set[w] = true
+		}
+	}
+	return set
+})
+
+// SetWordlist replaces Words, the list passphrases are picked from by
+// default, e.g. with one in a team's own language loaded by Load. It needs
+// at least MinWords distinct words, so passphrases are no easier to guess
+// than with the smallest built-in list, and words without dashes or spaces,
+// which separate the words of a code.
+//
+// Codes don't record which list they came from, and needn't: a passphrase
+// decrypts the same whichever list its words came from.
+func SetWordlist(words []string) error {
+	seen := make(map[string]bool, len(words))
+	for _, w := range words {
+		if w == "" || strings.ContainsAny(w, "- \t\r\n") {
+			return fmt.Errorf("wordlist word %q is empty or has a dash or space", w)
+		}
+		if seen[w] {
+			return fmt.Errorf("wordlist has %q more than once", w)
+		}
+		seen[w] = true
+	}
+	if len(words) < MinWords {
+		return fmt.Errorf("wordlist has %d words, but passphrases need at least %d to be hard enough to guess", len(words), MinWords)
+	}
+	Words = slices.Clone(words)
+	return nil
+}
+
+// Len returns the number of words in the list passphrases are picked from
+// by default: the built-in English list, or the one given to SetWordlist.
+func Len() int {
+	return len(Words)
+}
+
+// Pick returns n random words from the wordlist, joined by the given separator.
+func Pick(n int, sep string) (string, error) {
+	return PickFrom(Words, n, sep)
+}
+
+// PickFrom returns n random words from list, joined by the given separator.
+func PickFrom(list []string, n int, sep string) (string, error) {
+	words := make([]string, n)
+	max := big.NewInt(int64(len(list)))
+	for i := 0; i < n; i++ {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		words[i] = list[idx.Int64()]
+	}
+	return strings.Join(words, sep), nil
+}
+
+// Languages returns the codes of the built-in wordlists, sorted.
+func Languages() []string {
+	langs := []string{DefaultLang}
+	entries, _ := lists.ReadDir("lists")
+	for _, e := range entries {
+		langs = append(langs, strings.TrimSuffix(e.Name(), ".txt"))
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// ForLang returns the built-in wordlist for a language code such as "de".
+// Only generation depends on the language: a passphrase decrypts the same
+// whichever list its words came from.
+func ForLang(lang string) ([]string, error) {
+	if lang == "" || lang == DefaultLang {
+		return Words, nil
+	}
+	byLang, err := builtIn()
+	if err != nil {
+		return nil, err
+	}
+	words, ok := byLang[lang]
+	if !ok {
+		return nil, fmt.Errorf("no built-in wordlist for %q (available: %s)", lang, strings.Join(Languages(), ", "))
+	}
+	return words, nil
+}
+
+// Load reads a wordlist with one word per line. Diceware-style lines such as
+// "11111 abacus" are accepted, using the last field as the word. Blank lines,
+// "#" comments, and duplicate words are skipped.
+func Load(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening wordlist: %w", err)
+	}
+	defer f.Close()
+
+	words, err := parse(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) < 2 {
+		return nil, fmt.Errorf("wordlist %s needs at least 2 distinct words, found %d", path, len(words))
+	}
+	return words, nil
+}
+
+// parse reads the words of a wordlist in the format Load accepts.
+func parse(r io.Reader) ([]string, error) {
+	var words []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		word := fields[len(fields)-1]
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading wordlist: %w", err)
+	}
+	return words, nil
+}
+
+// Contains reports whether word is in one of the built-in lists.
+func Contains(word string) bool {
+	return builtInWords()[word]
+}
+
+// Near returns the words in the built-in lists, of every language, that are
+// one typo away from word: one letter added, removed, or changed. Word
+// itself is never included. The result is sorted.
+func Near(word string) []string {
+	var near []string
+	for w := range builtInWords() {
+		if w != word && oneEditApart(w, word) {
+			near = append(near, w)
+		}
+	}
+	sort.Strings(near)
+	return near
+}
+
+// oneEditApart reports whether a and b differ by at most one inserted,
+// deleted, or substituted letter, i.e. their Levenshtein distance is <= 1.
+func oneEditApart(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(ra)-len(rb) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(rb) && ra[i] == rb[i] {
+		i++
+	}
+	if len(ra) == len(rb) {
+		// Past the first difference, the rest must match
+		return i == len(ra) || string(ra[i+1:]) == string(rb[i+1:])
+	}
+	return string(ra[i+1:]) == string(rb[i:])
+}
+
+// Entropy returns the bits of entropy in n words picked uniformly from a
+// list of size words, i.e. log2(size^n).
+func Entropy(size, n int) float64 {
+	if size < 1 || n < 1 {
+		return 0
+	}
+	return float64(n) * math.Log2(float64(size))
+}
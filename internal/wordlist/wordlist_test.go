@@ -0,0 +1,218 @@
+package wordlist
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinLists(t *testing.T) {
+	for _, lang := range Languages() {
+		t.Run(lang, func(t *testing.T) {
+			words, err := ForLang(lang)
+			if err != nil {
+				t.Fatalf("ForLang(%q) error: %v", lang, err)
+			}
+			if len(words) < MinWords {
+				t.Errorf("%d words, want at least %d", len(words), MinWords)
+			}
+
+			seen := make(map[string]bool)
+			for _, word := range rawWords(t, lang) {
+				if seen[word] {
+					t.Errorf("duplicate word %q", word)
+				}
+				seen[word] = true
+				if strings.Trim(word, "abcdefghijklmnopqrstuvwxyz") != "" {
+					t.Errorf("word %q has characters other than a-z", word)
+				}
+			}
+
+			passphrase, err := PickFrom(words, 4, "-")
+			if err != nil {
+				t.Fatalf("PickFrom error: %v", err)
+			}
+			picked := strings.Split(passphrase, "-")
+			if len(picked) != 4 {
+				t.Fatalf("PickFrom returned %q, want 4 words", passphrase)
+			}
+			for _, word := range picked {
+				if !seen[word] {
+					t.Errorf("picked %q, which is not in the list", word)
+				}
+			}
+		})
+	}
+}
+
+func TestWordsSize(t *testing.T) {
+	n := Len()
+	if n != 1024 || n&(n-1) != 0 {
+		t.Errorf("Len() = %d, want 1024 (a power of two)", n)
+	}
+	if bits := Entropy(n, 1); bits != 10 {
+		t.Errorf("one word carries %v bits, want 10", bits)
+	}
+}
+
+func TestPickInRange(t *testing.T) {
+	index := make(map[string]int, Len())
+	for i, w := range Words {
+		index[w] = i
+	}
+	seen := make(map[int]bool)
+	for range 5000 {
+		passphrase, err := Pick(4, "-")
+		if err != nil {
+			t.Fatalf("Pick error: %v", err)
+		}
+		for _, w := range strings.Split(passphrase, "-") {
+			i, ok := index[w]
+			if !ok || i < 0 || i >= Len() {
+				t.Fatalf("Pick returned %q, which is not in Words", w)
+			}
+			seen[i] = true
+		}
+	}
+	// 20000 draws all but guarantee both ends of the list come up
+	if !seen[0] || !seen[Len()-1] {
+		t.Errorf("first or last word never picked in 20000 draws")
+	}
+}
+
+func TestNear(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"fsih", nil},                      // two letters swapped is two edits
+		{"bikr", []string{"bier", "bike"}}, // German "bier" too
+		{"bke", []string{"bike"}},
+		{"biike", []string{"bike"}},
+		{"zzzzzz", nil},
+	}
+	for _, tt := range tests {
+		got := Near(tt.word)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Near(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+
+	// A listed word's neighbors don't include itself
+	for _, w := range Near("bark") {
+		if w == "bark" || !oneEditApart(w, "bark") {
+			t.Errorf("Near(\"bark\") includes %q", w)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	for word, want := range map[string]bool{"bike": true, "bier": true, "bikr": false, "": false} {
+		if got := Contains(word); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", word, got, want)
+		}
+	}
+}
+
+func TestOneEditApart(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"bike", "bike", true},
+		{"bike", "bake", true},
+		{"bike", "bikes", true},
+		{"bike", "ike", true},
+		{"bike", "biek", false},
+		{"bike", "bi", false},
+		{"", "a", true},
+		{"über", "uber", true},
+	}
+	for _, tt := range tests {
+		if got := oneEditApart(tt.a, tt.b); got != tt.want {
+			t.Errorf("oneEditApart(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := oneEditApart(tt.b, tt.a); got != tt.want {
+			t.Errorf("oneEditApart(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestForLangUnknown(t *testing.T) {
+	if _, err := ForLang("xx"); err == nil || !strings.Contains(err.Error(), "available: ") {
+		t.Errorf("expected an error listing the languages, got %v", err)
+	}
+}
+
+// rawWords returns a list's words before parsing drops duplicates.
+func rawWords(t *testing.T, lang string) []string {
+	t.Helper()
+	if lang == DefaultLang {
+		return Words
+	}
+	f, err := lists.Open("lists/" + lang + ".txt")
+	if err != nil {
+		t.Fatalf("opening list: %v", err)
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words
+}
+
+func TestSetWordlist(t *testing.T) {
+	builtin := Words
+	t.Cleanup(func() { Words = builtin })
+
+	custom := make([]string, MinWords)
+	for i := range custom {
+		custom[i] = fmt.Sprintf("wort%d", i)
+	}
+	tests := []struct {
+		name    string
+		words   []string
+		wantErr string
+	}{
+		{"too short", custom[:MinWords-1], "at least 256"},
+		{"duplicate", append(slices.Clone(custom[:MinWords-1]), "wort0"), `"wort0" more than once`},
+		{"dash", append(slices.Clone(custom[:MinWords-1]), "foo-bar"), "dash or space"},
+		{"empty word", append(slices.Clone(custom[:MinWords-1]), ""), "dash or space"},
+		{"valid", custom, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SetWordlist(tt.words)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				if Len() != len(builtin) {
+					t.Errorf("a rejected list replaced Words")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if Len() != MinWords || Words[0] != "wort0" {
+				t.Errorf("Words was not replaced")
+			}
+			passphrase, _ := Pick(4, "-")
+			if !strings.HasPrefix(passphrase, "wort") {
+				t.Errorf("Pick returned %q, not words from the new list", passphrase)
+			}
+			// Autocorrect keeps to the built-in lists, which receivers have too
+			if Contains("wort0") || !Contains(builtin[0]) {
+				t.Error("Contains followed the replaced list")
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
-package main
-
-import "github.com/flawiddsouza/git-share/cmd"
-
-func main() {
-	cmd.Execute()
-}
+package main
+
+import "github.com/flawiddsouza/git-share/cmd"
+
+func main() {
+	cmd.Execute()
+}